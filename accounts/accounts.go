@@ -128,6 +128,24 @@ type Wallet interface {
 	SignTxWithPassphrase(account Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
 }
 
+// SignerWallet is an optional interface of wallets able to sign transactions
+// using the signing scheme of an arbitrary signer (e.g. a chain specific signing
+// domain) instead of deriving it from a chain ID. Users of a Wallet should check
+// for it with a type assertion, falling back to SignTx if not implemented.
+type SignerWallet interface {
+	// SignTxWithSigner requests the wallet to sign the given transaction using the
+	// signing scheme of the given signer.
+	//
+	// It looks up the account the same way as SignTx, also returning an
+	// AuthNeededError if additional authentication is required.
+	SignTxWithSigner(account Account, tx *types.Transaction, signer types.Signer) (*types.Transaction, error)
+
+	// SignTxWithSignerAndPassphrase requests the wallet to sign the given transaction
+	// using the signing scheme of the given signer, with the given passphrase as
+	// extra authentication information.
+	SignTxWithSignerAndPassphrase(account Account, passphrase string, tx *types.Transaction, signer types.Signer) (*types.Transaction, error)
+}
+
 // Backend is a "wallet provider" that may contain a batch of accounts they can
 // sign transactions with and upon request, do so.
 type Backend interface {
@@ -269,6 +269,12 @@ func (ks *KeyStore) SignHash(a accounts.Account, hash []byte) ([]byte, error) {
 
 // SignTx signs the given transaction with the requested account.
 func (ks *KeyStore) SignTx(a accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return ks.SignTxWithSigner(a, tx, chainSigner(chainID))
+}
+
+// SignTxWithSigner signs the given transaction with the requested account, using
+// the signing scheme of the given signer.
+func (ks *KeyStore) SignTxWithSigner(a accounts.Account, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
 	// Look up the key to sign with and abort if it cannot be found
 	ks.mu.RLock()
 	defer ks.mu.RUnlock()
@@ -277,11 +283,7 @@ func (ks *KeyStore) SignTx(a accounts.Account, tx *types.Transaction, chainID *b
 	if !found {
 		return nil, ErrLocked
 	}
-	// Depending on the presence of the chain ID, sign with EIP155 or homestead
-	if chainID != nil {
-		return types.SignTx(tx, types.NewEIP155Signer(chainID), unlockedKey.PrivateKey)
-	}
-	return types.SignTx(tx, types.HomesteadSigner{}, unlockedKey.PrivateKey)
+	return types.SignTx(tx, signer, unlockedKey.PrivateKey)
 }
 
 // SignHashWithPassphrase signs hash if the private key matching the given address
@@ -299,17 +301,29 @@ func (ks *KeyStore) SignHashWithPassphrase(a accounts.Account, passphrase string
 // SignTxWithPassphrase signs the transaction if the private key matching the
 // given address can be decrypted with the given passphrase.
 func (ks *KeyStore) SignTxWithPassphrase(a accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return ks.SignTxWithSignerAndPassphrase(a, passphrase, tx, chainSigner(chainID))
+}
+
+// SignTxWithSignerAndPassphrase signs the transaction using the signing scheme of
+// the given signer if the private key matching the given address can be decrypted
+// with the given passphrase.
+func (ks *KeyStore) SignTxWithSignerAndPassphrase(a accounts.Account, passphrase string, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
 	_, key, err := ks.getDecryptedKey(a, passphrase)
 	if err != nil {
 		return nil, err
 	}
 	defer zeroKey(key.PrivateKey)
 
-	// Depending on the presence of the chain ID, sign with EIP155 or homestead
+	return types.SignTx(tx, signer, key.PrivateKey)
+}
+
+// chainSigner returns the signer to use for the given chain ID: EIP155 if it's
+// present, homestead otherwise.
+func chainSigner(chainID *big.Int) types.Signer {
 	if chainID != nil {
-		return types.SignTx(tx, types.NewEIP155Signer(chainID), key.PrivateKey)
+		return types.NewEIP155Signer(chainID)
 	}
-	return types.SignTx(tx, types.HomesteadSigner{}, key.PrivateKey)
+	return types.HomesteadSigner{}
 }
 
 // Unlock unlocks the given account indefinitely.
@@ -104,6 +104,18 @@ func (w *keystoreWallet) SignTx(account accounts.Account, tx *types.Transaction,
 	return w.keystore.SignTx(account, tx, chainID)
 }
 
+// SignTxWithSigner implements accounts.SignerWallet, attempting to sign the
+// given transaction with the given account using the signing scheme of the
+// signer.
+func (w *keystoreWallet) SignTxWithSigner(account accounts.Account, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	// Make sure the requested account is contained within
+	if !w.Contains(account) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	// Account seems valid, request the keystore to sign
+	return w.keystore.SignTxWithSigner(account, tx, signer)
+}
+
 // SignHashWithPassphrase implements accounts.Wallet, attempting to sign the
 // given hash with the given account using passphrase as extra authentication.
 func (w *keystoreWallet) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
@@ -125,3 +137,15 @@ func (w *keystoreWallet) SignTxWithPassphrase(account accounts.Account, passphra
 	// Account seems valid, request the keystore to sign
 	return w.keystore.SignTxWithPassphrase(account, passphrase, tx, chainID)
 }
+
+// SignTxWithSignerAndPassphrase implements accounts.SignerWallet, attempting to
+// sign the given transaction with the given account using the signing scheme of
+// the signer and passphrase as extra authentication.
+func (w *keystoreWallet) SignTxWithSignerAndPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	// Make sure the requested account is contained within
+	if !w.Contains(account) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	// Account seems valid, request the keystore to sign
+	return w.keystore.SignTxWithSignerAndPassphrase(account, passphrase, tx, signer)
+}
@@ -19,6 +19,7 @@ package usbwallet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -40,6 +41,10 @@ const heartbeatCycle = time.Second
 // requesting accounts like crazy.
 const selfDeriveThrottling = time.Second
 
+// ErrUnsupportedSigner is returned if a transaction is requested to be signed
+// using a signing scheme the hardware wallets can't produce signatures for.
+var ErrUnsupportedSigner = errors.New("usbwallet: signing scheme not supported by device")
+
 // driver defines the vendor specific functionality hardware wallets instances
 // must implement to allow using them with the wallet lifecycle management.
 type driver interface {
@@ -547,6 +552,22 @@ func (w *wallet) SignTx(account accounts.Account, tx *types.Transaction, chainID
 	return signed, nil
 }
 
+// SignTxWithSigner implements accounts.SignerWallet, signing the given transaction
+// via SignTx if the device supports the signing scheme of the signer. The devices
+// compute the signature hashes themselves, so transactions can't be signed for a
+// chain specific signing domain.
+func (w *wallet) SignTxWithSigner(account accounts.Account, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	var chainID *big.Int
+	switch signer := signer.(type) {
+	case types.EIP155Signer:
+		chainID = signer.ChainID()
+	case types.HomesteadSigner, types.FrontierSigner:
+	default:
+		return nil, ErrUnsupportedSigner
+	}
+	return w.SignTx(account, tx, chainID)
+}
+
 // SignHashWithPassphrase implements accounts.Wallet, however signing arbitrary
 // data is not supported for Ledger wallets, so this method will always return
 // an error.
@@ -560,3 +581,10 @@ func (w *wallet) SignHashWithPassphrase(account accounts.Account, passphrase str
 func (w *wallet) SignTxWithPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
 	return w.SignTx(account, tx, chainID)
 }
+
+// SignTxWithSignerAndPassphrase implements accounts.SignerWallet, attempting to
+// sign the given transaction using the signing scheme of the signer. Since USB
+// wallets don't rely on passphrases, these are silently ignored.
+func (w *wallet) SignTxWithSignerAndPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	return w.SignTxWithSigner(account, tx, signer)
+}
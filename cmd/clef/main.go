@@ -110,6 +110,10 @@ var (
 		Usage: "File used to emit audit logs. Set to \"\" to disable",
 		Value: "audit.log",
 	}
+	signingDomainFlag = cli.StringFlag{
+		Name:  "signingdomain",
+		Usage: "Chain specific signing domain of the transactions (empty = plain EIP155)",
+	}
 	ruleFlag = cli.StringFlag{
 		Name:  "rules",
 		Usage: "Enable rule-engine",
@@ -182,6 +186,7 @@ func init() {
 		keystoreFlag,
 		configdirFlag,
 		utils.NetworkIdFlag,
+		signingDomainFlag,
 		utils.LightKDFFlag,
 		utils.NoUSBFlag,
 		utils.RPCListenAddrFlag,
@@ -408,6 +413,7 @@ func signer(c *cli.Context) error {
 
 	apiImpl := core.NewSignerAPI(
 		c.GlobalInt64(utils.NetworkIdFlag.Name),
+		c.GlobalString(signingDomainFlag.Name),
 		c.GlobalString(keystoreFlag.Name),
 		c.GlobalBool(utils.NoUSBFlag.Name),
 		ui, db,
@@ -0,0 +1,261 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package profiler implements an EVM tracer aggregating the gas usage and the
+// execution time of the executed code per opcode and per program counter.
+package profiler
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+)
+
+// Stat is the aggregated cost of a set of executed instructions. The gas and
+// time of an instruction exclude the cost of the inner calls it makes.
+type Stat struct {
+	Count uint64        // Number of executions
+	Gas   uint64        // Gas used by the executions
+	Time  time.Duration // Time spent in the executions
+}
+
+// Location identifies an instruction of a piece of code.
+type Location struct {
+	Code common.Hash // Hash of the executed code
+	PC   uint64      // Program counter of the instruction
+}
+
+// Code is a piece of code executed during profiling.
+type Code struct {
+	Hash    common.Hash    // Hash of the code
+	Address common.Address // Address the code was first executed at
+	Code    []byte         // Executed bytecode
+	Source  *SourceMap     // Source map of the code, nil if unknown
+}
+
+// Name returns the human readable name of the code, which is its contract name
+// if known or its address otherwise.
+func (c *Code) Name() string {
+	if c.Source != nil {
+		return c.Source.Name
+	}
+	return c.Address.Hex()
+}
+
+// label returns the flame graph frame name of the instruction at pc.
+func (c *Code) label(pc uint64, op vm.OpCode) string {
+	if c.Source != nil {
+		if line, ok := c.Source.Lines[pc]; ok {
+			return line.String()
+		}
+	}
+	return fmt.Sprintf("%v@%d", op, pc)
+}
+
+// step is an instruction executed by a call frame, waiting for its gas usage to
+// be known.
+type step struct {
+	pc       uint64
+	op       vm.OpCode
+	gas      uint64 // Gas available before executing the instruction
+	cost     uint64 // Gas cost of the instruction as reported by the interpreter
+	children uint64 // Gas used by the inner calls of the instruction
+	faulted  bool   // Whether the instruction failed, consuming all gas
+}
+
+// frame is a call frame being executed.
+type frame struct {
+	code  *Code
+	stack string // Flame graph frame names of the call stack
+	step  *step  // Last executed instruction, nil if none pending
+	total uint64 // Gas used within the frame, including inner calls
+}
+
+// Profiler is a vm.Tracer aggregating the gas usage and execution time of the
+// executed code per opcode, per instruction and per call stack.
+type Profiler struct {
+	Ops   map[vm.OpCode]*Stat   // Aggregated stats per opcode
+	PCs   map[Location]*Stat    // Aggregated stats per instruction
+	Codes map[common.Hash]*Code // All the executed code by hash
+	Stack map[string]uint64     // Gas used per call stack, flame graph style
+	order []common.Hash         // Executed code in order of first execution
+	srcs  *Sources              // Source maps to resolve the code with, if any
+
+	frames []*frame
+	last   []*Stat   // Stats to charge the time until the next instruction to
+	start  time.Time // Start time of the last executed instruction
+}
+
+// New creates a profiler, resolving the executed code to its sources using the
+// given source maps if not nil.
+func New(sources *Sources) *Profiler {
+	return &Profiler{
+		Ops:   make(map[vm.OpCode]*Stat),
+		PCs:   make(map[Location]*Stat),
+		Codes: make(map[common.Hash]*Code),
+		Stack: make(map[string]uint64),
+		srcs:  sources,
+	}
+}
+
+// Executed returns all the executed code, in order of first execution.
+func (p *Profiler) Executed() []*Code {
+	codes := make([]*Code, len(p.order))
+	for i, hash := range p.order {
+		codes[i] = p.Codes[hash]
+	}
+	return codes
+}
+
+// CaptureStart implements vm.Tracer.
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements vm.Tracer, charging the previous instruction of the
+// current call frame and starting to track the current one.
+func (p *Profiler) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	p.tick()
+
+	// Finish all the call frames that returned and charge the instruction that
+	// was waiting for the current frame to continue
+	for len(p.frames) > depth {
+		p.pop()
+	}
+	if len(p.frames) == depth {
+		if frame := p.frames[depth-1]; frame.step != nil {
+			used := frame.step.gas - gas
+			if used < frame.step.children {
+				used = frame.step.children
+			}
+			p.charge(frame, used-frame.step.children)
+		}
+	} else {
+		p.push(contract)
+	}
+	// Start tracking the current instruction
+	frame := p.frames[len(p.frames)-1]
+	frame.step = &step{pc: pc, op: op, gas: gas, cost: cost}
+
+	location := Location{Code: frame.code.Hash, PC: pc}
+	if p.Ops[op] == nil {
+		p.Ops[op] = new(Stat)
+	}
+	if p.PCs[location] == nil {
+		p.PCs[location] = new(Stat)
+	}
+	p.Ops[op].Count++
+	p.PCs[location].Count++
+	p.last = []*Stat{p.Ops[op], p.PCs[location]}
+
+	// Instructions failing before execution consume all the gas of the frame
+	if err != nil {
+		frame.step.faulted = true
+	}
+	return nil
+}
+
+// CaptureFault implements vm.Tracer, marking the current instruction as failed.
+// Reverts refund the remaining gas, all other failures consume it.
+func (p *Profiler) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if len(p.frames) == 0 || err == nil || err.Error() == "evm: execution reverted" {
+		return nil
+	}
+	if frame := p.frames[len(p.frames)-1]; frame.step != nil {
+		frame.step.faulted = true
+	}
+	return nil
+}
+
+// CaptureEnd implements vm.Tracer, finishing all the remaining call frames.
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	p.tick()
+	for len(p.frames) > 0 {
+		p.pop()
+	}
+	p.last = nil
+	return nil
+}
+
+// tick charges the time elapsed since the last executed instruction to it.
+func (p *Profiler) tick() {
+	now := time.Now()
+	for _, stat := range p.last {
+		stat.Time += now.Sub(p.start)
+	}
+	p.start = now
+}
+
+// push enters a new call frame executing the code of the given contract.
+func (p *Profiler) push(contract *vm.Contract) {
+	hash := crypto.Keccak256Hash(contract.Code)
+
+	code := p.Codes[hash]
+	if code == nil {
+		address := contract.Address()
+		if contract.CodeAddr != nil {
+			address = *contract.CodeAddr
+		}
+		code = &Code{Hash: hash, Address: address, Code: common.CopyBytes(contract.Code)}
+		if p.srcs != nil {
+			code.Source = p.srcs.Lookup(contract.Code)
+		}
+		p.Codes[hash] = code
+		p.order = append(p.order, hash)
+	}
+	stack := code.Name()
+	if len(p.frames) > 0 {
+		stack = p.frames[len(p.frames)-1].stack + ";" + stack
+	}
+	p.frames = append(p.frames, &frame{code: code, stack: stack})
+}
+
+// pop finishes the innermost call frame, charging its last instruction and
+// accounting its total gas usage to the instruction that called it.
+func (p *Profiler) pop() {
+	frame := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+
+	if frame.step != nil {
+		used := frame.step.cost
+		if frame.step.faulted {
+			used = frame.step.gas
+		}
+		p.charge(frame, used)
+	}
+	if len(p.frames) > 0 {
+		if parent := p.frames[len(p.frames)-1]; parent.step != nil {
+			parent.step.children += frame.total
+		}
+		p.frames[len(p.frames)-1].total += frame.total
+	}
+}
+
+// charge accounts the gas used by the pending instruction of the frame itself,
+// excluding its inner calls.
+func (p *Profiler) charge(frame *frame, used uint64) {
+	step := frame.step
+	frame.step = nil
+
+	p.Ops[step.op].Gas += used
+	p.PCs[Location{Code: frame.code.Hash, PC: step.pc}].Gas += used
+	p.Stack[frame.stack+";"+frame.code.label(step.pc, step.op)] += used
+	frame.total += used
+}
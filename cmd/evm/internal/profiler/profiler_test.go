@@ -0,0 +1,140 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package profiler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/core/vm/runtime"
+	"github.com/simplechain-org/simplechain/ethdb"
+)
+
+// Stores 1 into slot 0
+var calleeCode = common.FromHex("600160005500")
+
+// Calls the callee with all the available gas, then stores 2 into slot 0
+var callerCode = common.FromHex("6000600060006000600060b05af1506002600055")
+
+// profile executes the caller code with the profiler attached.
+func profile(t *testing.T, sources *Sources) *Profiler {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	statedb.SetCode(common.HexToAddress("0xb0"), calleeCode)
+
+	profiler := New(sources)
+	if _, _, err := runtime.Execute(callerCode, nil, &runtime.Config{
+		State:     statedb,
+		GasLimit:  1000000,
+		EVMConfig: vm.Config{Debug: true, Tracer: profiler},
+	}); err != nil {
+		t.Fatalf("failed to execute code: %v", err)
+	}
+	return profiler
+}
+
+func TestProfiler(t *testing.T) {
+	profiler := profile(t, nil)
+
+	// Check the aggregated gas usage per opcode, excluding the inner calls
+	ops := map[vm.OpCode]Stat{
+		vm.PUSH1:  {Count: 10, Gas: 30},
+		vm.SSTORE: {Count: 2, Gas: 40000},
+		vm.CALL:   {Count: 1, Gas: 700},
+		vm.GAS:    {Count: 1, Gas: 2},
+		vm.POP:    {Count: 1, Gas: 2},
+		vm.STOP:   {Count: 2, Gas: 0},
+	}
+	if len(profiler.Ops) != len(ops) {
+		t.Errorf("opcode count mismatch: have %d, want %d", len(profiler.Ops), len(ops))
+	}
+	for op, want := range ops {
+		have := profiler.Ops[op]
+		if have == nil || have.Count != want.Count || have.Gas != want.Gas {
+			t.Errorf("%v: stats mismatch: have %+v, want %+v", op, have, want)
+		}
+	}
+	// Check the per instruction stats and the call stacks
+	if codes := profiler.Executed(); len(codes) != 2 || !bytes.Equal(codes[1].Code, calleeCode) {
+		t.Fatalf("executed code mismatch: %v", codes)
+	}
+	callee := profiler.Executed()[1]
+	if stat := profiler.PCs[Location{Code: callee.Hash, PC: 4}]; stat == nil || stat.Gas != 20000 {
+		t.Errorf("callee SSTORE stats mismatch: %+v", stat)
+	}
+	var flame bytes.Buffer
+	if err := profiler.WriteFlameGraph(&flame); err != nil {
+		t.Fatalf("failed to write flame graph: %v", err)
+	}
+	if want := ";" + callee.Name() + ";SSTORE@4 20000\n"; !strings.Contains(flame.String(), want) {
+		t.Errorf("flame graph missing callee stack %q:\n%s", want, flame.String())
+	}
+}
+
+func TestProfilerSourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-profiler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Map the two instructions of the callee storing the value to line 2 and the
+	// rest to line 1
+	source := "contract Callee {\n  uint x = 1;\n}\n"
+	combined := `{
+		"contracts": {"callee.sol:Callee": {"bin-runtime": "600160005500", "srcmap-runtime": "0:30:0:-;20:9;;0:30"}},
+		"sourceList": ["callee.sol"]
+	}`
+	ioutil.WriteFile(filepath.Join(dir, "callee.sol"), []byte(source), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "combined.json"), []byte(combined), 0644)
+
+	sources, err := LoadCombinedJSON(filepath.Join(dir, "combined.json"))
+	if err != nil {
+		t.Fatalf("failed to load source maps: %v", err)
+	}
+	srcmap := sources.Lookup(calleeCode)
+	if srcmap == nil {
+		t.Fatalf("callee source map not found")
+	}
+	lines := map[uint64]int{0: 1, 2: 2, 4: 2, 5: 1}
+	for pc, line := range lines {
+		if have := srcmap.Lines[pc]; have.File != "callee.sol" || have.Line != line {
+			t.Errorf("pc %d: source mismatch: have %v, want line %d", pc, have, line)
+		}
+	}
+	profiler := profile(t, sources)
+
+	var flame, html bytes.Buffer
+	if err := profiler.WriteFlameGraph(&flame); err != nil {
+		t.Fatalf("failed to write flame graph: %v", err)
+	}
+	if want := ";Callee;callee.sol:2 20003\n"; !strings.Contains(flame.String(), want) {
+		t.Errorf("flame graph missing source line %q:\n%s", want, flame.String())
+	}
+	if err := profiler.WriteHTML(&html); err != nil {
+		t.Fatalf("failed to write coverage report: %v", err)
+	}
+	if want := "Callee (0x00000000000000000000000000000000000000B0): 4/4 instructions covered"; !strings.Contains(html.String(), want) {
+		t.Errorf("coverage report missing %q", want)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package profiler
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core/asm"
+	"github.com/simplechain-org/simplechain/core/vm"
+)
+
+// WriteSummary writes the aggregated stats per opcode, ordered by gas usage.
+func (p *Profiler) WriteSummary(w io.Writer) error {
+	ops := make([]vm.OpCode, 0, len(p.Ops))
+	for op := range p.Ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.Ops[ops[i]].Gas != p.Ops[ops[j]].Gas {
+			return p.Ops[ops[i]].Gas > p.Ops[ops[j]].Gas
+		}
+		return ops[i] < ops[j]
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "opcode\tcount\tgas\ttime\t")
+	for _, op := range ops {
+		stat := p.Ops[op]
+		fmt.Fprintf(tw, "%v\t%d\t%d\t%v\t\n", op, stat.Count, stat.Gas, stat.Time)
+	}
+	return tw.Flush()
+}
+
+// WriteFlameGraph writes the gas usage per call stack in the folded stack format
+// understood by flamegraph.pl and most flame graph viewers. Each line lists the
+// call frames separated by semicolons, ending with the source line or the
+// instruction, followed by the gas used.
+func (p *Profiler) WriteFlameGraph(w io.Writer) error {
+	stacks := make([]string, 0, len(p.Stack))
+	for stack := range p.Stack {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, p.Stack[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// htmlInstruction is an instruction of the HTML coverage report.
+type htmlInstruction struct {
+	PC     uint64
+	Op     string
+	Arg    string
+	Source string
+	Stat   Stat
+	Hit    bool
+}
+
+// htmlLine is a source line of the HTML coverage report.
+type htmlLine struct {
+	Number int
+	Text   string
+	Stat   Stat
+	Mapped bool // Whether any instruction maps to the line
+	Hit    bool // Whether any instruction of the line was executed
+}
+
+// htmlFile is a source file of the HTML coverage report.
+type htmlFile struct {
+	Name  string
+	Lines []*htmlLine
+}
+
+// htmlCode is an executed piece of code of the HTML coverage report.
+type htmlCode struct {
+	Name         string
+	Address      string
+	Covered      int
+	Total        int
+	Instructions []*htmlInstruction
+}
+
+// WriteHTML writes a coverage report of the executed code, listing the gas
+// usage and execution count of every instruction and, if the source maps are
+// known, of every source line.
+func (p *Profiler) WriteHTML(w io.Writer) error {
+	var (
+		codes []*htmlCode
+		files = make(map[string]*htmlFile)
+	)
+	if p.srcs != nil {
+		for _, file := range p.srcs.Files {
+			report := &htmlFile{Name: file.Name}
+			for i, text := range file.Lines {
+				report.Lines = append(report.Lines, &htmlLine{Number: i + 1, Text: text})
+			}
+			files[file.Name] = report
+		}
+	}
+	for _, code := range p.Executed() {
+		report := &htmlCode{Name: code.Name(), Address: code.Address.Hex()}
+
+		it := asm.NewInstructionIterator(code.Code)
+		for it.Next() {
+			instruction := &htmlInstruction{PC: it.PC(), Op: it.Op().String()}
+			if len(it.Arg()) > 0 {
+				instruction.Arg = hexutil.Encode(it.Arg())
+			}
+			if stat := p.PCs[Location{Code: code.Hash, PC: it.PC()}]; stat != nil {
+				instruction.Stat, instruction.Hit = *stat, true
+				report.Covered++
+			}
+			report.Total++
+
+			if code.Source != nil {
+				if source, ok := code.Source.Lines[it.PC()]; ok {
+					instruction.Source = source.String()
+					if file := files[source.File]; file != nil && source.Line <= len(file.Lines) {
+						line := file.Lines[source.Line-1]
+						line.Mapped = true
+						line.Hit = line.Hit || instruction.Hit
+						line.Stat.Count += instruction.Stat.Count
+						line.Stat.Gas += instruction.Stat.Gas
+						line.Stat.Time += instruction.Stat.Time
+					}
+				}
+			}
+			report.Instructions = append(report.Instructions, instruction)
+		}
+		codes = append(codes, report)
+	}
+	var sources []*htmlFile
+	if p.srcs != nil {
+		for _, file := range p.srcs.Files {
+			sources = append(sources, files[file.Name])
+		}
+	}
+	return htmlReport.Execute(w, map[string]interface{}{
+		"Codes":   codes,
+		"Sources": sources,
+	})
+}
+
+// htmlReport is the template of the HTML coverage report.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>EVM coverage report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td, th { padding: 0 8px; text-align: left; white-space: pre; }
+td.num { text-align: right; color: #666; }
+tr.hit { background: #dfd; }
+tr.miss { background: #fdd; }
+</style>
+</head>
+<body>
+{{range .Sources}}
+<h2>{{.Name}}</h2>
+<table>
+<tr><th>line</th><th>count</th><th>gas</th><th>time</th><th>source</th></tr>
+{{range .Lines}}<tr{{if .Hit}} class="hit"{{else if .Mapped}} class="miss"{{end}}><td class="num">{{.Number}}</td><td class="num">{{if .Hit}}{{.Stat.Count}}{{end}}</td><td class="num">{{if .Hit}}{{.Stat.Gas}}{{end}}</td><td class="num">{{if .Hit}}{{.Stat.Time}}{{end}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+{{range .Codes}}
+<h2>{{.Name}} ({{.Address}}): {{.Covered}}/{{.Total}} instructions covered</h2>
+<table>
+<tr><th>pc</th><th>count</th><th>gas</th><th>time</th><th>instruction</th><th>source</th></tr>
+{{range .Instructions}}<tr class="{{if .Hit}}hit{{else}}miss{{end}}"><td class="num">{{.PC}}</td><td class="num">{{.Stat.Count}}</td><td class="num">{{.Stat.Gas}}</td><td class="num">{{.Stat.Time}}</td><td>{{.Op}} {{.Arg}}</td><td>{{.Source}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
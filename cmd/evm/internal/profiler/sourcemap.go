@@ -0,0 +1,196 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package profiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/vm"
+)
+
+// SourceLine is a line of a source file.
+type SourceLine struct {
+	File string // Name of the source file
+	Line int    // Line number, starting at 1
+}
+
+// String implements fmt.Stringer.
+func (l SourceLine) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// SourceFile is a source file compiled into the profiled contracts.
+type SourceFile struct {
+	Name  string   // Name of the file as listed by the compiler
+	Lines []string // Content of the file, line by line
+}
+
+// SourceMap maps the instructions of a compiled contract to its source lines.
+type SourceMap struct {
+	Name  string                // Name of the contract
+	Code  []byte                // Compiled bytecode
+	Lines map[uint64]SourceLine // Source lines of the instructions by program counter
+}
+
+// Sources is a set of compiled contracts with their source maps.
+type Sources struct {
+	Files    []*SourceFile // Source files, in the compiler's source list order
+	runtime  []*SourceMap  // Source maps of the runtime code of the contracts
+	creation []*SourceMap  // Source maps of the creation code of the contracts
+}
+
+// combinedJSON is the output format of solc --combined-json.
+type combinedJSON struct {
+	Contracts map[string]struct {
+		Bin           string `json:"bin"`
+		BinRuntime    string `json:"bin-runtime"`
+		SrcMap        string `json:"srcmap"`
+		SrcMapRuntime string `json:"srcmap-runtime"`
+	} `json:"contracts"`
+	SourceList []string `json:"sourceList"`
+}
+
+// LoadCombinedJSON reads the output of solc --combined-json, which must include
+// bin, bin-runtime, srcmap and srcmap-runtime, along with the source files it
+// refers to. The source files are looked up relative to the JSON file first
+// and to the working directory second.
+func LoadCombinedJSON(path string) (*Sources, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var output combinedJSON
+	if err := json.Unmarshal(blob, &output); err != nil {
+		return nil, fmt.Errorf("invalid combined json: %v", err)
+	}
+	sources := new(Sources)
+	for _, name := range output.SourceList {
+		src, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			if src, err = ioutil.ReadFile(name); err != nil {
+				return nil, fmt.Errorf("failed to read source %s: %v", name, err)
+			}
+		}
+		sources.Files = append(sources.Files, &SourceFile{Name: name, Lines: strings.Split(string(src), "\n")})
+	}
+	// Decode the source maps in a stable order
+	names := make([]string, 0, len(output.Contracts))
+	for name := range output.Contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		contract := output.Contracts[name]
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+		if contract.BinRuntime != "" && contract.SrcMapRuntime != "" {
+			srcmap, err := sources.decode(name, common.FromHex(contract.BinRuntime), contract.SrcMapRuntime)
+			if err != nil {
+				return nil, fmt.Errorf("contract %s: %v", name, err)
+			}
+			sources.runtime = append(sources.runtime, srcmap)
+		}
+		if contract.Bin != "" && contract.SrcMap != "" {
+			srcmap, err := sources.decode(name+" (constructor)", common.FromHex(contract.Bin), contract.SrcMap)
+			if err != nil {
+				return nil, fmt.Errorf("contract %s: %v", name, err)
+			}
+			sources.creation = append(sources.creation, srcmap)
+		}
+	}
+	return sources, nil
+}
+
+// Lookup returns the source map of the given code, or nil if unknown. Runtime
+// code must match exactly, creation code may be followed by constructor
+// arguments.
+func (s *Sources) Lookup(code []byte) *SourceMap {
+	for _, srcmap := range s.runtime {
+		if bytes.Equal(srcmap.Code, code) {
+			return srcmap
+		}
+	}
+	for _, srcmap := range s.creation {
+		if len(srcmap.Code) > 0 && bytes.HasPrefix(code, srcmap.Code) {
+			return srcmap
+		}
+	}
+	return nil
+}
+
+// decode parses a compressed solc source map, resolving the source line of each
+// instruction of the code.
+//
+// The source map contains an entry "s:l:f:j" per instruction, where s is the
+// byte offset in the source file with index f, l the length of the range and j
+// the jump type. Empty fields inherit the value of the previous entry, a file
+// index of -1 denotes compiler generated code without a source.
+func (s *Sources) decode(name string, code []byte, srcmap string) (*SourceMap, error) {
+	result := &SourceMap{Name: name, Code: code, Lines: make(map[uint64]SourceLine)}
+
+	var (
+		entries = strings.Split(srcmap, ";")
+		fields  = []int{0, 0, -1}
+		pc      uint64
+	)
+	for _, entry := range entries {
+		if pc >= uint64(len(code)) {
+			break
+		}
+		for i, field := range strings.Split(entry, ":") {
+			if i >= len(fields) || field == "" {
+				continue
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source map entry %q", entry)
+			}
+			fields[i] = n
+		}
+		if file := fields[2]; file >= 0 && file < len(s.Files) {
+			result.Lines[pc] = SourceLine{File: s.Files[file].Name, Line: s.line(file, fields[0])}
+		}
+		// Skip to the next instruction, jumping over the push data
+		op := vm.OpCode(code[pc])
+		pc++
+		if op >= vm.PUSH1 && op <= vm.PUSH32 {
+			pc += uint64(op - vm.PUSH1 + 1)
+		}
+	}
+	return result, nil
+}
+
+// line converts a byte offset into the given source file into a line number.
+func (s *Sources) line(file int, offset int) int {
+	for i, line := range s.Files[file].Lines {
+		if offset <= len(line) {
+			return i + 1
+		}
+		offset -= len(line) + 1
+	}
+	return len(s.Files[file].Lines)
+}
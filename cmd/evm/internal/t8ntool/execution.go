@@ -0,0 +1,241 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/consensus/misc"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rlp"
+	"github.com/simplechain-org/simplechain/tests"
+	"golang.org/x/crypto/sha3"
+)
+
+// Prestate is the state and block environment the transactions are applied to.
+type Prestate struct {
+	Env stEnv             `json:"env"`
+	Pre core.GenesisAlloc `json:"pre"`
+}
+
+// ExecutionResult contains the roots, receipts and rejected transactions of a
+// state transition.
+type ExecutionResult struct {
+	StateRoot   common.Hash    `json:"stateRoot"`
+	TxRoot      common.Hash    `json:"txRoot"`
+	ReceiptRoot common.Hash    `json:"receiptRoot"`
+	LogsHash    common.Hash    `json:"logsHash"`
+	Bloom       types.Bloom    `json:"logsBloom"`
+	Receipts    types.Receipts `json:"receipts"`
+	Rejected    []int          `json:"rejected,omitempty"` // Indexes of the transactions which couldn't be applied
+}
+
+// ommer is an uncle of the block, located by its distance from the block.
+type ommer struct {
+	Delta   uint64         `json:"delta"`
+	Address common.Address `json:"address"`
+}
+
+// stEnv is the block environment of the state transition.
+type stEnv struct {
+	Coinbase    common.Address                      `json:"currentCoinbase"`
+	Difficulty  *math.HexOrDecimal256               `json:"currentDifficulty"`
+	GasLimit    math.HexOrDecimal64                 `json:"currentGasLimit"`
+	Number      math.HexOrDecimal64                 `json:"currentNumber"`
+	Timestamp   math.HexOrDecimal64                 `json:"currentTimestamp"`
+	BlockHashes map[math.HexOrDecimal64]common.Hash `json:"blockHashes,omitempty"`
+	Ommers      []ommer                             `json:"ommers,omitempty"`
+}
+
+// getTracerFn returns the tracer to run the transaction with the given index and
+// hash with, or nil if tracing is disabled.
+type getTracerFn func(txIndex int, txHash common.Hash) (vm.Tracer, error)
+
+// Apply applies the transactions to the prestate, returning the resulting state
+// and the execution result. Transactions which can't be applied, for example due
+// to an invalid nonce or insufficient funds, are rejected and skipped. A negative
+// mining reward disables the block and ommer rewards.
+func (pre *Prestate) Apply(vmConfig vm.Config, chainConfig *params.ChainConfig, txs types.Transactions, miningReward int64, getTracerFn getTracerFn) (*state.StateDB, *ExecutionResult, error) {
+	if pre.Env.Difficulty == nil {
+		return nil, nil, fmt.Errorf("missing currentDifficulty in env")
+	}
+	var (
+		statedb     = tests.MakePreState(ethdb.NewMemDatabase(), pre.Pre)
+		number      = new(big.Int).SetUint64(uint64(pre.Env.Number))
+		signer      = types.MakeSigner(chainConfig, number)
+		gaspool     = new(core.GasPool).AddGas(uint64(pre.Env.GasLimit))
+		blockHash   = common.Hash{0x13, 0x37}
+		rejectedTxs []int
+		includedTxs types.Transactions
+		gasUsed     uint64
+		receipts    = make(types.Receipts, 0)
+		txIndex     = 0
+	)
+	vmContext := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    pre.Env.Coinbase,
+		BlockNumber: number,
+		Time:        new(big.Int).SetUint64(uint64(pre.Env.Timestamp)),
+		Difficulty:  (*big.Int)(pre.Env.Difficulty),
+		GasLimit:    uint64(pre.Env.GasLimit),
+		GetHash: func(n uint64) common.Hash {
+			return pre.Env.BlockHashes[math.HexOrDecimal64(n)]
+		},
+	}
+	// If DAO is supported/enabled, we need to handle it here. In geth 'proper', it's
+	// done in StateProcessor.Process(block, ...), right before transactions are applied.
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(number) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range txs {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "err", err)
+			rejectedTxs = append(rejectedTxs, i)
+			continue
+		}
+		tracer, err := getTracerFn(txIndex, tx.Hash())
+		if err != nil {
+			return nil, nil, err
+		}
+		vmConfig.Tracer = tracer
+		vmConfig.Debug = tracer != nil
+		statedb.Prepare(tx.Hash(), blockHash, txIndex)
+
+		vmContext.Origin = msg.From()
+		vmContext.GasPrice = msg.GasPrice()
+		evm := vm.NewEVM(vmContext, statedb, chainConfig, vmConfig)
+
+		snapshot := statedb.Snapshot()
+		_, gas, failed, err := core.ApplyMessage(evm, msg, gaspool)
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "from", msg.From(), "err", err)
+			rejectedTxs = append(rejectedTxs, i)
+			continue
+		}
+		includedTxs = append(includedTxs, tx)
+		gasUsed += gas
+
+		// Create a new receipt for the transaction, storing the intermediate root
+		// and gas used by the tx
+		var root []byte
+		if chainConfig.IsByzantium(number) {
+			statedb.Finalise(true)
+		} else {
+			root = statedb.IntermediateRoot(chainConfig.IsEIP158(number)).Bytes()
+		}
+		receipt := types.NewReceipt(root, failed, gasUsed)
+		receipt.TxHash = tx.Hash()
+		receipt.GasUsed = gas
+
+		// If the transaction created a contract, store the creation address in the receipt
+		if msg.To() == nil {
+			receipt.ContractAddress = crypto.CreateAddress(evm.Context.Origin, tx.Nonce())
+		}
+		receipt.Logs = statedb.GetLogs(tx.Hash())
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		receipts = append(receipts, receipt)
+
+		txIndex++
+	}
+	statedb.IntermediateRoot(chainConfig.IsEIP158(number))
+
+	// Add mining reward, the miner gets an extra 1/32 of the reward per included
+	// ommer, the ommers get a share depending on their distance
+	if miningReward >= 0 {
+		var (
+			blockReward = big.NewInt(miningReward)
+			minerReward = new(big.Int).Set(blockReward)
+			perOmmer    = new(big.Int).Div(blockReward, big.NewInt(32))
+		)
+		for _, ommer := range pre.Env.Ommers {
+			minerReward.Add(minerReward, perOmmer)
+
+			reward := big.NewInt(8)
+			reward.Sub(reward, new(big.Int).SetUint64(ommer.Delta))
+			reward.Mul(reward, blockReward)
+			reward.Div(reward, big.NewInt(8))
+			statedb.AddBalance(ommer.Address, reward)
+		}
+		statedb.AddBalance(pre.Env.Coinbase, minerReward)
+	}
+	// Commit block
+	root, err := statedb.Commit(chainConfig.IsEIP158(number))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not commit state: %v", err)
+	}
+	result := &ExecutionResult{
+		StateRoot:   root,
+		TxRoot:      types.DeriveSha(includedTxs),
+		ReceiptRoot: types.DeriveSha(receipts),
+		Bloom:       types.CreateBloom(receipts),
+		LogsHash:    rlpHash(statedb.Logs()),
+		Receipts:    receipts,
+		Rejected:    rejectedTxs,
+	}
+	return statedb, result, nil
+}
+
+// dumpAlloc converts the committed state into an allocation, suitable as the
+// prestate of a further transition.
+func dumpAlloc(statedb *state.StateDB) (core.GenesisAlloc, error) {
+	alloc := make(core.GenesisAlloc)
+	for addr, account := range statedb.RawDump().Accounts {
+		balance, ok := new(big.Int).SetString(account.Balance, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid balance %q of account %s", account.Balance, addr)
+		}
+		dumped := core.GenesisAccount{
+			Code:    common.FromHex(account.Code),
+			Balance: balance,
+			Nonce:   account.Nonce,
+		}
+		// Storage values are dumped in their RLP encoded trie form
+		for key, value := range account.Storage {
+			content, _, err := rlp.SplitString(common.FromHex(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid storage slot %s of account %s: %v", key, addr, err)
+			}
+			if dumped.Storage == nil {
+				dumped.Storage = make(map[common.Hash]common.Hash)
+			}
+			dumped.Storage[common.HexToHash(key)] = common.BytesToHash(content)
+		}
+		alloc[common.HexToAddress(addr)] = dumped
+	}
+	return alloc, nil
+}
+
+// rlpHash returns the Keccak256 hash of the RLP encoding of x.
+func rlpHash(x interface{}) (h common.Hash) {
+	hw := sha3.NewLegacyKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
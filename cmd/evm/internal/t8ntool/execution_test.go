@@ -0,0 +1,114 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/tests"
+)
+
+// noTracer disables tracing for all transactions.
+func noTracer(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+	return nil, nil
+}
+
+func TestApply(t *testing.T) {
+	var (
+		key, _    = crypto.HexToECDSA("45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8")
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.HexToAddress("0xb0")
+		coinbase  = common.HexToAddress("0xc0")
+		config    = tests.Forks["Istanbul"]
+		signer    = types.NewEIP155Signer(config.ChainID)
+	)
+	pre := &Prestate{
+		Env: stEnv{
+			Coinbase:   coinbase,
+			Difficulty: (*math.HexOrDecimal256)(big.NewInt(0x20000)),
+			GasLimit:   1000000,
+			Number:     1,
+			Timestamp:  1000,
+		},
+		Pre: core.GenesisAlloc{
+			sender: {Balance: big.NewInt(1000000000)},
+		},
+	}
+	// Transfer some value, create a contract storing 1 into slot 0 and send a
+	// transaction with a stale nonce
+	tx1, _ := types.SignTx(types.NewTransaction(0, recipient, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+	tx2, _ := types.SignTx(types.NewContractCreation(1, new(big.Int), 100000, big.NewInt(1), common.FromHex("600160005500")), signer, key)
+	tx3, _ := types.SignTx(types.NewTransaction(0, recipient, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+
+	statedb, result, err := pre.Apply(vm.Config{}, config, types.Transactions{tx1, tx2, tx3}, 2000, noTracer)
+	if err != nil {
+		t.Fatalf("failed to apply transactions: %v", err)
+	}
+	if !reflect.DeepEqual(result.Rejected, []int{2}) {
+		t.Errorf("rejected transactions mismatch: have %v, want [2]", result.Rejected)
+	}
+	if len(result.Receipts) != 2 {
+		t.Fatalf("receipt count mismatch: have %d, want 2", len(result.Receipts))
+	}
+	if receipt := result.Receipts[0]; receipt.Status != types.ReceiptStatusSuccessful || receipt.GasUsed != 21000 || receipt.TxHash != tx1.Hash() {
+		t.Errorf("transfer receipt mismatch: %+v", receipt)
+	}
+	created := crypto.CreateAddress(sender, 1)
+	if receipt := result.Receipts[1]; receipt.Status != types.ReceiptStatusSuccessful || receipt.ContractAddress != created {
+		t.Errorf("creation receipt mismatch: %+v", receipt)
+	}
+	if result.TxRoot != types.DeriveSha(types.Transactions{tx1, tx2}) {
+		t.Errorf("transaction root mismatch")
+	}
+	// Check the post state, including the fees and the mining reward
+	alloc, err := dumpAlloc(statedb)
+	if err != nil {
+		t.Fatalf("failed to dump alloc: %v", err)
+	}
+	fees := result.Receipts[1].CumulativeGasUsed
+	if have, want := alloc[coinbase].Balance.Uint64(), fees+2000; have != want {
+		t.Errorf("coinbase balance mismatch: have %d, want %d", have, want)
+	}
+	if have, want := alloc[sender].Balance.Uint64(), 1000000000-fees-1; have != want {
+		t.Errorf("sender balance mismatch: have %d, want %d", have, want)
+	}
+	if slot := alloc[created].Storage[common.Hash{}]; slot != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("contract storage mismatch: have %x", slot)
+	}
+	// Applying nothing to the dumped post state must reproduce the state root
+	blob, _ := json.Marshal(alloc)
+	pre.Pre = nil
+	if err := json.Unmarshal(blob, &pre.Pre); err != nil {
+		t.Fatalf("failed to decode dumped alloc: %v", err)
+	}
+	_, again, err := pre.Apply(vm.Config{}, config, nil, -1, noTracer)
+	if err != nil {
+		t.Fatalf("failed to apply dumped alloc: %v", err)
+	}
+	if again.StateRoot != result.StateRoot {
+		t.Errorf("state root mismatch after round trip: have %x, want %x", again.StateRoot, result.StateRoot)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package t8ntool implements the state transition tool of the evm command,
+// applying a set of transactions to a prestate and block environment.
+package t8ntool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// input is the combined input of the tool when read from stdin.
+type input struct {
+	Alloc core.GenesisAlloc  `json:"alloc,omitempty"`
+	Env   *stEnv             `json:"env,omitempty"`
+	Txs   types.Transactions `json:"txs,omitempty"`
+}
+
+// Main is the action of the transition command, reading the prestate, the
+// environment and the transactions, applying the transactions and writing the
+// post state allocation and the execution result.
+func Main(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	baseDir := ctx.String(OutputBasedir.Name)
+	if baseDir != "" {
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			return fmt.Errorf("failed creating output basedir: %v", err)
+		}
+	}
+	// Configure the per transaction tracers
+	getTracer := func(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+		return nil, nil
+	}
+	if ctx.Bool(TraceFlag.Name) {
+		logConfig := &vm.LogConfig{
+			DisableStack:  ctx.Bool(TraceDisableStackFlag.Name),
+			DisableMemory: ctx.Bool(TraceDisableMemoryFlag.Name),
+		}
+		var prevFile *os.File
+		// This one closes the last file
+		defer func() {
+			if prevFile != nil {
+				prevFile.Close()
+			}
+		}()
+		getTracer = func(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+			if prevFile != nil {
+				prevFile.Close()
+			}
+			traceFile, err := os.Create(filepath.Join(baseDir, fmt.Sprintf("trace-%d-%v.jsonl", txIndex, txHash.String())))
+			if err != nil {
+				return nil, fmt.Errorf("failed creating trace file: %v", err)
+			}
+			prevFile = traceFile
+			return vm.NewJSONLogger(logConfig, traceFile), nil
+		}
+	}
+	// Read the inputs, the ones from stdin are combined into a single object
+	var (
+		prestate Prestate
+		txs      types.Transactions
+		stdin    input
+	)
+	allocStr, envStr, txStr := ctx.String(InputAllocFlag.Name), ctx.String(InputEnvFlag.Name), ctx.String(InputTxsFlag.Name)
+	if allocStr == "stdin" || envStr == "stdin" || txStr == "stdin" {
+		if err := json.NewDecoder(os.Stdin).Decode(&stdin); err != nil {
+			return fmt.Errorf("failed unmarshaling stdin: %v", err)
+		}
+	}
+	if allocStr == "stdin" {
+		prestate.Pre = stdin.Alloc
+	} else if err := readJSON(allocStr, &prestate.Pre); err != nil {
+		return err
+	}
+	if envStr == "stdin" {
+		if stdin.Env == nil {
+			return fmt.Errorf("missing env in stdin")
+		}
+		prestate.Env = *stdin.Env
+	} else if err := readJSON(envStr, &prestate.Env); err != nil {
+		return err
+	}
+	if txStr == "stdin" {
+		txs = stdin.Txs
+	} else if err := readJSON(txStr, &txs); err != nil {
+		return err
+	}
+	// Select the ruleset to apply the transactions with
+	config, ok := tests.Forks[ctx.String(ForknameFlag.Name)]
+	if !ok {
+		return tests.UnsupportedForkError{Name: ctx.String(ForknameFlag.Name)}
+	}
+	chainConfig := *config
+	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Run the transactions and write the results
+	state, result, err := prestate.Apply(vm.Config{}, &chainConfig, txs, ctx.Int64(RewardFlag.Name), getTracer)
+	if err != nil {
+		return err
+	}
+	alloc, err := dumpAlloc(state)
+	if err != nil {
+		return err
+	}
+	if err := dispatchOutput(baseDir, ctx.String(OutputAllocFlag.Name), alloc); err != nil {
+		return err
+	}
+	return dispatchOutput(baseDir, ctx.String(OutputResultFlag.Name), result)
+}
+
+// readJSON decodes the JSON content of the given file into v.
+func readJSON(path string, v interface{}) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed reading %s: %v", path, err)
+	}
+	if err := json.Unmarshal(blob, v); err != nil {
+		return fmt.Errorf("failed unmarshaling %s: %v", path, err)
+	}
+	return nil
+}
+
+// dispatchOutput writes the JSON encoding of v to stdout, stderr or the given
+// file within the base directory.
+func dispatchOutput(baseDir string, dest string, v interface{}) error {
+	blob, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed marshaling output: %v", err)
+	}
+	switch dest {
+	case "stdout":
+		os.Stdout.Write(blob)
+		os.Stdout.WriteString("\n")
+	case "stderr":
+		os.Stderr.Write(blob)
+		os.Stderr.WriteString("\n")
+	default:
+		if err := ioutil.WriteFile(filepath.Join(baseDir, dest), blob, 0644); err != nil {
+			return fmt.Errorf("failed writing output: %v", err)
+		}
+	}
+	return nil
+}
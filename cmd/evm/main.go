@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"os"
 
+	"github.com/simplechain-org/simplechain/cmd/evm/internal/t8ntool"
 	"github.com/simplechain-org/simplechain/cmd/utils"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -110,8 +111,46 @@ var (
 		Name:  "nostack",
 		Usage: "disable stack output",
 	}
+	ProfileFlag = cli.BoolFlag{
+		Name:  "profile",
+		Usage: "displays the gas usage and execution time per opcode",
+	}
+	ProfileFlameGraphFlag = cli.StringFlag{
+		Name:  "profile.flamegraph",
+		Usage: "writes the gas usage per call stack to the given path in folded stack format",
+	}
+	ProfileHTMLFlag = cli.StringFlag{
+		Name:  "profile.html",
+		Usage: "writes an HTML coverage report with the gas usage per instruction to the given path",
+	}
+	ProfileSourcesFlag = cli.StringFlag{
+		Name:  "profile.combinedjson",
+		Usage: "solc --combined-json output with bin, bin-runtime, srcmap and srcmap-runtime, mapping the profile to source lines",
+	}
 )
 
+var transitionCommand = cli.Command{
+	Name:    "transition",
+	Aliases: []string{"t8n"},
+	Usage:   "executes a full state transition",
+	Action:  t8ntool.Main,
+	Flags: []cli.Flag{
+		t8ntool.TraceFlag,
+		t8ntool.TraceDisableMemoryFlag,
+		t8ntool.TraceDisableStackFlag,
+		t8ntool.OutputBasedir,
+		t8ntool.OutputAllocFlag,
+		t8ntool.OutputResultFlag,
+		t8ntool.InputAllocFlag,
+		t8ntool.InputEnvFlag,
+		t8ntool.InputTxsFlag,
+		t8ntool.ForknameFlag,
+		t8ntool.ChainIDFlag,
+		t8ntool.RewardFlag,
+		t8ntool.VerbosityFlag,
+	},
+}
+
 func init() {
 	app.Flags = []cli.Flag{
 		CreateFlag,
@@ -133,12 +172,17 @@ func init() {
 		ReceiverFlag,
 		DisableMemoryFlag,
 		DisableStackFlag,
+		ProfileFlag,
+		ProfileFlameGraphFlag,
+		ProfileHTMLFlag,
+		ProfileSourcesFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		transitionCommand,
 	}
 }
 
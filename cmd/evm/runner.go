@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"os"
@@ -28,6 +29,7 @@ import (
 	"time"
 
 	"github.com/simplechain-org/simplechain/cmd/evm/internal/compiler"
+	"github.com/simplechain-org/simplechain/cmd/evm/internal/profiler"
 	"github.com/simplechain-org/simplechain/cmd/utils"
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core"
@@ -82,6 +84,7 @@ func runCmd(ctx *cli.Context) error {
 	var (
 		tracer        vm.Tracer
 		debugLogger   *vm.StructLogger
+		profile       *profiler.Profiler
 		statedb       *state.StateDB
 		chainConfig   *params.ChainConfig
 		sender        = common.BytesToAddress([]byte("sender"))
@@ -96,6 +99,20 @@ func runCmd(ctx *cli.Context) error {
 	} else {
 		debugLogger = vm.NewStructLogger(logconfig)
 	}
+	if ctx.GlobalBool(ProfileFlag.Name) || ctx.GlobalString(ProfileFlameGraphFlag.Name) != "" || ctx.GlobalString(ProfileHTMLFlag.Name) != "" {
+		if tracer != nil {
+			utils.Fatalf("Profiling can't be combined with --%s or --%s", DebugFlag.Name, MachineFlag.Name)
+		}
+		var sources *profiler.Sources
+		if path := ctx.GlobalString(ProfileSourcesFlag.Name); path != "" {
+			var err error
+			if sources, err = profiler.LoadCombinedJSON(path); err != nil {
+				utils.Fatalf("Failed to load source maps: %v", err)
+			}
+		}
+		profile = profiler.New(sources)
+		tracer = profile
+	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		genesisConfig = gen
@@ -172,7 +189,7 @@ func runCmd(ctx *cli.Context) error {
 		BlockNumber: new(big.Int).SetUint64(genesisConfig.Number),
 		EVMConfig: vm.Config{
 			Tracer: tracer,
-			Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
+			Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name) || profile != nil,
 		},
 	}
 
@@ -245,7 +262,12 @@ Gas used:           %d
 
 `, execTime, mem.HeapObjects, mem.Alloc, mem.TotalAlloc, mem.NumGC, initialGas-leftOverGas)
 	}
-	if tracer == nil {
+	if profile != nil {
+		if err := writeProfile(ctx, profile); err != nil {
+			return err
+		}
+	}
+	if tracer == nil || profile != nil {
 		fmt.Printf("0x%x\n", ret)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
@@ -254,3 +276,36 @@ Gas used:           %d
 
 	return nil
 }
+
+// writeProfile displays the gas usage per opcode and writes the requested flame
+// graph and coverage reports.
+func writeProfile(ctx *cli.Context, profile *profiler.Profiler) error {
+	if ctx.GlobalBool(ProfileFlag.Name) {
+		fmt.Fprintln(os.Stderr, "#### PROFILE ####")
+		profile.WriteSummary(os.Stderr)
+	}
+	reports := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{ctx.GlobalString(ProfileFlameGraphFlag.Name), profile.WriteFlameGraph},
+		{ctx.GlobalString(ProfileHTMLFlag.Name), profile.WriteHTML},
+	}
+	for _, report := range reports {
+		if report.path == "" {
+			continue
+		}
+		f, err := os.Create(report.path)
+		if err != nil {
+			return err
+		}
+		if err := report.write(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -472,8 +472,12 @@ func (f *faucet) apiHandler(conn *websocket.Conn) {
 			amount = new(big.Int).Mul(amount, new(big.Int).Exp(big.NewInt(5), big.NewInt(int64(msg.Tier)), nil))
 			amount = new(big.Int).Div(amount, new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(msg.Tier)), nil))
 
+			var pending *big.Int
+			if f.head != nil {
+				pending = new(big.Int).Add(f.head.Number, big.NewInt(1))
+			}
 			tx := types.NewTransaction(f.nonce+uint64(len(f.reqs)), address, amount, 21000, f.price, nil)
-			signed, err := f.keystore.SignTx(f.account, tx, f.config.ChainID)
+			signed, err := f.keystore.SignTxWithSigner(f.account, tx, types.LatestSigner(f.config, pending))
 			if err != nil {
 				f.lock.Unlock()
 				if err = sendError(conn, err); err != nil {
@@ -79,6 +79,9 @@ var (
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
 		utils.WhitelistFlag,
+		utils.SyncCheckpointFlag,
+		utils.SyncCheckpointFileFlag,
+		utils.SyncCheckpointSignersFlag,
 		utils.CacheFlag,
 		utils.CacheDatabaseFlag,
 		utils.CacheTrieFlag,
@@ -87,6 +90,9 @@ var (
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
+		utils.BandwidthUploadFlag,
+		utils.BandwidthDownloadFlag,
+		utils.LightPropagationFlag,
 		utils.MiningEnabledFlag,
 		utils.MinerThreadsFlag,
 		utils.MinerLegacyThreadsFlag,
@@ -112,6 +118,9 @@ var (
 		utils.DeveloperPeriodFlag,
 		utils.TestnetFlag,
 		utils.VMEnableDebugFlag,
+		utils.BlockProfilingFlag,
+		utils.ParallelExecutionFlag,
+		utils.TransferIndexFlag,
 		utils.NetworkIdFlag,
 		utils.RPCCORSDomainFlag,
 		utils.RPCVirtualHostsFlag,
@@ -121,6 +130,8 @@ var (
 		utils.NoCompactionFlag,
 		utils.GpoBlocksFlag,
 		utils.GpoPercentileFlag,
+		utils.GpoSlowPercentileFlag,
+		utils.GpoFastPercentileFlag,
 		utils.EWASMInterpreterFlag,
 		utils.EVMInterpreterFlag,
 		configFileFlag,
@@ -139,6 +150,9 @@ var (
 		utils.IPCDisabledFlag,
 		utils.IPCPathFlag,
 		utils.RPCGlobalGasCap,
+		utils.RPCLogsMaxResultsFlag,
+		utils.RPCLogsMaxSpanFlag,
+		utils.RPCTraceMaxSpanFlag,
 	}
 
 	whisperFlags = []cli.Flag{
@@ -81,6 +81,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.LightPeersFlag,
 			utils.LightKDFFlag,
 			utils.WhitelistFlag,
+			utils.SyncCheckpointFlag,
+			utils.SyncCheckpointFileFlag,
+			utils.SyncCheckpointSignersFlag,
 		},
 	},
 	{
@@ -131,6 +134,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RPCPortFlag,
 			utils.RPCApiFlag,
 			utils.RPCGlobalGasCap,
+			utils.RPCLogsMaxResultsFlag,
+			utils.RPCLogsMaxSpanFlag,
+			utils.RPCTraceMaxSpanFlag,
 			utils.WSEnabledFlag,
 			utils.WSListenAddrFlag,
 			utils.WSPortFlag,
@@ -154,6 +160,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
+			utils.BandwidthUploadFlag,
+			utils.BandwidthDownloadFlag,
+			utils.LightPropagationFlag,
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
@@ -182,12 +191,17 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.GpoBlocksFlag,
 			utils.GpoPercentileFlag,
+			utils.GpoSlowPercentileFlag,
+			utils.GpoFastPercentileFlag,
 		},
 	},
 	{
 		Name: "VIRTUAL MACHINE",
 		Flags: []cli.Flag{
 			utils.VMEnableDebugFlag,
+			utils.BlockProfilingFlag,
+			utils.ParallelExecutionFlag,
+			utils.TransferIndexFlag,
 			utils.EVMInterpreterFlag,
 			utils.EWASMInterpreterFlag,
 		},
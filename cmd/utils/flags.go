@@ -156,7 +156,7 @@ var (
 	defaultSyncMode = eth.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "snap", "full", or "light")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -182,6 +182,18 @@ var (
 		Name:  "whitelist",
 		Usage: "Comma separated block number-to-hash mappings to enforce (<number>=<hash>)",
 	}
+	SyncCheckpointFlag = cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "Trusted block to fast or snap sync an empty chain from (<number>:<hash>:<td>)",
+	}
+	SyncCheckpointFileFlag = cli.StringFlag{
+		Name:  "checkpoint.file",
+		Usage: "Signed checkpoint file to fast or snap sync an empty chain from",
+	}
+	SyncCheckpointSignersFlag = cli.StringFlag{
+		Name:  "checkpoint.signers",
+		Usage: "Comma separated addresses of the trusted checkpoint file signers",
+	}
 	// Ethash settings
 	EthashCacheDirFlag = DirectoryFlag{
 		Name:  "ethash.cachedir",
@@ -379,10 +391,37 @@ var (
 		Name:  "vmdebug",
 		Usage: "Record information useful for VM and contract debugging",
 	}
+	BlockProfilingFlag = cli.BoolFlag{
+		Name:  "blockprofiling",
+		Usage: "Record a timing profile of every imported block (debug_profileBlock)",
+	}
+	ParallelExecutionFlag = cli.BoolFlag{
+		Name:  "parallelexec",
+		Usage: "Execute the transactions of imported blocks optimistically in parallel",
+	}
+	TransferIndexFlag = cli.BoolFlag{
+		Name:  "transferindex",
+		Usage: "Index the internal value transfers of imported blocks (eth_getInternalTransfers, requires --syncmode=full --gcmode=archive)",
+	}
 	RPCGlobalGasCap = cli.Uint64Flag{
 		Name:  "rpc.gascap",
 		Usage: "Sets a cap on gas that can be used in eth_call/estimateGas",
 	}
+	RPCLogsMaxResultsFlag = cli.IntFlag{
+		Name:  "rpc.logs.maxresults",
+		Usage: "Maximum number of logs returned by eth_getLogs or a single page of eth_getLogsPage (0 = unlimited)",
+		Value: eth.DefaultConfig.Filters.MaxResults,
+	}
+	RPCLogsMaxSpanFlag = cli.Uint64Flag{
+		Name:  "rpc.logs.maxspan",
+		Usage: "Maximum number of blocks searched by eth_getLogs or a single page of eth_getLogsPage (0 = unlimited)",
+		Value: eth.DefaultConfig.Filters.MaxBlockSpan,
+	}
+	RPCTraceMaxSpanFlag = cli.Uint64Flag{
+		Name:  "rpc.trace.maxspan",
+		Usage: "Maximum number of blocks traced by trace_filter (0 = unlimited)",
+		Value: eth.DefaultConfig.TraceFilterMaxSpan,
+	}
 	// Logging and debug settings
 	EthStatsURLFlag = cli.StringFlag{
 		Name:  "ethstats",
@@ -478,6 +517,20 @@ var (
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
 		Value: 0,
 	}
+	BandwidthUploadFlag = cli.Uint64Flag{
+		Name:  "bandwidth.upload",
+		Usage: "Maximum upload rate of the eth protocol in KB/s (0 = unlimited)",
+		Value: 0,
+	}
+	BandwidthDownloadFlag = cli.Uint64Flag{
+		Name:  "bandwidth.download",
+		Usage: "Maximum download rate of the eth protocol in KB/s (0 = unlimited)",
+		Value: 0,
+	}
+	LightPropagationFlag = cli.BoolFlag{
+		Name:  "propagation.light",
+		Usage: "Relay new blocks to all peers after checking their seal and body hashes, without waiting for their import",
+	}
 	ListenPortFlag = cli.IntFlag{
 		Name:  "port",
 		Usage: "Network listening port",
@@ -542,6 +595,16 @@ var (
 		Usage: "Suggested gas price is the given percentile of a set of recent transaction gas prices",
 		Value: eth.DefaultConfig.GPO.Percentile,
 	}
+	GpoSlowPercentileFlag = cli.IntFlag{
+		Name:  "gposlowpercentile",
+		Usage: "Slow gas price suggestion is the given percentile of recent gas prices (at most gpopercentile)",
+		Value: eth.DefaultConfig.GPO.SlowPercentile,
+	}
+	GpoFastPercentileFlag = cli.IntFlag{
+		Name:  "gpofastpercentile",
+		Usage: "Fast gas price suggestion is the given percentile of recent gas prices (at least gpopercentile)",
+		Value: eth.DefaultConfig.GPO.FastPercentile,
+	}
 	WhisperEnabledFlag = cli.BoolFlag{
 		Name:  "shh",
 		Usage: "Enable Whisper",
@@ -602,7 +665,7 @@ var (
 
 	EWASMInterpreterFlag = cli.StringFlag{
 		Name:  "vm.ewasm",
-		Usage: "External ewasm configuration (default = built-in interpreter)",
+		Usage: "Ewasm interpreter to enable even before the ewasm fork (\"builtin\" for the built-in one)",
 		Value: "",
 	}
 	EVMInterpreterFlag = cli.StringFlag{
@@ -982,6 +1045,12 @@ func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
 	if ctx.GlobalIsSet(GpoPercentileFlag.Name) {
 		cfg.Percentile = ctx.GlobalInt(GpoPercentileFlag.Name)
 	}
+	if ctx.GlobalIsSet(GpoSlowPercentileFlag.Name) {
+		cfg.SlowPercentile = ctx.GlobalInt(GpoSlowPercentileFlag.Name)
+	}
+	if ctx.GlobalIsSet(GpoFastPercentileFlag.Name) {
+		cfg.FastPercentile = ctx.GlobalInt(GpoFastPercentileFlag.Name)
+	}
 }
 
 func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
@@ -1071,6 +1140,32 @@ func setWhitelist(ctx *cli.Context, cfg *eth.Config) {
 	}
 }
 
+func setCheckpoint(ctx *cli.Context, cfg *eth.Config) {
+	if text := ctx.GlobalString(SyncCheckpointFlag.Name); text != "" {
+		checkpoint, err := downloader.ParseCheckpoint(text)
+		if err != nil {
+			Fatalf("Invalid checkpoint: %v", err)
+		}
+		cfg.SyncCheckpoint = checkpoint
+	}
+	if path := ctx.GlobalString(SyncCheckpointFileFlag.Name); path != "" {
+		var signers []common.Address
+		for _, signer := range strings.Split(ctx.GlobalString(SyncCheckpointSignersFlag.Name), ",") {
+			if trimmed := strings.TrimSpace(signer); trimmed != "" {
+				if !common.IsHexAddress(trimmed) {
+					Fatalf("Invalid checkpoint signer address: %s", trimmed)
+				}
+				signers = append(signers, common.HexToAddress(trimmed))
+			}
+		}
+		checkpoint, err := downloader.LoadCheckpointFile(path, signers)
+		if err != nil {
+			Fatalf("Failed to load checkpoint file: %v", err)
+		}
+		cfg.SyncCheckpoint = checkpoint
+	}
+}
+
 // checkExclusive verifies that only a single instance of the provided flags was
 // set by the user. Each flag might optionally be followed by a string type to
 // specialize it further.
@@ -1130,6 +1225,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	// Avoid conflicting network flags
 	checkExclusive(ctx, DeveloperFlag, TestnetFlag)
 	checkExclusive(ctx, LightServFlag, SyncModeFlag, "light")
+	checkExclusive(ctx, SyncCheckpointFlag, SyncCheckpointFileFlag)
 
 	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
 	setEtherbase(ctx, ks, cfg)
@@ -1137,6 +1233,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setTxPool(ctx, &cfg.TxPool)
 	setEthash(ctx, cfg)
 	setWhitelist(ctx, cfg)
+	setCheckpoint(ctx, cfg)
 
 	if ctx.GlobalIsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
@@ -1147,6 +1244,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(LightPeersFlag.Name) {
 		cfg.LightPeers = ctx.GlobalInt(LightPeersFlag.Name)
 	}
+	if ctx.GlobalIsSet(BandwidthUploadFlag.Name) {
+		cfg.UploadLimit = ctx.GlobalUint64(BandwidthUploadFlag.Name) * 1024
+	}
+	if ctx.GlobalIsSet(BandwidthDownloadFlag.Name) {
+		cfg.DownloadLimit = ctx.GlobalUint64(BandwidthDownloadFlag.Name) * 1024
+	}
+	if ctx.GlobalIsSet(LightPropagationFlag.Name) {
+		cfg.LightPropagation = ctx.GlobalBool(LightPropagationFlag.Name)
+	}
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
@@ -1203,9 +1309,21 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
 	}
+	if ctx.GlobalIsSet(BlockProfilingFlag.Name) {
+		cfg.BlockProfiling = ctx.GlobalBool(BlockProfilingFlag.Name)
+	}
+	if ctx.GlobalIsSet(ParallelExecutionFlag.Name) {
+		cfg.ParallelTxExecution = ctx.GlobalBool(ParallelExecutionFlag.Name)
+	}
+	if ctx.GlobalIsSet(TransferIndexFlag.Name) {
+		cfg.InternalTransferIndex = ctx.GlobalBool(TransferIndexFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(EWASMInterpreterFlag.Name) {
 		cfg.EWASMInterpreter = ctx.GlobalString(EWASMInterpreterFlag.Name)
+		if cfg.EWASMInterpreter != "" && cfg.EWASMInterpreter != vm.BuiltinEWASMInterpreter {
+			Fatalf("Unsupported ewasm interpreter %q, only %q is available", cfg.EWASMInterpreter, vm.BuiltinEWASMInterpreter)
+		}
 	}
 
 	if ctx.GlobalIsSet(EVMInterpreterFlag.Name) {
@@ -1214,6 +1332,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(RPCGlobalGasCap.Name) {
 		cfg.RPCGasCap = new(big.Int).SetUint64(ctx.GlobalUint64(RPCGlobalGasCap.Name))
 	}
+	if ctx.GlobalIsSet(RPCLogsMaxResultsFlag.Name) {
+		cfg.Filters.MaxResults = ctx.GlobalInt(RPCLogsMaxResultsFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCLogsMaxSpanFlag.Name) {
+		cfg.Filters.MaxBlockSpan = ctx.GlobalUint64(RPCLogsMaxSpanFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCTraceMaxSpanFlag.Name) {
+		cfg.TraceFilterMaxSpan = ctx.GlobalUint64(RPCTraceMaxSpanFlag.Name)
+	}
 
 	// Override any default configs for hard coded networks.
 	switch {
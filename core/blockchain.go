@@ -62,6 +62,7 @@ const (
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
+	profileCacheLimit   = 64
 	triesInMemory       = 128
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
@@ -136,6 +137,10 @@ type BlockChain struct {
 
 	badBlocks      *lru.Cache              // Bad block cache
 	shouldPreserve func(*types.Block) bool // Function used to determine whether should preserve the given block.
+
+	profiling int32      // Whether block imports are profiled (atomic)
+	profiles  *lru.Cache // Profiles of the most recently imported blocks
+	parallel  int32      // Whether block transactions are executed in parallel (atomic)
 }
 
 // NewBlockChain returns a fully initialised block chain using information
@@ -155,6 +160,7 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	blockCache, _ := lru.New(blockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
 	badBlocks, _ := lru.New(badBlockLimit)
+	profiles, _ := lru.New(profileCacheLimit)
 
 	bc := &BlockChain{
 		chainConfig:    chainConfig,
@@ -172,6 +178,7 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		engine:         engine,
 		vmConfig:       vmConfig,
 		badBlocks:      badBlocks,
+		profiles:       profiles,
 	}
 	bc.SetValidator(NewBlockValidator(chainConfig, bc, engine))
 	bc.SetProcessor(NewStateProcessor(chainConfig, bc, engine))
@@ -1201,9 +1208,15 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		if err != nil {
 			return it.index, events, coalescedLogs, err
 		}
+		// Enable profiling of the block if requested
+		var profile *BlockProfile
+		if atomic.LoadInt32(&bc.profiling) == 1 {
+			profile = newBlockProfile(block)
+			state.EnableProfiling()
+		}
 		// Process block using the parent state as reference point.
 		t0 := time.Now()
-		receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
+		receipts, logs, usedGas, err := bc.process(block, state, profile)
 		t1 := time.Now()
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
@@ -1227,6 +1240,13 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		blockExecutionTimer.Update(t1.Sub(t0))
 		blockValidationTimer.Update(t2.Sub(t1))
 		blockWriteTimer.Update(t3.Sub(t2))
+
+		if profile != nil {
+			profile.Execution, profile.Validation, profile.Write = t1.Sub(t0), t2.Sub(t1), t3.Sub(t2)
+			profile.collect(state)
+			profile.report()
+			bc.profiles.Add(block.Hash(), profile)
+		}
 		switch status {
 		case CanonStatTy:
 			log.Debug("Inserted new block", "number", block.Number(), "hash", block.Hash(),
@@ -1575,6 +1595,12 @@ func (bc *BlockChain) BadBlocks() []*types.Block {
 	return blocks
 }
 
+// HasBadBlock checks whether a block was recently rejected as invalid, as opposed
+// to failing to import for local reasons.
+func (bc *BlockChain) HasBadBlock(hash common.Hash) bool {
+	return bc.badBlocks.Contains(hash)
+}
+
 // addBadBlock adds a bad block to the bad-block LRU cache
 func (bc *BlockChain) addBadBlock(block *types.Block) {
 	bc.badBlocks.Add(block.Hash(), block)
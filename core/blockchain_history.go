@@ -0,0 +1,170 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/log"
+)
+
+var (
+	// errChainNotEmpty is returned if a checkpoint is inserted into a chain which
+	// already contains blocks beyond the genesis.
+	errChainNotEmpty = errors.New("chain not empty")
+
+	// errHistoryComplete is returned if history is inserted into a chain which is
+	// not missing any.
+	errHistoryComplete = errors.New("history complete")
+)
+
+// InsertCheckpoint anchors an empty chain at a trusted checkpoint block, making it
+// the head header and head fast block without any of the history leading up to
+// it. Syncing continues on top of the checkpoint, while the missing history can
+// be backfilled afterwards via InsertHistory.
+//
+// The total difficulty of the checkpoint is taken at face value, the chain below
+// it is never consulted for fork choice.
+func (bc *BlockChain) InsertCheckpoint(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	if block.NumberU64() == 0 {
+		return errors.New("checkpoint at genesis")
+	}
+	if head := bc.CurrentHeader(); head.Number.Uint64() != 0 {
+		return fmt.Errorf("%v: head header #%d [%x…]", errChainNotEmpty, head.Number, head.Hash().Bytes()[:4])
+	}
+	if err := SetReceiptsData(bc.chainConfig, block, receipts); err != nil {
+		return fmt.Errorf("failed to set receipts data: %v", err)
+	}
+	hash, number := block.Hash(), block.NumberU64()
+
+	batch := bc.db.NewBatch()
+	rawdb.WriteTd(batch, hash, number, td)
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, hash, number, receipts)
+	rawdb.WriteCanonicalHash(batch, hash, number)
+	rawdb.WriteTxLookupEntries(batch, block)
+	rawdb.WriteHistoryTailHash(batch, hash)
+	rawdb.WriteHeadFastBlockHash(batch, hash)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.mu.Lock()
+	bc.hc.SetCurrentHeader(block.Header())
+	bc.currentFastBlock.Store(block)
+	bc.mu.Unlock()
+
+	log.Info("Anchored chain at trusted checkpoint", "number", number, "hash", hash, "td", td)
+	return nil
+}
+
+// HistoryTail retrieves the header of the lowest block of a chain anchored at a
+// trusted checkpoint, or nil if the history is complete down to the genesis.
+func (bc *BlockChain) HistoryTail() *types.Header {
+	hash := rawdb.ReadHistoryTailHash(bc.db)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetHeaderByHash(hash)
+}
+
+// InsertHistory backfills a batch of blocks and receipts below the history tail
+// of a chain anchored at a trusted checkpoint. The blocks need to be in reverse
+// order, each one being the parent of the previous one, the first being the
+// parent of the current tail. The hash chain leading down from the checkpoint is
+// what authenticates them, the bodies and receipts are expected to be verified
+// against their headers by the caller.
+//
+// Once the history reaches the genesis block, the chain is complete and the tail
+// is dropped.
+func (bc *BlockChain) InsertHistory(blockChain types.Blocks, receiptChain []types.Receipts) (int, error) {
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	tail := bc.HistoryTail()
+	if tail == nil {
+		return 0, errHistoryComplete
+	}
+	var (
+		start = time.Now()
+		batch = bc.db.NewBatch()
+		bytes = 0
+	)
+	for i, block := range blockChain {
+		// Short circuit insertion if shutting down
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			return i, nil
+		}
+		if block.NumberU64() == 0 || block.NumberU64()+1 != tail.Number.Uint64() || block.Hash() != tail.ParentHash {
+			log.Error("Non contiguous history insert", "number", block.Number(), "hash", block.Hash(), "tailnumber", tail.Number, "tailparent", tail.ParentHash)
+			return i, fmt.Errorf("non contiguous history insert: item %d is #%d [%x…], tail is #%d (parent [%x…])", i, block.NumberU64(),
+				block.Hash().Bytes()[:4], tail.Number, tail.ParentHash.Bytes()[:4])
+		}
+		if err := SetReceiptsData(bc.chainConfig, block, receiptChain[i]); err != nil {
+			return i, fmt.Errorf("failed to set receipts data: %v", err)
+		}
+		rawdb.WriteBlock(batch, block)
+		rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receiptChain[i])
+		rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+		rawdb.WriteTxLookupEntries(batch, block)
+
+		tail = block.Header()
+		if tail.Number.Uint64() == 1 && tail.ParentHash != bc.genesisBlock.Hash() {
+			log.Error("Trusted checkpoint not descending from the genesis", "genesis", bc.genesisBlock.Hash(), "parent", tail.ParentHash)
+			return i, fmt.Errorf("history leads to genesis [%x…], want [%x…]", tail.ParentHash.Bytes()[:4], bc.genesisBlock.Hash().Bytes()[:4])
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			rawdb.WriteHistoryTailHash(batch, tail.Hash())
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			bytes += batch.ValueSize()
+			batch.Reset()
+		}
+	}
+	if tail.Number.Uint64() == 1 {
+		rawdb.DeleteHistoryTailHash(batch)
+	} else {
+		rawdb.WriteHistoryTailHash(batch, tail.Hash())
+	}
+	bytes += batch.ValueSize()
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	log.Info("Backfilled chain history", "count", len(blockChain), "elapsed", common.PrettyDuration(time.Since(start)),
+		"number", tail.Number, "hash", tail.Hash(), "size", common.StorageSize(bytes))
+	if tail.Number.Uint64() == 1 {
+		log.Info("Chain history complete down to the genesis")
+	}
+	return len(blockChain), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/consensus/scrypt"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/params"
+)
+
+// Tests that an empty chain can be anchored at a checkpoint block, and that the
+// history below it can be backfilled in reverse order down to the genesis.
+func TestCheckpointHistoryBackfill(t *testing.T) {
+	var (
+		gendb   = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), gendb, 64, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	chain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	// Anchor the chain at a checkpoint and ensure the history is marked missing
+	checkpoint := blocks[47]
+	if err := chain.InsertCheckpoint(checkpoint, receipts[47], big.NewInt(1)); err != nil {
+		t.Fatalf("failed to insert checkpoint: %v", err)
+	}
+	if err := chain.InsertCheckpoint(checkpoint, receipts[47], big.NewInt(1)); err == nil {
+		t.Fatalf("checkpoint inserted into non empty chain")
+	}
+	if head := chain.CurrentHeader(); head.Hash() != checkpoint.Hash() {
+		t.Fatalf("head header mismatch: have #%d, want #%d", head.Number, checkpoint.Number())
+	}
+	if head := chain.CurrentFastBlock(); head.Hash() != checkpoint.Hash() {
+		t.Fatalf("head fast block mismatch: have #%d, want #%d", head.Number(), checkpoint.Number())
+	}
+	if tail := chain.HistoryTail(); tail == nil || tail.Hash() != checkpoint.Hash() {
+		t.Fatalf("history tail mismatch: have %v, want #%d", tail, checkpoint.Number())
+	}
+	// Backfill the history in reverse, rejecting non contiguous batches
+	reverse := func(from, to int) (types.Blocks, []types.Receipts) {
+		var (
+			bs types.Blocks
+			rs []types.Receipts
+		)
+		for i := from; i >= to; i-- {
+			bs, rs = append(bs, blocks[i]), append(rs, receipts[i])
+		}
+		return bs, rs
+	}
+	if _, err := chain.InsertHistory(reverse(45, 30)); err == nil {
+		t.Fatalf("non contiguous history inserted")
+	}
+	if n, err := chain.InsertHistory(reverse(46, 20)); err != nil {
+		t.Fatalf("failed to insert history item %d: %v", n, err)
+	}
+	if tail := chain.HistoryTail(); tail == nil || tail.Hash() != blocks[20].Hash() {
+		t.Fatalf("history tail mismatch: have %v, want #%d", tail, blocks[20].Number())
+	}
+	if n, err := chain.InsertHistory(reverse(19, 0)); err != nil {
+		t.Fatalf("failed to insert history item %d: %v", n, err)
+	}
+	if tail := chain.HistoryTail(); tail != nil {
+		t.Fatalf("history tail present after backfill: #%d", tail.Number)
+	}
+	if _, err := chain.InsertHistory(reverse(0, 0)); err != errHistoryComplete {
+		t.Fatalf("history insert error mismatch: have %v, want %v", err, errHistoryComplete)
+	}
+	// Ensure the backfilled history is served from the canonical chain
+	for i := 0; i < 48; i++ {
+		if hash := rawdb.ReadCanonicalHash(db, uint64(i+1)); hash != blocks[i].Hash() {
+			t.Fatalf("canonical hash #%d mismatch: have %x, want %x", i+1, hash, blocks[i].Hash())
+		}
+		if stored := rawdb.ReadReceipts(db, blocks[i].Hash(), blocks[i].NumberU64()); types.DeriveSha(stored) != blocks[i].ReceiptHash() {
+			t.Fatalf("receipts #%d mismatch", i+1)
+		}
+		tx := blocks[i].Transactions()[0]
+		if _, hash, _, _ := rawdb.ReadTransaction(db, tx.Hash()); hash != blocks[i].Hash() {
+			t.Fatalf("transaction lookup #%d mismatch: have %x, want %x", i+1, hash, blocks[i].Hash())
+		}
+	}
+}
@@ -17,6 +17,9 @@
 package core
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -768,7 +771,10 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 }
 
 // Tests that chain reorganisations handle transaction removals and reinsertions.
-func TestChainTxReorgs(t *testing.T) {
+func TestChainTxReorgs(t *testing.T)         { testChainTxReorgs(t, false) }
+func TestChainTxReorgsParallel(t *testing.T) { testChainTxReorgs(t, true) }
+
+func testChainTxReorgs(t *testing.T, parallel bool) {
 	var (
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 		key2, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
@@ -826,6 +832,7 @@ func TestChainTxReorgs(t *testing.T) {
 	})
 	// Import the chain. This runs all block validation rules.
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
+	blockchain.SetParallelExecution(parallel)
 	if i, err := blockchain.InsertChain(chain); err != nil {
 		t.Fatalf("failed to insert original chain[%d]: %v", i, err)
 	}
@@ -883,7 +890,10 @@ func TestChainTxReorgs(t *testing.T) {
 	}
 }
 
-func TestLogReorgs(t *testing.T) {
+func TestLogReorgs(t *testing.T)         { testLogReorgs(t, false) }
+func TestLogReorgsParallel(t *testing.T) { testLogReorgs(t, true) }
+
+func testLogReorgs(t *testing.T, parallel bool) {
 	var (
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
@@ -897,6 +907,7 @@ func TestLogReorgs(t *testing.T) {
 
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	rmLogsCh := make(chan RemovedLogsEvent)
 	blockchain.SubscribeRemovedLogsEvent(rmLogsCh)
@@ -929,7 +940,10 @@ func TestLogReorgs(t *testing.T) {
 	}
 }
 
-func TestLogRebirth(t *testing.T) {
+func TestLogRebirth(t *testing.T)         { testLogRebirth(t, false) }
+func TestLogRebirthParallel(t *testing.T) { testLogRebirth(t, true) }
+
+func testLogRebirth(t *testing.T, parallel bool) {
 	var (
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
@@ -974,6 +988,7 @@ func TestLogRebirth(t *testing.T) {
 
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	logsCh := make(chan []*types.Log)
 	blockchain.SubscribeLogsEvent(logsCh)
@@ -1051,7 +1066,10 @@ func TestLogRebirth(t *testing.T) {
 	}
 }
 
-func TestSideLogRebirth(t *testing.T) {
+func TestSideLogRebirth(t *testing.T)         { testSideLogRebirth(t, false) }
+func TestSideLogRebirthParallel(t *testing.T) { testSideLogRebirth(t, true) }
+
+func testSideLogRebirth(t *testing.T, parallel bool) {
 	var (
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
@@ -1096,6 +1114,7 @@ func TestSideLogRebirth(t *testing.T) {
 
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	logsCh := make(chan []*types.Log)
 	blockchain.SubscribeLogsEvent(logsCh)
@@ -1136,7 +1155,10 @@ func TestSideLogRebirth(t *testing.T) {
 	}
 }
 
-func TestReorgSideEvent(t *testing.T) {
+func TestReorgSideEvent(t *testing.T)         { testReorgSideEvent(t, false) }
+func TestReorgSideEventParallel(t *testing.T) { testReorgSideEvent(t, true) }
+
+func testReorgSideEvent(t *testing.T, parallel bool) {
 	var (
 		db      = ethdb.NewMemDatabase()
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
@@ -1151,6 +1173,7 @@ func TestReorgSideEvent(t *testing.T) {
 
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	chain, _ := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), db, 3, func(i int, gen *BlockGen) {})
 	if _, err := blockchain.InsertChain(chain); err != nil {
@@ -1263,7 +1286,10 @@ func TestCanonicalBlockRetrieval(t *testing.T) {
 	pend.Wait()
 }
 
-func TestEIP155Transition(t *testing.T) {
+func TestEIP155Transition(t *testing.T)         { testEIP155Transition(t, false) }
+func TestEIP155TransitionParallel(t *testing.T) { testEIP155Transition(t, true) }
+
+func testEIP155Transition(t *testing.T, parallel bool) {
 	// Configure and generate a sample block chain
 	var (
 		db         = ethdb.NewMemDatabase()
@@ -1280,6 +1306,7 @@ func TestEIP155Transition(t *testing.T) {
 
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	blocks, _ := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), db, 4, func(i int, block *BlockGen) {
 		var (
@@ -1366,7 +1393,10 @@ func TestEIP155Transition(t *testing.T) {
 	}
 }
 
-func TestEIP161AccountRemoval(t *testing.T) {
+func TestEIP161AccountRemoval(t *testing.T)         { testEIP161AccountRemoval(t, false) }
+func TestEIP161AccountRemovalParallel(t *testing.T) { testEIP161AccountRemoval(t, true) }
+
+func testEIP161AccountRemoval(t *testing.T, parallel bool) {
 	// Configure and generate a sample block chain
 	var (
 		db      = ethdb.NewMemDatabase()
@@ -1387,6 +1417,7 @@ func TestEIP161AccountRemoval(t *testing.T) {
 	)
 	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
 	defer blockchain.Stop()
+	blockchain.SetParallelExecution(parallel)
 
 	blocks, _ := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), db, 3, func(i int, block *BlockGen) {
 		var (
@@ -1744,3 +1775,242 @@ func TestLowDiffLongChain(t *testing.T) {
 		header = chain.GetHeader(header.ParentHash, number-1)
 	}
 }
+
+// Tests that the gas of sponsored transactions is charged to the fee payer while
+// the value is still transferred from the sender.
+func TestSponsoredTransactionGas(t *testing.T) {
+	var (
+		db           = ethdb.NewMemDatabase()
+		senderKey, _ = crypto.GenerateKey()
+		payerKey, _  = crypto.GenerateKey()
+		sender       = crypto.PubkeyToAddress(senderKey.PublicKey)
+		payer        = crypto.PubkeyToAddress(payerKey.PublicKey)
+		recipient    = common.Address{0x01}
+		value        = big.NewInt(1000)
+		gasPrice     = big.NewInt(10)
+		payerFunds   = big.NewInt(1000000000)
+		gspec        = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{sender: {Balance: value}, payer: {Balance: payerFunds}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
+	defer blockchain.Stop()
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), db, 1, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(0, recipient, value, 50000, gasPrice, nil), signer, senderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = types.SignPayer(tx, payerKey); err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := blockchain.State()
+	if balance := statedb.GetBalance(sender); balance.Sign() != 0 {
+		t.Errorf("sender balance mismatch: have %v, want 0", balance)
+	}
+	if balance := statedb.GetBalance(recipient); balance.Cmp(value) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want %v", balance, value)
+	}
+	want := new(big.Int).Sub(payerFunds, new(big.Int).Mul(gasPrice, big.NewInt(int64(params.TxGas))))
+	if balance := statedb.GetBalance(payer); balance.Cmp(want) != 0 {
+		t.Errorf("payer balance mismatch: have %v, want %v", balance, want)
+	}
+}
+
+// Tests that block imports are profiled if enabled, and that the profiles of
+// blocks imported without profiling can be reconstructed by re-execution.
+func TestBlockProfiling(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		key, _  = crypto.GenerateKey()
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
+	defer blockchain.Stop()
+
+	// Generate the chain on a separate database to keep its states off the disk
+	gendb := ethdb.NewMemDatabase()
+	gspec.MustCommit(gendb)
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), gendb, 3, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	// Profile the import of the middle block only: the first one is re-executed
+	// on the genesis state on disk, the last one on a state only held in memory
+	for i, block := range blocks {
+		blockchain.SetProfiling(i == 1)
+		if _, err := blockchain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blockchain.SetProfiling(false)
+
+	for i, block := range blocks {
+		profile, err := blockchain.ProfileBlock(block.Hash())
+		if err != nil {
+			t.Fatalf("block %d: failed to profile: %v", i, err)
+		}
+		if profile.Hash != block.Hash() || profile.Number != block.NumberU64() {
+			t.Errorf("block %d: profile mismatch: have #%d [%x], want #%d [%x]", i, profile.Number, profile.Hash, block.NumberU64(), block.Hash())
+		}
+		if reexec := i != 1; profile.Reexecuted != reexec {
+			t.Errorf("block %d: reexecution mismatch: have %v, want %v", i, profile.Reexecuted, reexec)
+		}
+		if len(profile.Transactions) != 1 || profile.Transactions[0].Hash != block.Transactions()[0].Hash() {
+			t.Errorf("block %d: transaction profiles mismatch: %v", i, profile.Transactions)
+		} else if profile.Transactions[0].GasUsed != params.TxGas {
+			t.Errorf("block %d: transaction gas mismatch: have %d, want %d", i, profile.Transactions[0].GasUsed, params.TxGas)
+		}
+		if _, ok := profile.Reads[address]; !ok {
+			t.Errorf("block %d: missing state reads of sender", i)
+		}
+		if profile.Execution == 0 || profile.Hashing == 0 {
+			t.Errorf("block %d: missing timings: execution %v, hashing %v", i, profile.Execution, profile.Hashing)
+		}
+		// States only held in memory must not be committed into the live cache again
+		if committed := i != 2; (profile.Commit != 0) != committed {
+			t.Errorf("block %d: commit mismatch: have %v, want committed %v", i, profile.Commit, committed)
+		}
+	}
+}
+
+// Tests that the parallel execution of block transactions produces the same
+// state and receipts as the sequential one, both for independent and for
+// conflicting transactions, and with the workers' state copies reused across
+// multiple transactions.
+func TestParallelExecution(t *testing.T) {
+	var (
+		db    = ethdb.NewMemDatabase()
+		keys  = make([]*ecdsa.PrivateKey, 4)
+		addrs = make([]common.Address, len(keys))
+		alloc = GenesisAlloc{
+			// Storage counter incrementing slot 0 and logging
+			common.Address{0xc0}: {Code: common.FromHex("600054600101600055600060006000a000"), Balance: big.NewInt(0)},
+			// Self destructing contract, sending its funds to the caller
+			common.Address{0xde}: {Code: common.FromHex("33ff"), Balance: big.NewInt(1000)},
+		}
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+		alloc[addrs[i]] = GenesisAccount{Balance: big.NewInt(1000000000000000)}
+	}
+	gspec := &Genesis{Config: params.TestChainConfig, Alloc: alloc}
+	genesis := gspec.MustCommit(db)
+	signer := types.NewEIP155Signer(gspec.Config.ChainID)
+
+	blocks, receipts := GenerateChain(gspec.Config, genesis, scrypt.NewFaker(), db, 6, func(i int, block *BlockGen) {
+		send := func(key int, to *common.Address, value int64, gas uint64, data []byte) {
+			var tx *types.Transaction
+			if to == nil {
+				tx = types.NewContractCreation(block.TxNonce(addrs[key]), big.NewInt(value), gas, big.NewInt(1), data)
+			} else {
+				tx = types.NewTransaction(block.TxNonce(addrs[key]), *to, big.NewInt(value), gas, big.NewInt(1), data)
+			}
+			tx, err := types.SignTx(tx, signer, keys[key])
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(tx)
+		}
+		counter, destruct := common.Address{0xc0}, common.Address{0xde}
+		switch i {
+		case 0:
+			// Independent transfers, shared recipient
+			for key := range keys {
+				send(key, &common.Address{0x01}, 1, params.TxGas, nil)
+			}
+		case 1:
+			// Same sender transactions and transfers between the senders
+			send(0, &addrs[1], 1000, params.TxGas, nil)
+			send(0, &addrs[2], 1000, params.TxGas, nil)
+			send(1, &addrs[0], 1000, params.TxGas, nil)
+			send(3, &common.Address{0x02}, 0, params.TxGas, nil)
+		case 2:
+			// Contract storage conflicts and contract creations
+			send(0, &counter, 0, 100000, nil)
+			send(1, &counter, 0, 100000, nil)
+			send(2, nil, 0, 100000, []byte{0x00})
+			send(3, &counter, 0, 100000, nil)
+		case 3:
+			// Coinbase interactions and self destructs
+			block.SetCoinbase(addrs[0])
+			send(1, &destruct, 0, 100000, nil)
+			send(0, &addrs[2], 1, params.TxGas, nil)
+			send(2, &destruct, 5, 100000, nil)
+			send(3, &addrs[0], 1, params.TxGas, nil)
+		case 4:
+			// Empty accounts touched and deleted
+			block.SetCoinbase(common.Address{0x03})
+			send(0, &common.Address{0x04}, 0, params.TxGas, nil)
+			send(1, &common.Address{0x04}, 0, params.TxGas, nil)
+			send(2, &counter, 0, 100000, nil)
+		case 5:
+			// More transactions than workers, interleaving conflicting and
+			// independent ones executed on the same state copies
+			for j := 0; j < 16; j++ {
+				for key := range keys {
+					if j%2 == 0 {
+						send(key, &counter, 0, 100000, nil)
+					} else {
+						send(key, &common.Address{byte(0x10 + j)}, 1, params.TxGas, nil)
+					}
+				}
+			}
+		}
+	})
+	// Import the chain both sequentially and in parallel, comparing the results
+	chains := make([]*BlockChain, 2)
+	for i := range chains {
+		chaindb := ethdb.NewMemDatabase()
+		gspec.MustCommit(chaindb)
+
+		chains[i], _ = NewBlockChain(chaindb, nil, gspec.Config, scrypt.NewFaker(), vm.Config{}, nil)
+		defer chains[i].Stop()
+
+		chains[i].SetParallelExecution(i == 1)
+		if n, err := chains[i].InsertChain(blocks); err != nil {
+			t.Fatalf("parallel %v: block %d: failed to insert into chain: %v", i == 1, n, err)
+		}
+	}
+	for i, block := range blocks {
+		want, _ := json.Marshal(rawdb.ReadReceipts(chains[0].db, block.Hash(), block.NumberU64()))
+		have, _ := json.Marshal(rawdb.ReadReceipts(chains[1].db, block.Hash(), block.NumberU64()))
+		if !bytes.Equal(have, want) {
+			t.Errorf("block %d: receipt mismatch:\nhave %s\nwant %s", i, have, want)
+		}
+		if len(receipts[i]) != len(block.Transactions()) {
+			t.Errorf("block %d: receipt count mismatch: have %d, want %d", i, len(receipts[i]), len(block.Transactions()))
+		}
+	}
+	statedb, _ := chains[1].State()
+	if statedb.GetState(common.Address{0xc0}, common.Hash{}) != common.BigToHash(big.NewInt(36)) {
+		t.Errorf("counter mismatch: have %x, want 36", statedb.GetState(common.Address{0xc0}, common.Hash{}))
+	}
+	for j := 1; j < 16; j += 2 {
+		if balance := statedb.GetBalance(common.Address{byte(0x10 + j)}); balance.Cmp(big.NewInt(int64(len(keys)))) != 0 {
+			t.Errorf("recipient %d: balance mismatch: have %v, want %d", j, balance, len(keys))
+		}
+	}
+	if code := statedb.GetCode(common.Address{0xde}); len(code) != 0 {
+		t.Errorf("self destructed contract code still exists: %x", code)
+	}
+}
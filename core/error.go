@@ -32,4 +32,8 @@ var (
 	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrSponsorNotActive is returned if a sponsored transaction is included or
+	// submitted before the fork activating fee payer sponsorship.
+	ErrSponsorNotActive = errors.New("sponsored transactions not yet activated")
 )
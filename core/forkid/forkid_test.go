@@ -0,0 +1,185 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rlp"
+)
+
+var rinkebyGenesisHash = common.HexToHash("0x6341fd3daf94b748c72ced5a5b26028f2474f5f00d824504e4fa37a75767e177")
+
+// TestCreation tests that different genesis and fork rule combinations result
+// in the correct fork ID.
+func TestCreation(t *testing.T) {
+	// The chain specific forks are checksummed like the Ethereum ones
+	scrypt := *params.MainnetChainConfig
+	scrypt.SigningDomainBlock = big.NewInt(100)
+	scrypt.CryptoBlock = big.NewInt(200)
+	scrypt.EWASMBlock = big.NewInt(200)
+
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		config  *params.ChainConfig
+		genesis common.Hash
+		cases   []testcase
+	}{
+		// Mainnet test cases, every fork is activated at genesis
+		{
+			params.MainnetChainConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x41a1c9dd), Next: 0}},
+				{10000000, ID{Hash: checksumToBytes(0x41a1c9dd), Next: 0}},
+			},
+		},
+		// Rinkeby test cases
+		{
+			params.RinkebyChainConfig,
+			rinkebyGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x3b8e0691), Next: 1}},             // Unsynced, last Frontier block
+				{1, ID{Hash: checksumToBytes(0x60949295), Next: 2}},             // First and last Homestead block
+				{2, ID{Hash: checksumToBytes(0x8bde40dd), Next: 3}},             // First and last Tangerine block
+				{3, ID{Hash: checksumToBytes(0xcb3a64bb), Next: 1035301}},       // First Spurious block
+				{1035300, ID{Hash: checksumToBytes(0xcb3a64bb), Next: 1035301}}, // Last Spurious block
+				{1035301, ID{Hash: checksumToBytes(0x8d748b57), Next: 3660663}}, // First Byzantium block
+				{3660662, ID{Hash: checksumToBytes(0x8d748b57), Next: 3660663}}, // Last Byzantium block
+				{3660663, ID{Hash: checksumToBytes(0xe49cab14), Next: 4321234}}, // First Constantinople block
+				{4321233, ID{Hash: checksumToBytes(0xe49cab14), Next: 4321234}}, // Last Constantinople block
+				{4321234, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}},       // First Petersburg block
+				{5000000, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}},       // Future Petersburg block
+			},
+		},
+		// Chain specific fork test cases, the crypto and ewasm forks share a block
+		{
+			&scrypt,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x41a1c9dd), Next: 100}},
+				{99, ID{Hash: checksumToBytes(0x41a1c9dd), Next: 100}},
+				{100, ID{Hash: checksumToBytes(0x5cb3700f), Next: 200}},
+				{199, ID{Hash: checksumToBytes(0x5cb3700f), Next: 200}},
+				{200, ID{Hash: checksumToBytes(0xa468147d), Next: 0}},
+			},
+		},
+	}
+	for i, tt := range tests {
+		for j, ttt := range tt.cases {
+			if have := newID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
+			}
+		}
+	}
+}
+
+// TestValidation tests that a local peer correctly validates and accepts a remote
+// fork ID.
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local is Petersburg, remote announces the same. No future fork is announced.
+		{4321234, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, nil},
+
+		// Local is Petersburg, remote announces the same. Remote also announces a next fork
+		// at block 0xffffffff, but that is uncertain.
+		{4321234, ID{Hash: checksumToBytes(0xafec6b27), Next: math.MaxUint32}, nil},
+
+		// Local is Byzantium, remote announces Byzantium and also Constantinople at the
+		// expected block. Remote is simply not yet updated to the next fork.
+		{3660662, ID{Hash: checksumToBytes(0x8d748b57), Next: 3660663}, nil},
+
+		// Local is Byzantium, remote announces Byzantium, but Constantinople at a later
+		// block than ours. Not passed it yet, this might still be postponed.
+		{3660662, ID{Hash: checksumToBytes(0x8d748b57), Next: 4000000}, nil},
+
+		// Local is Constantinople, remote announces Byzantium with a Constantinople
+		// block after ours. Remote is not on our chain, reject.
+		{3660663, ID{Hash: checksumToBytes(0x8d748b57), Next: 4000000}, ErrRemoteStale},
+
+		// Local is Byzantium, remote announces Byzantium with a Constantinople block
+		// before our head. We already passed it without forking, reject.
+		{3660662, ID{Hash: checksumToBytes(0x8d748b57), Next: 3000000}, ErrLocalIncompatibleOrStale},
+
+		// Local is Petersburg, remote announces Byzantium with Constantinople at the
+		// correct block. Remote is simply syncing, accept.
+		{4321234, ID{Hash: checksumToBytes(0x8d748b57), Next: 3660663}, nil},
+
+		// Local is Petersburg, remote announces Spurious with Byzantium at the correct
+		// block. Remote is simply syncing, accept.
+		{4321234, ID{Hash: checksumToBytes(0xcb3a64bb), Next: 1035301}, nil},
+
+		// Local is Byzantium, remote announces Petersburg. Local is out of sync, accept.
+		{1035301, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, nil},
+
+		// Local is Spurious, remote announces Byzantium but is not aware of further
+		// forks. Local is out of sync, accept.
+		{3, ID{Hash: checksumToBytes(0x8d748b57), Next: 0}, nil},
+
+		// Local is Petersburg, remote announces Byzantium with an unknown next fork.
+		// Remote needs the software update, reject.
+		{4321234, ID{Hash: checksumToBytes(0x8d748b57), Next: 0}, ErrRemoteStale},
+
+		// Local is Petersburg, remote announces a chain diverging at genesis, reject.
+		{4321234, ID{Hash: checksumToBytes(0x41a1c9dd), Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is Petersburg, remote announces a fork unknown to us. Local needs the
+		// software update, reject.
+		{4321234, ID{Hash: checksumToBytes(0xdeadbeef), Next: 0}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(params.RinkebyChainConfig, rinkebyGenesisHash, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that IDs are properly RLP encoded (specifically important because we
+// use uint32 to store the hash, but we need to encode it as [4]byte).
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		id   ID
+		want []byte
+	}{
+		{ID{Hash: checksumToBytes(0), Next: 0}, common.Hex2Bytes("c6840000000080")},
+		{ID{Hash: checksumToBytes(0xdeadbeef), Next: 0xBADDCAFE}, common.Hex2Bytes("ca84deadbeef84baddcafe")},
+		{ID{Hash: checksumToBytes(math.MaxUint32), Next: math.MaxUint64}, common.Hex2Bytes("ce84ffffffff88ffffffffffffffff")},
+	}
+	for i, tt := range tests {
+		have, err := rlp.EncodeToBytes(tt.id)
+		if err != nil {
+			t.Errorf("test %d: failed to encode forkid: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: RLP mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/consensus/misc"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/metrics"
+)
+
+var (
+	parallelSpeculatedMeter = metrics.NewRegisteredMeter("chain/parallel/speculated", nil)
+	parallelConflictMeter   = metrics.NewRegisteredMeter("chain/parallel/conflicts", nil)
+)
+
+// accessKind enumerates the parts of an account a transaction may access.
+type accessKind uint8
+
+const (
+	accessBalance accessKind = iota
+	accessNonce
+	accessCode
+	accessStorage
+)
+
+// accessKey identifies a single piece of state accessed by a transaction.
+type accessKey struct {
+	addr common.Address
+	kind accessKind
+	slot common.Hash // Only set for storage accesses
+}
+
+// accountResult is the state of an account written by a transaction, as left
+// behind by its execution.
+type accountResult struct {
+	exists  bool     // Whether the account survived the transaction
+	balance *big.Int // Balance of the account, zero if it doesn't exist
+	nonce   uint64
+	code    []byte
+}
+
+// accessTracker wraps a StateDB, recording the pieces of state a transaction
+// executed on top of it reads and writes.
+//
+// Operations replacing account data (e.g. nonce updates, suicides) are always
+// considered to also read the account. The only blind writes are balance
+// changes not preceded by any read of the account (e.g. the fee payment to the
+// coinbase), which are commutative and replayed as deltas.
+type accessTracker struct {
+	*state.StateDB
+
+	reads     map[accessKey]struct{}
+	writes    map[accessKey]struct{}
+	balances  map[common.Address]*big.Int // Balances prior to the first balance change
+	created   map[common.Address]struct{} // Accounts (re)created by the transaction
+	preimages map[common.Hash][]byte      // Preimages recorded by the transaction
+	untracked bool                        // Whether the accesses could not be tracked
+
+	accounts map[common.Address]*accountResult // Written accounts, captured by seal
+	storage  map[accessKey]common.Hash         // Written storage slots, captured by seal
+	logs     []*types.Log                      // Logs emitted, captured by seal
+}
+
+// newAccessTracker creates a tracker recording the accesses to statedb.
+func newAccessTracker(statedb *state.StateDB) *accessTracker {
+	return &accessTracker{
+		StateDB:   statedb,
+		reads:     make(map[accessKey]struct{}),
+		writes:    make(map[accessKey]struct{}),
+		balances:  make(map[common.Address]*big.Int),
+		created:   make(map[common.Address]struct{}),
+		preimages: make(map[common.Hash][]byte),
+	}
+}
+
+// readAccount marks the given parts of an account as read.
+func (t *accessTracker) readAccount(addr common.Address, kinds ...accessKind) {
+	for _, kind := range kinds {
+		t.reads[accessKey{addr: addr, kind: kind}] = struct{}{}
+	}
+}
+
+// writeAccount marks the given parts of an account as written.
+func (t *accessTracker) writeAccount(addr common.Address, kinds ...accessKind) {
+	for _, kind := range kinds {
+		t.writes[accessKey{addr: addr, kind: kind}] = struct{}{}
+	}
+}
+
+// replaceAccount marks the entire account as read and written.
+func (t *accessTracker) replaceAccount(addr common.Address) {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	t.writeAccount(addr, accessBalance, accessNonce, accessCode)
+}
+
+// changeBalance marks the balance of an account as written, remembering the
+// original balance for replaying the change as a delta.
+func (t *accessTracker) changeBalance(addr common.Address) {
+	if _, ok := t.balances[addr]; !ok {
+		t.balances[addr] = t.StateDB.GetBalance(addr)
+	}
+	t.writeAccount(addr, accessBalance)
+}
+
+func (t *accessTracker) CreateAccount(addr common.Address) {
+	t.replaceAccount(addr)
+	t.created[addr] = struct{}{}
+	t.StateDB.CreateAccount(addr)
+}
+
+func (t *accessTracker) SubBalance(addr common.Address, amount *big.Int) {
+	t.changeBalance(addr)
+	t.StateDB.SubBalance(addr, amount)
+}
+
+func (t *accessTracker) AddBalance(addr common.Address, amount *big.Int) {
+	t.changeBalance(addr)
+	t.StateDB.AddBalance(addr, amount)
+}
+
+func (t *accessTracker) GetBalance(addr common.Address) *big.Int {
+	t.readAccount(addr, accessBalance)
+	return t.StateDB.GetBalance(addr)
+}
+
+func (t *accessTracker) GetNonce(addr common.Address) uint64 {
+	t.readAccount(addr, accessNonce)
+	return t.StateDB.GetNonce(addr)
+}
+
+func (t *accessTracker) SetNonce(addr common.Address, nonce uint64) {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	t.writeAccount(addr, accessNonce)
+	t.StateDB.SetNonce(addr, nonce)
+}
+
+func (t *accessTracker) GetCodeHash(addr common.Address) common.Hash {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode) // zero hash for non-existent accounts
+	return t.StateDB.GetCodeHash(addr)
+}
+
+func (t *accessTracker) GetCode(addr common.Address) []byte {
+	t.readAccount(addr, accessCode)
+	return t.StateDB.GetCode(addr)
+}
+
+func (t *accessTracker) SetCode(addr common.Address, code []byte) {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	t.writeAccount(addr, accessCode)
+	t.StateDB.SetCode(addr, code)
+}
+
+func (t *accessTracker) GetCodeSize(addr common.Address) int {
+	t.readAccount(addr, accessCode)
+	return t.StateDB.GetCodeSize(addr)
+}
+
+func (t *accessTracker) GetCommittedState(addr common.Address, slot common.Hash) common.Hash {
+	t.reads[accessKey{addr: addr, kind: accessStorage, slot: slot}] = struct{}{}
+	return t.StateDB.GetCommittedState(addr, slot)
+}
+
+func (t *accessTracker) GetState(addr common.Address, slot common.Hash) common.Hash {
+	t.reads[accessKey{addr: addr, kind: accessStorage, slot: slot}] = struct{}{}
+	return t.StateDB.GetState(addr, slot)
+}
+
+func (t *accessTracker) SetState(addr common.Address, slot common.Hash, value common.Hash) {
+	key := accessKey{addr: addr, kind: accessStorage, slot: slot}
+	t.reads[key] = struct{}{}
+	t.writes[key] = struct{}{}
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	t.StateDB.SetState(addr, slot, value)
+}
+
+func (t *accessTracker) Suicide(addr common.Address) bool {
+	t.replaceAccount(addr)
+	return t.StateDB.Suicide(addr)
+}
+
+func (t *accessTracker) HasSuicided(addr common.Address) bool {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	return t.StateDB.HasSuicided(addr)
+}
+
+func (t *accessTracker) Exist(addr common.Address) bool {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	return t.StateDB.Exist(addr)
+}
+
+func (t *accessTracker) Empty(addr common.Address) bool {
+	t.readAccount(addr, accessBalance, accessNonce, accessCode)
+	return t.StateDB.Empty(addr)
+}
+
+func (t *accessTracker) AddPreimage(hash common.Hash, preimage []byte) {
+	t.preimages[hash] = preimage
+	t.StateDB.AddPreimage(hash, preimage)
+}
+
+func (t *accessTracker) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	t.untracked = true
+	t.StateDB.ForEachStorage(addr, cb)
+}
+
+// conflicts reports whether the transaction read any state modified by the
+// previously applied transactions.
+func (t *accessTracker) conflicts(written map[accessKey]struct{}) bool {
+	if t.untracked {
+		return true
+	}
+	// Iterate over the smaller set for the lookups
+	if len(t.reads) < len(written) {
+		for key := range t.reads {
+			if _, ok := written[key]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for key := range written {
+		if _, ok := t.reads[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// seal captures the results of the executed transaction, i.e. the final state
+// of everything it wrote and the logs it emitted, and detaches the tracker from
+// the underlying state. Accounts destructed or, if deleteEmpty is set, left
+// empty are considered deleted, the same way as finalising the state would.
+// The state may be rolled back and reused once the tracker is sealed.
+func (t *accessTracker) seal(txhash common.Hash, deleteEmpty bool) {
+	t.accounts = make(map[common.Address]*accountResult)
+	t.storage = make(map[accessKey]common.Hash)
+
+	for key := range t.writes {
+		if _, ok := t.accounts[key.addr]; !ok {
+			result := &accountResult{balance: new(big.Int)}
+			if t.StateDB.Exist(key.addr) && !t.StateDB.HasSuicided(key.addr) && !(deleteEmpty && t.StateDB.Empty(key.addr)) {
+				result.exists = true
+				result.balance.Set(t.StateDB.GetBalance(key.addr))
+				result.nonce = t.StateDB.GetNonce(key.addr)
+				result.code = common.CopyBytes(t.StateDB.GetCode(key.addr))
+			}
+			t.accounts[key.addr] = result
+		}
+		if key.kind == accessStorage {
+			t.storage[key] = t.StateDB.GetState(key.addr, key.slot)
+		}
+	}
+	t.logs = append([]*types.Log(nil), t.StateDB.GetLogs(txhash)...)
+	t.StateDB = nil
+}
+
+// apply replays the state changes captured by the sealed tracker on top of
+// statedb. The state must only differ from the one the transaction was executed
+// on in parts the transaction did not read.
+func (t *accessTracker) apply(statedb *state.StateDB) {
+	replaced := make(map[common.Address]struct{})
+	for key := range t.writes {
+		addr := key.addr
+		if _, ok := t.reads[accessKey{addr: addr, kind: accessBalance}]; ok {
+			replaced[addr] = struct{}{}
+			continue
+		}
+		// Blind balance changes can't depend on the account, replay as a delta
+		delta := new(big.Int).Sub(t.accounts[addr].balance, t.balances[addr])
+		if delta.Sign() >= 0 {
+			statedb.AddBalance(addr, delta)
+		} else {
+			statedb.SubBalance(addr, delta.Neg(delta))
+		}
+	}
+	for addr := range replaced {
+		result := t.accounts[addr]
+		if !result.exists {
+			// The account was destructed or deleted as empty
+			if statedb.Exist(addr) && !statedb.HasSuicided(addr) {
+				statedb.Suicide(addr)
+			}
+			continue
+		}
+		if _, ok := t.created[addr]; ok {
+			statedb.CreateAccount(addr)
+		}
+		if result.balance.Cmp(statedb.GetBalance(addr)) != 0 {
+			statedb.SetBalance(addr, result.balance)
+		}
+		if result.nonce != statedb.GetNonce(addr) {
+			statedb.SetNonce(addr, result.nonce)
+		}
+		if !bytes.Equal(result.code, statedb.GetCode(addr)) {
+			statedb.SetCode(addr, result.code)
+		}
+	}
+	// Storage writes must be replayed after the account (re)creations
+	for key, value := range t.storage {
+		if !t.accounts[key.addr].exists {
+			continue
+		}
+		if value != statedb.GetState(key.addr, key.slot) {
+			statedb.SetState(key.addr, key.slot, value)
+		}
+	}
+	for hash, preimage := range t.preimages {
+		statedb.AddPreimage(hash, preimage)
+	}
+}
+
+// SetParallelExecution enables or disables the optimistic parallel execution of
+// the transactions of imported blocks.
+func (bc *BlockChain) SetParallelExecution(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&bc.parallel, 1)
+	} else {
+		atomic.StoreInt32(&bc.parallel, 0)
+	}
+}
+
+// speculation is the outcome of executing a transaction on top of the state
+// preceding the block, concurrently with the rest of the block's transactions.
+type speculation struct {
+	tracker *accessTracker
+	msg     types.Message
+	gas     uint64
+	failed  bool
+	err     error
+}
+
+// processParallel processes the block's transactions optimistically in
+// parallel. Every worker executes its transactions on its own copy of the state
+// prior to the block, rolling the copy back after each one, after which the
+// results are applied in order. Transactions which
+// read state modified by a preceding one are re-executed serially, so the
+// resulting state and receipts are identical to those of sequential execution.
+func (p *StateProcessor) processParallel(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	var (
+		receipts types.Receipts
+		usedGas  = new(uint64)
+		header   = block.Header()
+		allLogs  []*types.Log
+		gp       = new(GasPool).AddGas(block.GasLimit())
+		txs      = block.Transactions()
+		signer   = types.MakeSigner(p.config, header.Number)
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	// Speculatively execute all the transactions concurrently
+	specs := make([]*speculation, len(txs))
+	for i := range txs {
+		specs[i] = new(speculation)
+	}
+	var (
+		pend  sync.WaitGroup
+		tasks = make(chan int, len(txs))
+	)
+	for i := range txs {
+		tasks <- i
+	}
+	close(tasks)
+
+	threads := runtime.NumCPU()
+	if threads > len(txs) {
+		threads = len(txs)
+	}
+	pend.Add(threads)
+	for th := 0; th < threads; th++ {
+		go func() {
+			defer pend.Done()
+
+			// Copy the state only once the worker has something to execute
+			var local *state.StateDB
+			for i := range tasks {
+				if local == nil {
+					local = statedb.Copy()
+				}
+				p.speculate(block, header, txs[i], i, signer, local, specs[i], cfg)
+			}
+		}()
+	}
+	pend.Wait()
+
+	// Apply the speculative results in order, re-executing conflicting ones
+	written := make(map[accessKey]struct{})
+	conflicts := 0
+	for i, tx := range txs {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		var (
+			spec    = specs[i]
+			tracker = spec.tracker
+			msg     = spec.msg
+			gas     = spec.gas
+			failed  = spec.failed
+		)
+		if spec.err == nil && gp.Gas() >= msg.Gas() && !tracker.conflicts(written) {
+			if err := gp.SubGas(gas); err != nil {
+				return nil, nil, 0, err
+			}
+			tracker.apply(statedb)
+			for _, log := range tracker.logs {
+				statedb.AddLog(log)
+			}
+		} else {
+			conflicts++
+
+			if tx.Sponsored() && !p.config.IsSponsor(header.Number) {
+				return nil, nil, 0, ErrSponsorNotActive
+			}
+			var err error
+			if msg, err = tx.AsMessage(signer); err != nil {
+				return nil, nil, 0, err
+			}
+			tracker = newAccessTracker(statedb)
+			vmenv := vm.NewEVM(NewEVMContext(msg, header, p.bc, nil), tracker, p.config, cfg)
+			if _, gas, failed, err = ApplyMessage(vmenv, msg, gp); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		for key := range tracker.writes {
+			written[key] = struct{}{}
+		}
+		receipt := finaliseTransaction(p.config, statedb, header, tx, msg, usedGas, gas, failed)
+
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	parallelSpeculatedMeter.Mark(int64(len(txs)))
+	parallelConflictMeter.Mark(int64(conflicts))
+	log.Trace("Executed transactions in parallel", "number", block.Number(), "txs", len(txs), "conflicts", conflicts)
+
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	p.engine.Finalize(p.bc, header, statedb, txs, block.Uncles(), receipts)
+
+	return receipts, allLogs, *usedGas, nil
+}
+
+// speculate executes a transaction on top of the given worker's state copy,
+// sealing the results into the speculation and rolling the copy back for the
+// next transaction. Execution errors are stored in the speculation, deferring
+// the transaction to the serial path.
+func (p *StateProcessor) speculate(block *types.Block, header *types.Header, tx *types.Transaction, index int, signer types.Signer, statedb *state.StateDB, spec *speculation, cfg vm.Config) {
+	snapshot := statedb.Snapshot()
+	defer statedb.RevertToSnapshot(snapshot)
+
+	spec.tracker = newAccessTracker(statedb)
+	statedb.Prepare(tx.Hash(), block.Hash(), index)
+
+	if tx.Sponsored() && !p.config.IsSponsor(header.Number) {
+		spec.err = ErrSponsorNotActive
+		return
+	}
+	if spec.msg, spec.err = tx.AsMessage(signer); spec.err != nil {
+		return
+	}
+	vmenv := vm.NewEVM(NewEVMContext(spec.msg, header, p.bc, nil), spec.tracker, p.config, cfg)
+	if _, spec.gas, spec.failed, spec.err = ApplyMessage(vmenv, spec.msg, new(GasPool).AddGas(header.GasLimit)); spec.err != nil {
+		return
+	}
+	// Capture the results the same way as the sequential processing would
+	// finalise them, the copy is rolled back afterwards
+	spec.tracker.seal(tx.Hash(), p.config.IsByzantium(header.Number) || p.config.IsEIP158(header.Number))
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/metrics"
+)
+
+var (
+	profileSenderHistogram       = newProfileHistogram("chain/profile/senders")
+	profileExecutionHistogram    = newProfileHistogram("chain/profile/execution")
+	profileTxExecutionHistogram  = newProfileHistogram("chain/profile/txexecution")
+	profileAccountReadsHistogram = newProfileHistogram("chain/profile/account/reads")
+	profileStorageReadsHistogram = newProfileHistogram("chain/profile/storage/reads")
+	profileHashingHistogram      = newProfileHistogram("chain/profile/hashing")
+	profileCommitHistogram       = newProfileHistogram("chain/profile/commit")
+)
+
+// newProfileHistogram creates and registers a histogram tracking the durations
+// (in nanoseconds) of one of the block processing stages.
+func newProfileHistogram(name string) metrics.Histogram {
+	return metrics.NewRegisteredHistogram(name, nil, metrics.NewExpDecaySample(1028, 0.015))
+}
+
+// TxProfile is the execution profile of a single transaction within a block.
+type TxProfile struct {
+	Hash      common.Hash   `json:"hash"`
+	GasUsed   uint64        `json:"gasUsed"`
+	Execution time.Duration `json:"execution"`
+}
+
+// BlockProfile is a breakdown of where the time went while processing and
+// importing a single block. All durations are in nanoseconds.
+type BlockProfile struct {
+	Number     uint64      `json:"number"`
+	Hash       common.Hash `json:"hash"`
+	GasUsed    uint64      `json:"gasUsed"`
+	Reexecuted bool        `json:"reexecuted"` // Whether the profile was taken after import by re-executing the block
+
+	SenderRecovery time.Duration `json:"senderRecovery"` // Time spent recovering transaction senders and fee payers
+	Execution      time.Duration `json:"execution"`      // Time spent processing the block, including sender recovery
+	Validation     time.Duration `json:"validation"`     // Time spent validating the post state, including hashing
+	Write          time.Duration `json:"write"`          // Time spent writing the block, including the state commit
+
+	AccountReads time.Duration `json:"accountReads"` // Time spent loading accounts from the state trie
+	StorageReads time.Duration `json:"storageReads"` // Time spent loading storage slots from the storage tries
+	Hashing      time.Duration `json:"hashing"`      // Time spent hashing the state tries
+	Commit       time.Duration `json:"commit"`       // Time spent committing the state tries
+
+	Transactions []*TxProfile                        `json:"transactions"`
+	Reads        map[common.Address]*state.ReadStats `json:"reads"`
+}
+
+// newBlockProfile creates an empty profile for the given block.
+func newBlockProfile(block *types.Block) *BlockProfile {
+	return &BlockProfile{
+		Number:       block.NumberU64(),
+		Hash:         block.Hash(),
+		GasUsed:      block.GasUsed(),
+		Transactions: make([]*TxProfile, 0, len(block.Transactions())),
+	}
+}
+
+// collect gathers the database measurements of the state the block was
+// processed on into the profile.
+func (p *BlockProfile) collect(statedb *state.StateDB) {
+	prof := statedb.Profile()
+	if prof == nil {
+		return
+	}
+	p.AccountReads = prof.AccountReads()
+	p.StorageReads = prof.StorageReads()
+	p.Hashing = prof.Hashing
+	p.Commit = prof.Commit
+	p.Reads = prof.Reads
+}
+
+// report updates the block processing histograms in the metrics registry.
+func (p *BlockProfile) report() {
+	profileSenderHistogram.Update(int64(p.SenderRecovery))
+	profileExecutionHistogram.Update(int64(p.Execution))
+	for _, tx := range p.Transactions {
+		profileTxExecutionHistogram.Update(int64(tx.Execution))
+	}
+	profileAccountReadsHistogram.Update(int64(p.AccountReads))
+	profileStorageReadsHistogram.Update(int64(p.StorageReads))
+	profileHashingHistogram.Update(int64(p.Hashing))
+	profileCommitHistogram.Update(int64(p.Commit))
+}
+
+// blockProfiler is implemented by processors able to break down the time spent
+// processing a block.
+type blockProfiler interface {
+	Profile(block *types.Block, statedb *state.StateDB, cfg vm.Config, profile *BlockProfile) (types.Receipts, []*types.Log, uint64, error)
+}
+
+// SetProfiling enables or disables the profiling of block imports. Profiles of
+// the recently imported blocks can be retrieved via ProfileBlock.
+func (bc *BlockChain) SetProfiling(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&bc.profiling, 1)
+	} else {
+		atomic.StoreInt32(&bc.profiling, 0)
+	}
+}
+
+// process runs the block through the configured processor, recording a profile
+// of its execution if requested and supported by the processor.
+func (bc *BlockChain) process(block *types.Block, statedb *state.StateDB, profile *BlockProfile) (types.Receipts, []*types.Log, uint64, error) {
+	processor := bc.Processor()
+	if profiler, ok := processor.(blockProfiler); ok && profile != nil {
+		return profiler.Profile(block, statedb, bc.vmConfig, profile)
+	}
+	return processor.Process(block, statedb, bc.vmConfig)
+}
+
+// ProfileBlock returns the import profile of the block with the given hash. If
+// the block was not profiled during import, it is re-executed on top of its
+// parent state, which must be available. The write and commit of re-executed
+// blocks are only measured if the parent state was already flushed to disk.
+func (bc *BlockChain) ProfileBlock(hash common.Hash) (*BlockProfile, error) {
+	if profile, ok := bc.profiles.Get(hash); ok {
+		return profile.(*BlockProfile), nil
+	}
+	block := bc.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", hash)
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not profilable")
+	}
+	parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	processor, ok := bc.Processor().(blockProfiler)
+	if !ok {
+		return nil, errors.New("block processor does not support profiling")
+	}
+	// Execute on a throwaway database over the disk, so that committing the
+	// recreated state cannot pollute the live trie cache. Recent states are only
+	// available in memory though, which are executed on the live database with
+	// the commit skipped.
+	commit := true
+	statedb, err := state.New(parent.Root(), state.NewDatabase(bc.db))
+	if err != nil {
+		if statedb, err = state.New(parent.Root(), bc.stateCache); err != nil {
+			return nil, err
+		}
+		commit = false
+	}
+	statedb.EnableProfiling()
+
+	profile := newBlockProfile(block)
+	profile.Reexecuted = true
+
+	start := time.Now()
+	receipts, _, usedGas, err := processor.Profile(block, statedb, bc.vmConfig, profile)
+	if err != nil {
+		return nil, err
+	}
+	profile.Execution = time.Since(start)
+
+	start = time.Now()
+	if err := bc.Validator().ValidateState(block, parent, statedb, receipts, usedGas); err != nil {
+		return nil, err
+	}
+	profile.Validation = time.Since(start)
+
+	if commit {
+		start = time.Now()
+		if _, err := statedb.Commit(bc.chainConfig.IsEIP158(block.Number())); err != nil {
+			return nil, err
+		}
+		profile.Write = time.Since(start)
+	}
+
+	profile.collect(statedb)
+	return profile, nil
+}
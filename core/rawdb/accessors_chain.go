@@ -126,6 +126,32 @@ func WriteFastTrieProgress(db DatabaseWriter, count uint64) {
 	}
 }
 
+// ReadHistoryTailHash retrieves the hash of the lowest block of a chain anchored
+// at a trusted checkpoint, below which the history is still missing.
+func ReadHistoryTailHash(db DatabaseReader) common.Hash {
+	data, _ := db.Get(historyTailKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteHistoryTailHash stores the hash of the lowest block of a chain anchored
+// at a trusted checkpoint.
+func WriteHistoryTailHash(db DatabaseWriter, hash common.Hash) {
+	if err := db.Put(historyTailKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store history tail's hash", "err", err)
+	}
+}
+
+// DeleteHistoryTailHash removes the history tail marker once the chain has been
+// backfilled down to the genesis block.
+func DeleteHistoryTailHash(db DatabaseDeleter) {
+	if err := db.Delete(historyTailKey); err != nil {
+		log.Crit("Failed to delete history tail's hash", "err", err)
+	}
+}
+
 // ReadHeaderRLP retrieves a block header in its raw RLP database encoding.
 func ReadHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(number, hash))
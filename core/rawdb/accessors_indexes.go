@@ -105,3 +105,46 @@ func WriteBloomBits(db DatabaseWriter, bit uint, section uint64, head common.Has
 		log.Crit("Failed to store bloom bits", "err", err)
 	}
 }
+
+// HasInternalTransfers checks if the internal value transfers of a block were
+// indexed.
+func HasInternalTransfers(db DatabaseReader, hash common.Hash, number uint64) bool {
+	if has, err := db.Has(internalTransfersKey(number, hash)); !has || err != nil {
+		return false
+	}
+	return true
+}
+
+// ReadInternalTransfers retrieves all the internal value transfers made by the
+// transactions of a block.
+func ReadInternalTransfers(db DatabaseReader, hash common.Hash, number uint64) []*types.InternalTransfer {
+	data, _ := db.Get(internalTransfersKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var transfers []*types.InternalTransfer
+	if err := rlp.DecodeBytes(data, &transfers); err != nil {
+		log.Error("Invalid internal transfer array RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return transfers
+}
+
+// WriteInternalTransfers stores all the internal value transfers made by the
+// transactions of a block. An empty list marks the block indexed.
+func WriteInternalTransfers(db DatabaseWriter, hash common.Hash, number uint64, transfers []*types.InternalTransfer) {
+	data, err := rlp.EncodeToBytes(transfers)
+	if err != nil {
+		log.Crit("Failed to encode internal transfers", "err", err)
+	}
+	if err := db.Put(internalTransfersKey(number, hash), data); err != nil {
+		log.Crit("Failed to store internal transfers", "err", err)
+	}
+}
+
+// DeleteInternalTransfers removes all the internal value transfers of a block.
+func DeleteInternalTransfers(db DatabaseDeleter, hash common.Hash, number uint64) {
+	if err := db.Delete(internalTransfersKey(number, hash)); err != nil {
+		log.Crit("Failed to delete internal transfers", "err", err)
+	}
+}
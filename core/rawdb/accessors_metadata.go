@@ -95,3 +95,18 @@ func WritePreimages(db DatabaseWriter, preimages map[common.Hash][]byte) {
 	preimageCounter.Inc(int64(len(preimages)))
 	preimageHitCounter.Inc(int64(len(preimages)))
 }
+
+// ReadPeerReputations retrieves the RLP encoded reputation scores of the remote
+// peers, persisted by the previous session.
+func ReadPeerReputations(db DatabaseReader) []byte {
+	data, _ := db.Get(peerReputationKey)
+	return data
+}
+
+// WritePeerReputations stores the RLP encoded reputation scores of the remote
+// peers for the next session.
+func WritePeerReputations(db DatabaseWriter, scores []byte) {
+	if err := db.Put(peerReputationKey, scores); err != nil {
+		log.Crit("Failed to store peer reputations", "err", err)
+	}
+}
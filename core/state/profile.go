@@ -0,0 +1,76 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+)
+
+// ReadStats contains the database reads done on behalf of a single account.
+type ReadStats struct {
+	Account time.Duration `json:"account"` // Time spent loading the account from the trie
+	Storage time.Duration `json:"storage"` // Time spent loading storage slots from the trie
+	Slots   int           `json:"slots"`   // Number of storage slots loaded from the trie
+}
+
+// Profile accumulates the time a StateDB spends in the underlying database and
+// tries. It is only maintained if profiling was enabled via EnableProfiling.
+type Profile struct {
+	Reads   map[common.Address]*ReadStats // Trie reads, grouped by account
+	Hashing time.Duration                 // Time spent hashing the account and storage tries
+	Commit  time.Duration                 // Time spent committing the tries into the database
+}
+
+// AccountReads returns the total time spent loading accounts.
+func (p *Profile) AccountReads() (total time.Duration) {
+	for _, stats := range p.Reads {
+		total += stats.Account
+	}
+	return total
+}
+
+// StorageReads returns the total time spent loading storage slots.
+func (p *Profile) StorageReads() (total time.Duration) {
+	for _, stats := range p.Reads {
+		total += stats.Storage
+	}
+	return total
+}
+
+// stats returns the read statistics of an account, creating them if needed.
+func (p *Profile) stats(addr common.Address) *ReadStats {
+	stats, ok := p.Reads[addr]
+	if !ok {
+		stats = new(ReadStats)
+		p.Reads[addr] = stats
+	}
+	return stats
+}
+
+// EnableProfiling starts tracking the time spent in database reads, hashing and
+// commits, returning the profile the measurements are accumulated into.
+func (self *StateDB) EnableProfiling() *Profile {
+	self.profile = &Profile{Reads: make(map[common.Address]*ReadStats)}
+	return self.profile
+}
+
+// Profile returns the accumulated profile, or nil if profiling is disabled.
+func (self *StateDB) Profile() *Profile {
+	return self.profile
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"math/big"
 	"sort"
+	"time"
 
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core/types"
@@ -86,6 +87,9 @@ type StateDB struct {
 	journal        *journal
 	validRevisions []revision
 	nextRevisionId int
+
+	// Timing measurements, only collected if profiling is enabled
+	profile *Profile
 }
 
 // Create a new state from a given trie.
@@ -360,6 +364,15 @@ func (self *StateDB) SetState(addr common.Address, key, value common.Hash) {
 	}
 }
 
+// SetStorage replaces the entire storage for the specified account with given
+// storage. This function should only be used for debugging.
+func (self *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common.Hash) {
+	stateObject := self.GetOrNewStateObject(addr)
+	if stateObject != nil {
+		stateObject.SetStorage(storage)
+	}
+}
+
 // Suicide marks the given account as suicided.
 // This clears the account balance.
 //
@@ -413,6 +426,9 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 	}
 
 	// Load the object from the database.
+	if self.profile != nil {
+		defer func(start time.Time) { self.profile.stats(addr).Account += time.Since(start) }(time.Now())
+	}
 	enc, err := self.trie.TryGet(addr[:])
 	if len(enc) == 0 {
 		self.setError(err)
@@ -599,6 +615,9 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 // It is called in between transactions to get the root hash that
 // goes into transaction receipts.
 func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
+	if s.profile != nil {
+		defer func(start time.Time) { s.profile.Hashing += time.Since(start) }(time.Now())
+	}
 	s.Finalise(deleteEmptyObjects)
 	return s.trie.Hash()
 }
@@ -621,6 +640,9 @@ func (s *StateDB) clearJournalAndRefund() {
 func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error) {
 	defer s.clearJournalAndRefund()
 
+	if s.profile != nil {
+		defer func(start time.Time) { s.profile.Commit += time.Since(start) }(time.Now())
+	}
 	for addr := range s.journal.dirties {
 		s.stateObjectsDirty[addr] = struct{}{}
 	}
@@ -421,6 +421,24 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 	}
 }
 
+// Tests that writes into the overridden storage of an account are journaled, so
+// reverting a snapshot restores the overridden values.
+func TestOverriddenStorageRevert(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("0x0a")
+	state.SetStorage(addr, map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))})
+
+	snapshot := state.Snapshot()
+	state.SetState(addr, common.Hash{}, common.BigToHash(big.NewInt(2)))
+	if have, want := state.GetState(addr, common.Hash{}), common.BigToHash(big.NewInt(2)); have != want {
+		t.Fatalf("store into overridden storage lost: have %x, want %x", have, want)
+	}
+	state.RevertToSnapshot(snapshot)
+	if have, want := state.GetState(addr, common.Hash{}), common.BigToHash(big.NewInt(1)); have != want {
+		t.Errorf("reverted store kept in overridden storage: have %x, want %x", have, want)
+	}
+}
+
 // TestCopyOfCopy tests that modified objects are carried over to the copy, and the copy of the copy.
 // See https://github.com/simplechain-org/simplechain/pull/15225#issuecomment-380191512
 func TestCopyOfCopy(t *testing.T) {
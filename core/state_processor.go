@@ -17,6 +17,9 @@
 package core
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/consensus"
 	"github.com/simplechain-org/simplechain/consensus/misc"
@@ -54,6 +57,20 @@ func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consen
 // returns the amount of gas that was used in the process. If any of the
 // transactions failed to execute due to insufficient gas it will return an error.
 func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	if p.bc != nil && atomic.LoadInt32(&p.bc.parallel) == 1 && !cfg.Debug && len(block.Transactions()) > 1 {
+		return p.processParallel(block, statedb, cfg)
+	}
+	return p.process(block, statedb, cfg, nil)
+}
+
+// Profile processes the block just like Process does, additionally recording
+// the time spent in sender recovery and in the execution of the individual
+// transactions into the given profile.
+func (p *StateProcessor) Profile(block *types.Block, statedb *state.StateDB, cfg vm.Config, profile *BlockProfile) (types.Receipts, []*types.Log, uint64, error) {
+	return p.process(block, statedb, cfg, profile)
+}
+
+func (p *StateProcessor) process(block *types.Block, statedb *state.StateDB, cfg vm.Config, profile *BlockProfile) (types.Receipts, []*types.Log, uint64, error) {
 	var (
 		receipts types.Receipts
 		usedGas  = new(uint64)
@@ -65,13 +82,32 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 		misc.ApplyDAOHardFork(statedb)
 	}
+	// Recover the senders upfront if profiling, so it's not attributed to execution
+	if profile != nil {
+		start := time.Now()
+		signer := types.MakeSigner(p.config, header.Number)
+		for _, tx := range block.Transactions() {
+			types.Sender(signer, tx)
+			types.Payer(signer, tx)
+		}
+		profile.SenderRecovery = time.Since(start)
+	}
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
+		start := time.Now()
+
 		statedb.Prepare(tx.Hash(), block.Hash(), i)
 		receipt, _, err := ApplyTransaction(p.config, p.bc, nil, gp, statedb, header, tx, usedGas, cfg)
 		if err != nil {
 			return nil, nil, 0, err
 		}
+		if profile != nil {
+			profile.Transactions = append(profile.Transactions, &TxProfile{
+				Hash:      tx.Hash(),
+				GasUsed:   receipt.GasUsed,
+				Execution: time.Since(start),
+			})
+		}
 		receipts = append(receipts, receipt)
 		allLogs = append(allLogs, receipt.Logs...)
 	}
@@ -86,6 +122,9 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
+	if tx.Sponsored() && !config.IsSponsor(header.Number) {
+		return nil, 0, ErrSponsorNotActive
+	}
 	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
 	if err != nil {
 		return nil, 0, err
@@ -100,6 +139,12 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	if err != nil {
 		return nil, 0, err
 	}
+	return finaliseTransaction(config, statedb, header, tx, msg, usedGas, gas, failed), gas, nil
+}
+
+// finaliseTransaction updates the state with the pending changes of an applied
+// transaction and creates its receipt.
+func finaliseTransaction(config *params.ChainConfig, statedb *state.StateDB, header *types.Header, tx *types.Transaction, msg types.Message, usedGas *uint64, gas uint64, failed bool) *types.Receipt {
 	// Update the state with pending changes
 	var root []byte
 	if config.IsByzantium(header.Number) {
@@ -116,11 +161,11 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.GasUsed = gas
 	// if the transaction created a contract, store the creation address in the receipt.
 	if msg.To() == nil {
-		receipt.ContractAddress = crypto.CreateAddress(vmenv.Context.Origin, tx.Nonce())
+		receipt.ContractAddress = crypto.CreateAddress(msg.From(), tx.Nonce())
 	}
 	// Set the receipt logs and create a bloom for filtering
 	receipt.Logs = statedb.GetLogs(tx.Hash())
 	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 
-	return receipt, gas, err
+	return receipt
 }
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math"
@@ -44,6 +45,10 @@ var (
 	// ErrInvalidSender is returned if the transaction contains an invalid signature.
 	ErrInvalidSender = errors.New("invalid sender")
 
+	// ErrInvalidPayer is returned if a sponsored transaction contains an invalid
+	// fee payer signature.
+	ErrInvalidPayer = errors.New("invalid fee payer")
+
 	// ErrNonceTooLow is returned if the nonce of a transaction is lower than the
 	// one present in the local chain.
 	ErrNonceTooLow = errors.New("nonce too low")
@@ -60,6 +65,10 @@ var (
 	// is higher than the balance of the user's account.
 	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
 
+	// ErrInsufficientPayerFunds is returned if the fee payer of a sponsored
+	// transaction cannot cover the gas of executing it.
+	ErrInsufficientPayerFunds = errors.New("insufficient fee payer funds for gas * price")
+
 	// ErrIntrinsicGas is returned if the transaction is specified to use less gas
 	// than required to start the invocation.
 	ErrIntrinsicGas = errors.New("intrinsic gas too low")
@@ -230,6 +239,8 @@ type TxPool struct {
 	wg sync.WaitGroup // for shutdown sync
 
 	homestead bool
+	istanbul  bool // Fork indicator whether we are in the istanbul stage.
+	sponsor   bool // Fork indicator whether sponsored transactions are accepted.
 }
 
 // NewTxPool creates a new transaction pool to gather, sort and filter inbound
@@ -434,6 +445,16 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	pool.pendingState = state.ManageState(statedb)
 	pool.currentMaxGas = newHead.GasLimit
 
+	// Update the fork indicator for the next pending block
+	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
+	pool.istanbul = pool.chainconfig.IsIstanbul(next)
+	pool.sponsor = pool.chainconfig.IsSponsor(next)
+
+	if signer := types.LatestSigner(pool.chainconfig, next); !signer.Equal(pool.signer) {
+		pool.signer = signer
+		pool.locals.signer = signer
+	}
+
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	senderCacher.recover(pool.signer, reinject)
@@ -590,6 +611,10 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tx.Size() > 32*1024 {
 		return ErrOversizedData
 	}
+	// Reject sponsored transactions until the fork activating them
+	if tx.Sponsored() && !pool.sponsor {
+		return ErrSponsorNotActive
+	}
 	// Transactions can't be negative. This may never happen using RLP decoded
 	// transactions but may occur if you create a transaction using the RPC.
 	if tx.Value().Sign() < 0 {
@@ -615,10 +640,31 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	}
 	// Transactor should have enough funds to cover the costs
 	// cost == V + GP * GL
-	if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
-		return ErrInsufficientFunds
+	if !tx.Sponsored() {
+		if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
+			return ErrInsufficientFunds
+		}
+	} else {
+		// Sponsored transactions split the cost: the sender covers the value
+		// and the fee payer covers GP * GL
+		payer, err := types.Payer(pool.signer, tx)
+		if err != nil {
+			return ErrInvalidPayer
+		}
+		if payer == from {
+			if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
+				return ErrInsufficientFunds
+			}
+		} else {
+			if pool.currentState.GetBalance(from).Cmp(tx.Value()) < 0 {
+				return ErrInsufficientFunds
+			}
+			if pool.currentState.GetBalance(payer).Cmp(tx.GasCost()) < 0 {
+				return ErrInsufficientPayerFunds
+			}
+		}
 	}
-	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
+	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead, pool.istanbul)
 	if err != nil {
 		return err
 	}
@@ -893,6 +939,12 @@ func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
 	return pool.all.Get(hash)
 }
 
+// Has returns an indicator whether txpool has a transaction cached with the
+// given hash.
+func (pool *TxPool) Has(hash common.Hash) bool {
+	return pool.all.Get(hash) != nil
+}
+
 // removeTx removes a single transaction from the queue, moving all subsequent
 // transactions back to the future queue.
 func (pool *TxPool) removeTx(hash common.Hash, outofbound bool) {
@@ -972,6 +1024,17 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			pool.priced.Removed()
 			queuedNofundsCounter.Inc(1)
 		}
+		// Drop all sponsored transactions whose fee payer cannot cover the gas
+		for _, tx := range list.Flatten() {
+			if tx.Sponsored() && !pool.payerCovers(tx) {
+				hash := tx.Hash()
+				log.Trace("Removed queued transaction with unpayable gas", "hash", hash)
+				list.Remove(tx)
+				pool.all.Remove(hash)
+				pool.priced.Removed()
+				queuedNofundsCounter.Inc(1)
+			}
+		}
 		// Gather all executable transactions and promote them
 		for _, tx := range list.Ready(pool.pendingState.GetNonce(addr)) {
 			hash := tx.Hash()
@@ -995,6 +1058,17 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			delete(pool.queue, addr)
 		}
 	}
+	// Drop any promoted transactions whose fee payer cannot cover them all
+	if pool.truncatePayers() {
+		executable := promoted[:0]
+		for _, tx := range promoted {
+			addr, _ := types.Sender(pool.signer, tx) // already validated during insertion
+			if list := pool.pending[addr]; list != nil && list.txs.Get(tx.Nonce()) == tx {
+				executable = append(executable, tx)
+			}
+		}
+		promoted = executable
+	}
 	// Notify subsystem for new promoted transactions.
 	if len(promoted) > 0 {
 		go pool.txFeed.Send(NewTxsEvent{promoted})
@@ -1155,6 +1229,68 @@ func (pool *TxPool) demoteUnexecutables() {
 			delete(pool.beats, addr)
 		}
 	}
+	// Drop all sponsored transactions whose fee payer became unable to cover them
+	pool.truncatePayers()
+}
+
+// payerCovers reports whether the fee payer of a sponsored transaction can
+// cover its gas on its own, disregarding any other transactions it sponsors.
+func (pool *TxPool) payerCovers(tx *types.Transaction) bool {
+	payer, _ := types.Payer(pool.signer, tx) // already validated during insertion
+	return pool.currentState.GetBalance(payer).Cmp(tx.GasCost()) >= 0
+}
+
+// truncatePayers charges the gas of all pending sponsored transactions against
+// the balances of their fee payers, net of the payers' own pending costs. The
+// first transaction of a sender that its payer cannot cover anymore is dropped,
+// moving all subsequent transactions of that sender back to the future queue.
+// It reports whether any transaction was dropped.
+func (pool *TxPool) truncatePayers() bool {
+	// Iterate over the senders in a stable order to keep evictions deterministic
+	senders := make([]common.Address, 0, len(pool.pending))
+	for addr := range pool.pending {
+		senders = append(senders, addr)
+	}
+	sort.Slice(senders, func(i, j int) bool { return bytes.Compare(senders[i][:], senders[j][:]) < 0 })
+
+	// Gather the pending sponsored transactions by fee payer
+	sponsored := make(map[common.Address]types.Transactions)
+	for _, addr := range senders {
+		for _, tx := range pool.pending[addr].Flatten() {
+			if tx.Sponsored() {
+				payer, _ := types.Payer(pool.signer, tx) // already validated during insertion
+				sponsored[payer] = append(sponsored[payer], tx)
+			}
+		}
+	}
+	// Drop everything a payer cannot cover, starting with the lowest sender nonces
+	truncated := false
+	for payer, txs := range sponsored {
+		balance := new(big.Int).Set(pool.currentState.GetBalance(payer))
+		if list := pool.pending[payer]; list != nil {
+			for _, tx := range list.Flatten() {
+				balance.Sub(balance, senderCost(tx))
+			}
+		}
+		dropped := make(map[common.Address]bool)
+		for _, tx := range txs {
+			from, _ := types.Sender(pool.signer, tx) // already validated during insertion
+			if dropped[from] {
+				continue
+			}
+			if balance.Cmp(tx.GasCost()) < 0 {
+				hash := tx.Hash()
+				log.Trace("Removed pending transaction with unpayable gas", "hash", hash, "payer", payer)
+				pool.removeTx(hash, true)
+				pendingNofundsCounter.Inc(1)
+
+				dropped[from], truncated = true, true
+				continue
+			}
+			balance.Sub(balance, tx.GasCost())
+		}
+	}
+	return truncated
 }
 
 // addressByHeartbeat is an account address tagged with its last activity timestamp.
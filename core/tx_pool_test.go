@@ -29,6 +29,7 @@ import (
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core/state"
 	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
 	"github.com/simplechain-org/simplechain/crypto"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
@@ -1832,3 +1833,133 @@ func benchmarkPoolBatchInsert(b *testing.B, size int) {
 		pool.AddRemotes(batch)
 	}
 }
+
+// Tests that sponsored transactions are validated against the balance of the
+// fee payer for gas and against the balance of the sender for the value.
+func TestSponsoredTransactionFunds(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	payerKey, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(payerKey.PublicKey)
+
+	tx, _ := types.SignPayer(transaction(0, 100000, key), payerKey)
+	from, _ := deriveSender(tx)
+
+	// The sender only needs to cover the value, not the gas
+	pool.currentState.AddBalance(from, tx.Value())
+	if err := pool.AddRemote(tx); err != ErrInsufficientPayerFunds {
+		t.Error("expected", ErrInsufficientPayerFunds, "got", err)
+	}
+	pool.currentState.AddBalance(payer, tx.GasCost())
+	if err := pool.AddRemote(tx); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	// A payer signature lifted onto another transaction recovers to an
+	// unrelated, unfunded account
+	v, r, s := tx.RawPayerSignatureValues()
+	sig := make([]byte, 65)
+	copy(sig[32-len(r.Bytes()):32], r.Bytes())
+	copy(sig[64-len(s.Bytes()):64], s.Bytes())
+	sig[64] = byte(v.Uint64() - 27)
+
+	pool.currentState.AddBalance(from, tx.Value())
+	forged, _ := transaction(1, 100000, key).WithPayerSignature(sig)
+	if err := pool.AddRemote(forged); err != ErrInsufficientPayerFunds && err != ErrInvalidPayer {
+		t.Error("expected", ErrInsufficientPayerFunds, "got", err)
+	}
+}
+
+// Tests that sponsored transactions are dropped from the pool once their fee
+// payer can no longer cover the gas of everything it sponsors.
+func TestSponsoredTransactionPayerDrain(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	payerKey, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(payerKey.PublicKey)
+
+	tx0, _ := types.SignPayer(transaction(0, 100000, key), payerKey)
+	tx1, _ := types.SignPayer(transaction(1, 100000, key), payerKey)
+	tx3, _ := types.SignPayer(transaction(3, 100000, key), payerKey)
+	from, _ := deriveSender(tx0)
+
+	pool.currentState.AddBalance(from, big.NewInt(1000))
+	pool.currentState.AddBalance(payer, new(big.Int).Mul(tx0.GasCost(), big.NewInt(2)))
+	for i, tx := range []*types.Transaction{tx0, tx1, tx3} {
+		if err := pool.AddRemote(tx); err != nil {
+			t.Fatalf("failed to add transaction %d: %v", i, err)
+		}
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d pending, %d queued, want 2 pending, 1 queued", pending, queued)
+	}
+	// Drain the payer to cover a single transaction and check that the rest
+	// is dropped from the pending set
+	pool.currentState.AddBalance(payer, new(big.Int).Neg(tx0.GasCost()))
+	pool.lockedReset(nil, nil)
+
+	if pool.pending[from].txs.Get(0) == nil {
+		t.Errorf("covered pending transaction missing")
+	}
+	if pool.pending[from].txs.Get(1) != nil {
+		t.Errorf("uncovered pending transaction present")
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Errorf("pool stats mismatch: have %d pending, %d queued, want 1 pending, 1 queued", pending, queued)
+	}
+	// Drain the payer completely and check that nothing it sponsors remains
+	pool.currentState.AddBalance(payer, new(big.Int).Neg(tx0.GasCost()))
+	pool.lockedReset(nil, nil)
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Errorf("pool stats mismatch: have %d pending, %d queued, want 0 pending, 0 queued", pending, queued)
+	}
+	if pool.all.Count() != 0 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 0)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that sponsored transactions are rejected by both the pool and the state
+// processor until the sponsorship fork activates.
+func TestSponsoredTransactionFork(t *testing.T) {
+	t.Parallel()
+
+	config := *params.TestChainConfig
+	config.SponsorBlock = big.NewInt(2)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	pool := NewTxPool(testTxPoolConfig, &config, blockchain)
+	defer pool.Stop()
+
+	key, _ := crypto.GenerateKey()
+	payerKey, _ := crypto.GenerateKey()
+
+	tx, _ := types.SignPayer(transaction(0, 100000, key), payerKey)
+	from, _ := deriveSender(tx)
+	payer, _ := types.Payer(pool.signer, tx)
+
+	statedb.AddBalance(from, big.NewInt(1000))
+	statedb.AddBalance(payer, tx.GasCost())
+
+	// The pending block of the pool is block 1, before the fork
+	if err := pool.AddRemote(tx); err != ErrSponsorNotActive {
+		t.Errorf("pool: expected %v, got %v", ErrSponsorNotActive, err)
+	}
+	for number, want := range map[int64]error{1: ErrSponsorNotActive, 2: nil} {
+		header := &types.Header{Number: big.NewInt(number), GasLimit: 1000000, Difficulty: big.NewInt(1)}
+		_, _, err := ApplyTransaction(&config, nil, &common.Address{}, new(GasPool).AddGas(header.GasLimit), statedb.Copy(), header, tx, new(uint64), vm.Config{})
+		if err != want {
+			t.Errorf("block %d: expected %v, got %v", number, want, err)
+		}
+	}
+}
@@ -24,6 +24,9 @@ func (t txdata) MarshalJSON() ([]byte, error) {
 		V            *hexutil.Big    `json:"v" gencodec:"required"`
 		R            *hexutil.Big    `json:"r" gencodec:"required"`
 		S            *hexutil.Big    `json:"s" gencodec:"required"`
+		PayerV       *hexutil.Big    `json:"payerV,omitempty" rlp:"-"`
+		PayerR       *hexutil.Big    `json:"payerR,omitempty" rlp:"-"`
+		PayerS       *hexutil.Big    `json:"payerS,omitempty" rlp:"-"`
 		Hash         *common.Hash    `json:"hash" rlp:"-"`
 	}
 	var enc txdata
@@ -36,6 +39,9 @@ func (t txdata) MarshalJSON() ([]byte, error) {
 	enc.V = (*hexutil.Big)(t.V)
 	enc.R = (*hexutil.Big)(t.R)
 	enc.S = (*hexutil.Big)(t.S)
+	enc.PayerV = (*hexutil.Big)(t.PayerV)
+	enc.PayerR = (*hexutil.Big)(t.PayerR)
+	enc.PayerS = (*hexutil.Big)(t.PayerS)
 	enc.Hash = t.Hash
 	return json.Marshal(&enc)
 }
@@ -51,6 +57,9 @@ func (t *txdata) UnmarshalJSON(input []byte) error {
 		V            *hexutil.Big    `json:"v" gencodec:"required"`
 		R            *hexutil.Big    `json:"r" gencodec:"required"`
 		S            *hexutil.Big    `json:"s" gencodec:"required"`
+		PayerV       *hexutil.Big    `json:"payerV,omitempty" rlp:"-"`
+		PayerR       *hexutil.Big    `json:"payerR,omitempty" rlp:"-"`
+		PayerS       *hexutil.Big    `json:"payerS,omitempty" rlp:"-"`
 		Hash         *common.Hash    `json:"hash" rlp:"-"`
 	}
 	var dec txdata
@@ -92,6 +101,15 @@ func (t *txdata) UnmarshalJSON(input []byte) error {
 		return errors.New("missing required field 's' for txdata")
 	}
 	t.S = (*big.Int)(dec.S)
+	if dec.PayerV != nil {
+		t.PayerV = (*big.Int)(dec.PayerV)
+	}
+	if dec.PayerR != nil {
+		t.PayerR = (*big.Int)(dec.PayerR)
+	}
+	if dec.PayerS != nil {
+		t.PayerS = (*big.Int)(dec.PayerS)
+	}
 	if dec.Hash != nil {
 		t.Hash = dec.Hash
 	}
@@ -38,9 +38,10 @@ var (
 type Transaction struct {
 	data txdata
 	// caches
-	hash atomic.Value
-	size atomic.Value
-	from atomic.Value
+	hash  atomic.Value
+	size  atomic.Value
+	from  atomic.Value
+	payer atomic.Value
 }
 
 type txdata struct {
@@ -56,6 +57,11 @@ type txdata struct {
 	R *big.Int `json:"r" gencodec:"required"`
 	S *big.Int `json:"s" gencodec:"required"`
 
+	// Fee payer signature values, only present on sponsored transactions
+	PayerV *big.Int `json:"payerV,omitempty" rlp:"-"`
+	PayerR *big.Int `json:"payerR,omitempty" rlp:"-"`
+	PayerS *big.Int `json:"payerS,omitempty" rlp:"-"`
+
 	// This is only used when marshaling to JSON.
 	Hash *common.Hash `json:"hash" rlp:"-"`
 }
@@ -69,6 +75,23 @@ type txdataMarshaling struct {
 	V            *hexutil.Big
 	R            *hexutil.Big
 	S            *hexutil.Big
+	PayerV       *hexutil.Big
+	PayerR       *hexutil.Big
+	PayerS       *hexutil.Big
+}
+
+// sponsoredTxdata is the RLP layout of a sponsored transaction. It is the plain
+// transaction followed by the signature of the account paying for its gas.
+type sponsoredTxdata struct {
+	AccountNonce uint64
+	Price        *big.Int
+	GasLimit     uint64
+	Recipient    *common.Address `rlp:"nil"`
+	Amount       *big.Int
+	Payload      []byte
+	V, R, S      *big.Int
+
+	PayerV, PayerR, PayerS *big.Int
 }
 
 func NewTransaction(nonce uint64, to common.Address, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) *Transaction {
@@ -123,20 +146,72 @@ func isProtectedV(V *big.Int) bool {
 	return true
 }
 
+// Sponsored returns whether the gas of the transaction is paid by a fee payer
+// other than the sender.
+func (tx *Transaction) Sponsored() bool {
+	return tx.data.PayerV != nil
+}
+
 // EncodeRLP implements rlp.Encoder
 func (tx *Transaction) EncodeRLP(w io.Writer) error {
+	if tx.Sponsored() {
+		return rlp.Encode(w, &sponsoredTxdata{
+			AccountNonce: tx.data.AccountNonce,
+			Price:        tx.data.Price,
+			GasLimit:     tx.data.GasLimit,
+			Recipient:    tx.data.Recipient,
+			Amount:       tx.data.Amount,
+			Payload:      tx.data.Payload,
+			V:            tx.data.V,
+			R:            tx.data.R,
+			S:            tx.data.S,
+			PayerV:       tx.data.PayerV,
+			PayerR:       tx.data.PayerR,
+			PayerS:       tx.data.PayerS,
+		})
+	}
 	return rlp.Encode(w, &tx.data)
 }
 
 // DecodeRLP implements rlp.Decoder
 func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
-	_, size, _ := s.Kind()
-	err := s.Decode(&tx.data)
-	if err == nil {
-		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
+	raw, err := s.Raw()
+	if err != nil {
+		return err
 	}
-
-	return err
+	content, _, err := rlp.SplitList(raw)
+	if err != nil {
+		return err
+	}
+	fields, err := rlp.CountValues(content)
+	if err != nil {
+		return err
+	}
+	// Sponsored transactions carry three extra fields for the payer signature
+	if fields == 12 {
+		var dec sponsoredTxdata
+		if err := rlp.DecodeBytes(raw, &dec); err != nil {
+			return err
+		}
+		tx.data = txdata{
+			AccountNonce: dec.AccountNonce,
+			Price:        dec.Price,
+			GasLimit:     dec.GasLimit,
+			Recipient:    dec.Recipient,
+			Amount:       dec.Amount,
+			Payload:      dec.Payload,
+			V:            dec.V,
+			R:            dec.R,
+			S:            dec.S,
+			PayerV:       dec.PayerV,
+			PayerR:       dec.PayerR,
+			PayerS:       dec.PayerS,
+		}
+	} else if err := rlp.DecodeBytes(raw, &tx.data); err != nil {
+		return err
+	}
+	tx.size.Store(common.StorageSize(len(raw)))
+	return nil
 }
 
 // MarshalJSON encodes the web3 RPC transaction format.
@@ -167,6 +242,14 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 			return ErrInvalidSig
 		}
 	}
+	if dec.PayerV != nil {
+		if dec.PayerR == nil || dec.PayerS == nil || dec.PayerV.BitLen() > 8 {
+			return ErrInvalidSig
+		}
+		if !crypto.ValidateSignatureValues(byte(dec.PayerV.Uint64()-27), dec.PayerR, dec.PayerS, true) {
+			return ErrInvalidSig
+		}
+	}
 
 	*tx = Transaction{data: dec}
 	return nil
@@ -207,7 +290,7 @@ func (tx *Transaction) Size() common.StorageSize {
 		return size.(common.StorageSize)
 	}
 	c := writeCounter(0)
-	rlp.Encode(&c, &tx.data)
+	rlp.Encode(&c, tx)
 	tx.size.Store(common.StorageSize(c))
 	return common.StorageSize(c)
 }
@@ -230,6 +313,10 @@ func (tx *Transaction) AsMessage(s Signer) (Message, error) {
 
 	var err error
 	msg.from, err = Sender(s, tx)
+	if err != nil {
+		return msg, err
+	}
+	msg.payer, err = Payer(s, tx)
 	return msg, err
 }
 
@@ -245,17 +332,42 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	return cpy, nil
 }
 
+// WithPayerSignature returns a new sponsored transaction with the given fee
+// payer signature. This signature needs to be in the [R || S || V] format where
+// V is 0 or 1.
+func (tx *Transaction) WithPayerSignature(sig []byte) (*Transaction, error) {
+	r, s, v, err := HomesteadSigner{}.SignatureValues(tx, sig)
+	if err != nil {
+		return nil, err
+	}
+	cpy := &Transaction{data: tx.data}
+	cpy.data.PayerR, cpy.data.PayerS, cpy.data.PayerV = r, s, v
+	return cpy, nil
+}
+
 // Cost returns amount + gasprice * gaslimit.
 func (tx *Transaction) Cost() *big.Int {
-	total := new(big.Int).Mul(tx.data.Price, new(big.Int).SetUint64(tx.data.GasLimit))
+	total := tx.GasCost()
 	total.Add(total, tx.data.Amount)
 	return total
 }
 
+// GasCost returns gasprice * gaslimit, the amount charged upfront to whoever
+// pays for the gas of the transaction.
+func (tx *Transaction) GasCost() *big.Int {
+	return new(big.Int).Mul(tx.data.Price, new(big.Int).SetUint64(tx.data.GasLimit))
+}
+
 func (tx *Transaction) RawSignatureValues() (*big.Int, *big.Int, *big.Int) {
 	return tx.data.V, tx.data.R, tx.data.S
 }
 
+// RawPayerSignatureValues returns the fee payer signature values of a sponsored
+// transaction, or nils if the transaction is not sponsored.
+func (tx *Transaction) RawPayerSignatureValues() (*big.Int, *big.Int, *big.Int) {
+	return tx.data.PayerV, tx.data.PayerR, tx.data.PayerS
+}
+
 // Transactions is a Transaction slice type for basic sorting.
 type Transactions []*Transaction
 
@@ -386,6 +498,7 @@ func (t *TransactionsByPriceAndNonce) Pop() {
 type Message struct {
 	to         *common.Address
 	from       common.Address
+	payer      common.Address
 	nonce      uint64
 	amount     *big.Int
 	gasLimit   uint64
@@ -397,6 +510,7 @@ type Message struct {
 func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, checkNonce bool) Message {
 	return Message{
 		from:       from,
+		payer:      from,
 		to:         to,
 		nonce:      nonce,
 		amount:     amount,
@@ -407,11 +521,12 @@ func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *b
 	}
 }
 
-func (m Message) From() common.Address { return m.from }
-func (m Message) To() *common.Address  { return m.to }
-func (m Message) GasPrice() *big.Int   { return m.gasPrice }
-func (m Message) Value() *big.Int      { return m.amount }
-func (m Message) Gas() uint64          { return m.gasLimit }
-func (m Message) Nonce() uint64        { return m.nonce }
-func (m Message) Data() []byte         { return m.data }
-func (m Message) CheckNonce() bool     { return m.checkNonce }
+func (m Message) From() common.Address  { return m.from }
+func (m Message) Payer() common.Address { return m.payer }
+func (m Message) To() *common.Address   { return m.to }
+func (m Message) GasPrice() *big.Int    { return m.gasPrice }
+func (m Message) Value() *big.Int       { return m.amount }
+func (m Message) Gas() uint64           { return m.gasLimit }
+func (m Message) Nonce() uint64         { return m.nonce }
+func (m Message) Data() []byte          { return m.data }
+func (m Message) CheckNonce() bool      { return m.checkNonce }
@@ -29,6 +29,7 @@ import (
 
 var (
 	ErrInvalidChainId = errors.New("invalid chain id for signer")
+	ErrUnprotectedTx  = errors.New("transaction is not replay protected")
 )
 
 // sigCache is used to cache the derived sender and contains
@@ -42,6 +43,8 @@ type sigCache struct {
 func MakeSigner(config *params.ChainConfig, blockNumber *big.Int) Signer {
 	var signer Signer
 	switch {
+	case config.IsSigningDomain(blockNumber):
+		signer = NewDomainSigner(config.ChainID, config.SigningDomain)
 	case config.IsEIP155(blockNumber):
 		signer = NewEIP155Signer(config.ChainID)
 	case config.IsHomestead(blockNumber):
@@ -52,6 +55,17 @@ func MakeSigner(config *params.ChainConfig, blockNumber *big.Int) Signer {
 	return signer
 }
 
+// LatestSigner returns the signer accepting the most recent transaction formats
+// valid at the given block number. As opposed to MakeSigner, it returns an
+// EIP155 signer even before the EIP155 fork, which also accepts unprotected
+// transactions.
+func LatestSigner(config *params.ChainConfig, blockNumber *big.Int) Signer {
+	if config.IsSigningDomain(blockNumber) {
+		return NewDomainSigner(config.ChainID, config.SigningDomain)
+	}
+	return NewEIP155Signer(config.ChainID)
+}
+
 // SignTx signs the transaction using the given signer and private key
 func SignTx(tx *Transaction, s Signer, prv *ecdsa.PrivateKey) (*Transaction, error) {
 	h := s.Hash(tx)
@@ -62,6 +76,56 @@ func SignTx(tx *Transaction, s Signer, prv *ecdsa.PrivateKey) (*Transaction, err
 	return tx.WithSignature(s, sig)
 }
 
+// SignPayer signs the transaction as its fee payer using the given private key.
+// The transaction must already carry the signature of its sender.
+func SignPayer(tx *Transaction, prv *ecdsa.PrivateKey) (*Transaction, error) {
+	h := PayerHash(tx)
+	sig, err := crypto.Sign(h[:], prv)
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithPayerSignature(sig)
+}
+
+// PayerHash returns the hash to be signed by the fee payer of a sponsored
+// transaction. It commits to the transaction including the signature of the
+// sender (and thereby to its chain id), so a payer signature cannot be lifted
+// onto a different transaction or replayed on another chain.
+func PayerHash(tx *Transaction) common.Hash {
+	return rlpHash([]interface{}{
+		tx.data.AccountNonce,
+		tx.data.Price,
+		tx.data.GasLimit,
+		tx.data.Recipient,
+		tx.data.Amount,
+		tx.data.Payload,
+		tx.data.V,
+		tx.data.R,
+		tx.data.S,
+	})
+}
+
+// Payer returns the address paying for the gas of the transaction. This is the
+// account derived from the fee payer signature for sponsored transactions and
+// the sender for all others, in which case the signer is used to derive it.
+//
+// Payer may cache the address, allowing it to be used regardless of signing
+// method.
+func Payer(signer Signer, tx *Transaction) (common.Address, error) {
+	if !tx.Sponsored() {
+		return Sender(signer, tx)
+	}
+	if payer := tx.payer.Load(); payer != nil {
+		return payer.(common.Address), nil
+	}
+	addr, err := recoverPlain(PayerHash(tx), tx.data.PayerR, tx.data.PayerS, tx.data.PayerV, true)
+	if err != nil {
+		return common.Address{}, err
+	}
+	tx.payer.Store(addr)
+	return addr, nil
+}
+
 // Sender returns the address derived from the signature (V, R, S) using secp256k1
 // elliptic curve and an error if it failed deriving or upon an incorrect
 // signature.
@@ -102,6 +166,54 @@ type Signer interface {
 	Equal(Signer) bool
 }
 
+// DomainSigner implements Signer using the EIP155 rules extended with a chain
+// specific signing domain. The domain is mixed into the signature hash, so the
+// transactions can't be replayed on chains sharing the same chain id. As
+// opposed to EIP155Signer, unprotected transactions are not accepted.
+type DomainSigner struct {
+	EIP155Signer
+	domain common.Hash
+}
+
+// NewDomainSigner creates a signer for the given chain id and signing domain.
+func NewDomainSigner(chainId *big.Int, domain string) DomainSigner {
+	return DomainSigner{
+		EIP155Signer: NewEIP155Signer(chainId),
+		domain:       crypto.Keccak256Hash([]byte(domain)),
+	}
+}
+
+func (s DomainSigner) Equal(s2 Signer) bool {
+	other, ok := s2.(DomainSigner)
+	return ok && other.chainId.Cmp(s.chainId) == 0 && other.domain == s.domain
+}
+
+func (s DomainSigner) Sender(tx *Transaction) (common.Address, error) {
+	if !tx.Protected() {
+		return common.Address{}, ErrUnprotectedTx
+	}
+	if tx.ChainId().Cmp(s.chainId) != 0 {
+		return common.Address{}, ErrInvalidChainId
+	}
+	V := new(big.Int).Sub(tx.data.V, s.chainIdMul)
+	V.Sub(V, big8)
+	return recoverPlain(s.Hash(tx), tx.data.R, tx.data.S, V, true)
+}
+
+// Hash returns the hash to be signed by the sender.
+// It does not uniquely identify the transaction.
+func (s DomainSigner) Hash(tx *Transaction) common.Hash {
+	return rlpHash([]interface{}{
+		tx.data.AccountNonce,
+		tx.data.Price,
+		tx.data.GasLimit,
+		tx.data.Recipient,
+		tx.data.Amount,
+		tx.data.Payload,
+		s.chainId, s.domain, uint(0), uint(0),
+	})
+}
+
 // EIP155Transaction implements Signer using the EIP155 rules.
 type EIP155Signer struct {
 	chainId, chainIdMul *big.Int
@@ -117,6 +229,11 @@ func NewEIP155Signer(chainId *big.Int) EIP155Signer {
 	}
 }
 
+// ChainID returns the chain id the signer signs transactions for.
+func (s EIP155Signer) ChainID() *big.Int {
+	return new(big.Int).Set(s.chainId)
+}
+
 func (s EIP155Signer) Equal(s2 Signer) bool {
 	eip155, ok := s2.(EIP155Signer)
 	return ok && eip155.chainId.Cmp(s.chainId) == 0
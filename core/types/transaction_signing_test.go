@@ -22,6 +22,7 @@ import (
 
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/params"
 	"github.com/simplechain-org/simplechain/rlp"
 )
 
@@ -136,3 +137,60 @@ func TestChainId(t *testing.T) {
 		t.Error("expected no error")
 	}
 }
+
+func TestDomainSigning(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+
+	signer := NewDomainSigner(big.NewInt(1), "simplechain")
+	tx, err := SignTx(NewTransaction(0, addr, new(big.Int), 0, new(big.Int), nil), signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Protected() || tx.ChainId().Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("expected tx to be protected for chain 1, got chain %v", tx.ChainId())
+	}
+	from, err := Sender(signer, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != addr {
+		t.Errorf("expected from and address to be equal. Got %x want %x", from, addr)
+	}
+	// Ensure the transaction can't be replayed on chains sharing the chain id
+	for _, other := range []Signer{NewEIP155Signer(big.NewInt(1)), NewDomainSigner(big.NewInt(1), "ethereum")} {
+		if from, err := Sender(other, tx); err == nil && from == addr {
+			t.Errorf("%T: transaction accepted outside of its signing domain", other)
+		}
+	}
+	if _, err := Sender(NewDomainSigner(big.NewInt(2), "simplechain"), tx); err != ErrInvalidChainId {
+		t.Errorf("chain id mismatch: have %v, want %v", err, ErrInvalidChainId)
+	}
+	// Ensure unprotected transactions are rejected
+	tx, err = SignTx(NewTransaction(0, addr, new(big.Int), 0, new(big.Int), nil), HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sender(signer, tx); err != ErrUnprotectedTx {
+		t.Errorf("unprotected transaction error mismatch: have %v, want %v", err, ErrUnprotectedTx)
+	}
+}
+
+func TestMakeSignerDomain(t *testing.T) {
+	config := &params.ChainConfig{
+		ChainID:            big.NewInt(1),
+		HomesteadBlock:     big.NewInt(0),
+		EIP155Block:        big.NewInt(0),
+		SigningDomainBlock: big.NewInt(10),
+		SigningDomain:      "simplechain",
+	}
+	if signer := MakeSigner(config, big.NewInt(9)); !signer.Equal(NewEIP155Signer(big.NewInt(1))) {
+		t.Errorf("pre-fork signer mismatch: have %T", signer)
+	}
+	if signer := MakeSigner(config, big.NewInt(10)); !signer.Equal(NewDomainSigner(big.NewInt(1), "simplechain")) {
+		t.Errorf("post-fork signer mismatch: have %T", signer)
+	}
+	if NewDomainSigner(big.NewInt(1), "simplechain").Equal(NewDomainSigner(big.NewInt(1), "ethereum")) {
+		t.Errorf("signers with different domains reported equal")
+	}
+}
@@ -224,3 +224,69 @@ func TestTransactionJSON(t *testing.T) {
 		}
 	}
 }
+
+// Tests that sponsored transactions survive an RLP and JSON round trip and that
+// both the sender and the fee payer can be recovered afterwards.
+func TestSponsoredTransaction(t *testing.T) {
+	senderKey, sender := defaultTestKey()
+	payerKey, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(payerKey.PublicKey)
+
+	signer := NewEIP155Signer(common.Big1)
+	tx, err := SignTx(NewTransaction(0, common.Address{1}, common.Big1, 21000, common.Big2, nil), signer, senderKey)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if addr, err := Payer(signer, tx); err != nil || addr != sender {
+		t.Fatalf("unsponsored payer mismatch: have %x, want %x (err %v)", addr, sender, err)
+	}
+	sponsored, err := SignPayer(tx, payerKey)
+	if err != nil {
+		t.Fatalf("could not sign as fee payer: %v", err)
+	}
+	if !sponsored.Sponsored() {
+		t.Fatalf("transaction not marked sponsored")
+	}
+	if sponsored.Hash() == tx.Hash() {
+		t.Fatalf("payer signature not included in transaction hash")
+	}
+	// Round trip through RLP and JSON and check the recovered accounts
+	enc, err := rlp.EncodeToBytes(sponsored)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	fromRLP, err := decodeTx(enc)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if fromRLP.Size() != common.StorageSize(len(enc)) {
+		t.Errorf("size mismatch: have %v, want %v", fromRLP.Size(), len(enc))
+	}
+	data, err := json.Marshal(sponsored)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var fromJSON *Transaction
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	for i, dec := range []*Transaction{fromRLP, fromJSON} {
+		if dec.Hash() != sponsored.Hash() {
+			t.Errorf("decoding %d: hash mismatch: have %x, want %x", i, dec.Hash(), sponsored.Hash())
+		}
+		if addr, err := Sender(signer, dec); err != nil || addr != sender {
+			t.Errorf("decoding %d: sender mismatch: have %x, want %x (err %v)", i, addr, sender, err)
+		}
+		if addr, err := Payer(signer, dec); err != nil || addr != payer {
+			t.Errorf("decoding %d: payer mismatch: have %x, want %x (err %v)", i, addr, payer, err)
+		}
+	}
+	// Plain transactions must keep decoding as such
+	enc, err = rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if dec, err := decodeTx(enc); err != nil || dec.Sponsored() {
+		t.Errorf("plain transaction decoded as sponsored (err %v)", err)
+	}
+}
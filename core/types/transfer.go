@@ -0,0 +1,63 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/simplechain-org/simplechain/common"
+)
+
+// TransferKind is the way an internal value transfer was made.
+type TransferKind uint8
+
+const (
+	// TransferCall is value sent along with a CALL.
+	TransferCall TransferKind = iota
+
+	// TransferCreate is the endowment of a contract created by CREATE or CREATE2.
+	TransferCreate
+
+	// TransferSelfdestruct is the balance of a self destructed contract moved
+	// to its beneficiary.
+	TransferSelfdestruct
+)
+
+// String implements fmt.Stringer.
+func (kind TransferKind) String() string {
+	switch kind {
+	case TransferCall:
+		return "call"
+	case TransferCreate:
+		return "create"
+	case TransferSelfdestruct:
+		return "selfdestruct"
+	default:
+		return "unknown"
+	}
+}
+
+// InternalTransfer is a value transfer made by a contract while executing a
+// transaction, as opposed to the value sent by the transaction itself.
+type InternalTransfer struct {
+	Kind    TransferKind
+	TxHash  common.Hash
+	TxIndex uint
+	From    common.Address
+	To      common.Address
+	Value   *big.Int
+}
@@ -16,6 +16,30 @@
 
 package vm
 
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/simplechain-org/simplechain/common"
+)
+
+// analysisCacheSize is the number of code analyses kept in the process wide
+// analysis cache.
+const analysisCacheSize = 4096
+
+// analysisCache is a process wide cache of the JUMPDEST analysis of contract
+// code, keyed by code hash, shared by all the EVM instances.
+var analysisCache, _ = lru.New(analysisCacheSize)
+
+// codeAnalysis returns the JUMPDEST analysis of the code with the given hash,
+// analysing and caching it if not yet done.
+func codeAnalysis(hash common.Hash, code []byte) bitvec {
+	if analysis, ok := analysisCache.Get(hash); ok {
+		return analysis.(bitvec)
+	}
+	analysis := codeBitmap(code)
+	analysisCache.Add(hash, analysis)
+	return analysis
+}
+
 // bitvec is a bit vector which maps bytes in a program.
 // An unset bit means the byte is an opcode, a set bit means
 // it's data (i.e. argument of PUSHxx).
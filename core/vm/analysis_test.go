@@ -55,6 +55,23 @@ func TestJumpDestAnalysis(t *testing.T) {
 	}
 }
 
+func TestJumpDestAnalysisCache(t *testing.T) {
+	code := []byte{byte(PUSH1), byte(JUMPDEST), byte(JUMPDEST)}
+	hash := crypto.Keccak256Hash(code)
+
+	analysis := codeAnalysis(hash, code)
+	if !analysisCache.Contains(hash) {
+		t.Fatalf("analysis not cached")
+	}
+	if analysis.codeSegment(1) || !analysis.codeSegment(2) {
+		t.Fatalf("analysis mismatch: %08b", analysis)
+	}
+	// The cached analysis must be reused instead of analysing the code again
+	if cached := codeAnalysis(hash, nil); &cached[0] != &analysis[0] {
+		t.Fatalf("cached analysis not reused")
+	}
+}
+
 func BenchmarkJumpdestAnalysis_1200k(bench *testing.B) {
 	// 1.4 ms
 	code := make([]byte, 1200000)
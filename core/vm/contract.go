@@ -97,9 +97,10 @@ func (c *Contract) validJumpdest(dest *big.Int) bool {
 		// Does parent context have the analysis?
 		analysis, exist := c.jumpdests[c.CodeHash]
 		if !exist {
-			// Do the analysis and save in parent context
-			// We do not need to store it in c.analysis
-			analysis = codeBitmap(c.Code)
+			// Retrieve the analysis from the process wide cache, analysing the
+			// code if needed, and save it in parent context. We do not need to
+			// store it in c.analysis
+			analysis = codeAnalysis(c.CodeHash, c.Code)
 			c.jumpdests[c.CodeHash] = analysis
 		}
 		return analysis.codeSegment(udest)
@@ -17,13 +17,19 @@
 package vm
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"math/big"
 
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/consensus/scrypt"
 	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/crypto/blake2b"
+	"github.com/simplechain-org/simplechain/crypto/bls12381"
 	"github.com/simplechain-org/simplechain/crypto/bn256"
 	"github.com/simplechain-org/simplechain/params"
 	"golang.org/x/crypto/ripemd160"
@@ -54,9 +60,59 @@ var PrecompiledContractsByzantium = map[common.Address]PrecompiledContract{
 	common.BytesToAddress([]byte{3}): &ripemd160hash{},
 	common.BytesToAddress([]byte{4}): &dataCopy{},
 	common.BytesToAddress([]byte{5}): &bigModExp{},
-	common.BytesToAddress([]byte{6}): &bn256Add{},
-	common.BytesToAddress([]byte{7}): &bn256ScalarMul{},
-	common.BytesToAddress([]byte{8}): &bn256Pairing{},
+	common.BytesToAddress([]byte{6}): &bn256AddByzantium{},
+	common.BytesToAddress([]byte{7}): &bn256ScalarMulByzantium{},
+	common.BytesToAddress([]byte{8}): &bn256PairingByzantium{},
+}
+
+// PrecompiledContractsIstanbul contains the default set of pre-compiled Ethereum
+// contracts used in the Istanbul release.
+var PrecompiledContractsIstanbul = map[common.Address]PrecompiledContract{
+	common.BytesToAddress([]byte{1}): &ecrecover{},
+	common.BytesToAddress([]byte{2}): &sha256hash{},
+	common.BytesToAddress([]byte{3}): &ripemd160hash{},
+	common.BytesToAddress([]byte{4}): &dataCopy{},
+	common.BytesToAddress([]byte{5}): &bigModExp{},
+	common.BytesToAddress([]byte{6}): &bn256AddIstanbul{},
+	common.BytesToAddress([]byte{7}): &bn256ScalarMulIstanbul{},
+	common.BytesToAddress([]byte{8}): &bn256PairingIstanbul{},
+	common.BytesToAddress([]byte{9}): &blake2F{},
+}
+
+// PrecompiledContractsCrypto contains the default set of pre-compiled contracts
+// extended with the native cryptography of SimpleChain.
+var PrecompiledContractsCrypto = map[common.Address]PrecompiledContract{
+	common.BytesToAddress([]byte{1}):  &ecrecover{},
+	common.BytesToAddress([]byte{2}):  &sha256hash{},
+	common.BytesToAddress([]byte{3}):  &ripemd160hash{},
+	common.BytesToAddress([]byte{4}):  &dataCopy{},
+	common.BytesToAddress([]byte{5}):  &bigModExp{},
+	common.BytesToAddress([]byte{6}):  &bn256AddIstanbul{},
+	common.BytesToAddress([]byte{7}):  &bn256ScalarMulIstanbul{},
+	common.BytesToAddress([]byte{8}):  &bn256PairingIstanbul{},
+	common.BytesToAddress([]byte{9}):  &blake2F{},
+	common.BytesToAddress([]byte{10}): &scryptHash{},
+	common.BytesToAddress([]byte{11}): &bls12381G1Add{},
+	common.BytesToAddress([]byte{12}): &bls12381G1Mul{},
+	common.BytesToAddress([]byte{13}): &bls12381G2Add{},
+	common.BytesToAddress([]byte{14}): &bls12381G2Mul{},
+	common.BytesToAddress([]byte{15}): &bls12381Pairing{},
+	common.BytesToAddress([]byte{16}): &p256Verify{},
+}
+
+// ActivePrecompiledContracts returns the set of pre-compiled contracts active
+// at the given block number.
+func ActivePrecompiledContracts(config *params.ChainConfig, num *big.Int) map[common.Address]PrecompiledContract {
+	switch {
+	case config.IsCrypto(num):
+		return PrecompiledContractsCrypto
+	case config.IsIstanbul(num):
+		return PrecompiledContractsIstanbul
+	case config.IsByzantium(num):
+		return PrecompiledContractsByzantium
+	default:
+		return PrecompiledContractsHomestead
+	}
 }
 
 // RunPrecompiledContract runs and evaluates the output of a precompiled contract.
@@ -271,15 +327,9 @@ func newTwistPoint(blob []byte) (*bn256.G2, error) {
 	return p, nil
 }
 
-// bn256Add implements a native elliptic curve point addition.
-type bn256Add struct{}
-
-// RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256Add) RequiredGas(input []byte) uint64 {
-	return params.Bn256AddGas
-}
-
-func (c *bn256Add) Run(input []byte) ([]byte, error) {
+// runBn256Add implements the Bn256Add precompile, referenced by both
+// Byzantium and Istanbul operations.
+func runBn256Add(input []byte) ([]byte, error) {
 	x, err := newCurvePoint(getData(input, 0, 64))
 	if err != nil {
 		return nil, err
@@ -293,15 +343,35 @@ func (c *bn256Add) Run(input []byte) ([]byte, error) {
 	return res.Marshal(), nil
 }
 
-// bn256ScalarMul implements a native elliptic curve scalar multiplication.
-type bn256ScalarMul struct{}
+// bn256AddByzantium implements a native elliptic curve point addition
+// conforming to Byzantium consensus rules.
+type bn256AddByzantium struct{}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256ScalarMul) RequiredGas(input []byte) uint64 {
-	return params.Bn256ScalarMulGas
+func (c *bn256AddByzantium) RequiredGas(input []byte) uint64 {
+	return params.Bn256AddGas
+}
+
+func (c *bn256AddByzantium) Run(input []byte) ([]byte, error) {
+	return runBn256Add(input)
 }
 
-func (c *bn256ScalarMul) Run(input []byte) ([]byte, error) {
+// bn256AddIstanbul implements a native elliptic curve point addition
+// conforming to Istanbul consensus rules.
+type bn256AddIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256AddIstanbul) RequiredGas(input []byte) uint64 {
+	return params.Bn256AddGasIstanbul
+}
+
+func (c *bn256AddIstanbul) Run(input []byte) ([]byte, error) {
+	return runBn256Add(input)
+}
+
+// runBn256ScalarMul implements the Bn256ScalarMul precompile, referenced by
+// both Byzantium and Istanbul operations.
+func runBn256ScalarMul(input []byte) ([]byte, error) {
 	p, err := newCurvePoint(getData(input, 0, 64))
 	if err != nil {
 		return nil, err
@@ -311,6 +381,32 @@ func (c *bn256ScalarMul) Run(input []byte) ([]byte, error) {
 	return res.Marshal(), nil
 }
 
+// bn256ScalarMulByzantium implements a native elliptic curve scalar
+// multiplication conforming to Byzantium consensus rules.
+type bn256ScalarMulByzantium struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256ScalarMulByzantium) RequiredGas(input []byte) uint64 {
+	return params.Bn256ScalarMulGas
+}
+
+func (c *bn256ScalarMulByzantium) Run(input []byte) ([]byte, error) {
+	return runBn256ScalarMul(input)
+}
+
+// bn256ScalarMulIstanbul implements a native elliptic curve scalar
+// multiplication conforming to Istanbul consensus rules.
+type bn256ScalarMulIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256ScalarMulIstanbul) RequiredGas(input []byte) uint64 {
+	return params.Bn256ScalarMulGasIstanbul
+}
+
+func (c *bn256ScalarMulIstanbul) Run(input []byte) ([]byte, error) {
+	return runBn256ScalarMul(input)
+}
+
 var (
 	// true32Byte is returned if the bn256 pairing check succeeds.
 	true32Byte = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
@@ -322,15 +418,9 @@ var (
 	errBadPairingInput = errors.New("bad elliptic curve pairing size")
 )
 
-// bn256Pairing implements a pairing pre-compile for the bn256 curve
-type bn256Pairing struct{}
-
-// RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256Pairing) RequiredGas(input []byte) uint64 {
-	return params.Bn256PairingBaseGas + uint64(len(input)/192)*params.Bn256PairingPerPointGas
-}
-
-func (c *bn256Pairing) Run(input []byte) ([]byte, error) {
+// runBn256Pairing implements the Bn256Pairing precompile, referenced by both
+// Byzantium and Istanbul operations.
+func runBn256Pairing(input []byte) ([]byte, error) {
 	// Handle some corner cases cheaply
 	if len(input)%192 > 0 {
 		return nil, errBadPairingInput
@@ -358,3 +448,299 @@ func (c *bn256Pairing) Run(input []byte) ([]byte, error) {
 	}
 	return false32Byte, nil
 }
+
+// bn256PairingByzantium implements a pairing pre-compile for the bn256 curve
+// conforming to Byzantium consensus rules.
+type bn256PairingByzantium struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256PairingByzantium) RequiredGas(input []byte) uint64 {
+	return params.Bn256PairingBaseGas + uint64(len(input)/192)*params.Bn256PairingPerPointGas
+}
+
+func (c *bn256PairingByzantium) Run(input []byte) ([]byte, error) {
+	return runBn256Pairing(input)
+}
+
+// bn256PairingIstanbul implements a pairing pre-compile for the bn256 curve
+// conforming to Istanbul consensus rules.
+type bn256PairingIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256PairingIstanbul) RequiredGas(input []byte) uint64 {
+	return params.Bn256PairingBaseGasIstanbul + uint64(len(input)/192)*params.Bn256PairingPerPointGasIstanbul
+}
+
+func (c *bn256PairingIstanbul) Run(input []byte) ([]byte, error) {
+	return runBn256Pairing(input)
+}
+
+const blake2FInputLength = 213
+
+var (
+	// errBlake2FInvalidInputLength is returned if the blake2f input is not
+	// exactly 213 bytes long.
+	errBlake2FInvalidInputLength = errors.New("invalid input length")
+
+	// errBlake2FInvalidFinalFlag is returned if the blake2f final block
+	// indicator is neither 0 nor 1.
+	errBlake2FInvalidFinalFlag = errors.New("invalid final flag")
+)
+
+// blake2F implements the BLAKE2b compression function F as a native contract
+// (EIP-152).
+type blake2F struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *blake2F) RequiredGas(input []byte) uint64 {
+	// If the input is malformed, we can't calculate the gas, return 0 and let the
+	// actual call choke and fault.
+	if len(input) != blake2FInputLength {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(input[0:4])) * params.Blake2FRoundGas
+}
+
+func (c *blake2F) Run(input []byte) ([]byte, error) {
+	// Make sure the input is valid (correct length and final flag)
+	if len(input) != blake2FInputLength {
+		return nil, errBlake2FInvalidInputLength
+	}
+	if input[212] != 0 && input[212] != 1 {
+		return nil, errBlake2FInvalidFinalFlag
+	}
+	// Parse the input into the Blake2b call parameters
+	var (
+		rounds = binary.BigEndian.Uint32(input[0:4])
+		final  = input[212] == 1
+
+		h [8]uint64
+		m [16]uint64
+		t [2]uint64
+	)
+	for i := 0; i < 8; i++ {
+		offset := 4 + i*8
+		h[i] = binary.LittleEndian.Uint64(input[offset : offset+8])
+	}
+	for i := 0; i < 16; i++ {
+		offset := 68 + i*8
+		m[i] = binary.LittleEndian.Uint64(input[offset : offset+8])
+	}
+	t[0] = binary.LittleEndian.Uint64(input[196:204])
+	t[1] = binary.LittleEndian.Uint64(input[204:212])
+
+	// Execute the compression function, extract and return the result
+	blake2b.F(&h, m, t, final, rounds)
+
+	output := make([]byte, 64)
+	for i := 0; i < 8; i++ {
+		offset := i * 8
+		binary.LittleEndian.PutUint64(output[offset:offset+8], h[i])
+	}
+	return output, nil
+}
+
+// scryptHashInputLength is the length of the input of the scrypt precompile, the
+// seal hash of a header followed by its big endian nonce.
+const scryptHashInputLength = 40
+
+// scryptHash implements the proof-of-work hash of the scrypt consensus engine as
+// a native contract, returning the mix digest and the result to compare against
+// the difficulty target.
+type scryptHash struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *scryptHash) RequiredGas(input []byte) uint64 {
+	return params.ScryptHashGas
+}
+
+func (c *scryptHash) Run(input []byte) ([]byte, error) {
+	input = common.RightPadBytes(input, scryptHashInputLength)
+
+	digest, result := scrypt.ScryptHash(input[:32], binary.BigEndian.Uint64(input[32:40]))
+	return append(digest, result...), nil
+}
+
+var (
+	// errBLS12381InvalidInputLength is returned if the input of a BLS12-381
+	// precompile has an invalid length.
+	errBLS12381InvalidInputLength = errors.New("invalid input length")
+
+	// errBLS12381G1PointSubgroup is returned if a G1 point is not within the
+	// subgroup of order r.
+	errBLS12381G1PointSubgroup = errors.New("g1 point is not on correct subgroup")
+
+	// errBLS12381G2PointSubgroup is returned if a G2 point is not within the
+	// subgroup of order r.
+	errBLS12381G2PointSubgroup = errors.New("g2 point is not on correct subgroup")
+)
+
+// bls12381G1Add implements the BLS12-381 G1 point addition as a native contract.
+type bls12381G1Add struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G1Add) RequiredGas(input []byte) uint64 {
+	return params.Bls12381G1AddGas
+}
+
+func (c *bls12381G1Add) Run(input []byte) ([]byte, error) {
+	if len(input) != 2*bls12381.G1EncodedLength {
+		return nil, errBLS12381InvalidInputLength
+	}
+	p0, err := bls12381.DecodeG1(input[:bls12381.G1EncodedLength])
+	if err != nil {
+		return nil, err
+	}
+	p1, err := bls12381.DecodeG1(input[bls12381.G1EncodedLength:])
+	if err != nil {
+		return nil, err
+	}
+	return new(bls12381.G1).Add(p0, p1).Encode(), nil
+}
+
+// bls12381G1Mul implements the BLS12-381 G1 scalar multiplication as a native
+// contract. As in EIP-2537, the subgroup of the point is not checked.
+type bls12381G1Mul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G1Mul) RequiredGas(input []byte) uint64 {
+	return params.Bls12381G1MulGas
+}
+
+func (c *bls12381G1Mul) Run(input []byte) ([]byte, error) {
+	if len(input) != bls12381.G1EncodedLength+32 {
+		return nil, errBLS12381InvalidInputLength
+	}
+	p, err := bls12381.DecodeG1(input[:bls12381.G1EncodedLength])
+	if err != nil {
+		return nil, err
+	}
+	k := new(big.Int).SetBytes(input[bls12381.G1EncodedLength:])
+	return new(bls12381.G1).ScalarMult(p, k).Encode(), nil
+}
+
+// bls12381G2Add implements the BLS12-381 G2 point addition as a native contract.
+type bls12381G2Add struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G2Add) RequiredGas(input []byte) uint64 {
+	return params.Bls12381G2AddGas
+}
+
+func (c *bls12381G2Add) Run(input []byte) ([]byte, error) {
+	if len(input) != 2*bls12381.G2EncodedLength {
+		return nil, errBLS12381InvalidInputLength
+	}
+	p0, err := bls12381.DecodeG2(input[:bls12381.G2EncodedLength])
+	if err != nil {
+		return nil, err
+	}
+	p1, err := bls12381.DecodeG2(input[bls12381.G2EncodedLength:])
+	if err != nil {
+		return nil, err
+	}
+	return new(bls12381.G2).Add(p0, p1).Encode(), nil
+}
+
+// bls12381G2Mul implements the BLS12-381 G2 scalar multiplication as a native
+// contract. As in EIP-2537, the subgroup of the point is not checked.
+type bls12381G2Mul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G2Mul) RequiredGas(input []byte) uint64 {
+	return params.Bls12381G2MulGas
+}
+
+func (c *bls12381G2Mul) Run(input []byte) ([]byte, error) {
+	if len(input) != bls12381.G2EncodedLength+32 {
+		return nil, errBLS12381InvalidInputLength
+	}
+	p, err := bls12381.DecodeG2(input[:bls12381.G2EncodedLength])
+	if err != nil {
+		return nil, err
+	}
+	k := new(big.Int).SetBytes(input[bls12381.G2EncodedLength:])
+	return new(bls12381.G2).ScalarMult(p, k).Encode(), nil
+}
+
+// bls12381PairingPairLength is the length of a G1, G2 point pair of the pairing
+// check input.
+const bls12381PairingPairLength = bls12381.G1EncodedLength + bls12381.G2EncodedLength
+
+// bls12381Pairing implements the BLS12-381 pairing check as a native contract.
+type bls12381Pairing struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381Pairing) RequiredGas(input []byte) uint64 {
+	k := uint64(len(input) / bls12381PairingPairLength)
+	return params.Bls12381PairingBaseGas + k*params.Bls12381PairingPerPairGas
+}
+
+func (c *bls12381Pairing) Run(input []byte) ([]byte, error) {
+	k := len(input) / bls12381PairingPairLength
+	if k == 0 || len(input) != k*bls12381PairingPairLength {
+		return nil, errBLS12381InvalidInputLength
+	}
+	var (
+		g1s = make([]*bls12381.G1, k)
+		g2s = make([]*bls12381.G2, k)
+	)
+	for i := 0; i < k; i++ {
+		offset := i * bls12381PairingPairLength
+
+		p, err := bls12381.DecodeG1(input[offset : offset+bls12381.G1EncodedLength])
+		if err != nil {
+			return nil, err
+		}
+		q, err := bls12381.DecodeG2(input[offset+bls12381.G1EncodedLength : offset+bls12381PairingPairLength])
+		if err != nil {
+			return nil, err
+		}
+		if !p.InCorrectSubgroup() {
+			return nil, errBLS12381G1PointSubgroup
+		}
+		if !q.InCorrectSubgroup() {
+			return nil, errBLS12381G2PointSubgroup
+		}
+		g1s[i], g2s[i] = p, q
+	}
+	if bls12381.PairingCheck(g1s, g2s) {
+		return true32Byte, nil
+	}
+	return false32Byte, nil
+}
+
+// p256VerifyInputLength is the length of the input of the secp256r1 signature
+// verification, the message hash, the r and s signature values and the x and y
+// coordinates of the public key.
+const p256VerifyInputLength = 160
+
+// p256Verify implements the secp256r1 (NIST P-256) signature verification as a
+// native contract. Valid signatures return 1, invalid ones return no data.
+type p256Verify struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *p256Verify) RequiredGas(input []byte) uint64 {
+	return params.P256VerifyGas
+}
+
+func (c *p256Verify) Run(input []byte) ([]byte, error) {
+	if len(input) != p256VerifyInputLength {
+		return nil, nil
+	}
+	var (
+		hash = input[:32]
+		r    = new(big.Int).SetBytes(input[32:64])
+		s    = new(big.Int).SetBytes(input[64:96])
+		x    = new(big.Int).SetBytes(input[96:128])
+		y    = new(big.Int).SetBytes(input[128:160])
+	)
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil
+	}
+	if ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, hash, r, s) {
+		return true32Byte, nil
+	}
+	return nil, nil
+}
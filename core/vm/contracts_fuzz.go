@@ -0,0 +1,76 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package vm
+
+import (
+	"bytes"
+
+	"github.com/simplechain-org/simplechain/common"
+)
+
+// fuzzPrecompiled runs the precompiled contract on the input twice, checking
+// that it neither panics nor returns differing results.
+func fuzzPrecompiled(p PrecompiledContract, input []byte) int {
+	p.RequiredGas(input)
+
+	ret1, err1 := p.Run(common.CopyBytes(input))
+	ret2, err2 := p.Run(common.CopyBytes(input))
+	if err1 != err2 || !bytes.Equal(ret1, ret2) {
+		panic("non deterministic precompile")
+	}
+	if err1 != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzScryptHash fuzzes the scrypt proof-of-work hash precompile.
+func FuzzScryptHash(input []byte) int {
+	return fuzzPrecompiled(&scryptHash{}, input)
+}
+
+// FuzzBLS12381G1Add fuzzes the BLS12-381 G1 addition precompile.
+func FuzzBLS12381G1Add(input []byte) int {
+	return fuzzPrecompiled(&bls12381G1Add{}, input)
+}
+
+// FuzzBLS12381G1Mul fuzzes the BLS12-381 G1 scalar multiplication precompile.
+func FuzzBLS12381G1Mul(input []byte) int {
+	return fuzzPrecompiled(&bls12381G1Mul{}, input)
+}
+
+// FuzzBLS12381G2Add fuzzes the BLS12-381 G2 addition precompile.
+func FuzzBLS12381G2Add(input []byte) int {
+	return fuzzPrecompiled(&bls12381G2Add{}, input)
+}
+
+// FuzzBLS12381G2Mul fuzzes the BLS12-381 G2 scalar multiplication precompile.
+func FuzzBLS12381G2Mul(input []byte) int {
+	return fuzzPrecompiled(&bls12381G2Mul{}, input)
+}
+
+// FuzzBLS12381Pairing fuzzes the BLS12-381 pairing check precompile.
+func FuzzBLS12381Pairing(input []byte) int {
+	return fuzzPrecompiled(&bls12381Pairing{}, input)
+}
+
+// FuzzP256Verify fuzzes the secp256r1 signature verification precompile.
+func FuzzP256Verify(input []byte) int {
+	return fuzzPrecompiled(&p256Verify{}, input)
+}
@@ -0,0 +1,601 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm/wasm"
+	"github.com/simplechain-org/simplechain/params"
+)
+
+// eeiModule is the module name the EEI host functions are imported from.
+const eeiModule = "ethereum"
+
+var (
+	errEEIValueOverflow   = errors.New("ewasm: value does not fit in its encoding")
+	errEEIInvalidArgument = errors.New("ewasm: invalid host function argument")
+)
+
+// Results of the calling host functions.
+const (
+	eeiCallSuccess = 0
+	eeiCallFailure = 1
+	eeiCallRevert  = 2
+)
+
+// eeiFunc is a host function of the Ethereum Environment Interface.
+type eeiFunc struct {
+	sig wasm.FuncType
+	fn  func(c *ewasmCall, args []uint64) (uint64, error)
+}
+
+var (
+	i32 = wasm.I32
+	i64 = wasm.I64
+
+	noResult  []wasm.ValueType
+	i32Result = []wasm.ValueType{wasm.I32}
+	i64Result = []wasm.ValueType{wasm.I64}
+)
+
+// eeiSig returns the signature of a host function.
+func eeiSig(results []wasm.ValueType, params ...wasm.ValueType) wasm.FuncType {
+	return wasm.FuncType{Params: params, Results: results}
+}
+
+// eeiFuncs are the host functions contracts may import, by name. Memory
+// offsets are i32 values, addresses are 20 bytes, storage keys and values,
+// hashes and topics 32 bytes, and amounts of ether 128 bit little endian
+// integers.
+var eeiFuncs map[string]eeiFunc
+
+func init() {
+	// Assigned at init as the creating host functions lead back to the
+	// validation of the imports against this table.
+	eeiFuncs = map[string]eeiFunc{
+		"useGas":              {eeiSig(noResult, i64), (*ewasmCall).useGas},
+		"getGasLeft":          {eeiSig(i64Result), (*ewasmCall).getGasLeft},
+		"getAddress":          {eeiSig(noResult, i32), (*ewasmCall).getAddress},
+		"getExternalBalance":  {eeiSig(noResult, i32, i32), (*ewasmCall).getExternalBalance},
+		"getBlockHash":        {eeiSig(i32Result, i64, i32), (*ewasmCall).getBlockHash},
+		"getCallDataSize":     {eeiSig(i32Result), (*ewasmCall).getCallDataSize},
+		"callDataCopy":        {eeiSig(noResult, i32, i32, i32), (*ewasmCall).callDataCopy},
+		"getCaller":           {eeiSig(noResult, i32), (*ewasmCall).getCaller},
+		"getCallValue":        {eeiSig(noResult, i32), (*ewasmCall).getCallValue},
+		"getCodeSize":         {eeiSig(i32Result), (*ewasmCall).getCodeSize},
+		"codeCopy":            {eeiSig(noResult, i32, i32, i32), (*ewasmCall).codeCopy},
+		"getExternalCodeSize": {eeiSig(i32Result, i32), (*ewasmCall).getExternalCodeSize},
+		"externalCodeCopy":    {eeiSig(noResult, i32, i32, i32, i32), (*ewasmCall).externalCodeCopy},
+		"getBlockCoinbase":    {eeiSig(noResult, i32), (*ewasmCall).getBlockCoinbase},
+		"getBlockDifficulty":  {eeiSig(noResult, i32), (*ewasmCall).getBlockDifficulty},
+		"getBlockGasLimit":    {eeiSig(i64Result), (*ewasmCall).getBlockGasLimit},
+		"getBlockNumber":      {eeiSig(i64Result), (*ewasmCall).getBlockNumber},
+		"getBlockTimestamp":   {eeiSig(i64Result), (*ewasmCall).getBlockTimestamp},
+		"getTxGasPrice":       {eeiSig(noResult, i32), (*ewasmCall).getTxGasPrice},
+		"getTxOrigin":         {eeiSig(noResult, i32), (*ewasmCall).getTxOrigin},
+		"storageStore":        {eeiSig(noResult, i32, i32), (*ewasmCall).storageStore},
+		"storageLoad":         {eeiSig(noResult, i32, i32), (*ewasmCall).storageLoad},
+		"log":                 {eeiSig(noResult, i32, i32, i32, i32, i32, i32, i32), (*ewasmCall).log},
+		"call":                {eeiSig(i32Result, i64, i32, i32, i32, i32), (*ewasmCall).call},
+		"callCode":            {eeiSig(i32Result, i64, i32, i32, i32, i32), (*ewasmCall).callCode},
+		"callDelegate":        {eeiSig(i32Result, i64, i32, i32, i32), (*ewasmCall).callDelegate},
+		"callStatic":          {eeiSig(i32Result, i64, i32, i32, i32), (*ewasmCall).callStatic},
+		"create":              {eeiSig(i32Result, i32, i32, i32, i32), (*ewasmCall).create},
+		"getReturnDataSize":   {eeiSig(i32Result), (*ewasmCall).getReturnDataSize},
+		"returnDataCopy":      {eeiSig(noResult, i32, i32, i32), (*ewasmCall).returnDataCopy},
+		"finish":              {eeiSig(noResult, i32, i32), (*ewasmCall).finish},
+		"revert":              {eeiSig(noResult, i32, i32), (*ewasmCall).revert},
+		"selfDestruct":        {eeiSig(noResult, i32), (*ewasmCall).selfDestruct},
+	}
+}
+
+// ewasmCall is the execution context of an ewasm contract, implementing the
+// host functions on top of the EVM.
+type ewasmCall struct {
+	in       *EWASMInterpreter
+	contract *Contract
+	vm       *wasm.VM
+	mem      *Memory // Empty EVM memory for pricing the host functions
+
+	returnData []byte // Output of the last call or failed creation
+	ret        []byte // Output of the contract, set by finish or revert
+}
+
+// resolve implements wasm.Resolver over the EEI host functions.
+func (c *ewasmCall) resolve(module, name string, sig wasm.FuncType) (wasm.HostFunc, error) {
+	fn, ok := eeiFuncs[name]
+	if module != eeiModule || !ok || !sig.Equal(fn.sig) {
+		return nil, fmt.Errorf("ewasm: unknown host function %s.%s", module, name)
+	}
+	return func(vm *wasm.VM, args []uint64) (uint64, error) {
+		return fn.fn(c, args)
+	}, nil
+}
+
+// useOpGas charges the gas the EVM charges for the opcode with the given
+// stack items, topmost first.
+func (c *ewasmCall) useOpGas(op OpCode, items ...*big.Int) error {
+	operation := c.in.cfg.JumpTable[op]
+	if !operation.valid {
+		return fmt.Errorf("ewasm: %v is not available", op)
+	}
+	stack := newstack()
+	defer returnStack(stack)
+	for i := len(items) - 1; i >= 0; i-- {
+		stack.push(items[i])
+	}
+	gas, err := operation.gasCost(c.in.gasTable, c.in.evm, c.contract, stack, c.mem, 0)
+	if err != nil || !c.contract.UseGas(gas) {
+		return ErrOutOfGas
+	}
+	return nil
+}
+
+// read copies a region of the linear memory.
+func (c *ewasmCall) read(offset, size uint64) ([]byte, error) {
+	offset, size = uint64(uint32(offset)), uint64(uint32(size))
+
+	mem := c.vm.Memory()
+	if offset+size > uint64(len(mem)) {
+		return nil, wasm.ErrMemoryOutOfBounds
+	}
+	return common.CopyBytes(mem[offset : offset+size]), nil
+}
+
+// write stores data into the linear memory.
+func (c *ewasmCall) write(offset uint64, data []byte) error {
+	offset = uint64(uint32(offset))
+
+	mem := c.vm.Memory()
+	if offset+uint64(len(data)) > uint64(len(mem)) {
+		return wasm.ErrMemoryOutOfBounds
+	}
+	copy(mem[offset:], data)
+	return nil
+}
+
+func (c *ewasmCall) readAddress(offset uint64) (common.Address, error) {
+	b, err := c.read(offset, common.AddressLength)
+	return common.BytesToAddress(b), err
+}
+
+func (c *ewasmCall) readHash(offset uint64) (common.Hash, error) {
+	b, err := c.read(offset, common.HashLength)
+	return common.BytesToHash(b), err
+}
+
+// readU128 reads a little endian 128 bit integer.
+func (c *ewasmCall) readU128(offset uint64) (*big.Int, error) {
+	b, err := c.read(offset, 16)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(reverse(b)), nil
+}
+
+// writeUint stores a little endian integer of the given size in bytes.
+func (c *ewasmCall) writeUint(offset uint64, v *big.Int, size int) error {
+	if v.Sign() < 0 || v.BitLen() > 8*size {
+		return errEEIValueOverflow
+	}
+	return c.write(offset, reverse(math.PaddedBigBytes(v, size)))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func u64(v uint64) *big.Int {
+	return new(big.Int).SetUint64(v)
+}
+
+func (c *ewasmCall) useGas(args []uint64) (uint64, error) {
+	if !c.contract.UseGas(args[0]) {
+		return 0, ErrOutOfGas
+	}
+	return 0, nil
+}
+
+func (c *ewasmCall) getGasLeft(args []uint64) (uint64, error) {
+	if err := c.useOpGas(GAS); err != nil {
+		return 0, err
+	}
+	return c.contract.Gas, nil
+}
+
+func (c *ewasmCall) getAddress(args []uint64) (uint64, error) {
+	if err := c.useOpGas(ADDRESS); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], c.contract.Address().Bytes())
+}
+
+func (c *ewasmCall) getExternalBalance(args []uint64) (uint64, error) {
+	addr, err := c.readAddress(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(BALANCE, addr.Big()); err != nil {
+		return 0, err
+	}
+	return 0, c.writeUint(args[1], c.in.evm.StateDB.GetBalance(addr), 16)
+}
+
+func (c *ewasmCall) getBlockHash(args []uint64) (uint64, error) {
+	if err := c.useOpGas(BLOCKHASH, u64(args[0])); err != nil {
+		return 0, err
+	}
+	num, current := args[0], c.in.evm.BlockNumber.Uint64()
+	if num >= current || current-num > 256 {
+		return eeiCallFailure, nil
+	}
+	return eeiCallSuccess, c.write(args[1], c.in.evm.GetHash(num).Bytes())
+}
+
+func (c *ewasmCall) getCallDataSize(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CALLDATASIZE); err != nil {
+		return 0, err
+	}
+	return uint64(len(c.contract.Input)), nil
+}
+
+func (c *ewasmCall) callDataCopy(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CALLDATACOPY, u64(args[0]), u64(args[1]), u64(args[2])); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], getData(c.contract.Input, uint64(uint32(args[1])), uint64(uint32(args[2]))))
+}
+
+func (c *ewasmCall) getCaller(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CALLER); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], c.contract.Caller().Bytes())
+}
+
+func (c *ewasmCall) getCallValue(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CALLVALUE); err != nil {
+		return 0, err
+	}
+	return 0, c.writeUint(args[0], c.contract.Value(), 16)
+}
+
+func (c *ewasmCall) getCodeSize(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CODESIZE); err != nil {
+		return 0, err
+	}
+	return uint64(len(c.contract.Code)), nil
+}
+
+func (c *ewasmCall) codeCopy(args []uint64) (uint64, error) {
+	if err := c.useOpGas(CODECOPY, u64(args[0]), u64(args[1]), u64(args[2])); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], getData(c.contract.Code, uint64(uint32(args[1])), uint64(uint32(args[2]))))
+}
+
+func (c *ewasmCall) getExternalCodeSize(args []uint64) (uint64, error) {
+	addr, err := c.readAddress(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(EXTCODESIZE, addr.Big()); err != nil {
+		return 0, err
+	}
+	return uint64(c.in.evm.StateDB.GetCodeSize(addr)), nil
+}
+
+func (c *ewasmCall) externalCodeCopy(args []uint64) (uint64, error) {
+	addr, err := c.readAddress(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(EXTCODECOPY, addr.Big(), u64(args[1]), u64(args[2]), u64(args[3])); err != nil {
+		return 0, err
+	}
+	code := c.in.evm.StateDB.GetCode(addr)
+	return 0, c.write(args[1], getData(code, uint64(uint32(args[2])), uint64(uint32(args[3]))))
+}
+
+func (c *ewasmCall) getBlockCoinbase(args []uint64) (uint64, error) {
+	if err := c.useOpGas(COINBASE); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], c.in.evm.Coinbase.Bytes())
+}
+
+func (c *ewasmCall) getBlockDifficulty(args []uint64) (uint64, error) {
+	if err := c.useOpGas(DIFFICULTY); err != nil {
+		return 0, err
+	}
+	return 0, c.writeUint(args[0], c.in.evm.Difficulty, 32)
+}
+
+func (c *ewasmCall) getBlockGasLimit(args []uint64) (uint64, error) {
+	if err := c.useOpGas(GASLIMIT); err != nil {
+		return 0, err
+	}
+	return c.in.evm.GasLimit, nil
+}
+
+func (c *ewasmCall) getBlockNumber(args []uint64) (uint64, error) {
+	if err := c.useOpGas(NUMBER); err != nil {
+		return 0, err
+	}
+	return c.in.evm.BlockNumber.Uint64(), nil
+}
+
+func (c *ewasmCall) getBlockTimestamp(args []uint64) (uint64, error) {
+	if err := c.useOpGas(TIMESTAMP); err != nil {
+		return 0, err
+	}
+	return c.in.evm.Time.Uint64(), nil
+}
+
+func (c *ewasmCall) getTxGasPrice(args []uint64) (uint64, error) {
+	if err := c.useOpGas(GASPRICE); err != nil {
+		return 0, err
+	}
+	return 0, c.writeUint(args[0], c.in.evm.GasPrice, 16)
+}
+
+func (c *ewasmCall) getTxOrigin(args []uint64) (uint64, error) {
+	if err := c.useOpGas(ORIGIN); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], c.in.evm.Origin.Bytes())
+}
+
+func (c *ewasmCall) storageStore(args []uint64) (uint64, error) {
+	if c.in.readOnly {
+		return 0, errWriteProtection
+	}
+	key, err := c.readHash(args[0])
+	if err != nil {
+		return 0, err
+	}
+	value, err := c.readHash(args[1])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(SSTORE, key.Big(), value.Big()); err != nil {
+		return 0, err
+	}
+	c.in.evm.StateDB.SetState(c.contract.Address(), key, value)
+	return 0, nil
+}
+
+func (c *ewasmCall) storageLoad(args []uint64) (uint64, error) {
+	key, err := c.readHash(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(SLOAD, key.Big()); err != nil {
+		return 0, err
+	}
+	value := c.in.evm.StateDB.GetState(c.contract.Address(), key)
+	return 0, c.write(args[1], value.Bytes())
+}
+
+func (c *ewasmCall) log(args []uint64) (uint64, error) {
+	if c.in.readOnly {
+		return 0, errWriteProtection
+	}
+	count := uint32(args[2])
+	if count > 4 {
+		return 0, errEEIInvalidArgument
+	}
+	if err := c.useOpGas(LOG0+OpCode(count), u64(args[0]), u64(args[1])); err != nil {
+		return 0, err
+	}
+	data, err := c.read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	topics := make([]common.Hash, count)
+	for i := range topics {
+		if topics[i], err = c.readHash(args[3+i]); err != nil {
+			return 0, err
+		}
+	}
+	c.in.evm.StateDB.AddLog(&types.Log{
+		Address: c.contract.Address(),
+		Topics:  topics,
+		Data:    data,
+		// This is a non-consensus field, but assigned here because
+		// core/state doesn't know the current block number.
+		BlockNumber: c.in.evm.BlockNumber.Uint64(),
+	})
+	return 0, nil
+}
+
+func (c *ewasmCall) call(args []uint64) (uint64, error) {
+	return c.callContract(CALL, args)
+}
+
+func (c *ewasmCall) callCode(args []uint64) (uint64, error) {
+	return c.callContract(CALLCODE, args)
+}
+
+func (c *ewasmCall) callDelegate(args []uint64) (uint64, error) {
+	return c.callContract(DELEGATECALL, args)
+}
+
+func (c *ewasmCall) callStatic(args []uint64) (uint64, error) {
+	return c.callContract(STATICCALL, args)
+}
+
+// callContract implements the calling host functions, whose arguments are the
+// gas, the address, the value for the value transferring calls, and the input.
+func (c *ewasmCall) callContract(op OpCode, args []uint64) (uint64, error) {
+	addr, err := c.readAddress(args[1])
+	if err != nil {
+		return 0, err
+	}
+	value, rest := new(big.Int), args[2:]
+	if op == CALL || op == CALLCODE {
+		if value, err = c.readU128(args[2]); err != nil {
+			return 0, err
+		}
+		rest = args[3:]
+	}
+	input, err := c.read(rest[0], rest[1])
+	if err != nil {
+		return 0, err
+	}
+	if c.in.readOnly && op == CALL && value.Sign() != 0 {
+		return 0, errWriteProtection
+	}
+	if err := c.useOpGas(op, u64(args[0]), addr.Big(), value); err != nil {
+		return 0, err
+	}
+	var (
+		evm       = c.in.evm
+		gas       = evm.callGasTemp
+		ret       []byte
+		returnGas uint64
+	)
+	if value.Sign() != 0 {
+		gas += params.CallStipend
+	}
+	switch op {
+	case CALL:
+		ret, returnGas, err = evm.Call(c.contract, addr, input, gas, value)
+	case CALLCODE:
+		ret, returnGas, err = evm.CallCode(c.contract, addr, input, gas, value)
+	case DELEGATECALL:
+		ret, returnGas, err = evm.DelegateCall(c.contract, addr, input, gas)
+	case STATICCALL:
+		ret, returnGas, err = evm.StaticCall(c.contract, addr, input, gas)
+	}
+	c.contract.Gas += returnGas
+	c.returnData = ret
+
+	return callResult(err), nil
+}
+
+// callResult converts the error of a call or creation into its EEI result.
+func callResult(err error) uint64 {
+	switch err {
+	case nil:
+		return eeiCallSuccess
+	case errExecutionReverted:
+		return eeiCallRevert
+	default:
+		return eeiCallFailure
+	}
+}
+
+func (c *ewasmCall) create(args []uint64) (uint64, error) {
+	if c.in.readOnly {
+		return 0, errWriteProtection
+	}
+	value, err := c.readU128(args[0])
+	if err != nil {
+		return 0, err
+	}
+	input, err := c.read(args[1], args[2])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(CREATE, value, u64(args[1]), u64(args[2])); err != nil {
+		return 0, err
+	}
+	evm := c.in.evm
+
+	gas := c.contract.Gas
+	if evm.ChainConfig().IsEIP150(evm.BlockNumber) {
+		gas -= gas / 64
+	}
+	c.contract.UseGas(gas)
+	ret, addr, returnGas, err := evm.Create(c.contract, input, gas, value)
+	c.contract.Gas += returnGas
+
+	c.returnData = nil
+	if err == errExecutionReverted {
+		c.returnData = ret
+	}
+	if err == nil {
+		if err := c.write(args[3], addr.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return callResult(err), nil
+}
+
+func (c *ewasmCall) getReturnDataSize(args []uint64) (uint64, error) {
+	if err := c.useOpGas(RETURNDATASIZE); err != nil {
+		return 0, err
+	}
+	return uint64(len(c.returnData)), nil
+}
+
+func (c *ewasmCall) returnDataCopy(args []uint64) (uint64, error) {
+	offset, size := uint64(uint32(args[1])), uint64(uint32(args[2]))
+	if offset+size > uint64(len(c.returnData)) {
+		return 0, errReturnDataOutOfBounds
+	}
+	if err := c.useOpGas(RETURNDATACOPY, u64(args[0]), u64(args[1]), u64(args[2])); err != nil {
+		return 0, err
+	}
+	return 0, c.write(args[0], c.returnData[offset:offset+size])
+}
+
+func (c *ewasmCall) finish(args []uint64) (uint64, error) {
+	ret, err := c.read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	c.ret = ret
+	return 0, errEWASMFinish
+}
+
+func (c *ewasmCall) revert(args []uint64) (uint64, error) {
+	ret, err := c.read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	c.ret = ret
+	return 0, errExecutionReverted
+}
+
+func (c *ewasmCall) selfDestruct(args []uint64) (uint64, error) {
+	if c.in.readOnly {
+		return 0, errWriteProtection
+	}
+	beneficiary, err := c.readAddress(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := c.useOpGas(SELFDESTRUCT, beneficiary.Big()); err != nil {
+		return 0, err
+	}
+	balance := c.in.evm.StateDB.GetBalance(c.contract.Address())
+	c.in.evm.StateDB.AddBalance(beneficiary, balance)
+
+	c.in.evm.StateDB.Suicide(c.contract.Address())
+	return 0, errEWASMFinish
+}
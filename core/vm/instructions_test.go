@@ -469,6 +469,22 @@ func TestOpMstore(t *testing.T) {
 	poolOfIntPools.put(evmInterpreter.intPool)
 }
 
+func TestMemoryAliases(t *testing.T) {
+	mem := NewMemory()
+	mem.Resize(64)
+
+	for _, ret := range [][]byte{mem.GetPtr(0, 32), mem.GetPtr(32, 32), mem.GetPtr(63, 1)} {
+		if !mem.aliases(ret) {
+			t.Errorf("slice of the memory not detected")
+		}
+	}
+	for _, ret := range [][]byte{nil, mem.GetPtr(0, 0), mem.Get(0, 32), make([]byte, 64)} {
+		if mem.aliases(ret) {
+			t.Errorf("slice outside the memory detected")
+		}
+	}
+}
+
 func BenchmarkOpMstore(bench *testing.B) {
 	var (
 		env            = NewEVM(Context{}, nil, params.TestChainConfig, Config{})
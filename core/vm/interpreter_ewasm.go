@@ -0,0 +1,208 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/vm/wasm"
+	"github.com/simplechain-org/simplechain/params"
+)
+
+// ewasmModuleCacheSize is the number of decoded modules kept in the process
+// wide module cache.
+const ewasmModuleCacheSize = 1024
+
+// ewasmModuleCache is a process wide cache of the validated and compiled ewasm
+// contracts, keyed by code hash, shared by all the EVM instances.
+var ewasmModuleCache, _ = lru.New(ewasmModuleCacheSize)
+
+// errEWASMFinish is returned by the host functions ending the execution of a
+// contract successfully.
+var errEWASMFinish = errors.New("ewasm: finish")
+
+// BuiltinEWASMInterpreter is the Config.EWASMInterpreter value selecting the
+// built-in ewasm interpreter, enabling it even before the EWASM fork block.
+const BuiltinEWASMInterpreter = "builtin"
+
+// EWASMInterpreter runs contracts compiled to WebAssembly, exposing the state
+// to them through the Ethereum Environment Interface (EEI). The host functions
+// are charged the gas of their EVM opcode counterparts.
+type EWASMInterpreter struct {
+	evm      *EVM
+	cfg      Config
+	gasTable params.GasTable
+
+	readOnly bool // Whether to throw on stateful modifications
+}
+
+// NewEWASMInterpreter returns a new instance of the built-in ewasm interpreter.
+func NewEWASMInterpreter(evm *EVM, cfg Config) *EWASMInterpreter {
+	// Resolve the jump table whose gas functions price the host functions
+	cfg.JumpTable = NewEVMInterpreter(evm, cfg).cfg.JumpTable
+
+	return &EWASMInterpreter{
+		evm:      evm,
+		cfg:      cfg,
+		gasTable: evm.ChainConfig().GasTable(evm.BlockNumber),
+	}
+}
+
+// Run instantiates the contract's module and invokes its main function.
+//
+// The errors follow the EVM interpreter semantics: any error is a revert-and-
+// consume-all-gas operation except for errExecutionReverted.
+func (in *EWASMInterpreter) Run(contract *Contract, input []byte, readOnly bool) ([]byte, error) {
+	// Increment the call depth which is restricted to 1024
+	in.evm.depth++
+	defer func() { in.evm.depth-- }()
+
+	// Make sure the readOnly is only set if we aren't in readOnly yet.
+	if readOnly && !in.readOnly {
+		in.readOnly = true
+		defer func() { in.readOnly = false }()
+	}
+	contract.Input = input
+
+	module, err := ewasmModule(contract.CodeHash, contract.Code)
+	if err != nil {
+		return nil, err
+	}
+	// The initial memory is paid for like grown one
+	if module.Memory != nil && !contract.UseGas(uint64(module.Memory.Min)*params.EWASMMemoryPageGas) {
+		return nil, ErrOutOfGas
+	}
+	call := &ewasmCall{
+		in:       in,
+		contract: contract,
+		mem:      NewMemory(),
+	}
+	config := wasm.DefaultConfig
+	config.MaxPages, config.PageGas = params.EWASMMaxMemoryPages, params.EWASMMemoryPageGas
+	config.MaxTable, config.TableGas = params.EWASMMaxTableSize, params.EWASMTableGas
+
+	if call.vm, err = wasm.NewVM(module, call.resolve, &ewasmMeter{evm: in.evm, contract: contract}, config); err != nil {
+		if err == wasm.ErrOutOfGas {
+			return nil, ErrOutOfGas
+		}
+		return nil, err
+	}
+	switch _, err = call.vm.Invoke("main"); err {
+	case nil:
+		return nil, nil
+	case errEWASMFinish:
+		return call.ret, nil
+	case errExecutionReverted:
+		return call.ret, err
+	case wasm.ErrOutOfGas:
+		return nil, ErrOutOfGas
+	default:
+		return nil, err
+	}
+}
+
+// CanRun tells if the code is a WebAssembly module.
+func (in *EWASMInterpreter) CanRun(code []byte) bool {
+	return isEWASMCode(code)
+}
+
+// isEWASMCode reports whether the code starts with the WebAssembly preamble.
+func isEWASMCode(code []byte) bool {
+	return bytes.HasPrefix(code, wasm.Magic)
+}
+
+// ewasmModule returns the compiled module of the contract code with the given
+// hash, decoding, validating and caching it if not yet done. Code without a
+// hash, such as creation code, is not cached.
+func ewasmModule(hash common.Hash, code []byte) (*wasm.Module, error) {
+	if hash != (common.Hash{}) {
+		if module, ok := ewasmModuleCache.Get(hash); ok {
+			return module.(*wasm.Module), nil
+		}
+	}
+	module, err := wasm.Decode(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateEWASMModule(module); err != nil {
+		return nil, err
+	}
+	if hash != (common.Hash{}) {
+		ewasmModuleCache.Add(hash, module)
+	}
+	return module, nil
+}
+
+// validateEWASMModule checks that a module satisfies the ewasm contract
+// interface: it exports its main function and its memory and nothing else,
+// imports only EEI functions with their correct signatures and has no start
+// function.
+func validateEWASMModule(module *wasm.Module) error {
+	if module.Start != nil {
+		return fmt.Errorf("ewasm: contract has a start function")
+	}
+	if len(module.Exports) != 2 {
+		return fmt.Errorf("ewasm: contract must export exactly main and memory")
+	}
+	main, ok := module.Export("main")
+	if !ok || main.Kind != wasm.ExternalFunction {
+		return fmt.Errorf("ewasm: contract does not export a main function")
+	}
+	if sig, _ := module.FuncType(main.Index); len(sig.Params) != 0 || len(sig.Results) != 0 {
+		return fmt.Errorf("ewasm: invalid main function signature %v", sig)
+	}
+	if export, ok := module.Export("memory"); !ok || export.Kind != wasm.ExternalMemory {
+		return fmt.Errorf("ewasm: contract does not export its memory")
+	}
+	for _, imp := range module.Imports {
+		if imp.Module != eeiModule {
+			return fmt.Errorf("ewasm: import %s.%s from unknown module", imp.Module, imp.Name)
+		}
+		fn, ok := eeiFuncs[imp.Name]
+		if !ok {
+			return fmt.Errorf("ewasm: unknown host function %s", imp.Name)
+		}
+		if sig := module.Types[imp.Type]; !sig.Equal(fn.sig) {
+			return fmt.Errorf("ewasm: host function %s imported as %v, want %v", imp.Name, sig, fn.sig)
+		}
+	}
+	return nil
+}
+
+// validateEWASMCode checks that the code deployed by a contract creation is a
+// valid ewasm contract, priming the module cache with it.
+func validateEWASMCode(hash common.Hash, code []byte) error {
+	_, err := ewasmModule(hash, code)
+	return err
+}
+
+// ewasmMeter charges the gas of the executed WebAssembly code to the contract,
+// running out of it if the EVM is cancelled.
+type ewasmMeter struct {
+	evm      *EVM
+	contract *Contract
+}
+
+// UseGas implements wasm.GasMeter.
+func (m *ewasmMeter) UseGas(gas uint64) bool {
+	return atomic.LoadInt32(&m.evm.abort) == 0 && m.contract.UseGas(gas)
+}
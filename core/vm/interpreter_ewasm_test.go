@@ -0,0 +1,294 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/vm/wasm"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/params"
+)
+
+// Test contracts, all exporting their main function and memory and importing
+// the used host functions from the ethereum module.
+var (
+	// ewasmCounter increments the last byte of storage slot zero and returns
+	// the slot:
+	//   storageLoad(0, 32); mem[63]++; storageStore(0, 32); finish(32, 32)
+	ewasmCounter = common.FromHex("0061736d0100000001090260027f7f0060000002420308657468657265756d0b73746f726167654c6f6164000008657468657265756d0c73746f7261676553746f7265000008657468657265756d0666696e6973680000030201010503010001071102046d61696e0003066d656d6f727902000a23012100410041201000413f413f2d000041016a3a00004100412010014120412010020b")
+
+	// ewasmDeployCounter is a constructor returning ewasmCounter, stored in a
+	// data segment:
+	//   finish(0, len(ewasmCounter))
+	ewasmDeployCounter = common.FromHex("0061736d0100000001090260027f7f0060000002130108657468657265756d0666696e6973680000030201010503010001071102046d61696e0001066d656d6f727902000a0b010900410041980110000b0b9f01010041000b98010061736d0100000001090260027f7f0060000002420308657468657265756d0b73746f726167654c6f6164000008657468657265756d0c73746f7261676553746f7265000008657468657265756d0666696e6973680000030201010503010001071102046d61696e0003066d656d6f727902000a23012100410041201000413f413f2d000041016a3a00004100412010014120412010020b")
+
+	// ewasmDeployInvalid is a constructor returning ewasmCounter without its
+	// memory export.
+	ewasmDeployInvalid = common.FromHex("0061736d0100000001090260027f7f0060000002130108657468657265756d0666696e6973680000030201010503010001071102046d61696e0001066d656d6f727902000a0b0109004100418f0110000b0b9601010041000b8f010061736d0100000001090260027f7f0060000002420308657468657265756d0b73746f726167654c6f6164000008657468657265756d0c73746f7261676553746f7265000008657468657265756d0666696e6973680000030201010503010001070801046d61696e00030a23012100410041201000413f413f2d000041016a3a00004100412010014120412010020b")
+
+	// ewasmRevert reverts with the data segment "oops":
+	//   revert(0, 4)
+	ewasmRevert = common.FromHex("0061736d0100000001090260027f7f0060000002130108657468657265756d067265766572740000030201010503010001071102046d61696e0001066d656d6f727902000a0a0108004100410410000b0b0a010041000b046f6f7073")
+
+	// ewasmLoop loops forever.
+	ewasmLoop = common.FromHex("0061736d0100000001090260027f7f00600000030201010503010001071102046d61696e0000066d656d6f727902000a0901070003400c000b0b")
+
+	// ewasmLogger logs its caller with a topic of 0xaa bytes and returns the
+	// call value:
+	//   getCaller(0); getCallValue(64); log(0, 20, 1, 32, 0, 0, 0); finish(64, 16)
+	ewasmLogger = common.FromHex("0061736d0100000001170460027f7f0060000060017f0060077f7f7f7f7f7f7f00024f0408657468657265756d0967657443616c6c6572000208657468657265756d0c67657443616c6c56616c7565000208657468657265756d036c6f67000308657468657265756d0666696e6973680000030201010503010001071102046d61696e0004066d656d6f727902000a240122004100100041c00010014100411441014120410041004100100241c000411010030b0b26010041200b20aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	// ewasmCaller calls the contract at 0xc0 and returns its output followed by
+	// the call result:
+	//   mem[96] = call(100000, 0, 32, 0, 0); returnDataCopy(64, 0, 32); finish(64, 33)
+	ewasmCaller = common.FromHex("0061736d0100000001180460027f7f0060000060057e7f7f7f7f017f60037f7f7f00023d0308657468657265756d0463616c6c000208657468657265756d0e72657475726e44617461436f7079000308657468657265756d0666696e6973680000030201010503010001071102046d61696e0003066d656d6f727902000a2801260041e00042a08d06410041204100410010003a000041c00041004120100141c000412110020b0b1a010041000b1400000000000000000000000000000000000000c0")
+)
+
+// newEWASMTestEVM creates an EVM with the ewasm fork activated, deploying the
+// given contracts.
+func newEWASMTestEVM(contracts map[common.Address][]byte) (*EVM, *state.StateDB) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	for addr, code := range contracts {
+		statedb.CreateAccount(addr)
+		statedb.SetCode(addr, code)
+	}
+	vmctx := Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	}
+	config := *params.TestChainConfig
+	config.EWASMBlock = big.NewInt(0)
+
+	return NewEVM(vmctx, statedb, &config, Config{}), statedb
+}
+
+func TestEWASMStorage(t *testing.T) {
+	counter := common.HexToAddress("0xc0")
+	evm, statedb := newEWASMTestEVM(map[common.Address][]byte{counter: ewasmCounter})
+
+	for i := byte(1); i <= 2; i++ {
+		ret, gas, err := evm.Call(AccountRef(common.Address{}), counter, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		if want := common.BytesToHash([]byte{i}); !bytes.Equal(ret, want[:]) {
+			t.Errorf("call %d: output mismatch: have %x, want %x", i, ret, want)
+		}
+		if used := 100000 - gas; used < params.SstoreResetGas {
+			t.Errorf("call %d: storage not charged, used %d gas", i, used)
+		}
+	}
+	if have := statedb.GetState(counter, common.Hash{}); have != common.BytesToHash([]byte{2}) {
+		t.Errorf("storage mismatch: have %x", have)
+	}
+	// Static calls must not modify the storage
+	if _, _, err := evm.StaticCall(AccountRef(common.Address{}), counter, nil, 100000); err != errWriteProtection {
+		t.Errorf("static call error mismatch: have %v, want %v", err, errWriteProtection)
+	}
+}
+
+func TestEWASMDeployment(t *testing.T) {
+	evm, statedb := newEWASMTestEVM(nil)
+
+	_, addr, _, err := evm.Create(AccountRef(common.Address{}), ewasmDeployCounter, 1000000, new(big.Int))
+	if err != nil {
+		t.Fatalf("deployment failed: %v", err)
+	}
+	if code := statedb.GetCode(addr); !bytes.Equal(code, ewasmCounter) {
+		t.Fatalf("deployed code mismatch: have %x", code)
+	}
+	if ret, _, err := evm.Call(AccountRef(common.Address{}), addr, nil, 100000, new(big.Int)); err != nil || len(ret) != 32 || ret[31] != 1 {
+		t.Errorf("deployed contract call failed: %x, %v", ret, err)
+	}
+	// Code violating the contract interface must be rejected
+	_, addr, gas, err := evm.Create(AccountRef(common.Address{}), ewasmDeployInvalid, 1000000, new(big.Int))
+	if err == nil {
+		t.Fatalf("invalid contract deployed")
+	}
+	if gas != 0 {
+		t.Errorf("gas left after invalid deployment: %d", gas)
+	}
+	if code := statedb.GetCode(addr); len(code) != 0 {
+		t.Errorf("invalid contract code stored: %x", code)
+	}
+}
+
+func TestEWASMRevertAndOutOfGas(t *testing.T) {
+	var (
+		reverter = common.HexToAddress("0xc1")
+		looper   = common.HexToAddress("0xc2")
+	)
+	evm, _ := newEWASMTestEVM(map[common.Address][]byte{reverter: ewasmRevert, looper: ewasmLoop})
+
+	ret, gas, err := evm.Call(AccountRef(common.Address{}), reverter, nil, 100000, new(big.Int))
+	if err != errExecutionReverted {
+		t.Fatalf("revert error mismatch: have %v, want %v", err, errExecutionReverted)
+	}
+	if string(ret) != "oops" {
+		t.Errorf("revert output mismatch: have %q", ret)
+	}
+	if gas == 0 {
+		t.Errorf("revert consumed all gas")
+	}
+	if _, gas, err = evm.Call(AccountRef(common.Address{}), looper, nil, 100000, new(big.Int)); err != ErrOutOfGas {
+		t.Fatalf("loop error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+	if gas != 0 {
+		t.Errorf("gas left after running out of it: %d", gas)
+	}
+}
+
+func TestEWASMEnvironment(t *testing.T) {
+	var (
+		logger = common.HexToAddress("0xc3")
+		caller = common.HexToAddress("0xb0")
+	)
+	evm, statedb := newEWASMTestEVM(map[common.Address][]byte{logger: ewasmLogger})
+
+	ret, _, err := evm.Call(AccountRef(caller), logger, nil, 100000, big.NewInt(0x0102))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if want := append([]byte{0x02, 0x01}, make([]byte, 14)...); !bytes.Equal(ret, want) {
+		t.Errorf("call value mismatch: have %x, want %x", ret, want)
+	}
+	logs := statedb.Logs()
+	if len(logs) != 1 {
+		t.Fatalf("log count mismatch: have %d, want 1", len(logs))
+	}
+	if logs[0].Address != logger || !bytes.Equal(logs[0].Data, caller[:]) {
+		t.Errorf("log mismatch: address %x, data %x", logs[0].Address, logs[0].Data)
+	}
+	if want := common.BytesToHash(bytes.Repeat([]byte{0xaa}, 32)); len(logs[0].Topics) != 1 || logs[0].Topics[0] != want {
+		t.Errorf("log topics mismatch: have %x", logs[0].Topics)
+	}
+}
+
+func TestEWASMCall(t *testing.T) {
+	var (
+		counter = common.HexToAddress("0xc0")
+		caller  = common.HexToAddress("0xd0")
+	)
+	evm, statedb := newEWASMTestEVM(map[common.Address][]byte{counter: ewasmCounter, caller: ewasmCaller})
+
+	ret, _, err := evm.Call(AccountRef(common.Address{}), caller, nil, 200000, new(big.Int))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(ret) != 33 || ret[31] != 1 || ret[32] != eeiCallSuccess {
+		t.Errorf("output mismatch: have %x", ret)
+	}
+	if have := statedb.GetState(counter, common.Hash{}); have != common.BytesToHash([]byte{1}) {
+		t.Errorf("callee storage mismatch: have %x", have)
+	}
+	// Without enough gas for the storage write the inner call fails, leaving
+	// no return data to copy
+	if _, _, err = evm.Call(AccountRef(common.Address{}), caller, nil, 15000, new(big.Int)); err != errReturnDataOutOfBounds {
+		t.Errorf("error mismatch: have %v, want %v", err, errReturnDataOutOfBounds)
+	}
+	if have := statedb.GetState(counter, common.Hash{}); have != common.BytesToHash([]byte{1}) {
+		t.Errorf("failed call modified callee storage: %x", have)
+	}
+}
+
+func TestEWASMForkActivation(t *testing.T) {
+	counter := common.HexToAddress("0xc0")
+	evm, statedb := newEWASMTestEVM(map[common.Address][]byte{counter: ewasmCounter})
+	evm.chainConfig.EWASMBlock = big.NewInt(2)
+	evm = NewEVM(evm.Context, statedb, evm.chainConfig, Config{})
+
+	// Before the fork the module is EVM code stopping at its leading zero byte
+	ret, _, err := evm.Call(AccountRef(common.Address{}), counter, nil, 100000, new(big.Int))
+	if err != nil || len(ret) != 0 {
+		t.Fatalf("pre-fork call mismatch: %x, %v", ret, err)
+	}
+	if have := statedb.GetState(counter, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("pre-fork call modified storage: %x", have)
+	}
+}
+
+func TestEWASMBuiltinInterpreter(t *testing.T) {
+	counter := common.HexToAddress("0xc0")
+	evm, statedb := newEWASMTestEVM(map[common.Address][]byte{counter: ewasmCounter})
+	evm.chainConfig.EWASMBlock = nil
+	evm = NewEVM(evm.Context, statedb, evm.chainConfig, Config{EWASMInterpreter: BuiltinEWASMInterpreter})
+
+	// Selecting the built-in interpreter runs the module without the fork
+	ret, _, err := evm.Call(AccountRef(common.Address{}), counter, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if want := common.BytesToHash([]byte{1}); !bytes.Equal(ret, want[:]) {
+		t.Errorf("output mismatch: have %x, want %x", ret, want)
+	}
+	if _, _, _, err := evm.Create(AccountRef(common.Address{}), ewasmDeployInvalid, 1000000, new(big.Int)); err == nil {
+		t.Errorf("invalid contract deployed")
+	}
+}
+
+func TestEWASMModuleValidation(t *testing.T) {
+	valid := func() *wasm.Module {
+		return &wasm.Module{
+			Types: []wasm.FuncType{{}, eeiFuncs["finish"].sig},
+			Imports: []wasm.Import{
+				{Module: eeiModule, Name: "finish", Type: 1},
+			},
+			Functions: []*wasm.Function{{Type: 0}},
+			Memory:    &wasm.Limits{Min: 1},
+			Exports: []wasm.Export{
+				{Name: "main", Kind: wasm.ExternalFunction, Index: 1},
+				{Name: "memory", Kind: wasm.ExternalMemory},
+			},
+		}
+	}
+	if err := validateEWASMModule(valid()); err != nil {
+		t.Fatalf("valid module rejected: %v", err)
+	}
+	tests := []struct {
+		name   string
+		mutate func(m *wasm.Module)
+	}{
+		{"start function", func(m *wasm.Module) { m.Start = new(uint32) }},
+		{"missing main", func(m *wasm.Module) { m.Exports[0].Name = "other" }},
+		{"imported main", func(m *wasm.Module) { m.Exports[0].Index = 0 }},
+		{"missing memory", func(m *wasm.Module) { m.Exports = m.Exports[:1] }},
+		{"extra export", func(m *wasm.Module) {
+			m.Exports = append(m.Exports, wasm.Export{Name: "other", Kind: wasm.ExternalFunction, Index: 1})
+		}},
+		{"foreign module", func(m *wasm.Module) { m.Imports[0].Module = "env" }},
+		{"unknown function", func(m *wasm.Module) { m.Imports[0].Name = "exit" }},
+		{"wrong signature", func(m *wasm.Module) { m.Imports[0].Type = 0 }},
+	}
+	for _, tt := range tests {
+		m := valid()
+		tt.mutate(m)
+		if err := validateEWASMModule(m); err == nil {
+			t.Errorf("%s: invalid module accepted", tt.name)
+		}
+	}
+}
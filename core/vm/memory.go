@@ -19,10 +19,22 @@ package vm
 import (
 	"fmt"
 	"math/big"
+	"sync"
 
 	"github.com/simplechain-org/simplechain/common/math"
 )
 
+// maxPooledMemory is the capacity above which memories aren't recycled, so that
+// large memory expansions aren't kept alive by the pool.
+const maxPooledMemory = 64 * 1024
+
+// memoryPool recycles the memories of finished call frames.
+var memoryPool = sync.Pool{
+	New: func() interface{} {
+		return NewMemory()
+	},
+}
+
 // Memory implements a simple memory model for the ethereum virtual machine.
 type Memory struct {
 	store       []byte
@@ -34,6 +46,36 @@ func NewMemory() *Memory {
 	return &Memory{}
 }
 
+// newMemory retrieves an empty memory from the pool.
+func newMemory() *Memory {
+	return memoryPool.Get().(*Memory)
+}
+
+// returnMemory resets the memory and puts it back into the pool. No slice of
+// the memory may be used anymore by the caller.
+func returnMemory(m *Memory) {
+	if !m.recyclable() {
+		return
+	}
+	m.store, m.lastGasCost = m.store[:0], 0
+	memoryPool.Put(m)
+}
+
+// recyclable returns whether the memory is put back into the pool when returned.
+func (m *Memory) recyclable() bool {
+	return cap(m.store) <= maxPooledMemory
+}
+
+// aliases returns whether the given slice points into the memory. Slices of the
+// memory share its backing array, so their capacity gives away their offset.
+func (m *Memory) aliases(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	offset := cap(m.store) - cap(b)
+	return offset >= 0 && offset < len(m.store) && &m.store[offset] == &b[0]
+}
+
 // Set sets offset + size to value
 func (m *Memory) Set(offset, size uint64, value []byte) {
 	// It's possible the offset is greater than 0 and size equals 0. This is because
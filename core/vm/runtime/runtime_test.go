@@ -17,16 +17,24 @@
 package runtime
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"math/big"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/simplechain-org/simplechain/accounts/abi"
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/core/vm"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rlp"
 )
 
 func TestDefaults(t *testing.T) {
@@ -203,3 +211,174 @@ func BenchmarkEVM_CREATE2_1200(bench *testing.B) {
 	// initcode size 1200K, repeatedly calls CREATE2 and then modifies the mem contents
 	benchmarkEVM_Create(bench, "5b5862124f80600080f5600152600056")
 }
+
+func TestReturnDataDetached(t *testing.T) {
+	// Returns the 32 bytes of memory written by the calldata value
+	code := common.FromHex("60003560005260206000f3")
+
+	first, _, err := Execute(code, common.LeftPadBytes([]byte{1}, 32), nil)
+	if err != nil {
+		t.Fatalf("failed to execute code: %v", err)
+	}
+	// Executing again must not alter the data returned by the first execution,
+	// even though the memory of the first call frame is reused
+	if _, _, err := Execute(code, common.LeftPadBytes([]byte{2}, 32), nil); err != nil {
+		t.Fatalf("failed to execute code: %v", err)
+	}
+	if want := common.LeftPadBytes([]byte{1}, 32); !bytes.Equal(first, want) {
+		t.Errorf("return data mismatch: have %x, want %x", first, want)
+	}
+}
+
+// benchmarkWorkload repeatedly calls the code deployed at the receiver, with a
+// fresh EVM per call as done for every transaction of a block. The other
+// accounts are deployed alongside. The gas throughput is reported as the byte
+// throughput, 1 MB/s corresponding to 1 Mgas/s.
+func benchmarkWorkload(b *testing.B, code string, accounts map[common.Address]string) {
+	var (
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+		sender     = common.BytesToAddress([]byte("sender"))
+		receiver   = common.BytesToAddress([]byte("receiver"))
+	)
+	statedb.CreateAccount(sender)
+	statedb.SetCode(receiver, common.FromHex(code))
+	for addr, code := range accounts {
+		statedb.SetCode(addr, common.FromHex(code))
+	}
+	config := Config{
+		Origin:      sender,
+		State:       statedb,
+		GasLimit:    10000000,
+		BlockNumber: big.NewInt(1),
+	}
+	// Measure the gas used by a single call
+	_, left, err := Call(receiver, nil, &config)
+	if err != nil {
+		b.Fatalf("failed to execute workload: %v", err)
+	}
+	b.SetBytes(int64(config.GasLimit - left))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Call(receiver, nil, &config)
+	}
+}
+
+func BenchmarkWorkloadTransfer(b *testing.B) {
+	// Token transfer: moves 100 units between two balance slots and emits a
+	// Transfer event
+	benchmarkWorkload(b, "606433540333556064"+"60b0540160b055"+"606460005260b0337f"+
+		"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"+"60206000a300", nil)
+}
+
+func BenchmarkWorkloadKeccakLoop(b *testing.B) {
+	// Hashes 64 bytes of memory 10000 times
+	benchmarkWorkload(b, "6127105b"+"6040600020"+"50"+"600190038060035700", nil)
+}
+
+func BenchmarkWorkloadJumpLoop(b *testing.B) {
+	// Jumps 10000 times to the next instruction
+	benchmarkWorkload(b, "6127105b"+"6007565b"+"600190038060035700", nil)
+}
+
+func BenchmarkWorkloadCallLoop(b *testing.B) {
+	// Calls a contract returning a memory word 1000 times, creating a call frame
+	// with its own stack, memory and jump destination analysis every time
+	benchmarkWorkload(b, "6103e85b"+"6020600060006000600060c05af150"+"600190038060035700", map[common.Address]string{
+		common.HexToAddress("0xc0"): "602a60005260206000f3",
+	})
+}
+
+// replayTest is a recorded mainnet transaction along with the prestate and the
+// block context it was executed in, as stored by the call tracer test suite.
+type replayTest struct {
+	Genesis *core.Genesis `json:"genesis"`
+	Context struct {
+		Number     math.HexOrDecimal64   `json:"number"`
+		Difficulty *math.HexOrDecimal256 `json:"difficulty"`
+		Time       math.HexOrDecimal64   `json:"timestamp"`
+		GasLimit   math.HexOrDecimal64   `json:"gasLimit"`
+		Miner      common.Address        `json:"miner"`
+	} `json:"context"`
+	Input string `json:"input"`
+}
+
+// BenchmarkTransactionReplay replays the recorded mainnet transactions of the
+// call tracer test suite on top of their prestate, measuring the interpreter on
+// real-world contract code. The gas throughput is reported as the byte
+// throughput, 1 MB/s corresponding to 1 Mgas/s.
+func BenchmarkTransactionReplay(b *testing.B) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "..", "eth", "tracers", "testdata", "call_tracer_*.json"))
+	if err != nil || len(files) == 0 {
+		b.Fatalf("failed to retrieve recorded transactions: %v", err)
+	}
+	for _, file := range files {
+		blob, err := ioutil.ReadFile(file)
+		if err != nil {
+			b.Fatalf("failed to read recorded transaction: %v", err)
+		}
+		test := new(replayTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			b.Fatalf("failed to parse recorded transaction: %v", err)
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "call_tracer_"), ".json")
+		b.Run(name, func(b *testing.B) {
+			benchmarkTransactionReplay(b, test)
+		})
+	}
+}
+
+func benchmarkTransactionReplay(b *testing.B, test *replayTest) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		b.Fatalf("failed to parse transaction: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		b.Fatalf("failed to prepare transaction: %v", err)
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      msg.From(),
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	// Assemble the prestate, committing it to start from a clean state
+	sdb := state.NewDatabase(ethdb.NewMemDatabase())
+	statedb, _ := state.New(common.Hash{}, sdb)
+	for addr, account := range test.Genesis.Alloc {
+		statedb.SetCode(addr, account.Code)
+		statedb.SetNonce(addr, account.Nonce)
+		statedb.SetBalance(addr, account.Balance)
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	root, _ := statedb.Commit(false)
+
+	replay := func(statedb *state.StateDB) uint64 {
+		evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{})
+		_, gas, _, err := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(msg.Gas())).TransitionDb()
+		if err != nil {
+			b.Fatalf("failed to execute transaction: %v", err)
+		}
+		return gas
+	}
+	statedb, _ = state.New(root, sdb)
+	b.SetBytes(int64(replay(statedb)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		statedb, _ := state.New(root, sdb)
+		b.StartTimer()
+
+		replay(statedb)
+	}
+}
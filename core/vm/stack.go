@@ -19,8 +19,16 @@ package vm
 import (
 	"fmt"
 	"math/big"
+	"sync"
 )
 
+// stackPool recycles the stacks of finished call frames.
+var stackPool = sync.Pool{
+	New: func() interface{} {
+		return &Stack{data: make([]*big.Int, 0, 1024)}
+	},
+}
+
 // Stack is an object for basic stack operations. Items popped to the stack are
 // expected to be changed and modified. stack does not take care of adding newly
 // initialised objects.
@@ -29,7 +37,14 @@ type Stack struct {
 }
 
 func newstack() *Stack {
-	return &Stack{data: make([]*big.Int, 0, 1024)}
+	return stackPool.Get().(*Stack)
+}
+
+// returnStack puts the stack back into the pool. The items must not be used
+// anymore by the caller.
+func returnStack(s *Stack) {
+	s.data = s.data[:0]
+	stackPool.Put(s)
 }
 
 // Data returns the underlying big.Int array.
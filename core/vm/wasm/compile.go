@@ -0,0 +1,756 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"fmt"
+)
+
+// Opcodes of the supported instructions.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+	opI32Load      = 0x28
+	opI64Load      = 0x29
+	opI32Load8S    = 0x2c
+	opI32Load8U    = 0x2d
+	opI32Load16S   = 0x2e
+	opI32Load16U   = 0x2f
+	opI64Load8S    = 0x30
+	opI64Load8U    = 0x31
+	opI64Load16S   = 0x32
+	opI64Load16U   = 0x33
+	opI64Load32S   = 0x34
+	opI64Load32U   = 0x35
+	opI32Store     = 0x36
+	opI64Store     = 0x37
+	opI32Store8    = 0x3a
+	opI32Store16   = 0x3b
+	opI64Store8    = 0x3c
+	opI64Store16   = 0x3d
+	opI64Store32   = 0x3e
+	opMemorySize   = 0x3f
+	opMemoryGrow   = 0x40
+	opI32Const     = 0x41
+	opI64Const     = 0x42
+	opI32Eqz       = 0x45
+	opI32Eq        = 0x46
+	opI32GeU       = 0x4f
+	opI64Eqz       = 0x50
+	opI64Eq        = 0x51
+	opI64GeU       = 0x5a
+	opI32Clz       = 0x67
+	opI32Ctz       = 0x68
+	opI32Popcnt    = 0x69
+	opI32Add       = 0x6a
+	opI32Sub       = 0x6b
+	opI32Mul       = 0x6c
+	opI32DivS      = 0x6d
+	opI32DivU      = 0x6e
+	opI32RemS      = 0x6f
+	opI32RemU      = 0x70
+	opI32And       = 0x71
+	opI32Or        = 0x72
+	opI32Xor       = 0x73
+	opI32Shl       = 0x74
+	opI32ShrS      = 0x75
+	opI32ShrU      = 0x76
+	opI32Rotl      = 0x77
+	opI32Rotr      = 0x78
+	opI64Clz       = 0x79
+	opI64Ctz       = 0x7a
+	opI64Popcnt    = 0x7b
+	opI64Add       = 0x7c
+	opI64Sub       = 0x7d
+	opI64Mul       = 0x7e
+	opI64DivS      = 0x7f
+	opI64DivU      = 0x80
+	opI64RemS      = 0x81
+	opI64RemU      = 0x82
+	opI64And       = 0x83
+	opI64Or        = 0x84
+	opI64Xor       = 0x85
+	opI64Shl       = 0x86
+	opI64ShrS      = 0x87
+	opI64ShrU      = 0x88
+	opI64Rotl      = 0x89
+	opI64Rotr      = 0x8a
+	opI32WrapI64   = 0xa7
+	opI64ExtendS   = 0xac
+	opI64ExtendU   = 0xad
+
+	// Internal instructions emitted by the compiler
+	opGas      = 0xf0 // Charge the gas of the basic block starting here
+	opBrUnless = 0xf1 // Branch if the top of the stack is zero
+)
+
+// Static gas costs of the instructions, charged at the entry of the basic
+// blocks containing them. Growing the memory is additionally charged per page
+// at runtime.
+const (
+	gasBase     = 1  // Cost of the simple instructions
+	gasMemory   = 3  // Cost of the loads and stores
+	gasMul      = 3  // Cost of the multiplications
+	gasDiv      = 5  // Cost of the divisions and remainders
+	gasBranch   = 2  // Cost of the conditional branches
+	gasCall     = 10 // Cost of a direct function call
+	gasIndirect = 15 // Cost of an indirect function call
+	gasGrow     = 10 // Base cost of growing the memory
+)
+
+// instr is a compiled instruction.
+type instr struct {
+	op   byte
+	a, b uint32 // Index immediates, or branch target and stack height
+	c    uint64 // Constant, memory offset, block gas or branch arity
+}
+
+// jump is the target of a branch.
+type jump struct {
+	pc     uint32 // Instruction to continue at
+	height uint32 // Operand stack height to unwind to
+	arity  uint32 // Number of values carried over
+}
+
+// control is a structured control instruction being validated.
+type control struct {
+	op          byte
+	results     []ValueType
+	height      int      // Operand stack height at the entry of the block
+	unreachable bool     // Whether the rest of the block is dead code
+	start       int      // Instruction branches to a loop continue at
+	fixups      []int    // Branches to the end of the block to be patched
+	tableFixups [][2]int // Table entries jumping to the end of the block
+	elseFixup   int      // Conditional branch of an if to be patched, or -1
+}
+
+// labelTypes returns the types of the values a branch to the block carries.
+func (c *control) labelTypes() []ValueType {
+	if c.op == opLoop {
+		return nil
+	}
+	return c.results
+}
+
+// compiler validates the body of a function while translating it to the
+// internal instruction stream.
+type compiler struct {
+	m      *Module
+	f      *Function
+	locals []ValueType // Parameters followed by locals
+
+	vals  []ValueType
+	ctrls []*control
+	code  []instr
+
+	gasPC int // Gas instruction of the current basic block
+	max   int // Maximum operand stack height
+}
+
+// compile validates and compiles a function body.
+func (m *Module) compile(f *Function, body []byte) error {
+	r := &reader{buf: body}
+	sig := m.Types[f.Type]
+
+	c := &compiler{m: m, f: f, locals: append([]ValueType{}, sig.Params...)}
+	groups, err := r.count(maxLocals)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < groups; i++ {
+		n, err := r.count(maxLocals)
+		if err != nil {
+			return err
+		}
+		t, err := r.valueType()
+		if err != nil {
+			return err
+		}
+		if len(f.Locals)+int(n) > maxLocals {
+			return fmt.Errorf("too many locals")
+		}
+		for j := uint32(0); j < n; j++ {
+			f.Locals = append(f.Locals, t)
+		}
+	}
+	c.locals = append(c.locals, f.Locals...)
+
+	c.pushCtrl(opBlock, sig.Results)
+	c.startBlock()
+	for len(c.ctrls) > 0 {
+		if r.eof() {
+			return errUnexpectedEnd
+		}
+		if err := c.step(r); err != nil {
+			return err
+		}
+	}
+	if !r.eof() {
+		return fmt.Errorf("trailing bytes after function end")
+	}
+	f.code, f.maxStack = c.code, c.max
+	return nil
+}
+
+// startBlock begins a new metered basic block at the current instruction.
+func (c *compiler) startBlock() {
+	c.gasPC = len(c.code)
+	c.code = append(c.code, instr{op: opGas})
+}
+
+func (c *compiler) emit(in instr, gas uint64) int {
+	c.code[c.gasPC].c += gas
+	c.code = append(c.code, in)
+	return len(c.code) - 1
+}
+
+func (c *compiler) push(t ValueType) {
+	c.vals = append(c.vals, t)
+	if len(c.vals) > c.max {
+		c.max = len(c.vals)
+	}
+}
+
+func (c *compiler) pop(expect ValueType) (ValueType, error) {
+	top := c.ctrls[len(c.ctrls)-1]
+	if len(c.vals) == top.height {
+		if top.unreachable {
+			return expect, nil
+		}
+		return 0, fmt.Errorf("operand stack underflow")
+	}
+	t := c.vals[len(c.vals)-1]
+	c.vals = c.vals[:len(c.vals)-1]
+	if t != expect && t != unknown && expect != unknown {
+		return 0, fmt.Errorf("type mismatch: have %v, want %v", t, expect)
+	}
+	if t == unknown {
+		return expect, nil
+	}
+	return t, nil
+}
+
+func (c *compiler) popAll(types []ValueType) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		if _, err := c.pop(types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) pushCtrl(op byte, results []ValueType) *control {
+	ctrl := &control{op: op, results: results, height: len(c.vals), start: len(c.code), elseFixup: -1}
+	c.ctrls = append(c.ctrls, ctrl)
+	return ctrl
+}
+
+func (c *compiler) popCtrl() (*control, error) {
+	top := c.ctrls[len(c.ctrls)-1]
+	if err := c.popAll(top.results); err != nil {
+		return nil, err
+	}
+	if len(c.vals) != top.height {
+		return nil, fmt.Errorf("operand stack not empty at block end")
+	}
+	c.ctrls = c.ctrls[:len(c.ctrls)-1]
+	return top, nil
+}
+
+// setUnreachable marks the rest of the current block as dead code.
+func (c *compiler) setUnreachable() {
+	top := c.ctrls[len(c.ctrls)-1]
+	c.vals = c.vals[:top.height]
+	top.unreachable = true
+}
+
+// label resolves the target of a branch to the given relative depth.
+func (c *compiler) label(depth uint32) (*control, error) {
+	if depth >= uint32(len(c.ctrls)) {
+		return nil, fmt.Errorf("unknown label %d", depth)
+	}
+	return c.ctrls[len(c.ctrls)-1-int(depth)], nil
+}
+
+// branch emits a branch instruction to the given block, registering it for
+// patching if the target is the yet unknown end of the block.
+func (c *compiler) branch(op byte, target *control, gas uint64) {
+	in := instr{op: op, b: uint32(target.height), c: uint64(len(target.labelTypes()))}
+	if target.op == opLoop {
+		in.a = uint32(target.start)
+		c.emit(in, gas)
+		return
+	}
+	target.fixups = append(target.fixups, c.emit(in, gas))
+}
+
+func (c *compiler) blockType(r *reader) ([]ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if b == 0x40 {
+		return nil, nil
+	}
+	r.pos--
+	t, err := r.valueType()
+	if err != nil {
+		return nil, err
+	}
+	return []ValueType{t}, nil
+}
+
+func (c *compiler) memarg(r *reader) (uint64, error) {
+	if c.m.Memory == nil {
+		return 0, fmt.Errorf("memory access without memory")
+	}
+	if _, err := r.u32(); err != nil { // alignment hint
+		return 0, err
+	}
+	offset, err := r.u32()
+	return uint64(offset), err
+}
+
+// step validates and compiles a single instruction.
+func (c *compiler) step(r *reader) error {
+	op, err := r.byte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case op == opUnreachable:
+		c.emit(instr{op: op}, gasBase)
+		c.setUnreachable()
+
+	case op == opNop:
+
+	case op == opBlock || op == opLoop:
+		results, err := c.blockType(r)
+		if err != nil {
+			return err
+		}
+		if op == opLoop {
+			// Every iteration pays for the loop body
+			c.startBlock()
+			c.pushCtrl(op, results).start = c.gasPC
+		} else {
+			c.pushCtrl(op, results)
+		}
+
+	case op == opIf:
+		results, err := c.blockType(r)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		fixup := c.emit(instr{op: opBrUnless}, gasBranch)
+		ctrl := c.pushCtrl(op, results)
+		ctrl.elseFixup = fixup
+		c.startBlock()
+
+	case op == opElse:
+		top := c.ctrls[len(c.ctrls)-1]
+		if top.op != opIf || top.elseFixup < 0 {
+			return fmt.Errorf("else without if")
+		}
+		if _, err := c.popCtrl(); err != nil {
+			return err
+		}
+		top.fixups = append(top.fixups, c.emit(instr{op: opBr, b: uint32(top.height), c: uint64(len(top.results))}, gasBase))
+		c.code[top.elseFixup].a = uint32(len(c.code))
+		c.code[top.elseFixup].b = uint32(top.height)
+		top.elseFixup = -1
+		top.unreachable = false
+		c.ctrls = append(c.ctrls, top)
+		c.startBlock()
+
+	case op == opEnd:
+		top, err := c.popCtrl()
+		if err != nil {
+			return err
+		}
+		if top.elseFixup >= 0 && len(top.results) != 0 {
+			return fmt.Errorf("if without else must not produce values")
+		}
+		end := uint32(len(c.code))
+		for _, pc := range top.fixups {
+			c.code[pc].a = end
+		}
+		for _, fixup := range top.tableFixups {
+			c.f.brTables[fixup[0]][fixup[1]].pc = end
+		}
+		if top.elseFixup >= 0 {
+			c.code[top.elseFixup].a = end
+			c.code[top.elseFixup].b = uint32(top.height)
+		}
+		if len(c.ctrls) == 0 {
+			// End of the function body, branches to it return
+			c.code = append(c.code, instr{op: opReturn})
+			return nil
+		}
+		for _, t := range top.results {
+			c.push(t)
+		}
+		c.startBlock()
+
+	case op == opBr:
+		depth, err := r.u32()
+		if err != nil {
+			return err
+		}
+		target, err := c.label(depth)
+		if err != nil {
+			return err
+		}
+		if err := c.popAll(target.labelTypes()); err != nil {
+			return err
+		}
+		c.branch(opBr, target, gasBase)
+		c.setUnreachable()
+
+	case op == opBrIf:
+		depth, err := r.u32()
+		if err != nil {
+			return err
+		}
+		target, err := c.label(depth)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		types := target.labelTypes()
+		if err := c.popAll(types); err != nil {
+			return err
+		}
+		c.branch(opBrIf, target, gasBranch)
+		for _, t := range types {
+			c.push(t)
+		}
+		c.startBlock()
+
+	case op == opBrTable:
+		n, err := r.count(maxTableSize)
+		if err != nil {
+			return err
+		}
+		depths := make([]uint32, n+1)
+		for i := range depths {
+			if depths[i], err = r.u32(); err != nil {
+				return err
+			}
+		}
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		def, err := c.label(depths[n])
+		if err != nil {
+			return err
+		}
+		arity := len(def.labelTypes())
+		index := len(c.f.brTables)
+		table := make([]jump, len(depths))
+		for i, depth := range depths {
+			target, err := c.label(depth)
+			if err != nil {
+				return err
+			}
+			if len(target.labelTypes()) != arity {
+				return fmt.Errorf("br_table arity mismatch")
+			}
+			table[i] = jump{height: uint32(target.height), arity: uint32(arity)}
+			if target.op == opLoop {
+				table[i].pc = uint32(target.start)
+			} else {
+				target.tableFixups = append(target.tableFixups, [2]int{index, i})
+			}
+		}
+		c.f.brTables = append(c.f.brTables, table)
+		if err := c.popAll(def.labelTypes()); err != nil {
+			return err
+		}
+		c.emit(instr{op: opBrTable, a: uint32(index)}, gasBranch)
+		c.setUnreachable()
+
+	case op == opReturn:
+		if err := c.popAll(c.ctrls[0].results); err != nil {
+			return err
+		}
+		c.emit(instr{op: opReturn}, gasBase)
+		c.setUnreachable()
+
+	case op == opCall:
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		t, ok := c.m.FuncType(index)
+		if !ok {
+			return fmt.Errorf("unknown function %d", index)
+		}
+		if err := c.popAll(t.Params); err != nil {
+			return err
+		}
+		c.emit(instr{op: op, a: index}, gasCall)
+		for _, rt := range t.Results {
+			c.push(rt)
+		}
+
+	case op == opCallIndirect:
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if reserved, err := r.byte(); err != nil || reserved != 0 {
+			return fmt.Errorf("invalid call_indirect table")
+		}
+		if c.m.Table == nil {
+			return fmt.Errorf("call_indirect without table")
+		}
+		if index >= uint32(len(c.m.Types)) {
+			return fmt.Errorf("unknown type %d", index)
+		}
+		t := c.m.Types[index]
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		if err := c.popAll(t.Params); err != nil {
+			return err
+		}
+		c.emit(instr{op: op, a: index}, gasIndirect)
+		for _, rt := range t.Results {
+			c.push(rt)
+		}
+
+	case op == opDrop:
+		if _, err := c.pop(unknown); err != nil {
+			return err
+		}
+		c.emit(instr{op: op}, gasBase)
+
+	case op == opSelect:
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		t1, err := c.pop(unknown)
+		if err != nil {
+			return err
+		}
+		t2, err := c.pop(t1)
+		if err != nil {
+			return err
+		}
+		c.emit(instr{op: op}, gasBase)
+		c.push(t2)
+
+	case op >= opLocalGet && op <= opLocalTee:
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if index >= uint32(len(c.locals)) {
+			return fmt.Errorf("unknown local %d", index)
+		}
+		t := c.locals[index]
+		if op != opLocalGet {
+			if _, err := c.pop(t); err != nil {
+				return err
+			}
+		}
+		c.emit(instr{op: op, a: index}, gasBase)
+		if op != opLocalSet {
+			c.push(t)
+		}
+
+	case op == opGlobalGet || op == opGlobalSet:
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if index >= uint32(len(c.m.Globals)) {
+			return fmt.Errorf("unknown global %d", index)
+		}
+		g := c.m.Globals[index]
+		if op == opGlobalSet {
+			if !g.Mutable {
+				return fmt.Errorf("global %d is immutable", index)
+			}
+			if _, err := c.pop(g.Type); err != nil {
+				return err
+			}
+		}
+		c.emit(instr{op: op, a: index}, gasBase)
+		if op == opGlobalGet {
+			c.push(g.Type)
+		}
+
+	case op >= opI32Load && op <= opI64Load32U:
+		t, ok := loadTypes[op]
+		if !ok {
+			return errFloatingPoint
+		}
+		offset, err := c.memarg(r)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		c.emit(instr{op: op, c: offset}, gasMemory)
+		c.push(t)
+
+	case op >= opI32Store && op <= opI64Store32:
+		t, ok := storeTypes[op]
+		if !ok {
+			return errFloatingPoint
+		}
+		offset, err := c.memarg(r)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pop(t); err != nil {
+			return err
+		}
+		if _, err := c.pop(I32); err != nil {
+			return err
+		}
+		c.emit(instr{op: op, c: offset}, gasMemory)
+
+	case op == opMemorySize || op == opMemoryGrow:
+		if reserved, err := r.byte(); err != nil || reserved != 0 {
+			return fmt.Errorf("invalid memory index")
+		}
+		if c.m.Memory == nil {
+			return fmt.Errorf("memory instruction without memory")
+		}
+		gas := uint64(gasBase)
+		if op == opMemoryGrow {
+			if _, err := c.pop(I32); err != nil {
+				return err
+			}
+			gas = gasGrow
+		}
+		c.emit(instr{op: op}, gas)
+		c.push(I32)
+
+	case op == opI32Const:
+		v, err := r.sleb(32)
+		if err != nil {
+			return err
+		}
+		c.emit(instr{op: op, c: uint64(uint32(v))}, gasBase)
+		c.push(I32)
+
+	case op == opI64Const:
+		v, err := r.sleb(64)
+		if err != nil {
+			return err
+		}
+		c.emit(instr{op: op, c: uint64(v)}, gasBase)
+		c.push(I64)
+
+	default:
+		sig, ok := numericTypes(op)
+		if !ok {
+			if op >= 0x43 && op <= 0xbf {
+				return errFloatingPoint
+			}
+			return fmt.Errorf("unsupported opcode %#x", op)
+		}
+		if err := c.popAll(sig.Params); err != nil {
+			return err
+		}
+		c.emit(instr{op: op}, numericGas(op))
+		c.push(sig.Results[0])
+	}
+	return nil
+}
+
+var (
+	loadTypes = map[byte]ValueType{
+		opI32Load: I32, opI64Load: I64,
+		opI32Load8S: I32, opI32Load8U: I32, opI32Load16S: I32, opI32Load16U: I32,
+		opI64Load8S: I64, opI64Load8U: I64, opI64Load16S: I64, opI64Load16U: I64, opI64Load32S: I64, opI64Load32U: I64,
+	}
+	storeTypes = map[byte]ValueType{
+		opI32Store: I32, opI64Store: I64,
+		opI32Store8: I32, opI32Store16: I32,
+		opI64Store8: I64, opI64Store16: I64, opI64Store32: I64,
+	}
+)
+
+// numericTypes returns the signature of a numeric instruction.
+func numericTypes(op byte) (FuncType, bool) {
+	unary := func(t, r ValueType) FuncType { return FuncType{Params: []ValueType{t}, Results: []ValueType{r}} }
+	binary := func(t, r ValueType) FuncType { return FuncType{Params: []ValueType{t, t}, Results: []ValueType{r}} }
+
+	switch {
+	case op == opI32Eqz:
+		return unary(I32, I32), true
+	case op >= opI32Eq && op <= opI32GeU:
+		return binary(I32, I32), true
+	case op == opI64Eqz:
+		return unary(I64, I32), true
+	case op >= opI64Eq && op <= opI64GeU:
+		return binary(I64, I32), true
+	case op >= opI32Clz && op <= opI32Popcnt:
+		return unary(I32, I32), true
+	case op >= opI32Add && op <= opI32Rotr:
+		return binary(I32, I32), true
+	case op >= opI64Clz && op <= opI64Popcnt:
+		return unary(I64, I64), true
+	case op >= opI64Add && op <= opI64Rotr:
+		return binary(I64, I64), true
+	case op == opI32WrapI64:
+		return unary(I64, I32), true
+	case op == opI64ExtendS || op == opI64ExtendU:
+		return unary(I32, I64), true
+	}
+	return FuncType{}, false
+}
+
+// numericGas returns the static gas cost of a numeric instruction.
+func numericGas(op byte) uint64 {
+	switch op {
+	case opI32Mul, opI64Mul:
+		return gasMul
+	case opI32DivS, opI32DivU, opI32RemS, opI32RemU, opI64DivS, opI64DivU, opI64RemS, opI64RemU:
+		return gasDiv
+	}
+	return gasBase
+}
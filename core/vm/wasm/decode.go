@@ -0,0 +1,614 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Section identifiers of the binary format.
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionTable    = 4
+	sectionMemory   = 5
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionStart    = 8
+	sectionElement  = 9
+	sectionCode     = 10
+	sectionData     = 11
+)
+
+// Decoding limits protecting against resource exhaustion by malicious modules.
+const (
+	maxFunctions = 1 << 16 // Maximum number of functions, types, globals and exports
+	maxLocals    = 1 << 16 // Maximum number of locals of a function
+	maxPages     = 1 << 16 // Maximum number of memory pages addressable by 32 bits
+	maxTableSize = 1 << 20 // Maximum number of table elements
+)
+
+// reader is a cursor over the bytes of a module.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) eof() bool { return r.pos >= len(r.buf) }
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errUnexpectedEnd
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(n) > uint64(len(r.buf)-r.pos) {
+		return nil, errUnexpectedEnd
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// uleb reads an unsigned LEB128 integer of at most the given bit size.
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var (
+		result uint64
+		shift  uint
+	)
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift+7 >= bits && b&0x7f>>(bits-shift) != 0 {
+			return 0, fmt.Errorf("wasm: integer too large")
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		if shift += 7; shift >= bits {
+			return 0, fmt.Errorf("wasm: integer representation too long")
+		}
+	}
+}
+
+// sleb reads a signed LEB128 integer of at most the given bit size.
+func (r *reader) sleb(bits uint) (int64, error) {
+	var (
+		result int64
+		shift  uint
+		b      byte
+		err    error
+	)
+	for {
+		if b, err = r.byte(); err != nil {
+			return 0, err
+		}
+		if shift+7 >= bits {
+			// The unused bits of the last byte must be a sign extension
+			rest := int8(b<<1) >> (bits - shift)
+			if b&0x80 != 0 || (rest != 0 && rest != -1) {
+				return 0, fmt.Errorf("wasm: integer too large")
+			}
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if shift < 64 && b&0x40 != 0 {
+		result |= -1 << shift
+	}
+	return result, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+// count reads a vector length, bounding it by the given limit.
+func (r *reader) count(limit uint32) (uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	if n > limit {
+		return 0, fmt.Errorf("wasm: too many entries: %d > %d", n, limit)
+	}
+	return n, nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("wasm: invalid utf-8 name")
+	}
+	return string(b), nil
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t := ValueType(b); t {
+	case I32, I64:
+		return t, nil
+	case f32, f64:
+		return 0, errFloatingPoint
+	default:
+		return 0, fmt.Errorf("wasm: invalid value type %#x", b)
+	}
+}
+
+func (r *reader) limits(max uint32) (*Limits, error) {
+	flag, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	l := new(Limits)
+	if l.Min, err = r.u32(); err != nil {
+		return nil, err
+	}
+	switch flag {
+	case 0:
+	case 1:
+		if l.Max, err = r.u32(); err != nil {
+			return nil, err
+		}
+		l.HasMax = true
+		if l.Max < l.Min {
+			return nil, fmt.Errorf("wasm: limit maximum below minimum")
+		}
+	default:
+		return nil, fmt.Errorf("wasm: invalid limits flag %#x", flag)
+	}
+	if l.Min > max || (l.HasMax && l.Max > max) {
+		return nil, fmt.Errorf("wasm: limits exceed %d", max)
+	}
+	return l, nil
+}
+
+// Decode parses a binary WebAssembly module, validates it and compiles all its
+// function bodies.
+func Decode(code []byte) (*Module, error) {
+	if len(code) < 8 || !bytes.Equal(code[:4], Magic) {
+		return nil, errInvalidMagic
+	}
+	if binary.LittleEndian.Uint32(code[4:8]) != Version {
+		return nil, errInvalidVersion
+	}
+	var (
+		m      = new(Module)
+		r      = &reader{buf: code, pos: 8}
+		last   = byte(0)
+		funcs  []uint32
+		bodies [][]byte
+	)
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		if id == sectionCustom {
+			continue
+		}
+		if id > sectionData {
+			return nil, fmt.Errorf("wasm: unknown section %d", id)
+		}
+		if id <= last {
+			return nil, fmt.Errorf("wasm: section %d out of order", id)
+		}
+		last = id
+
+		s := &reader{buf: payload}
+		switch id {
+		case sectionType:
+			err = m.decodeTypes(s)
+		case sectionImport:
+			err = m.decodeImports(s)
+		case sectionFunction:
+			funcs, err = m.decodeFunctions(s)
+		case sectionTable:
+			err = m.decodeTable(s)
+		case sectionMemory:
+			err = m.decodeMemory(s)
+		case sectionGlobal:
+			err = m.decodeGlobals(s)
+		case sectionExport:
+			err = m.decodeExports(s)
+		case sectionStart:
+			err = m.decodeStart(s)
+		case sectionElement:
+			err = m.decodeElements(s)
+		case sectionCode:
+			bodies, err = decodeCode(s)
+		case sectionData:
+			err = m.decodeData(s)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.eof() {
+			return nil, fmt.Errorf("wasm: trailing bytes in section %d", id)
+		}
+	}
+	if len(funcs) != len(bodies) {
+		return nil, fmt.Errorf("wasm: function and code section lengths differ")
+	}
+	// Declare all functions before compiling, bodies may call later ones
+	for _, t := range funcs {
+		m.Functions = append(m.Functions, &Function{Type: t})
+	}
+	for i, body := range bodies {
+		if err := m.compile(m.Functions[i], body); err != nil {
+			return nil, fmt.Errorf("wasm: function %d: %v", len(m.Imports)+i, err)
+		}
+	}
+	// Resolve the references to functions now that all of them are known
+	if m.Start != nil {
+		t, ok := m.FuncType(*m.Start)
+		if !ok {
+			return nil, fmt.Errorf("wasm: unknown start function %d", *m.Start)
+		}
+		if len(t.Params) != 0 || len(t.Results) != 0 {
+			return nil, fmt.Errorf("wasm: invalid start function signature %v", t)
+		}
+	}
+	for _, export := range m.Exports {
+		if export.Kind == ExternalFunction {
+			if _, ok := m.FuncType(export.Index); !ok {
+				return nil, fmt.Errorf("wasm: unknown exported function %d", export.Index)
+			}
+		}
+	}
+	for _, elem := range m.Elements {
+		for _, index := range elem.Funcs {
+			if _, ok := m.FuncType(index); !ok {
+				return nil, fmt.Errorf("wasm: unknown element function %d", index)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return fmt.Errorf("wasm: invalid function type form %#x", form)
+		}
+		var t FuncType
+		for _, list := range []*[]ValueType{&t.Params, &t.Results} {
+			count, err := r.count(maxLocals)
+			if err != nil {
+				return err
+			}
+			for j := uint32(0); j < count; j++ {
+				vt, err := r.valueType()
+				if err != nil {
+					return err
+				}
+				*list = append(*list, vt)
+			}
+		}
+		if len(t.Results) > 1 {
+			return fmt.Errorf("wasm: multiple results are not supported")
+		}
+		m.Types = append(m.Types, t)
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if ExternalKind(kind) != ExternalFunction {
+			return fmt.Errorf("wasm: unsupported import kind %d of %s.%s", kind, imp.Module, imp.Name)
+		}
+		if imp.Type, err = r.u32(); err != nil {
+			return err
+		}
+		if imp.Type >= uint32(len(m.Types)) {
+			return fmt.Errorf("wasm: unknown type %d of %s.%s", imp.Type, imp.Module, imp.Name)
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) decodeFunctions(r *reader) ([]uint32, error) {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return nil, err
+	}
+	funcs := make([]uint32, n)
+	for i := range funcs {
+		if funcs[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+		if funcs[i] >= uint32(len(m.Types)) {
+			return nil, fmt.Errorf("wasm: unknown type %d", funcs[i])
+		}
+	}
+	return funcs, nil
+}
+
+func (m *Module) decodeTable(r *reader) error {
+	n, err := r.count(1)
+	if err != nil || n == 0 {
+		return err
+	}
+	elem, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if elem != 0x70 {
+		return fmt.Errorf("wasm: invalid table element type %#x", elem)
+	}
+	m.Table, err = r.limits(maxTableSize)
+	return err
+}
+
+func (m *Module) decodeMemory(r *reader) error {
+	n, err := r.count(1)
+	if err != nil || n == 0 {
+		return err
+	}
+	m.Memory, err = r.limits(maxPages)
+	return err
+}
+
+// constExpr decodes a constant initializer expression of the given type.
+func constExpr(r *reader, t ValueType) (uint64, error) {
+	op, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	switch {
+	case op == opI32Const && t == I32:
+		n, err := r.sleb(32)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(uint32(n))
+	case op == opI64Const && t == I64:
+		n, err := r.sleb(64)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(n)
+	default:
+		return 0, fmt.Errorf("wasm: unsupported %v initializer opcode %#x", t, op)
+	}
+	if end, err := r.byte(); err != nil || end != opEnd {
+		return 0, fmt.Errorf("wasm: unterminated initializer expression")
+	}
+	return v, nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var g Global
+		if g.Type, err = r.valueType(); err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return fmt.Errorf("wasm: invalid global mutability %#x", mut)
+		}
+		g.Mutable = mut == 1
+		if g.Init, err = constExpr(r, g.Type); err != nil {
+			return err
+		}
+		m.Globals = append(m.Globals, g)
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := uint32(0); i < n; i++ {
+		var export Export
+		if export.Name, err = r.name(); err != nil {
+			return err
+		}
+		if names[export.Name] {
+			return fmt.Errorf("wasm: duplicate export %q", export.Name)
+		}
+		names[export.Name] = true
+
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		export.Kind = ExternalKind(kind)
+		if export.Index, err = r.u32(); err != nil {
+			return err
+		}
+		switch export.Kind {
+		case ExternalFunction:
+			// Checked once the code section is decoded
+		case ExternalTable:
+			if m.Table == nil || export.Index != 0 {
+				return fmt.Errorf("wasm: unknown exported table %d", export.Index)
+			}
+		case ExternalMemory:
+			if m.Memory == nil || export.Index != 0 {
+				return fmt.Errorf("wasm: unknown exported memory %d", export.Index)
+			}
+		case ExternalGlobal:
+			if export.Index >= uint32(len(m.Globals)) {
+				return fmt.Errorf("wasm: unknown exported global %d", export.Index)
+			}
+		default:
+			return fmt.Errorf("wasm: invalid export kind %d", kind)
+		}
+		m.Exports = append(m.Exports, export)
+	}
+	return nil
+}
+
+func (m *Module) decodeStart(r *reader) error {
+	index, err := r.u32()
+	if err != nil {
+		return err
+	}
+	m.Start = &index
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		table, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if table != 0 || m.Table == nil {
+			return fmt.Errorf("wasm: unknown table %d", table)
+		}
+		offset, err := constExpr(r, I32)
+		if err != nil {
+			return err
+		}
+		count, err := r.count(maxTableSize)
+		if err != nil {
+			return err
+		}
+		elem := Element{Offset: uint32(offset), Funcs: make([]uint32, count)}
+		for j := range elem.Funcs {
+			if elem.Funcs[j], err = r.u32(); err != nil {
+				return err
+			}
+		}
+		m.Elements = append(m.Elements, elem)
+	}
+	return nil
+}
+
+func decodeCode(r *reader) ([][]byte, error) {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return nil, err
+	}
+	bodies := make([][]byte, n)
+	for i := range bodies {
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		if bodies[i], err = r.bytes(size); err != nil {
+			return nil, err
+		}
+	}
+	return bodies, nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.count(maxFunctions)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		mem, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if mem != 0 || m.Memory == nil {
+			return fmt.Errorf("wasm: unknown memory %d", mem)
+		}
+		offset, err := constExpr(r, I32)
+		if err != nil {
+			return err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		init, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		m.Data = append(m.Data, Data{Offset: uint32(offset), Init: append([]byte{}, init...)})
+	}
+	return nil
+}
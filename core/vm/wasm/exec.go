@@ -0,0 +1,649 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// HostFunc is a function imported by a module. The arguments are passed as
+// raw 64 bit values, i32 ones zero extended. The result is ignored if the
+// signature of the import declares none. Returning an error traps.
+type HostFunc func(vm *VM, args []uint64) (uint64, error)
+
+// Resolver looks up the implementation of an imported function.
+type Resolver func(module, name string, sig FuncType) (HostFunc, error)
+
+// GasMeter is charged the gas of the executed code.
+type GasMeter interface {
+	// UseGas attempts to consume the given gas, returning false if there is
+	// not enough left.
+	UseGas(gas uint64) bool
+}
+
+// Config are the resource limits of a VM.
+type Config struct {
+	MaxPages     uint32 // Maximum number of pages of linear memory
+	MaxTable     uint32 // Maximum number of table elements
+	MaxCallDepth int    // Maximum depth of nested function calls
+	MaxStack     int    // Maximum number of values on the operand stack
+	PageGas      uint64 // Gas charged per page when growing the memory
+	TableGas     uint64 // Gas charged per table element when instantiating
+}
+
+// DefaultConfig are the resource limits used if none are given.
+var DefaultConfig = Config{
+	MaxPages:     256, // 16MB
+	MaxTable:     1 << 16,
+	MaxCallDepth: 1024,
+	MaxStack:     1 << 16,
+}
+
+// VM is an instantiated module.
+type VM struct {
+	module  *Module
+	config  Config
+	host    []HostFunc
+	meter   GasMeter
+	memory  []byte
+	globals []uint64
+	table   []int64 // Function indexes, -1 for uninitialised elements
+
+	stack []uint64
+	depth int
+}
+
+// NewVM instantiates a module, resolving its imports and initialising its
+// memory, table and globals. If the module has a start function, it is run.
+func NewVM(m *Module, resolve Resolver, meter GasMeter, config Config) (*VM, error) {
+	vm := &VM{
+		module: m,
+		config: config,
+		meter:  meter,
+		stack:  make([]uint64, 0, 1024),
+	}
+	for _, imp := range m.Imports {
+		fn, err := resolve(imp.Module, imp.Name, m.Types[imp.Type])
+		if err != nil {
+			return nil, err
+		}
+		vm.host = append(vm.host, fn)
+	}
+	if m.Memory != nil {
+		if m.Memory.Min > config.MaxPages {
+			return nil, fmt.Errorf("wasm: initial memory exceeds %d pages", config.MaxPages)
+		}
+		vm.memory = make([]byte, int(m.Memory.Min)*PageSize)
+	}
+	for _, g := range m.Globals {
+		vm.globals = append(vm.globals, g.Init)
+	}
+	if m.Table != nil {
+		if m.Table.Min > config.MaxTable {
+			return nil, fmt.Errorf("wasm: initial table exceeds %d elements", config.MaxTable)
+		}
+		if !meter.UseGas(uint64(m.Table.Min) * config.TableGas) {
+			return nil, ErrOutOfGas
+		}
+		vm.table = make([]int64, m.Table.Min)
+		for i := range vm.table {
+			vm.table[i] = -1
+		}
+	}
+	for _, elem := range m.Elements {
+		if uint64(elem.Offset)+uint64(len(elem.Funcs)) > uint64(len(vm.table)) {
+			return nil, fmt.Errorf("wasm: element segment out of bounds")
+		}
+		for i, index := range elem.Funcs {
+			vm.table[int(elem.Offset)+i] = int64(index)
+		}
+	}
+	for _, data := range m.Data {
+		if uint64(data.Offset)+uint64(len(data.Init)) > uint64(len(vm.memory)) {
+			return nil, fmt.Errorf("wasm: data segment out of bounds")
+		}
+		copy(vm.memory[data.Offset:], data.Init)
+	}
+	if m.Start != nil {
+		if err := vm.call(*m.Start); err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// Module returns the module the VM instantiates.
+func (vm *VM) Module() *Module {
+	return vm.module
+}
+
+// Memory returns the linear memory of the VM. The slice is invalidated when
+// the memory grows.
+func (vm *VM) Memory() []byte {
+	return vm.memory
+}
+
+// Invoke calls the exported function with the given name.
+func (vm *VM) Invoke(name string, args ...uint64) ([]uint64, error) {
+	export, ok := vm.module.Export(name)
+	if !ok || export.Kind != ExternalFunction {
+		return nil, ErrFunctionNotExported
+	}
+	sig, _ := vm.module.FuncType(export.Index)
+	if len(args) != len(sig.Params) {
+		return nil, fmt.Errorf("wasm: invalid argument count: have %d, want %d", len(args), len(sig.Params))
+	}
+	vm.stack = append(vm.stack[:0], args...)
+	if err := vm.call(export.Index); err != nil {
+		return nil, err
+	}
+	return append([]uint64{}, vm.stack[len(vm.stack)-len(sig.Results):]...), nil
+}
+
+// call executes the function with the given index, consuming its arguments
+// from the top of the operand stack and leaving its results there.
+func (vm *VM) call(index uint32) error {
+	if vm.depth >= vm.config.MaxCallDepth {
+		return ErrCallStackExhausted
+	}
+	vm.depth++
+	defer func() { vm.depth-- }()
+
+	if index < uint32(len(vm.host)) {
+		sig := vm.module.Types[vm.module.Imports[index].Type]
+		base := len(vm.stack) - len(sig.Params)
+
+		args := append([]uint64{}, vm.stack[base:]...)
+		res, err := vm.host[index](vm, args)
+		if err != nil {
+			return err
+		}
+		vm.stack = vm.stack[:base]
+		if len(sig.Results) > 0 {
+			vm.stack = append(vm.stack, res)
+		}
+		return nil
+	}
+	f := vm.module.Functions[index-uint32(len(vm.host))]
+	sig := vm.module.Types[f.Type]
+
+	base := len(vm.stack) - len(sig.Params)
+	if len(vm.stack)+len(f.Locals)+f.maxStack > vm.config.MaxStack {
+		return ErrCallStackExhausted
+	}
+	for range f.Locals {
+		vm.stack = append(vm.stack, 0)
+	}
+	if err := vm.execute(f, base); err != nil {
+		return err
+	}
+	// Move the results over the locals of the frame
+	results := len(sig.Results)
+	copy(vm.stack[base:], vm.stack[len(vm.stack)-results:])
+	vm.stack = vm.stack[:base+results]
+	return nil
+}
+
+// address returns the effective address of a memory access, checking that it
+// is in bounds.
+func (vm *VM) address(addr uint64, offset uint64, size uint64) (uint64, error) {
+	ea := uint64(uint32(addr)) + offset
+	if ea+size > uint64(len(vm.memory)) {
+		return 0, ErrMemoryOutOfBounds
+	}
+	return ea, nil
+}
+
+// execute runs the body of a function whose locals start at the given stack
+// index.
+func (vm *VM) execute(f *Function, locals int) error {
+	var (
+		code  = f.code
+		frame = locals + len(vm.module.Types[f.Type].Params) + len(f.Locals) // Operand stack base
+		pc    = 0
+	)
+	// Helpers operating on the shared operand stack
+	pop := func() uint64 {
+		v := vm.stack[len(vm.stack)-1]
+		vm.stack = vm.stack[:len(vm.stack)-1]
+		return v
+	}
+	push := func(v uint64) {
+		vm.stack = append(vm.stack, v)
+	}
+	branch := func(target, height, arity uint32) {
+		if arity > 0 {
+			vm.stack[frame+int(height)] = vm.stack[len(vm.stack)-1]
+		}
+		vm.stack = vm.stack[:frame+int(height)+int(arity)]
+		pc = int(target)
+	}
+	for {
+		in := &code[pc]
+		pc++
+
+		switch in.op {
+		case opGas:
+			if !vm.meter.UseGas(in.c) {
+				return ErrOutOfGas
+			}
+		case opUnreachable:
+			return ErrUnreachable
+
+		case opBr:
+			branch(in.a, in.b, uint32(in.c))
+		case opBrIf:
+			if uint32(pop()) != 0 {
+				branch(in.a, in.b, uint32(in.c))
+			}
+		case opBrUnless:
+			if uint32(pop()) == 0 {
+				branch(in.a, in.b, 0)
+			}
+		case opBrTable:
+			table := f.brTables[in.a]
+			i := uint64(uint32(pop()))
+			if i >= uint64(len(table)) {
+				i = uint64(len(table) - 1)
+			}
+			j := table[i]
+			branch(j.pc, j.height, j.arity)
+		case opReturn:
+			return nil
+
+		case opCall:
+			if err := vm.call(in.a); err != nil {
+				return err
+			}
+		case opCallIndirect:
+			i := uint64(uint32(pop()))
+			if i >= uint64(len(vm.table)) || vm.table[i] < 0 {
+				return ErrUndefinedElement
+			}
+			index := uint32(vm.table[i])
+			if sig, _ := vm.module.FuncType(index); !sig.Equal(vm.module.Types[in.a]) {
+				return ErrIndirectCallType
+			}
+			if err := vm.call(index); err != nil {
+				return err
+			}
+
+		case opDrop:
+			pop()
+		case opSelect:
+			cond, b := pop(), pop()
+			if uint32(cond) == 0 {
+				vm.stack[len(vm.stack)-1] = b
+			}
+
+		case opLocalGet:
+			push(vm.stack[locals+int(in.a)])
+		case opLocalSet:
+			vm.stack[locals+int(in.a)] = pop()
+		case opLocalTee:
+			vm.stack[locals+int(in.a)] = vm.stack[len(vm.stack)-1]
+		case opGlobalGet:
+			push(vm.globals[in.a])
+		case opGlobalSet:
+			vm.globals[in.a] = pop()
+
+		case opI32Load, opI64Load, opI32Load8S, opI32Load8U, opI32Load16S, opI32Load16U,
+			opI64Load8S, opI64Load8U, opI64Load16S, opI64Load16U, opI64Load32S, opI64Load32U:
+			v, err := vm.load(in.op, pop(), in.c)
+			if err != nil {
+				return err
+			}
+			push(v)
+		case opI32Store, opI64Store, opI32Store8, opI32Store16, opI64Store8, opI64Store16, opI64Store32:
+			v := pop()
+			if err := vm.store(in.op, pop(), in.c, v); err != nil {
+				return err
+			}
+
+		case opMemorySize:
+			push(uint64(len(vm.memory) / PageSize))
+		case opMemoryGrow:
+			res, err := vm.grow(uint32(pop()))
+			if err != nil {
+				return err
+			}
+			push(uint64(res))
+
+		case opI32Const, opI64Const:
+			push(in.c)
+
+		default:
+			if err := vm.numeric(in.op); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grow extends the linear memory by the given number of pages, returning the
+// previous size or -1 if the limits would be exceeded.
+func (vm *VM) grow(pages uint32) (uint32, error) {
+	old := uint32(len(vm.memory) / PageSize)
+	limit := vm.config.MaxPages
+	if vm.module.Memory.HasMax && vm.module.Memory.Max < limit {
+		limit = vm.module.Memory.Max
+	}
+	if uint64(old)+uint64(pages) > uint64(limit) {
+		return ^uint32(0), nil
+	}
+	if !vm.meter.UseGas(uint64(pages) * vm.config.PageGas) {
+		return 0, ErrOutOfGas
+	}
+	vm.memory = append(vm.memory, make([]byte, int(pages)*PageSize)...)
+	return old, nil
+}
+
+var accessSizes = map[byte]uint64{
+	opI32Load: 4, opI64Load: 8,
+	opI32Load8S: 1, opI32Load8U: 1, opI32Load16S: 2, opI32Load16U: 2,
+	opI64Load8S: 1, opI64Load8U: 1, opI64Load16S: 2, opI64Load16U: 2, opI64Load32S: 4, opI64Load32U: 4,
+	opI32Store: 4, opI64Store: 8, opI32Store8: 1, opI32Store16: 2,
+	opI64Store8: 1, opI64Store16: 2, opI64Store32: 4,
+}
+
+func (vm *VM) load(op byte, addr, offset uint64) (uint64, error) {
+	ea, err := vm.address(addr, offset, accessSizes[op])
+	if err != nil {
+		return 0, err
+	}
+	mem := vm.memory[ea:]
+	switch op {
+	case opI32Load, opI64Load32U:
+		return uint64(binary.LittleEndian.Uint32(mem)), nil
+	case opI64Load:
+		return binary.LittleEndian.Uint64(mem), nil
+	case opI32Load8S:
+		return uint64(uint32(int32(int8(mem[0])))), nil
+	case opI32Load8U, opI64Load8U:
+		return uint64(mem[0]), nil
+	case opI32Load16S:
+		return uint64(uint32(int32(int16(binary.LittleEndian.Uint16(mem))))), nil
+	case opI32Load16U, opI64Load16U:
+		return uint64(binary.LittleEndian.Uint16(mem)), nil
+	case opI64Load8S:
+		return uint64(int64(int8(mem[0]))), nil
+	case opI64Load16S:
+		return uint64(int64(int16(binary.LittleEndian.Uint16(mem)))), nil
+	case opI64Load32S:
+		return uint64(int64(int32(binary.LittleEndian.Uint32(mem)))), nil
+	}
+	panic(fmt.Sprintf("wasm: invalid load %#x", op))
+}
+
+func (vm *VM) store(op byte, addr, offset uint64, v uint64) error {
+	ea, err := vm.address(addr, offset, accessSizes[op])
+	if err != nil {
+		return err
+	}
+	mem := vm.memory[ea:]
+	switch op {
+	case opI32Store, opI64Store32:
+		binary.LittleEndian.PutUint32(mem, uint32(v))
+	case opI64Store:
+		binary.LittleEndian.PutUint64(mem, v)
+	case opI32Store8, opI64Store8:
+		mem[0] = byte(v)
+	case opI32Store16, opI64Store16:
+		binary.LittleEndian.PutUint16(mem, uint16(v))
+	}
+	return nil
+}
+
+// numeric executes a numeric instruction on the operand stack.
+func (vm *VM) numeric(op byte) error {
+	s := vm.stack
+	top := len(s) - 1
+
+	// Unary instructions replace the top of the stack
+	switch op {
+	case opI32Eqz:
+		s[top] = b2u(uint32(s[top]) == 0)
+		return nil
+	case opI64Eqz:
+		s[top] = b2u(s[top] == 0)
+		return nil
+	case opI32Clz:
+		s[top] = uint64(bits.LeadingZeros32(uint32(s[top])))
+		return nil
+	case opI32Ctz:
+		s[top] = uint64(bits.TrailingZeros32(uint32(s[top])))
+		return nil
+	case opI32Popcnt:
+		s[top] = uint64(bits.OnesCount32(uint32(s[top])))
+		return nil
+	case opI64Clz:
+		s[top] = uint64(bits.LeadingZeros64(s[top]))
+		return nil
+	case opI64Ctz:
+		s[top] = uint64(bits.TrailingZeros64(s[top]))
+		return nil
+	case opI64Popcnt:
+		s[top] = uint64(bits.OnesCount64(s[top]))
+		return nil
+	case opI32WrapI64:
+		s[top] = uint64(uint32(s[top]))
+		return nil
+	case opI64ExtendS:
+		s[top] = uint64(int64(int32(s[top])))
+		return nil
+	case opI64ExtendU:
+		s[top] = uint64(uint32(s[top]))
+		return nil
+	}
+	// Binary instructions pop the right operand and replace the left one
+	x, y := s[top-1], s[top]
+	vm.stack = s[:top]
+
+	var r uint64
+	switch {
+	case op >= opI32Eq && op <= opI32GeU:
+		r = b2u(compare32(op, uint32(x), uint32(y)))
+	case op >= opI64Eq && op <= opI64GeU:
+		r = b2u(compare64(op-opI64Eq+opI32Eq, x, y))
+	case op >= opI32Add && op <= opI32Rotr:
+		v, err := arith32(op, uint32(x), uint32(y))
+		if err != nil {
+			return err
+		}
+		r = uint64(v)
+	case op >= opI64Add && op <= opI64Rotr:
+		v, err := arith64(op-opI64Add+opI32Add, x, y)
+		if err != nil {
+			return err
+		}
+		r = v
+	default:
+		panic(fmt.Sprintf("wasm: invalid instruction %#x", op))
+	}
+	s[top-1] = r
+	return nil
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compare32 evaluates an i32 comparison.
+func compare32(op byte, x, y uint32) bool {
+	switch op - opI32Eq {
+	case 0:
+		return x == y
+	case 1:
+		return x != y
+	case 2:
+		return int32(x) < int32(y)
+	case 3:
+		return x < y
+	case 4:
+		return int32(x) > int32(y)
+	case 5:
+		return x > y
+	case 6:
+		return int32(x) <= int32(y)
+	case 7:
+		return x <= y
+	case 8:
+		return int32(x) >= int32(y)
+	default:
+		return x >= y
+	}
+}
+
+// compare64 evaluates an i64 comparison, identified by its i32 counterpart.
+func compare64(op byte, x, y uint64) bool {
+	switch op - opI32Eq {
+	case 0:
+		return x == y
+	case 1:
+		return x != y
+	case 2:
+		return int64(x) < int64(y)
+	case 3:
+		return x < y
+	case 4:
+		return int64(x) > int64(y)
+	case 5:
+		return x > y
+	case 6:
+		return int64(x) <= int64(y)
+	case 7:
+		return x <= y
+	case 8:
+		return int64(x) >= int64(y)
+	default:
+		return x >= y
+	}
+}
+
+// arith32 evaluates an i32 binary arithmetic instruction.
+func arith32(op byte, x, y uint32) (uint32, error) {
+	switch op {
+	case opI32Add:
+		return x + y, nil
+	case opI32Sub:
+		return x - y, nil
+	case opI32Mul:
+		return x * y, nil
+	case opI32DivS:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if int32(x) == -1<<31 && int32(y) == -1 {
+			return 0, ErrIntegerOverflow
+		}
+		return uint32(int32(x) / int32(y)), nil
+	case opI32DivU:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return x / y, nil
+	case opI32RemS:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if int32(y) == -1 {
+			return 0, nil
+		}
+		return uint32(int32(x) % int32(y)), nil
+	case opI32RemU:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return x % y, nil
+	case opI32And:
+		return x & y, nil
+	case opI32Or:
+		return x | y, nil
+	case opI32Xor:
+		return x ^ y, nil
+	case opI32Shl:
+		return x << (y & 31), nil
+	case opI32ShrS:
+		return uint32(int32(x) >> (y & 31)), nil
+	case opI32ShrU:
+		return x >> (y & 31), nil
+	case opI32Rotl:
+		return bits.RotateLeft32(x, int(y&31)), nil
+	default:
+		return bits.RotateLeft32(x, -int(y&31)), nil
+	}
+}
+
+// arith64 evaluates an i64 binary arithmetic instruction, identified by its
+// i32 counterpart.
+func arith64(op byte, x, y uint64) (uint64, error) {
+	switch op {
+	case opI32Add:
+		return x + y, nil
+	case opI32Sub:
+		return x - y, nil
+	case opI32Mul:
+		return x * y, nil
+	case opI32DivS:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if int64(x) == -1<<63 && int64(y) == -1 {
+			return 0, ErrIntegerOverflow
+		}
+		return uint64(int64(x) / int64(y)), nil
+	case opI32DivU:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return x / y, nil
+	case opI32RemS:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if int64(y) == -1 {
+			return 0, nil
+		}
+		return uint64(int64(x) % int64(y)), nil
+	case opI32RemU:
+		if y == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return x % y, nil
+	case opI32And:
+		return x & y, nil
+	case opI32Or:
+		return x | y, nil
+	case opI32Xor:
+		return x ^ y, nil
+	case opI32Shl:
+		return x << (y & 63), nil
+	case opI32ShrS:
+		return uint64(int64(x) >> (y & 63)), nil
+	case opI32ShrU:
+		return x >> (y & 63), nil
+	case opI32Rotl:
+		return bits.RotateLeft64(x, int(y&63)), nil
+	default:
+		return bits.RotateLeft64(x, -int(y&63)), nil
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package wasm implements a deterministic WebAssembly interpreter.
+//
+// The interpreter supports the integer subset of the WebAssembly MVP: floating
+// point types and instructions are rejected at decoding time, as are imports of
+// anything but functions. Function bodies are validated and compiled into an
+// internal instruction stream with resolved branch targets, metering the gas
+// cost of every basic block at its entry.
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Magic is the preamble of every WebAssembly binary module.
+var Magic = []byte{0x00, 0x61, 0x73, 0x6d}
+
+// Version is the supported binary format version.
+const Version = 1
+
+// PageSize is the size of a page of linear memory.
+const PageSize = 65536
+
+// ValueType is the type of a WebAssembly value.
+type ValueType byte
+
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+
+	f32 ValueType = 0x7d
+	f64 ValueType = 0x7c
+
+	// unknown is the type of the values of unreachable code during validation.
+	unknown ValueType = 0
+)
+
+// String implements fmt.Stringer.
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case f32:
+		return "f32"
+	case f64:
+		return "f64"
+	}
+	return fmt.Sprintf("type(%#x)", byte(t))
+}
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// Equal reports whether the two signatures are the same.
+func (t FuncType) Equal(o FuncType) bool {
+	if len(t.Params) != len(o.Params) || len(t.Results) != len(o.Results) {
+		return false
+	}
+	for i := range t.Params {
+		if t.Params[i] != o.Params[i] {
+			return false
+		}
+	}
+	for i := range t.Results {
+		if t.Results[i] != o.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String implements fmt.Stringer.
+func (t FuncType) String() string {
+	return fmt.Sprintf("%v -> %v", t.Params, t.Results)
+}
+
+// ExternalKind is the kind of an imported or exported definition.
+type ExternalKind byte
+
+const (
+	ExternalFunction ExternalKind = 0
+	ExternalTable    ExternalKind = 1
+	ExternalMemory   ExternalKind = 2
+	ExternalGlobal   ExternalKind = 3
+)
+
+// Import is a function imported by a module.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32 // Index of the signature of the function
+}
+
+// Export is a definition exported by a module.
+type Export struct {
+	Name  string
+	Kind  ExternalKind
+	Index uint32
+}
+
+// Limits are the size bounds of a memory or a table.
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// Global is a global variable defined by a module.
+type Global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64
+}
+
+// Element is a segment of function indexes initialising the table.
+type Element struct {
+	Offset uint32
+	Funcs  []uint32
+}
+
+// Data is a segment of bytes initialising the linear memory.
+type Data struct {
+	Offset uint32
+	Init   []byte
+}
+
+// Function is a function defined by a module.
+type Function struct {
+	Type   uint32      // Index of the signature of the function
+	Locals []ValueType // Local variables, excluding the parameters
+
+	code     []instr  // Compiled body
+	brTables [][]jump // Targets of the br_table instructions
+	maxStack int      // Maximum operand stack height
+}
+
+// Module is a decoded and validated WebAssembly module.
+type Module struct {
+	Types     []FuncType
+	Imports   []Import
+	Functions []*Function
+	Table     *Limits
+	Memory    *Limits
+	Globals   []Global
+	Exports   []Export
+	Start     *uint32
+	Elements  []Element
+	Data      []Data
+}
+
+// FuncType returns the signature of the function with the given index, where
+// the imported functions precede the defined ones.
+func (m *Module) FuncType(index uint32) (FuncType, bool) {
+	if index < uint32(len(m.Imports)) {
+		return m.Types[m.Imports[index].Type], true
+	}
+	index -= uint32(len(m.Imports))
+	if index < uint32(len(m.Functions)) {
+		return m.Types[m.Functions[index].Type], true
+	}
+	return FuncType{}, false
+}
+
+// Export returns the export with the given name, if any.
+func (m *Module) Export(name string) (Export, bool) {
+	for _, export := range m.Exports {
+		if export.Name == name {
+			return export, true
+		}
+	}
+	return Export{}, false
+}
+
+var (
+	errInvalidMagic   = errors.New("wasm: invalid magic number")
+	errInvalidVersion = errors.New("wasm: unsupported version")
+	errUnexpectedEnd  = errors.New("wasm: unexpected end of input")
+	errFloatingPoint  = errors.New("wasm: floating point is not supported")
+)
+
+// Errors trapping the execution.
+var (
+	ErrUnreachable         = errors.New("wasm: unreachable executed")
+	ErrMemoryOutOfBounds   = errors.New("wasm: out of bounds memory access")
+	ErrDivisionByZero      = errors.New("wasm: integer division by zero")
+	ErrIntegerOverflow     = errors.New("wasm: integer overflow")
+	ErrUndefinedElement    = errors.New("wasm: undefined table element")
+	ErrIndirectCallType    = errors.New("wasm: indirect call type mismatch")
+	ErrCallStackExhausted  = errors.New("wasm: call stack exhausted")
+	ErrOutOfGas            = errors.New("wasm: out of gas")
+	ErrFunctionNotExported = errors.New("wasm: function not exported")
+)
@@ -0,0 +1,612 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testFunc is a function of a module assembled by a test.
+type testFunc struct {
+	typ    uint32
+	locals []ValueType
+	body   []byte // Instructions, including the final end
+}
+
+// testModule assembles binary modules for the tests.
+type testModule struct {
+	types    []FuncType
+	imports  []Import
+	funcs    []testFunc
+	table    uint32 // Minimum table size, zero for no table
+	memory   *Limits
+	globals  []Global
+	exports  []Export
+	start    *uint32
+	elements []Element
+	data     []Data
+}
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		if v >>= 7; v != 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func vec(n int, items []byte) []byte {
+	return append(uleb(uint64(n)), items...)
+}
+
+func name(s string) []byte {
+	return vec(len(s), []byte(s))
+}
+
+func i32Const(v int32) []byte {
+	return append([]byte{opI32Const}, sleb(int64(v))...)
+}
+
+func (t *testModule) encode() []byte {
+	out := append(append([]byte{}, Magic...), Version, 0, 0, 0)
+	section := func(id byte, payload []byte) {
+		out = append(out, id)
+		out = append(out, uleb(uint64(len(payload)))...)
+		out = append(out, payload...)
+	}
+	var payload []byte
+	for _, typ := range t.types {
+		payload = append(payload, 0x60)
+		payload = append(payload, vec(len(typ.Params), valueTypes(typ.Params))...)
+		payload = append(payload, vec(len(typ.Results), valueTypes(typ.Results))...)
+	}
+	section(sectionType, vec(len(t.types), payload))
+
+	if len(t.imports) > 0 {
+		payload = nil
+		for _, imp := range t.imports {
+			payload = append(payload, name(imp.Module)...)
+			payload = append(payload, name(imp.Name)...)
+			payload = append(payload, byte(ExternalFunction))
+			payload = append(payload, uleb(uint64(imp.Type))...)
+		}
+		section(sectionImport, vec(len(t.imports), payload))
+	}
+	payload = nil
+	for _, f := range t.funcs {
+		payload = append(payload, uleb(uint64(f.typ))...)
+	}
+	section(sectionFunction, vec(len(t.funcs), payload))
+
+	if t.table > 0 {
+		section(sectionTable, append([]byte{1, 0x70, 0}, uleb(uint64(t.table))...))
+	}
+	if t.memory != nil {
+		payload = []byte{1, 0}
+		payload = append(payload, uleb(uint64(t.memory.Min))...)
+		if t.memory.HasMax {
+			payload[1] = 1
+			payload = append(payload, uleb(uint64(t.memory.Max))...)
+		}
+		section(sectionMemory, payload)
+	}
+	if len(t.globals) > 0 {
+		payload = nil
+		for _, g := range t.globals {
+			payload = append(payload, byte(g.Type), 0)
+			if g.Mutable {
+				payload[len(payload)-1] = 1
+			}
+			if g.Type == I32 {
+				payload = append(payload, i32Const(int32(g.Init))...)
+			} else {
+				payload = append(append(payload, opI64Const), sleb(int64(g.Init))...)
+			}
+			payload = append(payload, opEnd)
+		}
+		section(sectionGlobal, vec(len(t.globals), payload))
+	}
+	if len(t.exports) > 0 {
+		payload = nil
+		for _, export := range t.exports {
+			payload = append(payload, name(export.Name)...)
+			payload = append(payload, byte(export.Kind))
+			payload = append(payload, uleb(uint64(export.Index))...)
+		}
+		section(sectionExport, vec(len(t.exports), payload))
+	}
+	if t.start != nil {
+		section(sectionStart, uleb(uint64(*t.start)))
+	}
+	if len(t.elements) > 0 {
+		payload = nil
+		for _, elem := range t.elements {
+			payload = append(payload, 0)
+			payload = append(payload, i32Const(int32(elem.Offset))...)
+			payload = append(payload, opEnd)
+			var funcs []byte
+			for _, index := range elem.Funcs {
+				funcs = append(funcs, uleb(uint64(index))...)
+			}
+			payload = append(payload, vec(len(elem.Funcs), funcs)...)
+		}
+		section(sectionElement, vec(len(t.elements), payload))
+	}
+	payload = nil
+	for _, f := range t.funcs {
+		var body []byte
+		if len(f.locals) > 0 {
+			body = append(body, vec(len(f.locals), nil)...)
+			for _, local := range f.locals {
+				body = append(body, 1, byte(local))
+			}
+		} else {
+			body = append(body, 0)
+		}
+		body = append(body, f.body...)
+		payload = append(payload, vec(len(body), body)...)
+	}
+	section(sectionCode, vec(len(t.funcs), payload))
+
+	if len(t.data) > 0 {
+		payload = nil
+		for _, data := range t.data {
+			payload = append(payload, 0)
+			payload = append(payload, i32Const(int32(data.Offset))...)
+			payload = append(payload, opEnd)
+			payload = append(payload, vec(len(data.Init), data.Init)...)
+		}
+		section(sectionData, vec(len(t.data), payload))
+	}
+	return out
+}
+
+func valueTypes(types []ValueType) []byte {
+	out := make([]byte, len(types))
+	for i, t := range types {
+		out[i] = byte(t)
+	}
+	return out
+}
+
+// testMeter is a gas meter with a fixed allowance.
+type testMeter struct {
+	gas, used uint64
+}
+
+func (m *testMeter) UseGas(gas uint64) bool {
+	if m.gas < gas {
+		return false
+	}
+	m.gas -= gas
+	m.used += gas
+	return true
+}
+
+func noImports(module, name string, sig FuncType) (HostFunc, error) {
+	return nil, fmt.Errorf("unknown import %s.%s", module, name)
+}
+
+// instantiate assembles, decodes and instantiates a test module.
+func instantiate(t *testing.T, tm *testModule, resolve Resolver, gas uint64) (*VM, *testMeter) {
+	t.Helper()
+
+	m, err := Decode(tm.encode())
+	if err != nil {
+		t.Fatalf("failed to decode module: %v", err)
+	}
+	if resolve == nil {
+		resolve = noImports
+	}
+	meter := &testMeter{gas: gas}
+	config := DefaultConfig
+	config.PageGas = 100
+	vm, err := NewVM(m, resolve, meter, config)
+	if err != nil {
+		t.Fatalf("failed to instantiate module: %v", err)
+	}
+	return vm, meter
+}
+
+func exportFuncs(names ...string) []Export {
+	exports := make([]Export, len(names))
+	for i, name := range names {
+		exports[i] = Export{Name: name, Kind: ExternalFunction, Index: uint32(i)}
+	}
+	return exports
+}
+
+func TestLEB128(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 63, 64, -64, -65, 1 << 31, -1 << 31, 1<<63 - 1, -1 << 63} {
+		r := &reader{buf: sleb(v)}
+		if have, err := r.sleb(64); err != nil || have != v {
+			t.Errorf("sleb64 %d: have %d, err %v", v, have, err)
+		}
+	}
+	for _, v := range []int64{0, -1, 1<<31 - 1, -1 << 31} {
+		r := &reader{buf: sleb(v)}
+		if have, err := r.sleb(32); err != nil || have != v {
+			t.Errorf("sleb32 %d: have %d, err %v", v, have, err)
+		}
+	}
+	if _, err := (&reader{buf: sleb(1 << 31)}).sleb(32); err == nil {
+		t.Errorf("oversized sleb32 accepted")
+	}
+	if _, err := (&reader{buf: uleb(1 << 32)}).uleb(32); err == nil {
+		t.Errorf("oversized uleb32 accepted")
+	}
+	if _, err := (&reader{buf: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}}).uleb(32); err == nil {
+		t.Errorf("overlong uleb32 accepted")
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	tm := &testModule{
+		types: []FuncType{
+			{Params: []ValueType{I64}, Results: []ValueType{I64}},
+			{Params: []ValueType{I32}, Results: []ValueType{I32}},
+		},
+		funcs: []testFunc{
+			// Recursive factorial
+			{typ: 0, body: []byte{
+				opLocalGet, 0, opI64Eqz, opIf, byte(I64),
+				opI64Const, 1,
+				opElse,
+				opLocalGet, 0, opLocalGet, 0, opI64Const, 1, opI64Sub, opCall, 0, opI64Mul,
+				opEnd, opEnd,
+			}},
+			// Sum of 1..n in a loop
+			{typ: 1, locals: []ValueType{I32}, body: []byte{
+				opBlock, 0x40, opLoop, 0x40,
+				opLocalGet, 0, opI32Eqz, opBrIf, 1,
+				opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1,
+				opLocalGet, 0, opI32Const, 1, opI32Sub, opLocalSet, 0,
+				opBr, 0,
+				opEnd, opEnd,
+				opLocalGet, 1, opEnd,
+			}},
+			// Switch over 0, 1 and anything else
+			{typ: 1, body: []byte{
+				opBlock, 0x40, opBlock, 0x40, opBlock, 0x40,
+				opLocalGet, 0, opBrTable, 2, 0, 1, 2,
+				opEnd, opI32Const, 10, opReturn,
+				opEnd, opI32Const, 20, opReturn,
+				opEnd, opI32Const, 30, opEnd,
+			}},
+			// Table branch carrying a value out of a block
+			{typ: 1, body: []byte{
+				opBlock, byte(I32), opI32Const, 7, opLocalGet, 0, opBrTable, 1, 0, 0, opEnd, opEnd,
+			}},
+		},
+		exports: exportFuncs("fact", "sum", "switch", "carry"),
+	}
+	vm, _ := instantiate(t, tm, nil, 1000000)
+
+	tests := []struct {
+		fn   string
+		arg  uint64
+		want uint64
+	}{
+		{"fact", 0, 1}, {"fact", 5, 120}, {"fact", 20, 2432902008176640000},
+		{"sum", 0, 0}, {"sum", 10, 55}, {"sum", 1000, 500500},
+		{"switch", 0, 10}, {"switch", 1, 20}, {"switch", 2, 30}, {"switch", 1 << 31, 30},
+		{"carry", 0, 7}, {"carry", 9, 7},
+	}
+	for _, tt := range tests {
+		res, err := vm.Invoke(tt.fn, tt.arg)
+		if err != nil {
+			t.Errorf("%s(%d): failed: %v", tt.fn, tt.arg, err)
+			continue
+		}
+		if len(res) != 1 || res[0] != tt.want {
+			t.Errorf("%s(%d): have %v, want %d", tt.fn, tt.arg, res, tt.want)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	tm := &testModule{
+		types: []FuncType{
+			{Params: []ValueType{I32}, Results: []ValueType{I64}},
+			{Results: []ValueType{I32}},
+			{Params: []ValueType{I32}, Results: []ValueType{I32}},
+		},
+		funcs: []testFunc{
+			// Store -2 at address + 4 and load it back
+			{typ: 0, body: []byte{
+				opLocalGet, 0, opI64Const, 0x7e, opI64Store, 3, 4,
+				opLocalGet, 0, opI64Load, 3, 4, opEnd,
+			}},
+			// Sign extending load of the data segment
+			{typ: 1, body: []byte{opI32Const, 0, opI32Load8S, 0, 0, opEnd}},
+			// Grow the memory
+			{typ: 2, body: []byte{opLocalGet, 0, opMemoryGrow, 0, opEnd}},
+			// Memory size
+			{typ: 1, body: []byte{opMemorySize, 0, opEnd}},
+		},
+		memory:  &Limits{Min: 1, Max: 2, HasMax: true},
+		exports: exportFuncs("roundtrip", "load8", "grow", "size"),
+		data:    []Data{{Offset: 0, Init: []byte{0xfe, 0xff}}},
+	}
+	vm, meter := instantiate(t, tm, nil, 1000000)
+
+	if res, err := vm.Invoke("roundtrip", 64); err != nil || res[0] != 0xfffffffffffffffe {
+		t.Errorf("roundtrip: have %v, err %v", res, err)
+	}
+	if _, err := vm.Invoke("roundtrip", PageSize-8); err != ErrMemoryOutOfBounds {
+		t.Errorf("out of bounds store: have %v, want %v", err, ErrMemoryOutOfBounds)
+	}
+	if _, err := vm.Invoke("roundtrip", 0xffffffff); err != ErrMemoryOutOfBounds {
+		t.Errorf("wrapping store: have %v, want %v", err, ErrMemoryOutOfBounds)
+	}
+	if res, err := vm.Invoke("load8"); err != nil || res[0] != 0xfffffffe {
+		t.Errorf("load8: have %v, err %v", res, err)
+	}
+	used := meter.used
+	if res, err := vm.Invoke("grow", 1); err != nil || res[0] != 1 {
+		t.Errorf("grow: have %v, err %v", res, err)
+	}
+	if meter.used-used < 100 {
+		t.Errorf("grow not charged per page: %d", meter.used-used)
+	}
+	if res, err := vm.Invoke("grow", 1); err != nil || res[0] != 0xffffffff {
+		t.Errorf("grow beyond maximum: have %v, err %v", res, err)
+	}
+	if res, err := vm.Invoke("size"); err != nil || res[0] != 2 {
+		t.Errorf("size: have %v, err %v", res, err)
+	}
+	if res, err := vm.Invoke("roundtrip", PageSize-8); err != nil || res[0] != 0xfffffffffffffffe {
+		t.Errorf("roundtrip in grown memory: have %v, err %v", res, err)
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tm := &testModule{
+		types: []FuncType{
+			{Params: []ValueType{I32, I32}, Results: []ValueType{I32}},
+			{},
+			{Results: []ValueType{I32}},
+			{Params: []ValueType{I32}, Results: []ValueType{I32}},
+		},
+		funcs: []testFunc{
+			{typ: 0, body: []byte{opLocalGet, 0, opLocalGet, 1, opI32DivS, opEnd}},
+			{typ: 1, body: []byte{opUnreachable, opEnd}},
+			{typ: 2, body: []byte{opI32Const, 42, opEnd}},
+			{typ: 3, body: []byte{opLocalGet, 0, opEnd}},
+			{typ: 3, body: []byte{opLocalGet, 0, opCallIndirect, 2, 0, opEnd}},
+			{typ: 1, body: []byte{opCall, 5, opEnd}},
+		},
+		table:    3,
+		elements: []Element{{Offset: 0, Funcs: []uint32{2, 3}}},
+		exports:  exportFuncs("div", "unreachable", "const", "identity", "indirect", "recurse"),
+	}
+	vm, _ := instantiate(t, tm, nil, 10000000)
+
+	tests := []struct {
+		fn   string
+		args []uint64
+		want uint64
+		err  error
+	}{
+		{"div", []uint64{7, 2}, 3, nil},
+		{"div", []uint64{0xfffffff9, 2}, 0xfffffffd, nil},
+		{"div", []uint64{1, 0}, 0, ErrDivisionByZero},
+		{"div", []uint64{0x80000000, 0xffffffff}, 0, ErrIntegerOverflow},
+		{"unreachable", nil, 0, ErrUnreachable},
+		{"indirect", []uint64{0}, 42, nil},
+		{"indirect", []uint64{1}, 0, ErrIndirectCallType},
+		{"indirect", []uint64{2}, 0, ErrUndefinedElement},
+		{"indirect", []uint64{3}, 0, ErrUndefinedElement},
+		{"recurse", nil, 0, ErrCallStackExhausted},
+	}
+	for _, tt := range tests {
+		res, err := vm.Invoke(tt.fn, tt.args...)
+		if err != tt.err {
+			t.Errorf("%s%v: error mismatch: have %v, want %v", tt.fn, tt.args, err, tt.err)
+			continue
+		}
+		if err == nil && res[0] != tt.want {
+			t.Errorf("%s%v: have %d, want %d", tt.fn, tt.args, res[0], tt.want)
+		}
+	}
+}
+
+func TestHostFunctions(t *testing.T) {
+	errHost := errors.New("host failure")
+	tm := &testModule{
+		types:   []FuncType{{Params: []ValueType{I32, I32}, Results: []ValueType{I32}}, {Results: []ValueType{I32}}},
+		imports: []Import{{Module: "env", Name: "add", Type: 0}},
+		funcs: []testFunc{
+			{typ: 1, body: []byte{opI32Const, 2, opI32Const, 3, opCall, 0, opEnd}},
+			{typ: 1, body: []byte{opI32Const, 0, opI32Const, 3, opCall, 0, opEnd}},
+		},
+		exports: []Export{{Name: "add", Kind: ExternalFunction, Index: 1}, {Name: "fail", Kind: ExternalFunction, Index: 2}},
+	}
+	resolve := func(module, name string, sig FuncType) (HostFunc, error) {
+		if module != "env" || name != "add" {
+			return nil, fmt.Errorf("unknown import %s.%s", module, name)
+		}
+		return func(vm *VM, args []uint64) (uint64, error) {
+			if args[0] == 0 {
+				return 0, errHost
+			}
+			return args[0] + args[1], nil
+		}, nil
+	}
+	vm, _ := instantiate(t, tm, resolve, 1000)
+	if res, err := vm.Invoke("add"); err != nil || res[0] != 5 {
+		t.Errorf("host call: have %v, err %v", res, err)
+	}
+	if _, err := vm.Invoke("fail"); err != errHost {
+		t.Errorf("host error: have %v, want %v", err, errHost)
+	}
+	m, _ := Decode(tm.encode())
+	if _, err := NewVM(m, noImports, &testMeter{}, DefaultConfig); err == nil {
+		t.Errorf("unresolved import accepted")
+	}
+}
+
+func TestStartAndGlobals(t *testing.T) {
+	start := uint32(0)
+	tm := &testModule{
+		types: []FuncType{{}, {Results: []ValueType{I32}}},
+		funcs: []testFunc{
+			{typ: 0, body: []byte{opGlobalGet, 1, opI32Const, 5, opI32Add, opGlobalSet, 0, opEnd}},
+			{typ: 1, body: []byte{opGlobalGet, 0, opEnd}},
+		},
+		globals: []Global{{Type: I32, Mutable: true}, {Type: I32, Init: 37}},
+		exports: []Export{{Name: "get", Kind: ExternalFunction, Index: 1}},
+		start:   &start,
+	}
+	vm, _ := instantiate(t, tm, nil, 1000)
+	if res, err := vm.Invoke("get"); err != nil || res[0] != 42 {
+		t.Errorf("global: have %v, err %v", res, err)
+	}
+	if _, err := vm.Invoke("missing"); err != ErrFunctionNotExported {
+		t.Errorf("missing export: have %v, want %v", err, ErrFunctionNotExported)
+	}
+}
+
+func TestGasMetering(t *testing.T) {
+	tm := &testModule{
+		types: []FuncType{{Params: []ValueType{I32}, Results: []ValueType{I32}}},
+		funcs: []testFunc{{typ: 0, locals: []ValueType{I32}, body: []byte{
+			opBlock, 0x40, opLoop, 0x40,
+			opLocalGet, 0, opI32Eqz, opBrIf, 1,
+			opLocalGet, 1, opLocalGet, 0, opI32Mul, opLocalSet, 1,
+			opLocalGet, 0, opI32Const, 1, opI32Sub, opLocalSet, 0,
+			opBr, 0,
+			opEnd, opEnd,
+			opLocalGet, 1, opEnd,
+		}}},
+		exports: exportFuncs("loop"),
+	}
+	used := func(n uint64) uint64 {
+		vm, meter := instantiate(t, tm, nil, 1000000)
+		if _, err := vm.Invoke("loop", n); err != nil {
+			t.Fatalf("loop(%d) failed: %v", n, err)
+		}
+		return meter.used
+	}
+	base, once := used(0), used(1)
+	if once <= base {
+		t.Fatalf("loop iteration not charged: %d <= %d", once, base)
+	}
+	if have, want := used(100), base+100*(once-base); have != want {
+		t.Errorf("gas not linear in iterations: have %d, want %d", have, want)
+	}
+	vm, _ := instantiate(t, tm, nil, 1000)
+	if _, err := vm.Invoke("loop", 1000); err != ErrOutOfGas {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+}
+
+func TestTableLimits(t *testing.T) {
+	config := DefaultConfig
+	config.MaxTable, config.TableGas = 16, 2
+
+	newVM := func(size uint32, gas uint64) (*testMeter, error) {
+		m, err := Decode((&testModule{table: size}).encode())
+		if err != nil {
+			t.Fatalf("failed to decode module: %v", err)
+		}
+		meter := &testMeter{gas: gas}
+		_, err = NewVM(m, noImports, meter, config)
+		return meter, err
+	}
+	if meter, err := newVM(10, 100); err != nil {
+		t.Errorf("failed to instantiate table: %v", err)
+	} else if meter.used != 20 {
+		t.Errorf("table gas mismatch: have %d, want %d", meter.used, 20)
+	}
+	if _, err := newVM(16, 10); err != ErrOutOfGas {
+		t.Errorf("underpriced table error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+	if _, err := newVM(17, 100); err == nil {
+		t.Errorf("oversized table instantiated")
+	}
+}
+
+func TestInvalidModules(t *testing.T) {
+	valid := func() *testModule {
+		return &testModule{
+			types:   []FuncType{{Results: []ValueType{I32}}},
+			funcs:   []testFunc{{typ: 0, body: []byte{opI32Const, 1, opEnd}}},
+			exports: exportFuncs("main"),
+		}
+	}
+	if _, err := Decode(valid().encode()); err != nil {
+		t.Fatalf("valid module rejected: %v", err)
+	}
+	tests := []struct {
+		name   string
+		mutate func(tm *testModule)
+	}{
+		{"float type", func(tm *testModule) { tm.types[0].Results[0] = f32 }},
+		{"float instruction", func(tm *testModule) { tm.funcs[0].body = []byte{0x43, 0, 0, 0, 0, opDrop, opI32Const, 1, opEnd} }},
+		{"type mismatch", func(tm *testModule) { tm.funcs[0].body = []byte{opI64Const, 1, opEnd} }},
+		{"stack underflow", func(tm *testModule) { tm.funcs[0].body = []byte{opI32Const, 1, opI32Add, opEnd} }},
+		{"leftover values", func(tm *testModule) { tm.funcs[0].body = []byte{opI32Const, 1, opI32Const, 1, opEnd} }},
+		{"missing end", func(tm *testModule) { tm.funcs[0].body = []byte{opI32Const, 1} }},
+		{"unknown label", func(tm *testModule) { tm.funcs[0].body = []byte{opI32Const, 1, opBr, 1, opEnd} }},
+		{"unknown function", func(tm *testModule) { tm.funcs[0].body = []byte{opCall, 1, opEnd} }},
+		{"memory without memory", func(tm *testModule) { tm.funcs[0].body = []byte{opI32Const, 0, opI32Load, 2, 0, opEnd} }},
+		{"unknown export", func(tm *testModule) { tm.exports[0].Index = 1 }},
+		{"immutable global", func(tm *testModule) {
+			tm.globals = []Global{{Type: I32}}
+			tm.funcs[0].body = []byte{opI32Const, 1, opGlobalSet, 0, opI32Const, 1, opEnd}
+		}},
+	}
+	for _, tt := range tests {
+		tm := valid()
+		tt.mutate(tm)
+		if _, err := Decode(tm.encode()); err == nil {
+			t.Errorf("%s: invalid module accepted", tt.name)
+		}
+	}
+	if _, err := Decode([]byte("\x00asn\x01\x00\x00\x00")); err != errInvalidMagic {
+		t.Errorf("magic error mismatch: have %v", err)
+	}
+	if _, err := Decode([]byte("\x00asm\x02\x00\x00\x00")); err != errInvalidVersion {
+		t.Errorf("version error mismatch: have %v", err)
+	}
+	// Imports of anything but functions are rejected
+	blob := append(append([]byte{}, Magic...), 1, 0, 0, 0, sectionImport, 8, 1, 1, 'a', 1, 'b', byte(ExternalMemory), 0, 1)
+	if _, err := Decode(blob); err == nil {
+		t.Errorf("memory import accepted")
+	}
+}
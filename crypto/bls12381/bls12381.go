@@ -0,0 +1,232 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bls12381 implements the BLS12-381 pairing friendly elliptic curve
+// operations of the EIP-2537 precompiled contracts.
+//
+// The arithmetic is delegated to github.com/kilic/bls12-381, this package only
+// converting between its points and the EIP-2537 encoding. The scalar
+// multiplications are not constant time, so they must not be used with secret
+// scalars.
+package bls12381
+
+import (
+	"errors"
+	"math/big"
+
+	bls "github.com/kilic/bls12-381"
+)
+
+var (
+	// P is the prime modulus of the base field.
+	P, _ = new(big.Int).SetString("1a0111ea397fe69a4b1ba7b6434bacd764774b84f38512bf6730d2a0f6b0f6241eabfffeb153ffffb9feffffffffaaab", 16)
+
+	// Order is the order of the G1 and G2 groups.
+	Order, _ = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+)
+
+var (
+	errInvalidLength    = errors.New("bls12381: invalid encoding length")
+	errInvalidPadding   = errors.New("bls12381: invalid field element padding")
+	errInvalidFieldElem = errors.New("bls12381: field element not in field")
+	errPointNotOnCurve  = errors.New("bls12381: point not on curve")
+)
+
+// G1 is a point of the BLS12-381 curve over Fp.
+type G1 struct {
+	p *bls.PointG1
+}
+
+// G2 is a point of the BLS12-381 twist over Fp2.
+type G2 struct {
+	p *bls.PointG2
+}
+
+// The group instances of the underlying library keep preallocated temporaries
+// and are not safe for concurrent use, so a fresh one is created per operation.
+
+// G1Generator returns the generator of G1.
+func G1Generator() *G1 {
+	return &G1{bls.NewG1().One()}
+}
+
+// G2Generator returns the generator of G2.
+func G2Generator() *G2 {
+	return &G2{bls.NewG2().One()}
+}
+
+// IsInfinity reports whether the point is the point at infinity.
+func (g *G1) IsInfinity() bool { return bls.NewG1().IsZero(g.p) }
+
+// Equal reports whether the two points are equal.
+func (g *G1) Equal(h *G1) bool { return bls.NewG1().Equal(g.p, h.p) }
+
+// Add returns a + b.
+func (g *G1) Add(a, b *G1) *G1 {
+	g.p = bls.NewG1().Add(new(bls.PointG1), a.p, b.p)
+	return g
+}
+
+// Neg returns -a.
+func (g *G1) Neg(a *G1) *G1 {
+	g.p = bls.NewG1().Neg(new(bls.PointG1), a.p)
+	return g
+}
+
+// ScalarMult returns a * k.
+func (g *G1) ScalarMult(a *G1, k *big.Int) *G1 {
+	g.p = bls.NewG1().MulScalarBig(new(bls.PointG1), a.p, k)
+	return g
+}
+
+// InCorrectSubgroup reports whether the point is of order r.
+func (g *G1) InCorrectSubgroup() bool {
+	return bls.NewG1().InCorrectSubgroup(g.p)
+}
+
+// IsInfinity reports whether the point is the point at infinity.
+func (g *G2) IsInfinity() bool { return bls.NewG2().IsZero(g.p) }
+
+// Equal reports whether the two points are equal.
+func (g *G2) Equal(h *G2) bool { return bls.NewG2().Equal(g.p, h.p) }
+
+// Add returns a + b.
+func (g *G2) Add(a, b *G2) *G2 {
+	g.p = bls.NewG2().Add(new(bls.PointG2), a.p, b.p)
+	return g
+}
+
+// Neg returns -a.
+func (g *G2) Neg(a *G2) *G2 {
+	g.p = bls.NewG2().Neg(new(bls.PointG2), a.p)
+	return g
+}
+
+// ScalarMult returns a * k.
+func (g *G2) ScalarMult(a *G2, k *big.Int) *G2 {
+	g.p = bls.NewG2().MulScalarBig(new(bls.PointG2), a.p, k)
+	return g
+}
+
+// InCorrectSubgroup reports whether the point is of order r.
+func (g *G2) InCorrectSubgroup() bool {
+	return bls.NewG2().InCorrectSubgroup(g.p)
+}
+
+// PairingCheck reports whether the product of the pairings of the given G1 and
+// G2 points is one. The points are assumed to be within the correct subgroups.
+func PairingCheck(a []*G1, b []*G2) bool {
+	engine := bls.NewEngine()
+	for i := 0; i < len(a); i++ {
+		engine.AddPair(a[i].p, b[i].p)
+	}
+	return engine.Check()
+}
+
+// The points are encoded as the concatenation of their coordinates, with each
+// base field element padded to 64 bytes big endian. Fp2 elements are encoded
+// as c0 || c1 and the point at infinity as all zeroes.
+const (
+	fpEncodedLength = 64
+	fpPaddingLength = 16
+	fpByteLength    = fpEncodedLength - fpPaddingLength
+
+	// G1EncodedLength is the length of an encoded G1 point.
+	G1EncodedLength = 2 * fpEncodedLength
+
+	// G2EncodedLength is the length of an encoded G2 point.
+	G2EncodedLength = 4 * fpEncodedLength
+)
+
+// The underlying library serializes points as the concatenation of unpadded
+// base field elements, with Fp2 elements as c1 || c0. g1Layout and g2Layout are
+// the positions of the EIP-2537 encoded field elements within that form.
+var (
+	g1Layout = []int{0, 1}
+	g2Layout = []int{1, 0, 3, 2}
+)
+
+// unpad converts the padded base field elements of an EIP-2537 encoded point
+// into the serialization of the underlying library.
+func unpad(in []byte, layout []int) ([]byte, error) {
+	if len(in) != len(layout)*fpEncodedLength {
+		return nil, errInvalidLength
+	}
+	out := make([]byte, len(layout)*fpByteLength)
+	for i, idx := range layout {
+		elem := in[i*fpEncodedLength : (i+1)*fpEncodedLength]
+		for _, b := range elem[:fpPaddingLength] {
+			if b != 0 {
+				return nil, errInvalidPadding
+			}
+		}
+		if new(big.Int).SetBytes(elem[fpPaddingLength:]).Cmp(P) >= 0 {
+			return nil, errInvalidFieldElem
+		}
+		copy(out[idx*fpByteLength:], elem[fpPaddingLength:])
+	}
+	return out, nil
+}
+
+// pad converts a point serialized by the underlying library into its EIP-2537
+// encoding.
+func pad(in []byte, layout []int) []byte {
+	out := make([]byte, len(layout)*fpEncodedLength)
+	for i, idx := range layout {
+		copy(out[i*fpEncodedLength+fpPaddingLength:], in[idx*fpByteLength:(idx+1)*fpByteLength])
+	}
+	return out
+}
+
+// DecodeG1 decodes a G1 point, verifying that it is on the curve. The caller
+// has to check the subgroup of the point if needed.
+func DecodeG1(in []byte) (*G1, error) {
+	blob, err := unpad(in, g1Layout)
+	if err != nil {
+		return nil, err
+	}
+	p, err := bls.NewG1().FromBytes(blob)
+	if err != nil {
+		// The field elements are already validated, only the curve check remains
+		return nil, errPointNotOnCurve
+	}
+	return &G1{p}, nil
+}
+
+// Encode returns the encoding of the point.
+func (g *G1) Encode() []byte {
+	return pad(bls.NewG1().ToBytes(g.p), g1Layout)
+}
+
+// DecodeG2 decodes a G2 point, verifying that it is on the twist. The caller
+// has to check the subgroup of the point if needed.
+func DecodeG2(in []byte) (*G2, error) {
+	blob, err := unpad(in, g2Layout)
+	if err != nil {
+		return nil, err
+	}
+	p, err := bls.NewG2().FromBytes(blob)
+	if err != nil {
+		// The field elements are already validated, only the curve check remains
+		return nil, errPointNotOnCurve
+	}
+	return &G2{p}, nil
+}
+
+// Encode returns the encoding of the point.
+func (g *G2) Encode() []byte {
+	return pad(bls.NewG2().ToBytes(g.p), g2Layout)
+}
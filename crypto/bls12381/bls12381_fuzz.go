@@ -0,0 +1,92 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package bls12381
+
+import (
+	"bytes"
+	"math/big"
+)
+
+// FuzzG1 checks the group law of G1 on scalar multiples of the generator and
+// the encoding round trip of arbitrary points.
+func FuzzG1(data []byte) int {
+	if len(data) == G1EncodedLength {
+		p, err := DecodeG1(data)
+		if err != nil {
+			return 0
+		}
+		if !bytes.Equal(p.Encode(), data) {
+			panic("G1 encoding mismatch")
+		}
+		return 1
+	}
+	if len(data) < 64 {
+		return 0
+	}
+	a, b := new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:64])
+	g := G1Generator()
+
+	sum := new(G1).Add(new(G1).ScalarMult(g, a), new(G1).ScalarMult(g, b))
+	if !sum.Equal(new(G1).ScalarMult(g, new(big.Int).Add(a, b))) {
+		panic("G1 scalar multiplication not distributive")
+	}
+	return 1
+}
+
+// FuzzG2 checks the group law of G2 on scalar multiples of the generator and
+// the encoding round trip of arbitrary points.
+func FuzzG2(data []byte) int {
+	if len(data) == G2EncodedLength {
+		p, err := DecodeG2(data)
+		if err != nil {
+			return 0
+		}
+		if !bytes.Equal(p.Encode(), data) {
+			panic("G2 encoding mismatch")
+		}
+		return 1
+	}
+	if len(data) < 64 {
+		return 0
+	}
+	a, b := new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:64])
+	g := G2Generator()
+
+	sum := new(G2).Add(new(G2).ScalarMult(g, a), new(G2).ScalarMult(g, b))
+	if !sum.Equal(new(G2).ScalarMult(g, new(big.Int).Add(a, b))) {
+		panic("G2 scalar multiplication not distributive")
+	}
+	return 1
+}
+
+// FuzzPairing checks the bilinearity of the pairing, e(aP, Q) * e(-P, aQ) = 1.
+func FuzzPairing(data []byte) int {
+	if len(data) < 32 {
+		return 0
+	}
+	a := new(big.Int).SetBytes(data[:32])
+	g1, g2 := G1Generator(), G2Generator()
+
+	p := []*G1{new(G1).ScalarMult(g1, a), new(G1).Neg(g1)}
+	q := []*G2{g2, new(G2).ScalarMult(g2, a)}
+	if !PairingCheck(p, q) {
+		panic("pairing not bilinear")
+	}
+	return 1
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bls12381
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"testing"
+)
+
+func TestGenerators(t *testing.T) {
+	g1, g2 := G1Generator(), G2Generator()
+	if p, err := DecodeG1(g1.Encode()); err != nil || !p.Equal(g1) || !g1.InCorrectSubgroup() {
+		t.Errorf("G1 generator invalid: %v", err)
+	}
+	if q, err := DecodeG2(g2.Encode()); err != nil || !q.Equal(g2) || !g2.InCorrectSubgroup() {
+		t.Errorf("G2 generator invalid: %v", err)
+	}
+}
+
+// Tests the encoding of the multiples of the generators against the reference
+// vectors of the zkcrypto library, converted from its serialization: unpadded
+// coordinates, Fp2 elements as c1 || c0 and flags in the top bits.
+func TestZKCryptoVectors(t *testing.T) {
+	convert := func(vector []byte, layout []int) []byte {
+		if vector[0]&0x40 != 0 {
+			return make([]byte, len(layout)*fpEncodedLength)
+		}
+		return pad(vector, layout)
+	}
+	g1data, err := ioutil.ReadFile("testdata/g1_uncompressed_valid_test_vectors.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g1, g1sum := G1Generator(), new(G1).ScalarMult(G1Generator(), new(big.Int))
+	for i := 0; i < len(g1data)/(2*fpByteLength); i++ {
+		want := convert(g1data[i*2*fpByteLength:(i+1)*2*fpByteLength], g1Layout)
+		if have := g1sum.Encode(); !bytes.Equal(have, want) {
+			t.Fatalf("G1 vector %d: encoding mismatch: have %x, want %x", i, have, want)
+		}
+		if have := new(G1).ScalarMult(g1, big.NewInt(int64(i))).Encode(); !bytes.Equal(have, want) {
+			t.Fatalf("G1 vector %d: scalar multiple mismatch: have %x, want %x", i, have, want)
+		}
+		if p, err := DecodeG1(want); err != nil || !p.Equal(g1sum) {
+			t.Fatalf("G1 vector %d: decoding failed: %v", i, err)
+		}
+		g1sum.Add(g1sum, g1)
+	}
+	g2data, err := ioutil.ReadFile("testdata/g2_uncompressed_valid_test_vectors.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, g2sum := G2Generator(), new(G2).ScalarMult(G2Generator(), new(big.Int))
+	for i := 0; i < len(g2data)/(4*fpByteLength); i++ {
+		want := convert(g2data[i*4*fpByteLength:(i+1)*4*fpByteLength], g2Layout)
+		if have := g2sum.Encode(); !bytes.Equal(have, want) {
+			t.Fatalf("G2 vector %d: encoding mismatch: have %x, want %x", i, have, want)
+		}
+		if have := new(G2).ScalarMult(g2, big.NewInt(int64(i))).Encode(); !bytes.Equal(have, want) {
+			t.Fatalf("G2 vector %d: scalar multiple mismatch: have %x, want %x", i, have, want)
+		}
+		if q, err := DecodeG2(want); err != nil || !q.Equal(g2sum) {
+			t.Fatalf("G2 vector %d: decoding failed: %v", i, err)
+		}
+		g2sum.Add(g2sum, g2)
+	}
+}
+
+func TestGroupLaw(t *testing.T) {
+	g1 := G1Generator()
+	a := new(G1).ScalarMult(g1, big.NewInt(5))
+	b := new(G1).Add(new(G1).ScalarMult(g1, big.NewInt(2)), new(G1).ScalarMult(g1, big.NewInt(3)))
+	if !a.Equal(b) {
+		t.Errorf("G1: 2g + 3g != 5g")
+	}
+	if !new(G1).Add(a, new(G1).Neg(a)).IsInfinity() {
+		t.Errorf("G1: a - a != 0")
+	}
+	g2 := G2Generator()
+	c := new(G2).ScalarMult(g2, big.NewInt(5))
+	d := new(G2).Add(new(G2).ScalarMult(g2, big.NewInt(2)), new(G2).ScalarMult(g2, big.NewInt(3)))
+	if !c.Equal(d) {
+		t.Errorf("G2: 2g + 3g != 5g")
+	}
+	if !new(G2).Add(c, new(G2).Neg(c)).IsInfinity() {
+		t.Errorf("G2: a - a != 0")
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	g1 := new(G1).ScalarMult(G1Generator(), big.NewInt(7))
+	p, err := DecodeG1(g1.Encode())
+	if err != nil || !p.Equal(g1) {
+		t.Fatalf("G1 round trip failed: %v", err)
+	}
+	g2 := new(G2).ScalarMult(G2Generator(), big.NewInt(7))
+	q, err := DecodeG2(g2.Encode())
+	if err != nil || !q.Equal(g2) {
+		t.Fatalf("G2 round trip failed: %v", err)
+	}
+	if p, err := DecodeG1(make([]byte, G1EncodedLength)); err != nil || !p.IsInfinity() {
+		t.Errorf("zero encoding not infinity: %v", err)
+	}
+	// Corrupt encodings must be rejected
+	blob := g1.Encode()
+	blob[0] = 1
+	if _, err := DecodeG1(blob); err != errInvalidPadding {
+		t.Errorf("padding error mismatch: have %v", err)
+	}
+	blob = g1.Encode()
+	blob[G1EncodedLength-1] ^= 1
+	if _, err := DecodeG1(blob); err != errPointNotOnCurve {
+		t.Errorf("curve error mismatch: have %v", err)
+	}
+	blob = make([]byte, G1EncodedLength)
+	copy(blob[fpPaddingLength:], P.Bytes())
+	if _, err := DecodeG1(blob); err != errInvalidFieldElem {
+		t.Errorf("field error mismatch: have %v", err)
+	}
+	if _, err := DecodeG2(blob); err != errInvalidLength {
+		t.Errorf("length error mismatch: have %v", err)
+	}
+	if !bytes.Equal(new(G1).Neg(g1).Encode()[:fpEncodedLength], g1.Encode()[:fpEncodedLength]) {
+		t.Errorf("negation changed x coordinate")
+	}
+}
+
+func TestPairing(t *testing.T) {
+	g1, g2 := G1Generator(), G2Generator()
+
+	if PairingCheck([]*G1{g1}, []*G2{g2}) {
+		t.Fatalf("pairing is degenerate")
+	}
+	// e(aP, bQ) = e(P, Q)^(ab) = e(abP, Q), so e(aP, bQ) * e(-abP, Q) = 1
+	a, b := big.NewInt(6), big.NewInt(35)
+	ab := new(big.Int).Mul(a, b)
+	p := []*G1{new(G1).ScalarMult(g1, a), new(G1).Neg(new(G1).ScalarMult(g1, ab))}
+	q := []*G2{new(G2).ScalarMult(g2, b), g2}
+	if !PairingCheck(p, q) {
+		t.Errorf("pairing not bilinear")
+	}
+	// e(P, Q) * e(-P, Q) = 1
+	if !PairingCheck([]*G1{g1, new(G1).Neg(g1)}, []*G2{g2, g2}) {
+		t.Errorf("pairing check of inverse pairs failed")
+	}
+	if PairingCheck([]*G1{g1, g1}, []*G2{g2, g2}) {
+		t.Errorf("pairing check of non inverse pairs succeeded")
+	}
+	if !PairingCheck(nil, nil) {
+		t.Errorf("empty pairing check failed")
+	}
+}
+
+func BenchmarkPairing(b *testing.B) {
+	g1, g2 := G1Generator(), G2Generator()
+	for i := 0; i < b.N; i++ {
+		PairingCheck([]*G1{g1}, []*G2{g2})
+	}
+}
+
+func BenchmarkG1ScalarMult(b *testing.B) {
+	g1, k := G1Generator(), new(big.Int).Sub(Order, big.NewInt(1))
+	for i := 0; i < b.N; i++ {
+		new(G1).ScalarMult(g1, k)
+	}
+}
+
+func BenchmarkG2ScalarMult(b *testing.B) {
+	g2, k := G2Generator(), new(big.Int).Sub(Order, big.NewInt(1))
+	for i := 0; i < b.N; i++ {
+		new(G2).ScalarMult(g2, k)
+	}
+}
@@ -329,13 +329,20 @@ func (api *PrivateDebugAPI) GetBadBlocks(ctx context.Context) ([]*BadBlockArgs,
 		} else {
 			results[i].RLP = fmt.Sprintf("0x%x", rlpBytes)
 		}
-		if results[i].Block, err = ethapi.RPCMarshalBlock(block, true, true); err != nil {
+		if results[i].Block, err = ethapi.RPCMarshalBlock(block, true, true, api.eth.blockchain.Config()); err != nil {
 			results[i].Block = map[string]interface{}{"error": err.Error()}
 		}
 	}
 	return results, nil
 }
 
+// ProfileBlock returns a breakdown of the time spent importing the block with the
+// given hash. Blocks imported while block profiling was enabled are served from
+// memory, older ones are re-executed on top of their parent state.
+func (api *PrivateDebugAPI) ProfileBlock(ctx context.Context, hash common.Hash) (*core.BlockProfile, error) {
+	return api.eth.BlockChain().ProfileBlock(hash)
+}
+
 // StorageRangeResult is the result of a debug_storageRangeAt API call.
 type StorageRangeResult struct {
 	Storage storageMap   `json:"storage"`
@@ -0,0 +1,272 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+// flatCallTracer is the name of the native tracer producing Parity style traces.
+const flatCallTracer = "flatCallTracer"
+
+// FlatTrace is a single call of a transaction in the Parity trace format,
+// located in the chain by its block and transaction.
+type FlatTrace struct {
+	Action              json.RawMessage `json:"action"`
+	BlockHash           common.Hash     `json:"blockHash"`
+	BlockNumber         uint64          `json:"blockNumber"`
+	Error               string          `json:"error,omitempty"`
+	Result              json.RawMessage `json:"result,omitempty"`
+	Subtraces           int             `json:"subtraces"`
+	TraceAddress        []int           `json:"traceAddress"`
+	TransactionHash     common.Hash     `json:"transactionHash"`
+	TransactionPosition uint64          `json:"transactionPosition"`
+	Type                string          `json:"type"`
+}
+
+// addresses returns the sender and recipient of the traced call. Contract
+// creations are sent to the created contract, self destructs are sent from
+// the destructed contract to the beneficiary.
+func (trace *FlatTrace) addresses() (from common.Address, to common.Address) {
+	var action struct {
+		Address       *common.Address `json:"address"`
+		From          *common.Address `json:"from"`
+		RefundAddress *common.Address `json:"refundAddress"`
+		To            *common.Address `json:"to"`
+	}
+	var result struct {
+		Address *common.Address `json:"address"`
+	}
+	json.Unmarshal(trace.Action, &action)
+	if len(trace.Result) > 0 {
+		json.Unmarshal(trace.Result, &result)
+	}
+	switch {
+	case action.From != nil:
+		from = *action.From
+	case action.Address != nil:
+		from = *action.Address
+	}
+	switch {
+	case action.To != nil:
+		to = *action.To
+	case action.RefundAddress != nil:
+		to = *action.RefundAddress
+	case result.Address != nil:
+		to = *result.Address
+	}
+	return from, to
+}
+
+// TraceFilterArgs are the criteria of trace_filter. Traces match if they were
+// sent from any of the FromAddress and to any of the ToAddress, empty lists
+// matching everything.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"` // Number of matching traces to skip
+	Count       *uint64          `json:"count"` // Maximum number of traces to return
+}
+
+// matches checks whether the trace satisfies the address criteria.
+func (args *TraceFilterArgs) matches(trace *FlatTrace) bool {
+	from, to := trace.addresses()
+	return includes(args.FromAddress, from) && includes(args.ToAddress, to)
+}
+
+// includes checks whether the address is part of the list, an empty list
+// including all addresses.
+func includes(addresses []common.Address, address common.Address) bool {
+	if len(addresses) == 0 {
+		return true
+	}
+	for _, addr := range addresses {
+		if addr == address {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivateTraceAPI is the collection of Parity compatible tracing APIs, listing
+// all the calls made by transactions as flat traces. Block rewards are not
+// reported as they are consensus engine specific.
+type PrivateTraceAPI struct {
+	debug   *PrivateDebugAPI
+	maxSpan uint64 // Maximum number of blocks traced by a single filter (0 = unlimited)
+}
+
+// NewPrivateTraceAPI creates a new API definition for the Parity compatible
+// tracing methods of the Ethereum service.
+func NewPrivateTraceAPI(config *params.ChainConfig, eth *Ethereum) *PrivateTraceAPI {
+	return &PrivateTraceAPI{
+		debug:   NewPrivateDebugAPI(config, eth),
+		maxSpan: eth.config.TraceFilterMaxSpan,
+	}
+}
+
+// Block returns the traces of all the calls made by the transactions of the
+// given block.
+func (api *PrivateTraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*FlatTrace, error) {
+	var block *types.Block
+
+	switch number {
+	case rpc.PendingBlockNumber:
+		block = api.debug.eth.miner.PendingBlock()
+	case rpc.LatestBlockNumber:
+		block = api.debug.eth.blockchain.CurrentBlock()
+	default:
+		block = api.debug.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	return api.traceBlock(ctx, block)
+}
+
+// Transaction returns the traces of all the calls made by the given transaction.
+func (api *PrivateTraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*FlatTrace, error) {
+	tx, blockHash, blockNumber, index := rawdb.ReadTransaction(api.debug.eth.ChainDb(), hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %#x not found", hash)
+	}
+	msg, vmctx, statedb, err := api.debug.computeTxEnv(blockHash, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	tracer := flatCallTracer
+	res, err := api.debug.traceTx(ctx, msg, vmctx, statedb, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		return nil, err
+	}
+	return decodeTraces(res, blockHash, blockNumber, hash, index)
+}
+
+// Filter returns the traces of all the calls made within the given block range
+// matching the given address criteria.
+func (api *PrivateTraceAPI) Filter(ctx context.Context, args TraceFilterArgs) ([]*FlatTrace, error) {
+	// Resolve the block range, defaulting to the current head
+	head := api.debug.eth.blockchain.CurrentBlock().NumberU64()
+
+	resolve := func(number *rpc.BlockNumber) uint64 {
+		if number == nil || *number < 0 {
+			return head
+		}
+		return uint64(*number)
+	}
+	start, end := resolve(args.FromBlock), resolve(args.ToBlock)
+	if start > end {
+		return nil, fmt.Errorf("invalid block range #%d-#%d", start, end)
+	}
+	if end > head {
+		return nil, fmt.Errorf("block #%d not found", end)
+	}
+	if api.maxSpan > 0 && end-start >= api.maxSpan {
+		return nil, fmt.Errorf("block range #%d-#%d exceeds the maximum of %d blocks", start, end, api.maxSpan)
+	}
+	// Trace the blocks one by one, collecting the matching traces
+	var skip, count uint64
+	if args.After != nil {
+		skip = *args.After
+	}
+	full := func() bool {
+		return args.Count != nil && count >= *args.Count
+	}
+	traces := make([]*FlatTrace, 0)
+	for number := start; number <= end && !full(); number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.debug.eth.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		results, err := api.traceBlock(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range results {
+			if !args.matches(trace) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if full() {
+				break
+			}
+			traces = append(traces, trace)
+			count++
+		}
+	}
+	return traces, nil
+}
+
+// traceBlock traces all the transactions of the given block, returning the
+// flat traces of all their calls.
+func (api *PrivateTraceAPI) traceBlock(ctx context.Context, block *types.Block) ([]*FlatTrace, error) {
+	traces := make([]*FlatTrace, 0)
+	if len(block.Transactions()) == 0 {
+		return traces, nil
+	}
+	tracer := flatCallTracer
+	results, err := api.debug.traceBlock(ctx, block, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		tx := block.Transactions()[i]
+		if result.Error != "" {
+			return nil, fmt.Errorf("transaction %#x: %s", tx.Hash(), result.Error)
+		}
+		txTraces, err := decodeTraces(result.Result, block.Hash(), block.NumberU64(), tx.Hash(), uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, txTraces...)
+	}
+	return traces, nil
+}
+
+// decodeTraces parses the result of the flat call tracer, filling in the block
+// and transaction the calls were made in.
+func decodeTraces(result interface{}, blockHash common.Hash, blockNumber uint64, txHash common.Hash, index uint64) ([]*FlatTrace, error) {
+	blob, ok := result.(json.RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected trace result %T", result)
+	}
+	var traces []*FlatTrace
+	if err := json.Unmarshal(blob, &traces); err != nil {
+		return nil, err
+	}
+	for _, trace := range traces {
+		trace.BlockHash, trace.BlockNumber = blockHash, blockNumber
+		trace.TransactionHash, trace.TransactionPosition = txHash, index
+	}
+	return traces, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+func TestTraceAPI(t *testing.T) {
+	var (
+		key, _    = crypto.GenerateKey()
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		caller    = common.Address{0xa0}
+		callee    = common.HexToAddress("0xb0")
+		recipient = common.Address{0xee}
+		signer    = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		txs       []*types.Transaction
+	)
+	alloc := core.GenesisAlloc{
+		sender: {Balance: big.NewInt(params.Ether)},
+		// Calls the callee with all the available gas
+		caller: {Balance: new(big.Int), Code: common.FromHex("6000600060006000600060b05af100")},
+		// Stores 1 into slot 0
+		callee: {Balance: new(big.Int), Code: common.FromHex("600160005500")},
+	}
+	api := NewPrivateTraceAPI(params.TestChainConfig, newTestTracerAPI(t, alloc, 2, func(i int, block *core.BlockGen) {
+		if i != 0 {
+			return
+		}
+		tx1, _ := types.SignTx(types.NewTransaction(0, caller, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		tx2, _ := types.SignTx(types.NewTransaction(1, recipient, big.NewInt(1000), params.TxGas, big.NewInt(1), nil), signer, key)
+		block.AddTx(tx1)
+		block.AddTx(tx2)
+		txs = append(txs, tx1, tx2)
+	}).eth)
+
+	// Trace the whole block and check the call tree
+	traces, err := api.Block(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	want := []struct {
+		tx        common.Hash
+		from, to  common.Address
+		address   []int
+		subtraces int
+	}{
+		{txs[0].Hash(), sender, caller, []int{}, 1},
+		{txs[0].Hash(), caller, callee, []int{0}, 0},
+		{txs[1].Hash(), sender, recipient, []int{}, 0},
+	}
+	if len(traces) != len(want) {
+		t.Fatalf("trace count mismatch: have %d, want %d", len(traces), len(want))
+	}
+	for i, trace := range traces {
+		if trace.Type != "call" || trace.Error != "" || trace.BlockNumber != 1 {
+			t.Errorf("trace %d: unexpected trace: %+v", i, trace)
+		}
+		if trace.TransactionHash != want[i].tx {
+			t.Errorf("trace %d: transaction mismatch: have %x, want %x", i, trace.TransactionHash, want[i].tx)
+		}
+		if from, to := trace.addresses(); from != want[i].from || to != want[i].to {
+			t.Errorf("trace %d: addresses mismatch: have %x->%x, want %x->%x", i, from, to, want[i].from, want[i].to)
+		}
+		if !reflect.DeepEqual(trace.TraceAddress, want[i].address) {
+			t.Errorf("trace %d: trace address mismatch: have %v, want %v", i, trace.TraceAddress, want[i].address)
+		}
+		if trace.Subtraces != want[i].subtraces {
+			t.Errorf("trace %d: subtraces mismatch: have %d, want %d", i, trace.Subtraces, want[i].subtraces)
+		}
+	}
+	// Trace a single transaction
+	if traces, err := api.Transaction(context.Background(), txs[0].Hash()); err != nil {
+		t.Errorf("failed to trace transaction: %v", err)
+	} else if len(traces) != 2 || traces[1].TransactionPosition != 0 {
+		t.Errorf("transaction traces mismatch: %+v", traces)
+	}
+	// Filter the traces of a block range
+	var (
+		zero, last = rpc.BlockNumber(0), rpc.LatestBlockNumber
+		none, one  = uint64(0), uint64(1)
+	)
+	filters := []struct {
+		args TraceFilterArgs
+		want int
+	}{
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last}, 3},
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last, ToAddress: []common.Address{callee}}, 1},
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last, FromAddress: []common.Address{sender}}, 2},
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last, FromAddress: []common.Address{sender}, ToAddress: []common.Address{callee}}, 0},
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last, After: &one, Count: &one}, 1},
+		{TraceFilterArgs{FromBlock: &zero, ToBlock: &last, Count: &none}, 0},
+		{TraceFilterArgs{}, 0},
+	}
+	for i, filter := range filters {
+		traces, err := api.Filter(context.Background(), filter.args)
+		if err != nil {
+			t.Errorf("filter %d: failed to filter traces: %v", i, err)
+			continue
+		}
+		if len(traces) != filter.want {
+			t.Errorf("filter %d: trace count mismatch: have %d, want %d", i, len(traces), filter.want)
+		}
+	}
+	if traces, _ := api.Filter(context.Background(), filters[4].args); len(traces) == 1 {
+		if from, to := traces[0].addresses(); from != caller || to != callee {
+			t.Errorf("paginated trace mismatch: have %x->%x, want %x->%x", from, to, caller, callee)
+		}
+	}
+	// Ranges beyond the configured span must be rejected
+	api.maxSpan = 2
+	if _, err := api.Filter(context.Background(), filters[0].args); err == nil {
+		t.Errorf("oversized block range accepted")
+	}
+	api.maxSpan = 3
+	if traces, err := api.Filter(context.Background(), filters[0].args); err != nil || len(traces) != 3 {
+		t.Errorf("block range within the span rejected: %d traces, err %v", len(traces), err)
+	}
+}
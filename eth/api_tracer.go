@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -57,9 +58,16 @@ const (
 // TraceConfig holds extra parameters to trace functions.
 type TraceConfig struct {
 	*vm.LogConfig
-	Tracer  *string
-	Timeout *string
-	Reexec  *uint64
+	Tracer       *string
+	TracerConfig json.RawMessage
+	Timeout      *string
+	Reexec       *uint64
+}
+
+// TraceCallConfig holds extra parameters to call tracing functions.
+type TraceCallConfig struct {
+	TraceConfig
+	StateOverrides *ethapi.StateOverride
 }
 
 // StdTraceConfig holds extra parameters to standard-json trace functions.
@@ -710,6 +718,69 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	return api.traceTx(ctx, msg, vmctx, statedb, config)
 }
 
+// TraceCall lets you trace a given eth_call. It collects the structured logs
+// created during the execution of EVM if the given transaction was added on
+// top of the provided block and returns them as a JSON object. The state of
+// arbitrary accounts may be overridden for the duration of the call.
+func (api *PrivateDebugAPI) TraceCall(ctx context.Context, args ethapi.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (interface{}, error) {
+	// Fetch the block and state that we want to trace on top of
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	block, statedb, err := api.blockAndState(blockNrOrHash, reexec)
+	if err != nil {
+		return nil, err
+	}
+	// Apply the customized state rules if required
+	var traceConfig *TraceConfig
+	if config != nil {
+		if err := config.StateOverrides.Apply(statedb); err != nil {
+			return nil, err
+		}
+		traceConfig = &config.TraceConfig
+	}
+	// Execute the trace
+	msg := args.ToMessage(api.eth.APIBackend.RPCGasCap())
+	vmctx := core.NewEVMContext(msg, block.Header(), api.eth.blockchain, nil)
+
+	return api.traceTx(ctx, msg, vmctx, statedb, traceConfig)
+}
+
+// blockAndState retrieves the referenced block along with the state after it,
+// reexecuting at most reexec blocks to regenerate the state if needed.
+func (api *PrivateDebugAPI) blockAndState(blockNrOrHash rpc.BlockNumberOrHash, reexec uint64) (*types.Block, *state.StateDB, error) {
+	var block *types.Block
+
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		block = api.eth.blockchain.GetBlockByHash(hash)
+		if block != nil && blockNrOrHash.RequireCanonical && rawdb.ReadCanonicalHash(api.eth.ChainDb(), block.NumberU64()) != hash {
+			return nil, nil, fmt.Errorf("block %#x is not canonical", hash)
+		}
+	} else if number, ok := blockNrOrHash.Number(); ok {
+		switch number {
+		case rpc.PendingBlockNumber:
+			block, statedb := api.eth.miner.Pending()
+			if block == nil || statedb == nil {
+				return nil, nil, errors.New("pending block not available")
+			}
+			return block, statedb, nil
+		case rpc.LatestBlockNumber:
+			block = api.eth.blockchain.CurrentBlock()
+		default:
+			block = api.eth.blockchain.GetBlockByNumber(uint64(number))
+		}
+	}
+	if block == nil {
+		return nil, nil, fmt.Errorf("block %v not found", blockNrOrHash)
+	}
+	statedb, err := api.computeStateDB(block, reexec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, statedb, nil
+}
+
 // traceTx configures a new tracer according to the provided configuration, and
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
@@ -728,15 +799,15 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
+		// Constuct the native or JavaScript tracer to execute with
+		if tracer, err = tracers.NewTracer(*config.Tracer, config.TracerConfig); err != nil {
 			return nil, err
 		}
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
+			tracer.(tracers.Interface).Stop(errors.New("execution timeout"))
 		}()
 		defer cancel()
 
@@ -763,7 +834,7 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *tracers.Tracer:
+	case tracers.Interface:
 		return tracer.GetResult()
 
 	default:
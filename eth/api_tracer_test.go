@@ -0,0 +1,150 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/consensus/ethash"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/internal/ethapi"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+// newTestTracerAPI creates a debug API backed by a chain of the given length,
+// generated with the given generator on top of the genesis allocation.
+func newTestTracerAPI(t *testing.T, alloc core.GenesisAlloc, blocks int, generator func(int, *core.BlockGen)) *PrivateDebugAPI {
+	var (
+		db      = ethdb.NewMemDatabase()
+		gspec   = &core.Genesis{Config: params.TestChainConfig, Alloc: alloc}
+		genesis = gspec.MustCommit(db)
+	)
+	chain, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, blocks, generator)
+
+	blockchain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	eth := &Ethereum{config: &Config{RPCGasCap: big.NewInt(1000000)}, blockchain: blockchain, chainDb: db, engine: ethash.NewFaker()}
+	eth.APIBackend = &EthAPIBackend{eth: eth}
+
+	return NewPrivateDebugAPI(gspec.Config, eth)
+}
+
+func TestTraceCall(t *testing.T) {
+	var (
+		key, _   = crypto.GenerateKey()
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		poor     = common.Address{0xaa}
+		contract = common.Address{0xc0}
+		signer   = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	)
+	alloc := core.GenesisAlloc{
+		sender: {Balance: big.NewInt(params.Ether)},
+		// Returns the storage slot 0
+		contract: {Balance: new(big.Int), Code: common.FromHex("60005460005260206000f3"), Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))}},
+	}
+	api := newTestTracerAPI(t, alloc, 2, func(i int, block *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(sender), common.Address{0x01}, big.NewInt(1000), params.TxGas, big.NewInt(1), nil), signer, key)
+		block.AddTx(tx)
+	})
+	var (
+		latest  = rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+		genesis = rpc.BlockNumberOrHashWithHash(api.eth.blockchain.GetBlockByNumber(0).Hash(), true)
+		balance = (*hexutil.Big)(big.NewInt(params.Ether))
+		slot    = map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(42))}
+		tracer  = `{step: function() {}, fault: function() {}, result: function(ctx) { return ctx.type + ":" + ctx.gasUsed; }}`
+	)
+	tests := []struct {
+		args    ethapi.CallArgs
+		block   rpc.BlockNumberOrHash
+		config  *TraceCallConfig
+		fail    bool
+		gas     uint64
+		retval  string
+		jsonout string
+	}{
+		// Plain value transfer on top of the latest block
+		{args: ethapi.CallArgs{From: sender, To: &common.Address{0x02}, Value: hexutil.Big(*big.NewInt(1))}, block: latest, gas: params.TxGas},
+		// Value transfer from an account without funds
+		{args: ethapi.CallArgs{From: poor, To: &common.Address{0x02}, Value: hexutil.Big(*big.NewInt(1))}, block: latest, fail: true},
+		// Value transfer from an account with overridden funds
+		{
+			args:   ethapi.CallArgs{From: poor, To: &common.Address{0x02}, Value: hexutil.Big(*big.NewInt(1))},
+			block:  latest,
+			config: &TraceCallConfig{StateOverrides: &ethapi.StateOverride{poor: {Balance: &balance}}},
+			gas:    params.TxGas,
+		},
+		// Contract call on the genesis state, referenced by its hash
+		{args: ethapi.CallArgs{From: sender, To: &contract}, block: genesis, retval: common.BigToHash(big.NewInt(1)).Hex()[2:]},
+		// Contract call with overridden storage
+		{
+			args:   ethapi.CallArgs{From: sender, To: &contract},
+			block:  latest,
+			config: &TraceCallConfig{StateOverrides: &ethapi.StateOverride{contract: {State: &slot}}},
+			retval: common.BigToHash(big.NewInt(42)).Hex()[2:],
+		},
+		// Contract call with overridden code and a JavaScript tracer
+		{
+			args:    ethapi.CallArgs{From: sender, To: &common.Address{0x03}},
+			block:   latest,
+			config:  &TraceCallConfig{TraceConfig: TraceConfig{Tracer: &tracer}, StateOverrides: &ethapi.StateOverride{common.Address{0x03}: {Code: &hexutil.Bytes{0x60, 0x00, 0x50, 0x00}}}},
+			jsonout: `"CALL:5"`,
+		},
+		// Unknown block
+		{args: ethapi.CallArgs{From: sender}, block: rpc.BlockNumberOrHashWithNumber(10), fail: true},
+	}
+	for i, test := range tests {
+		result, err := api.TraceCall(context.Background(), test.args, test.block, test.config)
+		if test.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %v", i, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to trace call: %v", i, err)
+			continue
+		}
+		if test.jsonout != "" {
+			if have, _ := json.Marshal(result); string(have) != test.jsonout {
+				t.Errorf("test %d: result mismatch: have %s, want %s", i, have, test.jsonout)
+			}
+			continue
+		}
+		res := result.(*ethapi.ExecutionResult)
+		if res.Failed {
+			t.Errorf("test %d: execution failed", i)
+		}
+		if test.gas != 0 && res.Gas != test.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, res.Gas, test.gas)
+		}
+		if res.ReturnValue != test.retval {
+			t.Errorf("test %d: return value mismatch: have %s, want %s", i, res.ReturnValue, test.retval)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/metrics"
+	"github.com/simplechain-org/simplechain/p2p"
+)
+
+var (
+	bandwidthInThrottleTimer  = metrics.NewRegisteredTimer("eth/bandwidth/in/throttle", nil)
+	bandwidthOutThrottleTimer = metrics.NewRegisteredTimer("eth/bandwidth/out/throttle", nil)
+)
+
+// bandwidthLimiter is a token bucket capping the aggregate throughput of the eth
+// protocol in one direction, shared by all the connected peers. Messages are not
+// split, so the bucket may go into debt for a large one, delaying the subsequent
+// messages until repaid.
+type bandwidthLimiter struct {
+	rate  float64      // Allowed throughput in bytes per second
+	burst float64      // Maximum number of bytes allowed to accumulate while idle
+	clock mclock.Clock // Clock to refill the bucket by
+
+	tokens    float64        // Number of bytes currently transferable (negative = in debt)
+	updated   mclock.AbsTime // Time instance of the last refill
+	throttled mclock.AbsTime // Time instance until which transfers are held back (0 = never)
+	lock      sync.Mutex
+}
+
+// newBandwidthLimiter creates a limiter allowing the given number of bytes to be
+// transferred per second, allowing bursts up to one second's worth. A zero rate
+// means unlimited bandwidth, for which nil is returned.
+func newBandwidthLimiter(rate uint64, clock mclock.Clock) *bandwidthLimiter {
+	if rate == 0 {
+		return nil
+	}
+	return &bandwidthLimiter{
+		rate:    float64(rate),
+		burst:   float64(rate),
+		clock:   clock,
+		tokens:  float64(rate),
+		updated: clock.Now(),
+	}
+}
+
+// reserve takes the given number of bytes out of the bucket, returning how long
+// the caller needs to wait before transferring them.
+func (l *bandwidthLimiter) reserve(size uint32) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	l.tokens = math.Min(l.burst, l.tokens+float64(now-l.updated)*l.rate/float64(time.Second))
+	l.updated = now
+
+	l.tokens -= float64(size)
+	if l.tokens >= 0 {
+		return 0
+	}
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	if until := now + mclock.AbsTime(delay); until > l.throttled {
+		l.throttled = until
+	}
+	return delay
+}
+
+// throttledWithin reports whether any transfer was held back by the limiter
+// within the given period before now. It is always false on a nil (unlimited)
+// limiter.
+func (l *bandwidthLimiter) throttledWithin(window time.Duration) bool {
+	if l == nil {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.throttled != 0 && l.throttled > l.clock.Now()-mclock.AbsTime(window)
+}
+
+// wait blocks until the given number of bytes may be transferred, or until the
+// quit channel is closed. It is a noop on a nil (unlimited) limiter.
+func (l *bandwidthLimiter) wait(size uint32, quit <-chan struct{}, timer metrics.Timer) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(size)
+	if delay <= 0 {
+		return nil
+	}
+	timer.Update(delay)
+
+	select {
+	case <-l.clock.After(delay):
+		return nil
+	case <-quit:
+		return p2p.DiscQuitting
+	}
+}
+
+// limitedMsgReadWriter is a wrapper around a p2p.MsgReadWriter, throttling the
+// messages read and written to the shared bandwidth limits of the protocol.
+type limitedMsgReadWriter struct {
+	p2p.MsgReadWriter                   // Wrapped message stream to throttle
+	upload, download  *bandwidthLimiter // Shared limits of the protocol (nil = unlimited)
+	quit              <-chan struct{}   // Channel to abort throttling on when shutting down
+}
+
+// newLimitedMsgReadWriter wraps a p2p MsgReadWriter with bandwidth limits. If
+// neither direction is limited, this function returns the original object.
+func newLimitedMsgReadWriter(rw p2p.MsgReadWriter, upload, download *bandwidthLimiter, quit <-chan struct{}) p2p.MsgReadWriter {
+	if upload == nil && download == nil {
+		return rw
+	}
+	return &limitedMsgReadWriter{MsgReadWriter: rw, upload: upload, download: download, quit: quit}
+}
+
+// ReadMsg reads the next message, holding it back until the download allowance
+// permits. Delaying the reads pushes back on the remote peer via the transport.
+func (rw *limitedMsgReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	if err := rw.download.wait(msg.Size, rw.quit, bandwidthInThrottleTimer); err != nil {
+		msg.Discard()
+		return p2p.Msg{}, err
+	}
+	return msg, nil
+}
+
+// WriteMsg sends a message once the upload allowance permits.
+func (rw *limitedMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	if err := rw.upload.wait(msg.Size, rw.quit, bandwidthOutThrottleTimer); err != nil {
+		return err
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
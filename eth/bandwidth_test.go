@@ -0,0 +1,100 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/p2p"
+)
+
+// Tests that the bandwidth limiter allows bursts up to its rate, delays anything
+// beyond, and refills over time.
+func TestBandwidthLimiter(t *testing.T) {
+	if limiter := newBandwidthLimiter(0, mclock.System{}); limiter != nil {
+		t.Fatalf("unlimited bandwidth limiter created")
+	}
+	clock := &mclock.Simulated{}
+	limiter := newBandwidthLimiter(1000, clock)
+
+	if delay := limiter.reserve(600); delay != 0 {
+		t.Fatalf("burst delay mismatch: have %v, want 0", delay)
+	}
+	if delay := limiter.reserve(900); delay != 500*time.Millisecond {
+		t.Fatalf("debt delay mismatch: have %v, want %v", delay, 500*time.Millisecond)
+	}
+	clock.Run(time.Second)
+	if delay := limiter.reserve(400); delay != 0 {
+		t.Fatalf("refilled delay mismatch: have %v, want 0", delay)
+	}
+	// Idle time beyond a second must not accumulate more than the burst allowance
+	clock.Run(time.Minute)
+	if delay := limiter.reserve(2000); delay != time.Second {
+		t.Fatalf("capped burst delay mismatch: have %v, want %v", delay, time.Second)
+	}
+}
+
+// Tests that the limiter remembers how recently it held back transfers.
+func TestBandwidthLimiterThrottled(t *testing.T) {
+	var unlimited *bandwidthLimiter
+	if unlimited.throttledWithin(time.Hour) {
+		t.Fatalf("unlimited limiter reported throttling")
+	}
+	clock := &mclock.Simulated{}
+	limiter := newBandwidthLimiter(1000, clock)
+
+	limiter.reserve(1000)
+	if limiter.throttledWithin(time.Second) {
+		t.Fatalf("throttling reported within the burst allowance")
+	}
+	// Go into debt for half a second, which counts until the debt is repaid
+	limiter.reserve(500)
+	clock.Run(time.Second)
+	if !limiter.throttledWithin(time.Second) {
+		t.Fatalf("recent throttling not reported")
+	}
+	if limiter.throttledWithin(400 * time.Millisecond) {
+		t.Fatalf("throttling reported beyond its window")
+	}
+}
+
+// Tests that throttled message streams abort waiting when shutting down.
+func TestLimitedMsgReadWriterQuit(t *testing.T) {
+	clock := &mclock.Simulated{}
+	limiter := newBandwidthLimiter(100, clock)
+
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	defer net.Close()
+
+	quit := make(chan struct{})
+	rw := newLimitedMsgReadWriter(app, limiter, nil, quit)
+
+	errc := make(chan error, 1)
+	go func() { errc <- p2p.Send(rw, TxMsg, make([]byte, 1000)) }()
+
+	clock.WaitForTimers(1)
+	close(quit)
+	if err := <-errc; err != p2p.DiscQuitting {
+		t.Fatalf("throttled send error mismatch: have %v, want %v", err, p2p.DiscQuitting)
+	}
+	if unlimited := newLimitedMsgReadWriter(app, nil, nil, quit); unlimited != app {
+		t.Fatalf("unlimited stream wrapped")
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/simplechain-org/simplechain/consensus/ethash"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/eth/downloader"
+	"github.com/simplechain-org/simplechain/eth/filters"
 	"github.com/simplechain-org/simplechain/eth/gasprice"
 	"github.com/simplechain-org/simplechain/params"
 )
@@ -56,9 +57,13 @@ var DefaultConfig = Config{
 
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
-		Blocks:     20,
-		Percentile: 60,
+		Blocks:         20,
+		Percentile:     60,
+		SlowPercentile: 30,
+		FastPercentile: 90,
 	},
+	Filters:            filters.DefaultConfig,
+	TraceFilterMaxSpan: 1000,
 }
 
 func init() {
@@ -90,10 +95,20 @@ type Config struct {
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
 
+	// Trusted checkpoint to anchor an empty chain at when fast or snap syncing
+	SyncCheckpoint *downloader.Checkpoint `toml:"-"`
+
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
 
+	// Bandwidth options
+	UploadLimit   uint64 `toml:",omitempty"` // Maximum eth protocol upload rate in bytes per second (0 = unlimited)
+	DownloadLimit uint64 `toml:",omitempty"` // Maximum eth protocol download rate in bytes per second (0 = unlimited)
+
+	// Block propagation options
+	LightPropagation bool `toml:",omitempty"` // Relay blocks to all peers after seal and body hash checks, before importing them
+
 	// Database options
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
@@ -121,13 +136,29 @@ type Config struct {
 	// Gas Price Oracle options
 	GPO gasprice.Config
 
+	// Log query limits
+	Filters filters.Config
+
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Enables recording a timing profile of every imported block
+	BlockProfiling bool
+
+	// Enables the optimistic parallel execution of block transactions
+	ParallelTxExecution bool
+
+	// Enables indexing the internal value transfers of imported blocks, which
+	// needs the state of every block (full sync and no pruning)
+	InternalTransferIndex bool
+
+	// Maximum number of blocks traced by a single trace_filter call (0 = unlimited)
+	TraceFilterMaxSpan uint64 `toml:",omitempty"`
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 
-	// Type of the EWASM interpreter ("" for default)
+	// Type of the EWASM interpreter ("" for default, "builtin" to enable it before the fork)
 	EWASMInterpreter string
 
 	// Type of the EVM interpreter ("" for default)
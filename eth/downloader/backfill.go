@@ -0,0 +1,173 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/log"
+)
+
+// backfillBlocks is the maximum number of historical blocks to backfill from a
+// single peer in one go, before yielding to synchronisation again.
+const backfillBlocks = 2048
+
+var errCancelHistoryFetch = errors.New("history download canceled (requested)")
+
+// Backfill retrieves a batch of the chain history missing below the trusted
+// checkpoint the local chain was anchored at, walking backwards from the lowest
+// known block. It is a noop once the history is complete, and never runs in
+// parallel with synchronisation.
+func (d *Downloader) Backfill(id string) error {
+	err := d.backfill(id)
+	switch err {
+	case nil, errBusy, errCancelHistoryFetch:
+
+	case errBadPeer, errInvalidChain, errInvalidBody, errInvalidReceipt:
+		log.Warn("History backfill failed, dropping peer", "peer", id, "err", err)
+		d.report(id, err)
+		if d.dropPeer == nil {
+			log.Warn("Downloader wants to drop peer, but peerdrop-function is not set", "peer", id)
+		} else {
+			d.dropPeer(id)
+		}
+	default:
+		log.Debug("History backfill failed", "peer", id, "err", err)
+	}
+	return err
+}
+
+// backfill retrieves the history below the tail of the local chain from the given
+// peer, up to a batch limit.
+func (d *Downloader) backfill(id string) error {
+	if d.blockchain == nil || d.blockchain.HistoryTail() == nil {
+		return nil
+	}
+	// Make sure no synchronisation is running meanwhile, sharing its channels
+	if !atomic.CompareAndSwapInt32(&d.synchronising, 0, 1) {
+		return errBusy
+	}
+	defer atomic.StoreInt32(&d.synchronising, 0)
+
+	for _, ch := range []chan dataPack{d.headerCh, d.bodyCh, d.receiptCh} {
+		for empty := false; !empty; {
+			select {
+			case <-ch:
+			default:
+				empty = true
+			}
+		}
+	}
+	d.cancelLock.Lock()
+	d.cancelCh = make(chan struct{})
+	d.cancelPeer = id
+	d.cancelLock.Unlock()
+
+	defer d.Cancel()
+
+	p := d.peers.Peer(id)
+	if p == nil {
+		return errUnknownPeer
+	}
+	if p.version < 63 {
+		return nil // Receipts are unavailable from eth/62 peers
+	}
+	for fetched := 0; fetched < backfillBlocks; {
+		tail := d.blockchain.HistoryTail()
+		if tail == nil {
+			return nil
+		}
+		n, err := d.backfillBatch(p, tail)
+		if err != nil {
+			return err
+		}
+		fetched += n
+	}
+	return nil
+}
+
+// backfillBatch retrieves the headers, bodies and receipts of the blocks right
+// below the given tail, verifies them against the hash chain leading down from
+// the tail and inserts them into the local chain.
+func (d *Downloader) backfillBatch(p *peerConnection, tail *types.Header) (int, error) {
+	count := MaxBlockFetch
+	if n := tail.Number.Uint64() - 1; n < uint64(count) {
+		count = int(n)
+	}
+	go p.peer.RequestHeadersByHash(tail.ParentHash, count, 0, true)
+	packet, err := d.fetchPack(p, d.headerCh, errCancelHistoryFetch)
+	if err != nil {
+		return 0, err
+	}
+	headers := packet.(*headerPack).headers
+	if len(headers) == 0 {
+		return 0, errEmptyHeaderSet
+	}
+	if len(headers) > count {
+		return 0, errBadPeer
+	}
+	// Headers are authenticated by the hash chain leading down from the tail
+	hashes, child := make([]common.Hash, len(headers)), tail
+	for i, header := range headers {
+		if header.Hash() != child.ParentHash || header.Number.Uint64()+1 != child.Number.Uint64() {
+			p.log.Debug("Invalid history header", "number", header.Number, "hash", header.Hash(), "want", child.ParentHash)
+			return 0, errInvalidChain
+		}
+		hashes[i], child = header.Hash(), header
+	}
+	// Retrieve the bodies and receipts, verifying them against the headers
+	go p.peer.RequestBodies(hashes)
+	if packet, err = d.fetchPack(p, d.bodyCh, errCancelHistoryFetch); err != nil {
+		return 0, err
+	}
+	bodies := packet.(*bodyPack)
+	if len(bodies.transactions) == 0 || len(bodies.transactions) > len(headers) || len(bodies.uncles) != len(bodies.transactions) {
+		return 0, errBadPeer
+	}
+	headers = headers[:len(bodies.transactions)]
+	for i, header := range headers {
+		if types.DeriveSha(types.Transactions(bodies.transactions[i])) != header.TxHash || types.CalcUncleHash(bodies.uncles[i]) != header.UncleHash {
+			return 0, errInvalidBody
+		}
+	}
+	go p.peer.RequestReceipts(hashes[:len(headers)])
+	if packet, err = d.fetchPack(p, d.receiptCh, errCancelHistoryFetch); err != nil {
+		return 0, err
+	}
+	receipts := packet.(*receiptPack).receipts
+	if len(receipts) == 0 || len(receipts) > len(headers) {
+		return 0, errBadPeer
+	}
+	headers = headers[:len(receipts)]
+	for i, header := range headers {
+		if types.DeriveSha(types.Receipts(receipts[i])) != header.ReceiptHash {
+			return 0, errInvalidReceipt
+		}
+	}
+	blocks, results := make(types.Blocks, len(headers)), make([]types.Receipts, len(headers))
+	for i, header := range headers {
+		blocks[i] = types.NewBlockWithHeader(header).WithBody(bodies.transactions[i], bodies.uncles[i])
+		results[i] = receipts[i]
+	}
+	if _, err := d.blockchain.InsertHistory(blocks, results); err != nil {
+		return 0, err
+	}
+	return len(blocks), nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/common/math"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/rlp"
+)
+
+// Checkpoint is a trusted block an empty chain can be anchored at, fast or snap
+// syncing from there on instead of from the genesis. The history below it is
+// backfilled lazily afterwards, authenticated by the hash chain leading down from
+// the checkpoint.
+//
+// The total difficulty of the checkpoint is trusted just like its hash, as it
+// can't be verified without the history below the checkpoint.
+type Checkpoint struct {
+	Number uint64      // Number of the trusted block
+	Hash   common.Hash // Hash of the trusted block
+	Td     *big.Int    // Total difficulty of the trusted block
+}
+
+// ParseCheckpoint parses a trusted checkpoint in its textual <number>:<hash>:<td>
+// representation.
+func ParseCheckpoint(text string) (*Checkpoint, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid checkpoint %q, want <number>:<hash>:<td>", text)
+	}
+	number, err := strconv.ParseUint(parts[0], 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint number %s: %v", parts[0], err)
+	}
+	var hash common.Hash
+	if err := hash.UnmarshalText([]byte(parts[1])); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint hash %s: %v", parts[1], err)
+	}
+	td, ok := math.ParseBig256(parts[2])
+	if !ok {
+		return nil, fmt.Errorf("invalid checkpoint total difficulty %s", parts[2])
+	}
+	return &Checkpoint{Number: number, Hash: hash, Td: td}, nil
+}
+
+// String implements fmt.Stringer, returning the textual representation accepted
+// by ParseCheckpoint.
+func (c *Checkpoint) String() string {
+	return fmt.Sprintf("%d:%s:%v", c.Number, c.Hash.Hex(), c.Td)
+}
+
+// SigHash returns the hash signed by the signers of a checkpoint file.
+func (c *Checkpoint) SigHash() common.Hash {
+	blob, _ := rlp.EncodeToBytes([]interface{}{c.Number, c.Hash, c.Td})
+	return crypto.Keccak256Hash(blob)
+}
+
+// Sign signs the checkpoint with the given key, producing a signature accepted in
+// checkpoint files.
+func (c *Checkpoint) Sign(key *ecdsa.PrivateKey) ([]byte, error) {
+	return crypto.Sign(c.SigHash().Bytes(), key)
+}
+
+// checkpointFile is the JSON format of a signed checkpoint file.
+type checkpointFile struct {
+	Number     hexutil.Uint64  `json:"number"`
+	Hash       common.Hash     `json:"hash"`
+	Td         *hexutil.Big    `json:"td"`
+	Signatures []hexutil.Bytes `json:"signatures"`
+}
+
+// EncodeCheckpointFile assembles the JSON content of a checkpoint file from a
+// checkpoint and the signatures of its signers.
+func EncodeCheckpointFile(cp *Checkpoint, signatures [][]byte) ([]byte, error) {
+	file := &checkpointFile{
+		Number: hexutil.Uint64(cp.Number),
+		Hash:   cp.Hash,
+		Td:     (*hexutil.Big)(cp.Td),
+	}
+	for _, sig := range signatures {
+		file.Signatures = append(file.Signatures, sig)
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// LoadCheckpointFile reads a signed checkpoint file, accepting the checkpoint
+// only if it was signed by the majority of the given trusted signers.
+func LoadCheckpointFile(path string, signers []common.Address) (*Checkpoint, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCheckpointFile(blob, signers)
+}
+
+// DecodeCheckpointFile parses the JSON content of a signed checkpoint file,
+// accepting the checkpoint only if it was signed by the majority of the given
+// trusted signers.
+func DecodeCheckpointFile(blob []byte, signers []common.Address) (*Checkpoint, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("no trusted checkpoint signers")
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(blob, &file); err != nil {
+		return nil, err
+	}
+	if file.Td == nil {
+		return nil, errors.New("checkpoint without total difficulty")
+	}
+	cp := &Checkpoint{
+		Number: uint64(file.Number),
+		Hash:   file.Hash,
+		Td:     (*big.Int)(file.Td),
+	}
+	trusted := make(map[common.Address]bool)
+	for _, signer := range signers {
+		trusted[signer] = false
+	}
+	sighash := cp.SigHash()
+
+	approvals := 0
+	for _, sig := range file.Signatures {
+		pubkey, err := crypto.SigToPub(sighash.Bytes(), sig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint signature: %v", err)
+		}
+		signer := crypto.PubkeyToAddress(*pubkey)
+		if signed, ok := trusted[signer]; ok && !signed {
+			trusted[signer] = true
+			approvals++
+		}
+	}
+	if approvals <= len(signers)/2 {
+		return nil, fmt.Errorf("checkpoint approved by %d of %d trusted signers", approvals, len(signers))
+	}
+	return cp, nil
+}
+
+// anchorCheckpoint retrieves the trusted checkpoint block along with its receipts
+// from the given peer and anchors the empty local chain at it.
+func (d *Downloader) anchorCheckpoint(p *peerConnection) error {
+	cp := d.anchor
+	p.log.Debug("Retrieving trusted checkpoint", "number", cp.Number, "hash", cp.Hash)
+
+	go p.peer.RequestHeadersByHash(cp.Hash, 1, 0, false)
+	packet, err := d.fetchPack(p, d.headerCh, errCancelBlockFetch)
+	if err != nil {
+		return err
+	}
+	headers := packet.(*headerPack).headers
+	if len(headers) != 1 || headers[0].Hash() != cp.Hash || headers[0].Number.Uint64() != cp.Number {
+		p.log.Debug("Invalid checkpoint header", "headers", len(headers))
+		return errBadPeer
+	}
+	header := headers[0]
+
+	go p.peer.RequestBodies([]common.Hash{cp.Hash})
+	if packet, err = d.fetchPack(p, d.bodyCh, errCancelBlockFetch); err != nil {
+		return err
+	}
+	bodies := packet.(*bodyPack)
+	if len(bodies.transactions) != 1 || len(bodies.uncles) != 1 ||
+		types.DeriveSha(types.Transactions(bodies.transactions[0])) != header.TxHash || types.CalcUncleHash(bodies.uncles[0]) != header.UncleHash {
+		p.log.Debug("Invalid checkpoint body")
+		return errInvalidBody
+	}
+	go p.peer.RequestReceipts([]common.Hash{cp.Hash})
+	if packet, err = d.fetchPack(p, d.receiptCh, errCancelBlockFetch); err != nil {
+		return err
+	}
+	receipts := packet.(*receiptPack).receipts
+	if len(receipts) != 1 || types.DeriveSha(types.Receipts(receipts[0])) != header.ReceiptHash {
+		p.log.Debug("Invalid checkpoint receipts")
+		return errInvalidReceipt
+	}
+	block := types.NewBlockWithHeader(header).WithBody(bodies.transactions[0], bodies.uncles[0])
+	return d.blockchain.InsertCheckpoint(block, receipts[0], cp.Td)
+}
+
+// fetchPack waits for the response to a single request sent to the given peer on
+// the wanted delivery channel, discarding anything else delivered meanwhile.
+func (d *Downloader) fetchPack(p *peerConnection, want chan dataPack, errCancel error) (dataPack, error) {
+	ttl := d.requestTTL()
+	timeout := time.After(ttl)
+	for {
+		var (
+			packet dataPack
+			ch     chan dataPack
+		)
+		select {
+		case <-d.cancelCh:
+			return nil, errCancel
+
+		case <-timeout:
+			p.log.Debug("Waiting for response timed out", "elapsed", ttl)
+			return nil, errTimeout
+
+		case packet = <-d.headerCh:
+			ch = d.headerCh
+		case packet = <-d.bodyCh:
+			ch = d.bodyCh
+		case packet = <-d.receiptCh:
+			ch = d.receiptCh
+		}
+		// Discard anything not requested from the origin peer
+		if ch != want || packet.PeerId() != p.id {
+			log.Debug("Received unrequested data", "peer", packet.PeerId())
+			continue
+		}
+		return packet, nil
+	}
+}
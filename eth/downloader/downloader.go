@@ -29,6 +29,7 @@ import (
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core/rawdb"
 	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/eth/reputation"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
 	"github.com/simplechain-org/simplechain/log"
@@ -100,10 +101,11 @@ type Downloader struct {
 	mode SyncMode       // Synchronisation mode defining the strategy used (per sync cycle)
 	mux  *event.TypeMux // Event multiplexer to announce sync operation events
 
-	checkpoint uint64   // Checkpoint block number to enforce head against (e.g. fast sync)
-	genesis    uint64   // Genesis block number to limit sync to (e.g. light client CHT)
-	queue      *queue   // Scheduler for selecting the hashes to download
-	peers      *peerSet // Set of active peers from which download can proceed
+	checkpoint uint64      // Checkpoint block number to enforce head against (e.g. fast sync)
+	anchor     *Checkpoint // Trusted checkpoint to anchor an empty chain at (fast and snap sync)
+	genesis    uint64      // Genesis block number to limit sync to (e.g. light client CHT)
+	queue      *queue      // Scheduler for selecting the hashes to download
+	peers      *peerSet    // Set of active peers from which download can proceed
 	stateDB    ethdb.Database
 
 	rttEstimate   uint64 // Round trip time to target for download requests
@@ -119,7 +121,10 @@ type Downloader struct {
 	blockchain BlockChain
 
 	// Callbacks
-	dropPeer peerDropFn // Drops a peer for misbehaving
+	dropPeer  peerDropFn // Drops a peer for misbehaving
+	throttled throttleFn // Checks whether our own bandwidth limit delayed deliveries (nil = unlimited)
+
+	scores *reputation.Tracker // Reputation of the remote peers to prioritise by
 
 	// Status
 	synchroniseMock func(id string, hash common.Hash) error // Replacement for synchronise during testing
@@ -139,6 +144,8 @@ type Downloader struct {
 	stateSyncStart chan *stateSync
 	trackStateReq  chan *stateReq
 	stateCh        chan dataPack // [eth/63] Channel receiving inbound node state data
+	snapCh         chan dataPack // [snap/1] Channel receiving inbound account, storage and code ranges
+	snapSync       *snapSync     // [snap/1] Range retrieval of the pivot state, retained across pivot moves
 
 	// Cancellation and termination
 	cancelPeer string         // Identifier of the peer currently being used as the master (cancel on drop)
@@ -204,10 +211,20 @@ type BlockChain interface {
 
 	// InsertReceiptChain inserts a batch of receipts into the local chain.
 	InsertReceiptChain(types.Blocks, []types.Receipts) (int, error)
+
+	// InsertCheckpoint anchors an empty local chain at a trusted checkpoint block.
+	InsertCheckpoint(*types.Block, types.Receipts, *big.Int) error
+
+	// HistoryTail retrieves the lowest block of a chain anchored at a checkpoint,
+	// or nil if the history is complete.
+	HistoryTail() *types.Header
+
+	// InsertHistory inserts a batch of blocks below the history tail, in reverse.
+	InsertHistory(types.Blocks, []types.Receipts) (int, error)
 }
 
 // New creates a new downloader to fetch hashes and blocks from remote peers.
-func New(mode SyncMode, checkpoint uint64, stateDb ethdb.Database, mux *event.TypeMux, chain BlockChain, lightchain LightChain, dropPeer peerDropFn) *Downloader {
+func New(mode SyncMode, checkpoint uint64, anchor *Checkpoint, stateDb ethdb.Database, mux *event.TypeMux, chain BlockChain, lightchain LightChain, dropPeer peerDropFn, throttled throttleFn, scores *reputation.Tracker) *Downloader {
 	if lightchain == nil {
 		lightchain = chain
 	}
@@ -216,13 +233,16 @@ func New(mode SyncMode, checkpoint uint64, stateDb ethdb.Database, mux *event.Ty
 		stateDB:        stateDb,
 		mux:            mux,
 		checkpoint:     checkpoint,
+		anchor:         anchor,
 		queue:          newQueue(),
-		peers:          newPeerSet(),
+		peers:          newPeerSet(scores),
 		rttEstimate:    uint64(rttMaxEstimate),
 		rttConfidence:  uint64(1000000),
 		blockchain:     chain,
 		lightchain:     lightchain,
 		dropPeer:       dropPeer,
+		throttled:      throttled,
+		scores:         scores,
 		headerCh:       make(chan dataPack, 1),
 		bodyCh:         make(chan dataPack, 1),
 		receiptCh:      make(chan dataPack, 1),
@@ -231,6 +251,7 @@ func New(mode SyncMode, checkpoint uint64, stateDb ethdb.Database, mux *event.Ty
 		headerProcCh:   make(chan []*types.Header, 1),
 		quitCh:         make(chan struct{}),
 		stateCh:        make(chan dataPack),
+		snapCh:         make(chan dataPack),
 		stateSyncStart: make(chan *stateSync),
 		syncStatsState: stateSyncStats{
 			processed: rawdb.ReadFastTrieProgress(stateDb),
@@ -258,7 +279,7 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 	switch d.mode {
 	case FullSync:
 		current = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
 	case LightSync:
 		current = d.lightchain.CurrentHeader().Number.Uint64()
@@ -296,6 +317,33 @@ func (d *Downloader) RegisterLightPeer(id string, version int, peer LightPeer) e
 	return d.RegisterPeer(id, version, &lightPeerWrapper{peer})
 }
 
+// RegisterSnapPeer attaches the snap protocol connection of an already registered
+// peer, allowing the state to be retrieved from it in ranges.
+func (d *Downloader) RegisterSnapPeer(id string, peer SnapPeer) error {
+	p := d.peers.Peer(id)
+	if p == nil {
+		return errNotRegistered
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.snap = peer
+	return nil
+}
+
+// UnregisterSnapPeer detaches the snap protocol connection of a peer.
+func (d *Downloader) UnregisterSnapPeer(id string) error {
+	p := d.peers.Peer(id)
+	if p == nil {
+		return errNotRegistered
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.snap = nil
+	return nil
+}
+
 // UnregisterPeer remove a peer from the known list, preventing any action from
 // the specified peer. An effort is also made to return any pending fetches into
 // the queue.
@@ -332,6 +380,7 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 		errEmptyHeaderSet, errPeersUnavailable, errTooOld,
 		errInvalidAncestor, errInvalidChain:
 		log.Warn("Synchronisation failed, dropping peer", "peer", id, "err", err)
+		d.report(id, err)
 		if d.dropPeer == nil {
 			// The dropPeer method is nil when `--copydb` is used for a local copy.
 			// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
@@ -345,6 +394,30 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 	return err
 }
 
+// report accounts a synchronisation failure caused by the given peer to its
+// reputation.
+func (d *Downloader) report(id string, err error) {
+	switch err {
+	case errTimeout, errStallingPeer:
+		d.reportTimeout(id, d.requestTTL())
+	case errEmptyHeaderSet, errUnsyncedPeer:
+		d.scores.Report(id, reputation.Empty)
+	case errBadPeer, errInvalidAncestor, errInvalidChain, errInvalidBody, errInvalidReceipt:
+		d.scores.Report(id, reputation.Invalid)
+	}
+}
+
+// reportTimeout accounts a request timeout to the given peer's reputation, unless
+// our own download throttling held back deliveries while the request was in
+// flight, in which case the peer is not necessarily at fault.
+func (d *Downloader) reportTimeout(id string, ttl time.Duration) {
+	if d.throttled != nil && d.throttled(ttl) {
+		log.Debug("Not charging throttled timeout", "peer", id, "ttl", ttl)
+		return
+	}
+	d.scores.Report(id, reputation.Timeout)
+}
+
 // synchronise will select the peer and use it for synchronising. If an empty string is given
 // it will use the best peer possible and synchronize if its TD is higher than our own. If any of the
 // checks fail an error will be returned. This method is synchronous
@@ -436,10 +509,25 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	}
 	height := latest.Number.Uint64()
 
+	// Anchor an empty chain at the trusted checkpoint if one was configured
+	if d.anchor != nil && (d.mode == FastSync || d.mode == SnapSync) && d.lightchain.CurrentHeader().Number.Uint64() == 0 {
+		if height < d.anchor.Number {
+			p.log.Warn("Remote head below trusted checkpoint", "number", height, "checkpoint", d.anchor.Number)
+			return errUnsyncedPeer
+		}
+		if err := d.anchorCheckpoint(p); err != nil {
+			return err
+		}
+	}
 	origin, err := d.findAncestor(p, latest)
 	if err != nil {
 		return err
 	}
+	// Chains anchored at a checkpoint miss the history below their tail
+	var tail *types.Header
+	if d.mode != LightSync {
+		tail = d.blockchain.HistoryTail()
+	}
 	d.syncStatsLock.Lock()
 	if d.syncStatsChainHeight <= origin || d.syncStatsChainOrigin > origin {
 		d.syncStatsChainOrigin = origin
@@ -449,7 +537,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 
 	// Ensure our origin point is below any fast sync pivot point
 	pivot := uint64(0)
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		if height <= uint64(fsMinFullBlocks) {
 			origin = 0
 		} else {
@@ -458,9 +546,16 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 				origin = pivot - 1
 			}
 		}
+		// Without history below the tail, the pivot state needs to be above it
+		if tail != nil && origin < tail.Number.Uint64() {
+			if height <= tail.Number.Uint64() {
+				return nil
+			}
+			origin, pivot = tail.Number.Uint64(), tail.Number.Uint64()+1
+		}
 	}
 	d.committed = 1
-	if d.mode == FastSync && pivot != 0 {
+	if (d.mode == FastSync || d.mode == SnapSync) && pivot != 0 {
 		d.committed = 0
 	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
@@ -475,7 +570,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		func() error { return d.fetchReceipts(origin + 1) },        // Receipts are retrieved during fast sync
 		func() error { return d.processHeaders(origin+1, pivot, td) },
 	}
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		fetchers = append(fetchers, func() error { return d.processFastSyncContent(latest) })
 	} else if d.mode == FullSync {
 		fetchers = append(fetchers, d.processFullSyncContent)
@@ -579,7 +674,7 @@ func (d *Downloader) fetchHeight(p *peerConnection) (*types.Header, error) {
 				return nil, errBadPeer
 			}
 			head := headers[0]
-			if d.mode == FastSync && head.Number.Uint64() < d.checkpoint {
+			if (d.mode == FastSync || d.mode == SnapSync) && head.Number.Uint64() < d.checkpoint {
 				p.log.Warn("Remote head below checkpoint", "number", head.Number, "hash", head.Hash())
 				return nil, errUnsyncedPeer
 			}
@@ -664,7 +759,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	switch d.mode {
 	case FullSync:
 		localHeight = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		localHeight = d.blockchain.CurrentFastBlock().NumberU64()
 	default:
 		localHeight = d.lightchain.CurrentHeader().Number.Uint64()
@@ -694,6 +789,13 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 			}
 		}
 	}
+	// If the chain was anchored at a checkpoint, nothing below its history tail
+	// is known locally, nor can the chain fork off below it.
+	if d.mode != LightSync {
+		if tail := d.blockchain.HistoryTail(); tail != nil && floor < tail.Number.Int64()-1 {
+			floor = tail.Number.Int64() - 1
+		}
+	}
 	from, count, skip, max := calculateRequestSpan(remoteHeight, localHeight)
 
 	p.log.Trace("Span searching for common ancestor", "count", count, "from", from, "skip", skip)
@@ -745,7 +847,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -818,7 +920,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -1001,6 +1103,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, pivot uint64)
 			// Header retrieval timed out, consider the peer bad and drop
 			p.log.Debug("Header request timed out", "elapsed", ttl)
 			headerTimeoutMeter.Mark(1)
+			d.reportTimeout(p.id, ttl)
 			d.dropPeer(p.id)
 
 			// Finish the sync gracefully instead of dumping the gathered data though
@@ -1162,6 +1265,15 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 				if err != errStaleDelivery {
 					setIdle(peer, accepted)
 				}
+				// Account the delivery to the reputation of the peer
+				switch {
+				case err == errInvalidChain || err == errInvalidBody || err == errInvalidReceipt:
+					d.scores.Report(peer.id, reputation.Invalid)
+				case err == nil && accepted > 0:
+					d.scores.Report(peer.id, reputation.Useful)
+				case err == nil:
+					d.scores.Report(peer.id, reputation.Empty)
+				}
 				// Issue a log to the user to see what's going on
 				switch {
 				case err == nil && packet.Items() == 0:
@@ -1204,6 +1316,8 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 			// Check for fetch request timeouts and demote the responsible peers
 			for pid, fails := range expire() {
 				if peer := d.peers.Peer(pid); peer != nil {
+					d.reportTimeout(pid, d.requestTTL())
+
 					// If a lot of retrieval elements expired, we might have overestimated the remote peer or perhaps
 					// ourselves. Only reset to minimal throughput but don't drop just yet. If even the minimal times
 					// out that sync wise we need to get rid of the peer.
@@ -1363,7 +1477,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				// This check cannot be executed "as is" for full imports, since blocks may still be
 				// queued for processing when the header download completes. However, as long as the
 				// peer gave us something useful, we're already happy/progressed (above check).
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					head := d.lightchain.CurrentHeader()
 					if td.Cmp(d.lightchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
 						return errStallingPeer
@@ -1391,7 +1505,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				chunk := headers[:limit]
 
 				// In case of header only syncing, validate the chunk immediately
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					// Collect the yet unknown headers to mark them as uncertain
 					unknown := make([]*types.Header, 0, len(headers))
 					for _, header := range chunk {
@@ -1419,7 +1533,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 					}
 				}
 				// Unless we're doing light chains, schedule the headers for associated content retrieval
-				if d.mode == FullSync || d.mode == FastSync {
+				if d.mode == FullSync || d.mode == FastSync || d.mode == SnapSync {
 					// If we've reached the allowed number of pending headers, stall a bit
 					for d.queue.PendingBlocks() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
 						select {
@@ -1685,6 +1799,21 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverAccountRange injects a new range of accounts received from a remote node.
+func (d *Downloader) DeliverAccountRange(id string, reqID uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &accountRangePack{id, reqID, hashes, accounts, proof}, snapInMeter, snapDropMeter)
+}
+
+// DeliverStorageRanges injects a new batch of storage ranges received from a remote node.
+func (d *Downloader) DeliverStorageRanges(id string, reqID uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &storageRangesPack{id, reqID, hashes, slots, proof}, snapInMeter, snapDropMeter)
+}
+
+// DeliverByteCodes injects a new batch of contract codes received from a remote node.
+func (d *Downloader) DeliverByteCodes(id string, reqID uint64, codes [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &byteCodesPack{id, reqID, codes}, snapInMeter, snapDropMeter)
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
@@ -17,9 +17,11 @@
 package downloader
 
 import (
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,9 +30,14 @@ import (
 
 	ethereum "github.com/simplechain-org/simplechain"
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/core/state"
 	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/eth/reputation"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
+	"github.com/simplechain-org/simplechain/rlp"
 	"github.com/simplechain-org/simplechain/trie"
 )
 
@@ -55,6 +62,7 @@ type downloadTester struct {
 	ownBlocks   map[common.Hash]*types.Block   // Blocks belonging to the tester
 	ownReceipts map[common.Hash]types.Receipts // Receipts belonging to the tester
 	ownChainTd  map[common.Hash]*big.Int       // Total difficulties of the blocks in the local chain
+	historyTail *types.Header                  // Lowest block of a chain anchored at a checkpoint
 
 	lock sync.RWMutex
 }
@@ -74,7 +82,7 @@ func newTester() *downloadTester {
 	tester.stateDb = ethdb.NewMemDatabase()
 	tester.stateDb.Put(testGenesis.Root().Bytes(), []byte{0x00})
 
-	tester.downloader = New(FullSync, 0, tester.stateDb, new(event.TypeMux), tester, nil, tester.dropPeer)
+	tester.downloader = New(FullSync, 0, nil, tester.stateDb, new(event.TypeMux), tester, nil, tester.dropPeer, nil, nil)
 	return tester
 }
 
@@ -270,6 +278,56 @@ func (dl *downloadTester) InsertReceiptChain(blocks types.Blocks, receipts []typ
 	return len(blocks), nil
 }
 
+// InsertCheckpoint anchors the empty simulated chain at a trusted checkpoint.
+func (dl *downloadTester) InsertCheckpoint(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if len(dl.ownHashes) != 1 {
+		return errors.New("chain not empty")
+	}
+	dl.ownHashes = append(dl.ownHashes, block.Hash())
+	dl.ownHeaders[block.Hash()] = block.Header()
+	dl.ownBlocks[block.Hash()] = block
+	dl.ownReceipts[block.Hash()] = receipts
+	dl.ownChainTd[block.Hash()] = new(big.Int).Set(td)
+	dl.historyTail = block.Header()
+	return nil
+}
+
+// HistoryTail retrieves the lowest block of the simulated chain if it was
+// anchored at a checkpoint and its history is still incomplete.
+func (dl *downloadTester) HistoryTail() *types.Header {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.historyTail
+}
+
+// InsertHistory injects a batch of blocks below the history tail of the simulated
+// chain, in reverse order.
+func (dl *downloadTester) InsertHistory(blocks types.Blocks, receipts []types.Receipts) (i int, err error) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	for i, block := range blocks {
+		if dl.historyTail == nil || block.Hash() != dl.historyTail.ParentHash {
+			return i, errors.New("non contiguous history")
+		}
+		// Keep the hash chain in ascending order, right after the genesis
+		dl.ownHashes = append(dl.ownHashes[:1], append([]common.Hash{block.Hash()}, dl.ownHashes[1:]...)...)
+		dl.ownHeaders[block.Hash()] = block.Header()
+		dl.ownBlocks[block.Hash()] = block
+		dl.ownReceipts[block.Hash()] = receipts[i]
+
+		dl.historyTail = block.Header()
+		if block.ParentHash() == dl.genesis.Hash() {
+			dl.historyTail = nil
+		}
+	}
+	return len(blocks), nil
+}
+
 // Rollback removes some recently added elements from the chain.
 func (dl *downloadTester) Rollback(hashes []common.Hash) {
 	dl.lock.Lock()
@@ -286,14 +344,18 @@ func (dl *downloadTester) Rollback(hashes []common.Hash) {
 	}
 }
 
-// newPeer registers a new block download source into the downloader.
+// newPeer registers a new block download source into the downloader, serving
+// state ranges too.
 func (dl *downloadTester) newPeer(id string, version int, chain *testChain) error {
 	dl.lock.Lock()
 	defer dl.lock.Unlock()
 
 	peer := &downloadTesterPeer{dl: dl, id: id, chain: chain}
 	dl.peers[id] = peer
-	return dl.downloader.RegisterPeer(id, version, peer)
+	if err := dl.downloader.RegisterPeer(id, version, peer); err != nil {
+		return err
+	}
+	return dl.downloader.RegisterSnapPeer(id, peer)
 }
 
 // dropPeer simulates a hard peer removal from the connection pool.
@@ -311,6 +373,7 @@ type downloadTesterPeer struct {
 	lock          sync.RWMutex
 	chain         *testChain
 	missingStates map[common.Hash]bool // State entries that fast sync should not return
+	ranges        uint32               // Number of state range requests served (snap sync)
 }
 
 // Head constructs a function to retrieve a peer's current head hash
@@ -324,11 +387,7 @@ func (dlp *downloadTesterPeer) Head() (common.Hash, *big.Int) {
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
 func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
-	if reverse {
-		panic("reverse header requests not supported")
-	}
-
-	result := dlp.chain.headersByHash(origin, amount, skip)
+	result := dlp.chain.headersByHash(origin, amount, skip, reverse)
 	go dlp.dl.downloader.DeliverHeaders(dlp.id, result)
 	return nil
 }
@@ -337,11 +396,7 @@ func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount i
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
 func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
-	if reverse {
-		panic("reverse header requests not supported")
-	}
-
-	result := dlp.chain.headersByNumber(origin, amount, skip)
+	result := dlp.chain.headersByNumber(origin, amount, skip, reverse)
 	go dlp.dl.downloader.DeliverHeaders(dlp.id, result)
 	return nil
 }
@@ -383,6 +438,111 @@ func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// RequestAccountRange constructs a getAccountRange method associated with a
+// particular peer in the download tester, serving the accounts of the requested
+// root from the peer database along with the proofs of the range edges.
+func (dlp *downloadTesterPeer) RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	atomic.AddUint32(&dlp.ranges, 1)
+
+	tr, err := trie.New(root, trie.NewDatabase(dlp.dl.peerDb))
+	if err != nil {
+		go dlp.dl.downloader.DeliverAccountRange(dlp.id, id, nil, nil, nil)
+		return nil
+	}
+	var (
+		hashes   []common.Hash
+		accounts [][]byte
+		size     uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		hashes, accounts = append(hashes, hash), append(accounts, common.CopyBytes(it.Value))
+
+		if size += uint64(len(it.Key) + len(it.Value)); hash.Big().Cmp(limit.Big()) >= 0 || size >= bytes {
+			break
+		}
+	}
+	proof := ethdb.NewMemDatabase()
+	tr.Prove(origin[:], 0, proof)
+	if len(hashes) > 0 {
+		tr.Prove(hashes[len(hashes)-1][:], 0, proof)
+	}
+	var nodes [][]byte
+	for _, key := range proof.Keys() {
+		node, _ := proof.Get(key)
+		nodes = append(nodes, node)
+	}
+	go dlp.dl.downloader.DeliverAccountRange(dlp.id, id, hashes, accounts, nodes)
+	return nil
+}
+
+// RequestStorageRanges constructs a getStorageRanges method associated with a
+// particular peer in the download tester, serving complete storage tries only.
+func (dlp *downloadTesterPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	atomic.AddUint32(&dlp.ranges, 1)
+
+	triedb := trie.NewDatabase(dlp.dl.peerDb)
+	tr, err := trie.New(root, triedb)
+	if err != nil {
+		go dlp.dl.downloader.DeliverStorageRanges(dlp.id, id, nil, nil, nil)
+		return nil
+	}
+	var (
+		hashes [][]common.Hash
+		slots  [][][]byte
+	)
+	for _, account := range accounts {
+		blob, err := tr.TryGet(account[:])
+		if err != nil || blob == nil {
+			break
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			break
+		}
+		st, err := trie.New(acc.Root, triedb)
+		if err != nil {
+			break
+		}
+		var (
+			keys   []common.Hash
+			values [][]byte
+		)
+		it := trie.NewIterator(st.NodeIterator(nil))
+		for it.Next() {
+			keys, values = append(keys, common.BytesToHash(it.Key)), append(values, common.CopyBytes(it.Value))
+		}
+		hashes, slots = append(hashes, keys), append(slots, values)
+	}
+	go dlp.dl.downloader.DeliverStorageRanges(dlp.id, id, hashes, slots, nil)
+	return nil
+}
+
+// RequestByteCodes constructs a getByteCodes method associated with a particular
+// peer in the download tester, serving the contract codes from the peer database.
+func (dlp *downloadTesterPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	atomic.AddUint32(&dlp.ranges, 1)
+
+	var codes [][]byte
+	for _, hash := range hashes {
+		if code, err := dlp.dl.peerDb.Get(hash[:]); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	go dlp.dl.downloader.DeliverByteCodes(dlp.id, id, codes)
+	return nil
+}
+
 // assertOwnChain checks if the local chain contains the correct number of items
 // of the various chain components.
 func assertOwnChain(t *testing.T, tester *downloadTester, length int) {
@@ -430,6 +590,7 @@ func TestCanonicalSynchronisation63Fast(t *testing.T)  { testCanonicalSynchronis
 func TestCanonicalSynchronisation64Full(t *testing.T)  { testCanonicalSynchronisation(t, 64, FullSync) }
 func TestCanonicalSynchronisation64Fast(t *testing.T)  { testCanonicalSynchronisation(t, 64, FastSync) }
 func TestCanonicalSynchronisation64Light(t *testing.T) { testCanonicalSynchronisation(t, 64, LightSync) }
+func TestCanonicalSynchronisation65Snap(t *testing.T)  { testCanonicalSynchronisation(t, 65, SnapSync) }
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -448,6 +609,249 @@ func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	assertOwnChain(t, tester, chain.len())
 }
 
+// Tests that snap sync retrieves the state of the pivot block in ranges, healing
+// the trie edges afterwards, and falls back to trie node sync if no peer serves
+// ranges.
+func TestSnapSyncStateRanges(t *testing.T) { testSnapSyncStateRanges(t, true) }
+func TestSnapSyncFallback(t *testing.T)    { testSnapSyncStateRanges(t, false) }
+
+func testSnapSyncStateRanges(t *testing.T, snap bool) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", 65, chain)
+	if !snap {
+		tester.downloader.UnregisterSnapPeer("peer")
+	}
+	peer := tester.peers["peer"]
+
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, chain.len())
+
+	// Ensure the state of the pivot block is fully available
+	pivot := chain.blockm[chain.chain[chain.len()-1-fsMinFullBlocks]].Root()
+	tr, err := trie.New(pivot, trie.NewDatabase(tester.stateDb))
+	if err != nil {
+		t.Fatalf("failed to open pivot state: %v", err)
+	}
+	accounts, it := 0, trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		accounts++
+	}
+	if it.Err != nil || accounts == 0 {
+		t.Fatalf("pivot state incomplete: %d accounts, error %v", accounts, it.Err)
+	}
+	ranges := atomic.LoadUint32(&peer.ranges)
+	if snap && ranges == 0 {
+		t.Errorf("no state ranges retrieved")
+	}
+	if !snap && ranges != 0 {
+		t.Errorf("state ranges retrieved from peer without snap protocol: %d", ranges)
+	}
+}
+
+// Tests that an empty chain can be anchored at a trusted checkpoint, syncing only
+// on top of it, and that the history below it is backfilled afterwards.
+func TestCheckpointSync63Fast(t *testing.T) { testCheckpointSync(t, 63, FastSync) }
+func TestCheckpointSync64Fast(t *testing.T) { testCheckpointSync(t, 64, FastSync) }
+func TestCheckpointSync65Snap(t *testing.T) { testCheckpointSync(t, 65, SnapSync) }
+
+func testCheckpointSync(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", protocol, chain)
+
+	anchor := 300
+	hash := chain.chain[anchor]
+	tester.downloader.anchor = &Checkpoint{Number: uint64(anchor), Hash: hash, Td: chain.td(hash)}
+
+	// Synchronise with the peer and make sure nothing below the checkpoint was retrieved
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if tail := tester.HistoryTail(); tail == nil || tail.Hash() != hash {
+		t.Fatalf("history tail mismatch: have %v, want #%d", tail, anchor)
+	}
+	assertOwnChain(t, tester, chain.len()-anchor+1)
+
+	// Backfill the history and make sure the chain is complete down to the genesis
+	for i := 0; tester.HistoryTail() != nil; i++ {
+		if i == 10 {
+			t.Fatalf("history not backfilled, tail at #%d", tester.HistoryTail().Number)
+		}
+		if err := tester.downloader.Backfill("peer"); err != nil {
+			t.Fatalf("failed to backfill history: %v", err)
+		}
+	}
+	assertOwnChain(t, tester, chain.len())
+	for i, hash := range tester.ownHashes {
+		if hash != chain.chain[i] {
+			t.Fatalf("block #%d mismatch: have %x, want %x", i, hash, chain.chain[i])
+		}
+	}
+}
+
+// Tests that a checkpoint unknown to the remote peer is rejected, leaving the
+// local chain empty.
+func TestCheckpointSyncUnknown(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", 64, chain)
+
+	tester.downloader.anchor = &Checkpoint{Number: 300, Hash: common.Hash{0x01}, Td: big.NewInt(1)}
+	if err := tester.sync("peer", nil, FastSync); err != errBadPeer {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errBadPeer)
+	}
+	if tail := tester.HistoryTail(); tail != nil {
+		t.Fatalf("chain anchored at unknown checkpoint #%d", tail.Number)
+	}
+	assertOwnChain(t, tester, 1)
+}
+
+// Tests that checkpoints round trip through their textual representation and
+// that checkpoint files are only accepted if signed by a majority of the trusted
+// signers.
+func TestCheckpointParsing(t *testing.T) {
+	cp := &Checkpoint{Number: 300, Hash: testChainBase.chain[300], Td: testChainBase.td(testChainBase.chain[300])}
+	have, err := ParseCheckpoint(cp.String())
+	if err != nil {
+		t.Fatalf("failed to parse checkpoint %s: %v", cp, err)
+	}
+	if !reflect.DeepEqual(have, cp) {
+		t.Fatalf("checkpoint mismatch: have %v, want %v", have, cp)
+	}
+	for _, text := range []string{"", "300", "300:0x01", "x:0x01:1", "300:0xzz:1", "300:0x01:x", "300:0x01:1:2"} {
+		if _, err := ParseCheckpoint(text); err == nil {
+			t.Errorf("invalid checkpoint %q accepted", text)
+		}
+	}
+	// Sign the checkpoint with a subset of the trusted keys
+	var (
+		keys    = make([]*ecdsa.PrivateKey, 3)
+		signers = make([]common.Address, 3)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		signers[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	sign := func(keys ...*ecdsa.PrivateKey) []byte {
+		var sigs [][]byte
+		for _, key := range keys {
+			sig, err := cp.Sign(key)
+			if err != nil {
+				t.Fatalf("failed to sign checkpoint: %v", err)
+			}
+			sigs = append(sigs, sig)
+		}
+		blob, err := EncodeCheckpointFile(cp, sigs)
+		if err != nil {
+			t.Fatalf("failed to encode checkpoint file: %v", err)
+		}
+		return blob
+	}
+	if _, err := DecodeCheckpointFile(sign(keys[0]), signers); err == nil {
+		t.Errorf("checkpoint signed by minority accepted")
+	}
+	if _, err := DecodeCheckpointFile(sign(keys[0], keys[0]), signers); err == nil {
+		t.Errorf("checkpoint with duplicate signatures accepted")
+	}
+	if _, err := DecodeCheckpointFile(sign(keys[0], keys[1]), nil); err == nil {
+		t.Errorf("checkpoint without trusted signers accepted")
+	}
+	blob, err := EncodeCheckpointFile(&Checkpoint{Number: cp.Number, Hash: cp.Hash}, nil)
+	if err != nil {
+		t.Fatalf("failed to encode checkpoint file: %v", err)
+	}
+	if _, err := DecodeCheckpointFile(blob, signers); err == nil {
+		t.Errorf("checkpoint without total difficulty accepted")
+	}
+	have, err = DecodeCheckpointFile(sign(keys[0], keys[2]), signers)
+	if err != nil {
+		t.Fatalf("failed to decode checkpoint file: %v", err)
+	}
+	if !reflect.DeepEqual(have, cp) {
+		t.Fatalf("checkpoint mismatch: have %v, want %v", have, cp)
+	}
+}
+
+// Tests that useful deliveries raise the reputation of a peer, and that idle peers
+// are prioritised by their throughput weighted by reputation.
+func TestPeerReputation(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	scores := reputation.NewTracker(nil, mclock.System{})
+	tester.downloader.scores, tester.downloader.peers.scores = scores, scores
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("good", 63, chain)
+	tester.newPeer("bad", 63, chain)
+
+	if err := tester.sync("good", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, chain.len())
+	if score := scores.Score("good"); score <= 0 {
+		t.Fatalf("serving peer not credited: score %v", score)
+	}
+	// Equalise the throughputs and ensure the reputation decides
+	for _, p := range tester.downloader.peers.AllPeers() {
+		p.lock.Lock()
+		p.blockThroughput = 100
+		p.lock.Unlock()
+	}
+	scores.Report("bad", reputation.Invalid)
+
+	idle, _ := tester.downloader.peers.BodyIdlePeers()
+	if len(idle) != 2 || idle[0].id != "good" || idle[1].id != "bad" {
+		ids := make([]string, len(idle))
+		for i, p := range idle {
+			ids[i] = p.id
+		}
+		t.Fatalf("idle peer order mismatch: have %v, want [good bad]", ids)
+	}
+}
+
+// Tests that request timeouts are not charged to a peer's reputation while our
+// own inbound bandwidth limit is holding back deliveries.
+func TestThrottledTimeoutReputation(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	scores := reputation.NewTracker(nil, mclock.System{})
+	tester.downloader.scores, tester.downloader.peers.scores = scores, scores
+
+	throttled := true
+	tester.downloader.throttled = func(window time.Duration) bool { return throttled }
+
+	tester.downloader.report("peer", errTimeout)
+	if score := scores.Score("peer"); score != 0 {
+		t.Fatalf("throttled timeout charged: score %v", score)
+	}
+	throttled = false
+	tester.downloader.report("peer", errTimeout)
+	if score := scores.Score("peer"); score >= 0 {
+		t.Fatalf("unthrottled timeout not charged: score %v", score)
+	}
+}
+
 // Tests that if a large batch of blocks are being downloaded, it is throttled
 // until the cached blocks are retrieved.
 func TestThrottling62(t *testing.T)     { testThrottling(t, 62, FullSync) }
@@ -30,6 +30,7 @@ import (
 	"time"
 
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/eth/reputation"
 	"github.com/simplechain-org/simplechain/event"
 	"github.com/simplechain-org/simplechain/log"
 )
@@ -69,6 +70,7 @@ type peerConnection struct {
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
 	peer Peer
+	snap SnapPeer // Snap protocol connection of the peer (nil = ranges unsupported)
 
 	version int        // Eth protocol version number to switch strategies
 	log     log.Logger // Contextual logger to add extra infos to peer logs
@@ -90,6 +92,14 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// SnapPeer encapsulates the methods required to retrieve the state from a remote
+// peer in contiguous ranges (snap protocol).
+type SnapPeer interface {
+	RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+}
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -339,19 +349,30 @@ func (p *peerConnection) Lacks(hash common.Hash) bool {
 	return ok
 }
 
+// SnapPeer retrieves the snap protocol connection of the peer, or nil if it does
+// not serve state ranges.
+func (p *peerConnection) SnapPeer() SnapPeer {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.snap
+}
+
 // peerSet represents the collection of active peer participating in the chain
 // download procedure.
 type peerSet struct {
 	peers        map[string]*peerConnection
+	scores       *reputation.Tracker // Reputation of the peers to prioritise by
 	newPeerFeed  event.Feed
 	peerDropFeed event.Feed
 	lock         sync.RWMutex
 }
 
 // newPeerSet creates a new peer set top track the active download sources.
-func newPeerSet() *peerSet {
+func newPeerSet(scores *reputation.Tracker) *peerSet {
 	return &peerSet{
-		peers: make(map[string]*peerConnection),
+		peers:  make(map[string]*peerConnection),
+		scores: scores,
 	}
 }
 
@@ -470,7 +491,7 @@ func (ps *peerSet) HeaderIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.headerThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 65, idle, throughput)
 }
 
 // BodyIdlePeers retrieves a flat list of all the currently body-idle peers within
@@ -484,7 +505,7 @@ func (ps *peerSet) BodyIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.blockThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 65, idle, throughput)
 }
 
 // ReceiptIdlePeers retrieves a flat list of all the currently receipt-idle peers
@@ -498,7 +519,7 @@ func (ps *peerSet) ReceiptIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.receiptThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 65, idle, throughput)
 }
 
 // NodeDataIdlePeers retrieves a flat list of all the currently node-data-idle
@@ -512,12 +533,13 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.stateThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 65, idle, throughput)
 }
 
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
 // protocol version constraints, using the provided function to check idleness.
-// The resulting set of peers are sorted by their measure throughput.
+// The resulting set of peers are sorted by their measure throughput, weighted by
+// their reputation.
 func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peerConnection) bool, throughput func(*peerConnection) float64) ([]*peerConnection, int) {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
@@ -531,9 +553,13 @@ func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peerC
 			total++
 		}
 	}
+	weighted := make(map[*peerConnection]float64, len(idle))
+	for _, p := range idle {
+		weighted[p] = throughput(p) * ps.scores.Weight(p.id)
+	}
 	for i := 0; i < len(idle); i++ {
 		for j := i + 1; j < len(idle); j++ {
-			if throughput(idle[i]) < throughput(idle[j]) {
+			if weighted[idle[i]] < weighted[idle[j]] {
 				idle[i], idle[j] = idle[j], idle[i]
 			}
 		}
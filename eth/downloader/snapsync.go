@@ -0,0 +1,715 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/eth/reputation"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/rlp"
+	"github.com/simplechain-org/simplechain/trie"
+)
+
+const (
+	snapAccountConcurrency = 16         // Number of chunks the account hash space is split into
+	snapRequestBytes       = 512 * 1024 // Soft size limit of the responses requested from peers
+	snapStorageAccounts    = 128        // Maximum number of accounts to request storage ranges for at once
+	snapCodeBatch          = 128        // Maximum number of contract codes to request at once
+)
+
+var (
+	// snapMaxHash is the last hash of the key space, bounding the last chunk.
+	snapMaxHash = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+)
+
+// snapAccountTask is a chunk of the account hash space being retrieved.
+type snapAccountTask struct {
+	next   common.Hash         // Next account hash to retrieve
+	last   common.Hash         // Last account hash of the chunk
+	ranges []*snapAccountRange // Retrieved ranges waiting for their storage and codes
+	req    *snapRequest        // Pending request filling the chunk, if any
+	done   bool                // Whether the whole chunk has been retrieved
+}
+
+// snapAccountRange is a verified range of accounts whose trie nodes are written
+// once all the storage tries and contract codes it references are complete.
+type snapAccountRange struct {
+	task    *snapAccountTask // Chunk the range belongs to
+	origin  common.Hash      // First hash covered by the range
+	last    common.Hash      // Last hash covered by the range
+	keys    [][]byte         // Hashes of the accounts in the range
+	values  [][]byte         // RLP encoded accounts in the range
+	pending int              // Number of storage tries and codes still missing
+	chunked []common.Hash    // Accounts whose storage trie was retrieved in multiple ranges
+}
+
+// snapStorageTask is the storage trie of an account being retrieved.
+type snapStorageTask struct {
+	account common.Hash       // Hash of the account owning the storage trie
+	root    common.Hash       // Root hash of the storage trie
+	next    common.Hash       // Next storage slot hash to retrieve
+	owner   *snapAccountRange // Account range waiting for the storage trie
+}
+
+// snapRequest is a range request sent to a remote peer, one of account range,
+// storage ranges or contract codes.
+type snapRequest struct {
+	id    uint64          // Request ID to match up the response with
+	peer  *peerConnection // Peer the request was sent to
+	timer *time.Timer     // Timer to fire when the request times out
+
+	task    *snapAccountTask   // Account chunk being filled (account ranges)
+	origin  common.Hash        // Origin of the requested account range
+	storage []*snapStorageTask // Storage tries requested (storage ranges)
+	codes   []common.Hash      // Contract codes requested (bytecodes)
+}
+
+// snapSync retrieves the state of the pivot block as contiguous ranges of accounts
+// and storage slots from multiple peers in parallel, verifying each range with the
+// merkle proofs of its edges. Only the trie nodes fully covered by the verified
+// ranges are written, so every written node is the root of a complete subtrie,
+// which permits the trie node sync to heal the remaining edges afterwards.
+//
+// The progress is retained if the pivot moves: the nodes written for the old root
+// are still valid trie nodes, the differences being reconciled by the healing.
+type snapSync struct {
+	d    *Downloader // Downloader instance to access the peers and the database
+	root common.Hash // State root currently being retrieved
+
+	tasks     []*snapAccountTask                  // Chunks of the account hash space
+	storage   []*snapStorageTask                  // Storage tries queued for retrieval
+	codes     map[common.Hash][]*snapAccountRange // Missing contract codes and the ranges waiting for them
+	codeQueue []common.Hash                       // Contract codes queued for retrieval
+
+	active    map[uint64]*snapRequest // Requests currently in flight
+	busy      map[string]struct{}     // Peers with a request in flight
+	stateless map[string]struct{}     // Peers unable to serve the current root
+	nextID    uint64                  // Next request ID to use
+
+	batch ethdb.Batch // Pending database writes
+
+	accounts uint64    // Number of accounts retrieved
+	slots    uint64    // Number of storage slots retrieved
+	bytecode uint64    // Number of contract codes retrieved
+	nodes    uint64    // Number of trie nodes and codes written
+	logged   time.Time // Time of the last progress report
+}
+
+// newSnapSync creates a range retriever with the account hash space split into
+// equal chunks to fetch in parallel.
+func newSnapSync(d *Downloader) *snapSync {
+	s := &snapSync{
+		d:         d,
+		codes:     make(map[common.Hash][]*snapAccountRange),
+		active:    make(map[uint64]*snapRequest),
+		busy:      make(map[string]struct{}),
+		stateless: make(map[string]struct{}),
+		batch:     d.stateDB.NewBatch(),
+	}
+	step := new(big.Int).Div(snapMaxHash.Big(), big.NewInt(snapAccountConcurrency))
+
+	var next common.Hash
+	for i := 0; i < snapAccountConcurrency; i++ {
+		last := common.BigToHash(new(big.Int).Add(next.Big(), step))
+		if i == snapAccountConcurrency-1 {
+			last = snapMaxHash
+		}
+		s.tasks = append(s.tasks, &snapAccountTask{next: next, last: last})
+		next = incHash(last)
+	}
+	return s
+}
+
+// sync retrieves the ranges of the state with the given root until all of them
+// are written, the sync is canceled, or no peer is able to serve the ranges any
+// more. In the latter case the trie node sync picks up the remaining work.
+func (s *snapSync) sync(root common.Hash, deliver chan dataPack, cancel chan struct{}) error {
+	if root != s.root {
+		s.reset(root)
+	}
+	if s.complete() {
+		return nil
+	}
+	log.Info("Retrieving state ranges", "root", root)
+
+	// Listen for peer arrivals and departures to (re)assign tasks
+	newPeer := make(chan *peerConnection, 1024)
+	newPeerSub := s.d.peers.SubscribeNewPeers(newPeer)
+	defer newPeerSub.Unsubscribe()
+
+	peerDrop := make(chan *peerConnection, 1024)
+	peerDropSub := s.d.peers.SubscribePeerDrops(peerDrop)
+	defer peerDropSub.Unsubscribe()
+
+	timeout := make(chan *snapRequest)
+	quit := make(chan struct{})
+	defer close(quit)
+	defer s.cleanup()
+
+	for !s.complete() {
+		s.assign(timeout, quit)
+		if len(s.active) == 0 {
+			log.Info("No peers serving state ranges, falling back to trie node sync", "root", root)
+			return nil
+		}
+		select {
+		case <-newPeer:
+			// New peer arrived, try to assign it download tasks
+
+		case p := <-peerDrop:
+			for _, req := range s.active {
+				if req.peer.id == p.id {
+					s.revert(req)
+				}
+			}
+
+		case req := <-timeout:
+			// Ignore the stale timeout if the response already arrived
+			if s.active[req.id] != req {
+				continue
+			}
+			req.peer.log.Debug("State range request timed out", "reqid", req.id)
+			snapTimeoutMeter.Mark(1)
+			s.revert(req)
+
+		case pack := <-deliver:
+			if err := s.process(pack); err != nil {
+				return err
+			}
+
+		case <-cancel:
+			return errCancelStateFetch
+
+		case <-s.d.cancelCh:
+			return errCancelStateFetch
+		}
+		if s.batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	log.Info("Retrieved state ranges, healing trie", "root", root, "accounts", s.accounts, "slots", s.slots, "codes", s.bytecode, "nodes", s.nodes)
+	return nil
+}
+
+// reset switches the retrieval over to a new root. The ranges not yet written are
+// dropped, being retrieved anew from the new root.
+func (s *snapSync) reset(root common.Hash) {
+	if s.root != (common.Hash{}) {
+		log.Debug("State range pivot moved", "old", s.root, "new", root)
+	}
+	s.root = root
+	for _, task := range s.tasks {
+		if len(task.ranges) > 0 {
+			task.next, task.done = task.ranges[0].origin, false
+			task.ranges = nil
+		}
+	}
+	s.storage, s.codeQueue = nil, nil
+	s.codes = make(map[common.Hash][]*snapAccountRange)
+	s.stateless = make(map[string]struct{})
+}
+
+// complete reports whether all the account chunks have been retrieved and written.
+func (s *snapSync) complete() bool {
+	for _, task := range s.tasks {
+		if !task.done || len(task.ranges) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanup reverts all the requests still in flight and flushes any pending data.
+func (s *snapSync) cleanup() {
+	for _, req := range s.active {
+		s.revert(req)
+	}
+	if err := s.flush(); err != nil {
+		log.Error("Failed to write state ranges", "err", err)
+	}
+}
+
+// assign sends the next request to all the idle peers able to serve ranges.
+func (s *snapSync) assign(timeout chan *snapRequest, quit chan struct{}) {
+	for _, p := range s.d.peers.AllPeers() {
+		peer := p.SnapPeer()
+		if peer == nil {
+			continue
+		}
+		if _, ok := s.busy[p.id]; ok {
+			continue
+		}
+		if _, ok := s.stateless[p.id]; ok {
+			continue
+		}
+		req := s.nextRequest()
+		if req == nil {
+			return
+		}
+		s.nextID++
+		req.id, req.peer = s.nextID, p
+
+		s.active[req.id] = req
+		s.busy[p.id] = struct{}{}
+
+		req.timer = time.AfterFunc(s.d.requestTTL(), func() {
+			select {
+			case timeout <- req:
+			case <-quit:
+			}
+		})
+		var err error
+		switch {
+		case req.task != nil:
+			err = peer.RequestAccountRange(req.id, s.root, req.origin, req.task.last, snapRequestBytes)
+		case len(req.storage) > 0:
+			accounts := make([]common.Hash, len(req.storage))
+			for i, task := range req.storage {
+				accounts[i] = task.account
+			}
+			err = peer.RequestStorageRanges(req.id, s.root, accounts, req.storage[0].next, snapRequestBytes)
+		default:
+			err = peer.RequestByteCodes(req.id, req.codes, snapRequestBytes)
+		}
+		if err != nil {
+			p.log.Debug("Failed to request state ranges", "err", err)
+			s.revert(req)
+		}
+	}
+}
+
+// nextRequest assembles the next request to send out. Codes and storage ranges
+// take precedence to complete the pending account ranges as soon as possible.
+func (s *snapSync) nextRequest() *snapRequest {
+	if len(s.codeQueue) > 0 {
+		n := len(s.codeQueue)
+		if n > snapCodeBatch {
+			n = snapCodeBatch
+		}
+		req := &snapRequest{codes: append([]common.Hash{}, s.codeQueue[:n]...)}
+		s.codeQueue = s.codeQueue[n:]
+		return req
+	}
+	if len(s.storage) > 0 {
+		// The origin only applies to the first account of a request, so continued
+		// storage tries are requested on their own
+		tasks := []*snapStorageTask{s.storage[0]}
+		if s.storage[0].next == (common.Hash{}) {
+			for _, task := range s.storage[1:] {
+				if len(tasks) == snapStorageAccounts || task.next != (common.Hash{}) {
+					break
+				}
+				tasks = append(tasks, task)
+			}
+		}
+		s.storage = s.storage[len(tasks):]
+		return &snapRequest{storage: tasks}
+	}
+	for _, task := range s.tasks {
+		if task.done || task.req != nil {
+			continue
+		}
+		req := &snapRequest{task: task, origin: task.next}
+		task.req = req
+		return req
+	}
+	return nil
+}
+
+// revert abandons a request, placing its tasks back into the queues.
+func (s *snapSync) revert(req *snapRequest) {
+	req.timer.Stop()
+	delete(s.active, req.id)
+	delete(s.busy, req.peer.id)
+
+	if req.task != nil {
+		req.task.req = nil
+	}
+	s.storage = append(append([]*snapStorageTask{}, req.storage...), s.storage...)
+	s.codeQueue = append(s.codeQueue, req.codes...)
+}
+
+// process matches a response up with its request and handles its contents.
+func (s *snapSync) process(pack dataPack) error {
+	var id uint64
+	switch pack := pack.(type) {
+	case *accountRangePack:
+		id = pack.id
+	case *storageRangesPack:
+		id = pack.id
+	case *byteCodesPack:
+		id = pack.id
+	}
+	req := s.active[id]
+	if req == nil || req.peer.id != pack.PeerId() {
+		log.Debug("Unrequested state range", "peer", pack.PeerId(), "reqid", id)
+		return nil
+	}
+	req.timer.Stop()
+	delete(s.active, req.id)
+	delete(s.busy, req.peer.id)
+
+	switch pack := pack.(type) {
+	case *accountRangePack:
+		if req.task != nil {
+			return s.processAccounts(req, pack)
+		}
+	case *storageRangesPack:
+		if len(req.storage) > 0 {
+			return s.processStorage(req, pack)
+		}
+	case *byteCodesPack:
+		if len(req.codes) > 0 {
+			return s.processCodes(req, pack)
+		}
+	}
+	req.peer.log.Warn("Mismatching state range response", "reqid", req.id)
+	s.revert(req)
+	s.drop(req.peer)
+	return nil
+}
+
+// processAccounts verifies a range of accounts and queues up the retrieval of the
+// storage tries and codes they reference.
+func (s *snapSync) processAccounts(req *snapRequest, pack *accountRangePack) error {
+	task := req.task
+	task.req = nil
+
+	// An empty response without proofs signals that the peer doesn't have the state
+	if len(pack.hashes) == 0 && len(pack.proof) == 0 {
+		req.peer.log.Debug("Peer doesn't serve state ranges", "root", s.root)
+		s.stateless[req.peer.id] = struct{}{}
+		return nil
+	}
+	keys := make([][]byte, len(pack.hashes))
+	for i := range pack.hashes {
+		keys[i] = pack.hashes[i][:]
+	}
+	var last []byte
+	if len(keys) > 0 {
+		last = keys[len(keys)-1]
+	}
+	more, err := trie.VerifyRangeProof(s.root, req.origin[:], last, keys, pack.accounts, proofDatabase(pack.proof))
+	if err != nil {
+		req.peer.log.Warn("Invalid account range", "err", err)
+		s.drop(req.peer)
+		return nil
+	}
+	// Trim off any accounts beyond the chunk, they belong to the next one
+	rng := &snapAccountRange{task: task, origin: req.origin}
+	for i, key := range keys {
+		if bytes.Compare(key, task.last[:]) > 0 {
+			break
+		}
+		rng.keys, rng.values = append(rng.keys, key), append(rng.values, pack.accounts[i])
+	}
+	if len(rng.keys) < len(keys) || !more || bytes.Equal(last, task.last[:]) {
+		rng.last, task.done = task.last, true
+	} else {
+		rng.last = common.BytesToHash(last)
+		task.next = incHash(rng.last)
+	}
+	s.accounts += uint64(len(rng.keys))
+
+	// Queue up the storage tries and contract codes not yet available locally
+	for i, key := range rng.keys {
+		var account state.Account
+		if err := rlp.DecodeBytes(rng.values[i], &account); err != nil {
+			return err
+		}
+		if account.Root != types.EmptyRootHash {
+			if ok, _ := s.d.stateDB.Has(account.Root[:]); !ok {
+				s.storage = append(s.storage, &snapStorageTask{account: common.BytesToHash(key), root: account.Root, owner: rng})
+				rng.pending++
+			}
+		}
+		if hash := common.BytesToHash(account.CodeHash); hash != emptyCode {
+			if owners, ok := s.codes[hash]; ok {
+				s.codes[hash] = append(owners, rng)
+				rng.pending++
+			} else if ok, _ := s.d.stateDB.Has(hash[:]); !ok {
+				s.codes[hash] = []*snapAccountRange{rng}
+				s.codeQueue = append(s.codeQueue, hash)
+				rng.pending++
+			}
+		}
+	}
+	task.ranges = append(task.ranges, rng)
+	if rng.pending == 0 {
+		return s.commitAccounts(rng)
+	}
+	return nil
+}
+
+// processStorage verifies a batch of storage ranges, writing the covered trie nodes
+// and queueing up the continuation of partial storage tries.
+func (s *snapSync) processStorage(req *snapRequest, pack *storageRangesPack) error {
+	// An empty response signals that the peer doesn't have the state
+	if len(pack.hashes) == 0 {
+		req.peer.log.Debug("Peer doesn't serve storage ranges", "root", s.root)
+		s.stateless[req.peer.id] = struct{}{}
+		s.storage = append(append([]*snapStorageTask{}, req.storage...), s.storage...)
+		return nil
+	}
+	if len(pack.hashes) > len(req.storage) || len(pack.slots) != len(pack.hashes) {
+		req.peer.log.Warn("Invalid storage ranges", "requested", len(req.storage), "delivered", len(pack.hashes))
+		s.storage = append(append([]*snapStorageTask{}, req.storage...), s.storage...)
+		s.drop(req.peer)
+		return nil
+	}
+	// Requeue the storage tries not served, they are retrieved from the next peer
+	s.storage = append(append([]*snapStorageTask{}, req.storage[len(pack.hashes):]...), s.storage...)
+
+	for i, hashes := range pack.hashes {
+		task := req.storage[i]
+
+		keys := make([][]byte, len(hashes))
+		for j := range hashes {
+			keys[j] = hashes[j][:]
+		}
+		// Only the last range may be partial, proven by the proofs of its edges
+		var (
+			more bool
+			last = snapMaxHash
+			err  error
+		)
+		if i == len(pack.hashes)-1 && len(pack.proof) > 0 {
+			var lastKey []byte
+			if len(keys) > 0 {
+				lastKey = keys[len(keys)-1]
+			}
+			more, err = trie.VerifyRangeProof(task.root, task.next[:], lastKey, keys, pack.slots[i], proofDatabase(pack.proof))
+			if more {
+				last = common.BytesToHash(lastKey)
+			}
+		} else {
+			_, err = trie.VerifyRangeProof(task.root, nil, nil, keys, pack.slots[i], nil)
+		}
+		if err != nil {
+			req.peer.log.Warn("Invalid storage range", "account", task.account, "err", err)
+			s.storage = append(append([]*snapStorageTask{}, req.storage[i:len(pack.hashes)]...), s.storage...)
+			s.drop(req.peer)
+			return nil
+		}
+		nodes, err := trie.CommitRange(task.next[:], last[:], keys, pack.slots[i], s.batch)
+		if err != nil {
+			return err
+		}
+		s.nodes += uint64(nodes)
+		s.slots += uint64(len(keys))
+
+		if more {
+			// The storage trie is retrieved in multiple ranges, its edges need healing
+			if task.next == (common.Hash{}) {
+				task.owner.chunked = append(task.owner.chunked, task.account)
+			}
+			task.next = incHash(last)
+			s.storage = append([]*snapStorageTask{task}, s.storage...)
+			continue
+		}
+		if err := s.fulfil(task.owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processCodes writes the delivered contract codes and requeues the missing ones.
+func (s *snapSync) processCodes(req *snapRequest, pack *byteCodesPack) error {
+	requested := make(map[common.Hash]struct{}, len(req.codes))
+	for _, hash := range req.codes {
+		requested[hash] = struct{}{}
+	}
+	for _, code := range pack.codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := requested[hash]; !ok {
+			continue
+		}
+		delete(requested, hash)
+
+		if err := s.batch.Put(hash[:], code); err != nil {
+			return err
+		}
+		s.bytecode++
+		s.nodes++
+
+		owners := s.codes[hash]
+		delete(s.codes, hash)
+		for _, owner := range owners {
+			if err := s.fulfil(owner); err != nil {
+				return err
+			}
+		}
+	}
+	// Requeue the codes not delivered, avoiding the peer if it had none of them
+	if len(requested) == len(req.codes) {
+		req.peer.log.Debug("Peer doesn't serve contract codes", "root", s.root)
+		s.stateless[req.peer.id] = struct{}{}
+	}
+	for _, hash := range req.codes {
+		if _, ok := requested[hash]; ok {
+			s.codeQueue = append(s.codeQueue, hash)
+		}
+	}
+	return nil
+}
+
+// fulfil marks one of the storage tries or codes of an account range complete,
+// writing the range if nothing else is missing.
+func (s *snapSync) fulfil(rng *snapAccountRange) error {
+	if rng.pending--; rng.pending > 0 {
+		return nil
+	}
+	return s.commitAccounts(rng)
+}
+
+// commitAccounts writes the account trie nodes covered by a complete range. The
+// paths to the accounts whose storage tries were retrieved in multiple ranges
+// are skipped, since the edges of those storage tries still need healing.
+func (s *snapSync) commitAccounts(rng *snapAccountRange) error {
+	sort.Slice(rng.chunked, func(i, j int) bool {
+		return bytes.Compare(rng.chunked[i][:], rng.chunked[j][:]) < 0
+	})
+	var (
+		origin = rng.origin
+		keys   = rng.keys
+		values = rng.values
+		open   = true
+	)
+	for _, account := range rng.chunked {
+		index := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], account[:]) >= 0 })
+		if account != (common.Hash{}) {
+			if last := decHash(account); bytes.Compare(origin[:], last[:]) <= 0 {
+				if err := s.commitRange(origin, last, keys[:index], values[:index]); err != nil {
+					return err
+				}
+			}
+		}
+		keys, values = keys[index+1:], values[index+1:]
+		if account == snapMaxHash {
+			open = false
+			break
+		}
+		origin = incHash(account)
+	}
+	if open && bytes.Compare(origin[:], rng.last[:]) <= 0 {
+		if err := s.commitRange(origin, rng.last, keys, values); err != nil {
+			return err
+		}
+	}
+	// Range written, drop it from its chunk
+	ranges := rng.task.ranges
+	for i, r := range ranges {
+		if r == rng {
+			rng.task.ranges = append(ranges[:i:i], ranges[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// commitRange writes the trie nodes covered by a range of leaves into the batch.
+func (s *snapSync) commitRange(origin, last common.Hash, keys, values [][]byte) error {
+	nodes, err := trie.CommitRange(origin[:], last[:], keys, values, s.batch)
+	if err != nil {
+		return err
+	}
+	s.nodes += uint64(nodes)
+	return nil
+}
+
+// drop disconnects a peer delivering invalid state ranges.
+func (s *snapSync) drop(p *peerConnection) {
+	s.stateless[p.id] = struct{}{}
+	s.d.scores.Report(p.id, reputation.Invalid)
+	if s.d.dropPeer != nil {
+		s.d.dropPeer(p.id)
+	}
+}
+
+// flush writes the pending data to the database and reports the progress.
+func (s *snapSync) flush() error {
+	if s.batch.ValueSize() == 0 {
+		return nil
+	}
+	if err := s.batch.Write(); err != nil {
+		return err
+	}
+	s.batch.Reset()
+
+	s.d.syncStatsLock.Lock()
+	s.d.syncStatsState.processed = s.nodes
+	processed := s.d.syncStatsState.processed
+	s.d.syncStatsLock.Unlock()
+
+	rawdb.WriteFastTrieProgress(s.d.stateDB, processed)
+	if time.Since(s.logged) > 8*time.Second {
+		s.logged = time.Now()
+		log.Info("Imported new state ranges", "accounts", s.accounts, "slots", s.slots, "codes", s.bytecode, "nodes", s.nodes)
+	}
+	return nil
+}
+
+// proofDatabase collects the nodes of a merkle proof into a database keyed by
+// their hashes, as expected by the proof verification.
+func proofDatabase(proof [][]byte) *ethdb.MemDatabase {
+	db := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+// incHash returns the hash following the given one, wrapping around at the end.
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
+
+// decHash returns the hash preceding the given one, wrapping around at zero.
+func decHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]--
+		if h[i] != 0xff {
+			break
+		}
+	}
+	return h
+}
@@ -80,6 +80,8 @@ func (d *Downloader) stateFetcher() {
 			}
 		case <-d.stateCh:
 			// Ignore state responses while no sync is running.
+		case <-d.snapCh:
+			// Ignore range responses while no sync is running.
 		case <-d.quitCh:
 			return
 		}
@@ -152,6 +154,14 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			finished = append(finished, req)
 			delete(active, pack.PeerId())
 
+		// Forward range responses to the snap phase of the current sync:
+		case pack := <-d.snapCh:
+			select {
+			case s.snapCh <- pack:
+			case <-s.snapDone:
+				log.Debug("Unrequested state range", "peer", pack.PeerId(), "len", pack.Items())
+			}
+
 		// Handle dropped peer connections:
 		case p := <-peerDrop:
 			// Skip if no request is currently pending
@@ -212,7 +222,12 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root to retrieve
+
+	snap     *snapSync     // Range retrieval preceding the trie node healing (snap sync only)
+	snapCh   chan dataPack // Delivery channel of the range responses
+	snapDone chan struct{} // Channel to signal the end of the range retrieval
 
 	sched  *trie.Sync                 // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -236,23 +251,48 @@ type stateTask struct {
 
 // newStateSync creates a new state trie download scheduler. This method does not
 // yet start the sync. The user needs to call run to initiate.
+//
+// In snap sync mode, the trie node scheduler is only created once the ranges of
+// the state have been retrieved, healing whatever is still missing.
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
-	return &stateSync{
-		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB),
-		keccak:  sha3.NewLegacyKeccak256(),
-		tasks:   make(map[common.Hash]*stateTask),
-		deliver: make(chan *stateReq),
-		cancel:  make(chan struct{}),
-		done:    make(chan struct{}),
+	s := &stateSync{
+		d:        d,
+		root:     root,
+		snapCh:   make(chan dataPack),
+		snapDone: make(chan struct{}),
+		keccak:   sha3.NewLegacyKeccak256(),
+		tasks:    make(map[common.Hash]*stateTask),
+		deliver:  make(chan *stateReq),
+		cancel:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if d.mode == SnapSync {
+		if d.snapSync == nil {
+			d.snapSync = newSnapSync(d)
+		}
+		s.snap = d.snapSync
+	} else {
+		s.sched = state.NewStateSync(root, d.stateDB)
+		close(s.snapDone)
 	}
+	return s
 }
 
 // run starts the task assignment and response processing loop, blocking until
 // it finishes, and finally notifying any goroutines waiting for the loop to
 // finish.
 func (s *stateSync) run() {
-	s.err = s.loop()
+	if s.snap != nil {
+		s.err = s.snap.sync(s.root, s.snapCh, s.cancel)
+		close(s.snapDone)
+
+		if s.err == nil {
+			s.sched = state.NewStateSync(s.root, s.d.stateDB)
+		}
+	}
+	if s.err == nil {
+		s.err = s.loop()
+	}
 	close(s.done)
 }
 
@@ -310,6 +350,7 @@ func (s *stateSync) loop() (err error) {
 				// 2 items are the minimum requested, if even that times out, we've no use of
 				// this peer at the moment.
 				log.Warn("Stalling state sync, dropping peer", "peer", req.peer.id)
+				s.d.reportTimeout(req.peer.id, req.timeout)
 				s.d.dropPeer(req.peer.id)
 			}
 			// Process all the received blobs and check for stale delivery
@@ -18,13 +18,19 @@ package downloader
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core/types"
 )
 
 // peerDropFn is a callback type for dropping a peer detected as malicious.
 type peerDropFn func(id string)
 
+// throttleFn is a callback type for checking whether our own inbound bandwidth
+// limit held back any deliveries within the given period before now.
+type throttleFn func(window time.Duration) bool
+
 // dataPack is a data message returned by a peer for some query.
 type dataPack interface {
 	PeerId() string
@@ -77,3 +83,42 @@ type statePack struct {
 func (p *statePack) PeerId() string { return p.peerID }
 func (p *statePack) Items() int     { return len(p.states) }
 func (p *statePack) Stats() string  { return fmt.Sprintf("%d", len(p.states)) }
+
+// accountRangePack is a range of accounts returned by a peer, along with the
+// merkle proofs of its edges.
+type accountRangePack struct {
+	peerID   string
+	id       uint64
+	hashes   []common.Hash
+	accounts [][]byte
+	proof    [][]byte
+}
+
+func (p *accountRangePack) PeerId() string { return p.peerID }
+func (p *accountRangePack) Items() int     { return len(p.hashes) }
+func (p *accountRangePack) Stats() string  { return fmt.Sprintf("%d:%d", len(p.hashes), len(p.proof)) }
+
+// storageRangesPack is a batch of storage ranges returned by a peer, along with
+// the merkle proofs of the last range's edges.
+type storageRangesPack struct {
+	peerID string
+	id     uint64
+	hashes [][]common.Hash
+	slots  [][][]byte
+	proof  [][]byte
+}
+
+func (p *storageRangesPack) PeerId() string { return p.peerID }
+func (p *storageRangesPack) Items() int     { return len(p.hashes) }
+func (p *storageRangesPack) Stats() string  { return fmt.Sprintf("%d:%d", len(p.hashes), len(p.proof)) }
+
+// byteCodesPack is a batch of contract bytecodes returned by a peer.
+type byteCodesPack struct {
+	peerID string
+	id     uint64
+	codes  [][]byte
+}
+
+func (p *byteCodesPack) PeerId() string { return p.peerID }
+func (p *byteCodesPack) Items() int     { return len(p.codes) }
+func (p *byteCodesPack) Stats() string  { return fmt.Sprintf("%d", len(p.codes)) }
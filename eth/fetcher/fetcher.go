@@ -19,13 +19,16 @@ package fetcher
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/common/prque"
 	"github.com/simplechain-org/simplechain/consensus"
 	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/eth/reputation"
 	"github.com/simplechain-org/simplechain/log"
 )
 
@@ -37,6 +40,7 @@ const (
 	maxQueueDist  = 32                     // Maximum allowed distance from the chain head to queue
 	hashLimit     = 256                    // Maximum number of unique blocks a peer may have announced
 	blockLimit    = 64                     // Maximum number of unique blocks a peer may have delivered
+	badBlockLimit = 256                    // Maximum number of light relayed bad blocks to remember
 )
 
 var (
@@ -58,12 +62,20 @@ type headerVerifierFn func(header *types.Header) error
 // blockBroadcasterFn is a callback type for broadcasting a block to connected peers.
 type blockBroadcasterFn func(block *types.Block, propagate bool)
 
+// blockRelayFn is a callback type for relaying a block to all connected peers not
+// knowing about it yet.
+type blockRelayFn func(block *types.Block)
+
 // chainHeightFn is a callback type to retrieve the current chain height.
 type chainHeightFn func() uint64
 
 // chainInsertFn is a callback type to insert a batch of blocks into the local chain.
 type chainInsertFn func(types.Blocks) (int, error)
 
+// badBlockFn is a callback type to check whether the local chain rejected a block
+// as invalid, as opposed to failing to import it for local reasons.
+type badBlockFn func(hash common.Hash) bool
+
 // peerDropFn is a callback type for dropping a peer detected as malicious.
 type peerDropFn func(id string)
 
@@ -133,10 +145,16 @@ type Fetcher struct {
 	getBlock       blockRetrievalFn   // Retrieves a block from the local chain
 	verifyHeader   headerVerifierFn   // Checks if a block's headers have a valid proof of work
 	broadcastBlock blockBroadcasterFn // Broadcasts a block to connected peers
+	relayBlock     blockRelayFn       // Relays a block to all connected peers (nil = no light validation)
 	chainHeight    chainHeightFn      // Retrieves the current chain's height
 	insertChain    chainInsertFn      // Injects a batch of blocks into the chain
+	isBadBlock     badBlockFn         // Checks whether the chain rejected a block as invalid
 	dropPeer       peerDropFn         // Drops a peer for misbehaving
 
+	scores *reputation.Tracker // Reputation of the remote peers to prefer retrieving from
+
+	bad *lru.Cache // Hashes of light relayed blocks that turned out to be invalid
+
 	// Testing hooks
 	announceChangeHook func(common.Hash, bool) // Method to call upon adding or deleting a hash from the announce list
 	queueChangeHook    func(common.Hash, bool) // Method to call upon adding or deleting a block from the import queue
@@ -146,7 +164,17 @@ type Fetcher struct {
 }
 
 // New creates a block fetcher to retrieve blocks based on hash announcements.
-func New(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBlock blockBroadcasterFn, chainHeight chainHeightFn, insertChain chainInsertFn, dropPeer peerDropFn) *Fetcher {
+//
+// By default, propagated blocks are sent in full to a square root subset of the
+// peers once their seal checks out, the rest of the peers only being announced
+// the block after it is imported (and thus needing to fetch it themselves).
+//
+// If a relayBlock callback is given, light validation is enabled: blocks whose
+// seal, transaction root and uncle hash check out are sent in full to all peers
+// right away, without waiting for the import. If such a block turns out to be
+// invalid, it is remembered as bad and its origin is dropped.
+func New(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBlock blockBroadcasterFn, relayBlock blockRelayFn, chainHeight chainHeightFn, insertChain chainInsertFn, isBadBlock badBlockFn, dropPeer peerDropFn, scores *reputation.Tracker) *Fetcher {
+	bad, _ := lru.New(badBlockLimit)
 	return &Fetcher{
 		notify:         make(chan *announce),
 		inject:         make(chan *inject),
@@ -166,9 +194,13 @@ func New(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBloc
 		getBlock:       getBlock,
 		verifyHeader:   verifyHeader,
 		broadcastBlock: broadcastBlock,
+		relayBlock:     relayBlock,
 		chainHeight:    chainHeight,
 		insertChain:    insertChain,
+		isBadBlock:     isBadBlock,
 		dropPeer:       dropPeer,
+		scores:         scores,
+		bad:            bad,
 	}
 }
 
@@ -328,6 +360,12 @@ func (f *Fetcher) loop() {
 				propAnnounceDOSMeter.Mark(1)
 				break
 			}
+			// Ignore blocks already known to be bad
+			if f.bad.Contains(notification.hash) {
+				log.Debug("Peer announced bad block", "peer", notification.origin, "number", notification.number, "hash", notification.hash)
+				propAnnounceDropMeter.Mark(1)
+				break
+			}
 			// If we have a valid block number, check that it's potentially useful
 			if notification.number > 0 {
 				if dist := int64(notification.number) - int64(f.chainHeight()); dist < -maxUncleDist || dist > maxQueueDist {
@@ -368,8 +406,8 @@ func (f *Fetcher) loop() {
 
 			for hash, announces := range f.announced {
 				if time.Since(announces[0].time) > arriveTimeout-gatherSlack {
-					// Pick a random peer to retrieve from, reset all others
-					announce := announces[rand.Intn(len(announces))]
+					// Pick the most reputable peer to retrieve from, reset all others
+					announce := f.bestAnnounce(announces)
 					f.forgetHash(hash)
 
 					// If the block still didn't arrive, queue for fetching
@@ -403,8 +441,8 @@ func (f *Fetcher) loop() {
 			request := make(map[string][]common.Hash)
 
 			for hash, announces := range f.fetched {
-				// Pick a random peer to retrieve from, reset all others
-				announce := announces[rand.Intn(len(announces))]
+				// Pick the most reputable peer to retrieve from, reset all others
+				announce := f.bestAnnounce(announces)
 				f.forgetHash(hash)
 
 				// If the block still didn't arrive, queue for completion
@@ -450,6 +488,7 @@ func (f *Fetcher) loop() {
 					// If the delivered header does not match the promised number, drop the announcer
 					if header.Number.Uint64() != announce.number {
 						log.Trace("Invalid block number fetched", "peer", announce.origin, "hash", header.Hash(), "announced", announce.number, "provided", header.Number)
+						f.scores.Report(announce.origin, reputation.Invalid)
 						f.dropPeer(announce.origin)
 						f.forgetHash(hash)
 						continue
@@ -609,6 +648,13 @@ func (f *Fetcher) enqueue(peer string, block *types.Block) {
 		f.forgetHash(hash)
 		return
 	}
+	// Discard blocks already known to be bad
+	if f.bad.Contains(hash) {
+		log.Debug("Discarded propagated block, known bad", "peer", peer, "number", block.Number(), "hash", hash)
+		propBroadcastDropMeter.Mark(1)
+		f.forgetHash(hash)
+		return
+	}
 	// Discard any past or too distant blocks
 	if dist := int64(block.NumberU64()) - int64(f.chainHeight()); dist < -maxUncleDist || dist > maxQueueDist {
 		log.Debug("Discarded propagated block, too far away", "peer", peer, "number", block.Number(), "hash", hash, "distance", dist)
@@ -650,11 +696,29 @@ func (f *Fetcher) insert(peer string, block *types.Block) {
 			return
 		}
 		// Quickly validate the header and propagate the block if it passes
+		start := time.Now()
 		switch err := f.verifyHeader(block.Header()); err {
 		case nil:
-			// All ok, quickly propagate to our peers
+			// In light validation mode, the body must match the header too
+			if f.relayBlock != nil {
+				if err := verifyBody(block); err != nil {
+					log.Debug("Propagated block body verification failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+					f.scores.Report(peer, reputation.Invalid)
+					f.dropPeer(peer)
+					return
+				}
+				propLightValidationTimer.UpdateSince(start)
+			}
+			if delay := block.ReceivedAt.Sub(time.Unix(int64(block.Time()), 0)); delay > 0 {
+				propArrivalTimer.Update(delay)
+			}
+			// All ok, quickly propagate to our peers (all of them if light validating)
 			propBroadcastOutTimer.UpdateSince(block.ReceivedAt)
-			go f.broadcastBlock(block, true)
+			if f.relayBlock != nil {
+				go f.relayBlock(block)
+			} else {
+				go f.broadcastBlock(block, true)
+			}
 
 		case consensus.ErrFutureBlock:
 			// Weird future block, don't fail, but neither propagate
@@ -662,15 +726,32 @@ func (f *Fetcher) insert(peer string, block *types.Block) {
 		default:
 			// Something went very wrong, drop the peer
 			log.Debug("Propagated block verification failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+			f.scores.Report(peer, reputation.Invalid)
 			f.dropPeer(peer)
 			return
 		}
 		// Run the actual import and log any issues
+		start = time.Now()
 		if _, err := f.insertChain(types.Blocks{block}); err != nil {
 			log.Debug("Propagated block import failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+
+			// If the block was relayed in light validation mode but is invalid,
+			// drop the origin and make sure it is not relayed again. Failures
+			// for local reasons (e.g. an interrupted import) are not the origin's
+			// fault.
+			if f.relayBlock != nil && f.isBadBlock(hash) {
+				propLightFailMeter.Mark(1)
+				f.bad.Add(hash, struct{}{})
+				f.scores.Report(peer, reputation.Invalid)
+				f.dropPeer(peer)
+			}
 			return
 		}
-		// If import succeeded, broadcast the block
+		propImportTimer.UpdateSince(start)
+
+		// If import succeeded, credit the origin and announce the block (in light
+		// validation mode only reaching the peers connected since the relay)
+		f.scores.Report(peer, reputation.Useful)
 		propAnnounceOutTimer.UpdateSince(block.ReceivedAt)
 		go f.broadcastBlock(block, false)
 
@@ -681,6 +762,33 @@ func (f *Fetcher) insert(peer string, block *types.Block) {
 	}()
 }
 
+// verifyBody runs the cheap consistency checks of a block body against its
+// header, without executing any of the transactions.
+func verifyBody(block *types.Block) error {
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("uncle root hash mismatch: have %x, want %x", hash, block.UncleHash())
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	return nil
+}
+
+// bestAnnounce picks the announcement of the most reputable peer to retrieve an
+// announced block from. Announcers of equal standing are picked at random.
+func (f *Fetcher) bestAnnounce(announces []*announce) *announce {
+	start := rand.Intn(len(announces))
+
+	best, score := announces[start], f.scores.Score(announces[start].origin)
+	for i := 1; i < len(announces); i++ {
+		announce := announces[(start+i)%len(announces)]
+		if s := f.scores.Score(announce.origin); s > score {
+			best, score = announce, s
+		}
+	}
+	return best
+}
+
 // forgetHash removes all traces of a block announcement from the fetcher's
 // internal state.
 func (f *Fetcher) forgetHash(hash common.Hash) {
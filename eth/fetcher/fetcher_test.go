@@ -25,10 +25,12 @@ import (
 	"time"
 
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/mclock"
 	"github.com/simplechain-org/simplechain/consensus/ethash"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/eth/reputation"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/params"
 )
@@ -92,7 +94,7 @@ func newTester() *fetcherTester {
 		blocks: map[common.Hash]*types.Block{genesis.Hash(): genesis},
 		drops:  make(map[string]bool),
 	}
-	tester.fetcher = New(tester.getBlock, tester.verifyHeader, tester.broadcastBlock, tester.chainHeight, tester.insertChain, tester.dropPeer)
+	tester.fetcher = New(tester.getBlock, tester.verifyHeader, tester.broadcastBlock, nil, tester.chainHeight, tester.insertChain, nil, tester.dropPeer, nil)
 	tester.fetcher.Start()
 
 	return tester
@@ -342,6 +344,54 @@ func testConcurrentAnnouncements(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that if blocks are announced by multiple peers, they are retrieved from
+// the most reputable one, crediting it for the imports.
+func TestReputableAnnouncements62(t *testing.T) { testReputableAnnouncements(t, 62) }
+func TestReputableAnnouncements63(t *testing.T) { testReputableAnnouncements(t, 63) }
+func TestReputableAnnouncements64(t *testing.T) { testReputableAnnouncements(t, 64) }
+
+func testReputableAnnouncements(t *testing.T, protocol int) {
+	// Create a chain of blocks to import
+	targetBlocks := 16
+	hashes, blocks := makeChain(targetBlocks, 0, genesis)
+
+	// Assemble a tester preferring one of the announcers
+	tester := newTester()
+	scores := reputation.NewTracker(nil, mclock.System{})
+	scores.Report("bad", reputation.Empty)
+	tester.fetcher.scores = scores
+
+	goodHeaderFetcher := tester.makeHeaderFetcher("good", blocks, -gatherSlack)
+	goodBodyFetcher := tester.makeBodyFetcher("good", blocks, 0)
+	badHeaderFetcher := tester.makeHeaderFetcher("bad", blocks, -gatherSlack)
+	badBodyFetcher := tester.makeBodyFetcher("bad", blocks, 0)
+
+	counter := uint32(0)
+	badHeaderWrapper := func(hash common.Hash) error {
+		atomic.AddUint32(&counter, 1)
+		return badHeaderFetcher(hash)
+	}
+	// Iteratively announce blocks from both peers until all are imported
+	imported := make(chan *types.Block)
+	tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+	for i := len(hashes) - 2; i >= 0; i-- {
+		// Leave some time for both announcements to arrive before fetching
+		announced := time.Now().Add(-arriveTimeout + gatherSlack + 50*time.Millisecond)
+		tester.fetcher.Notify("bad", hashes[i], uint64(len(hashes)-i-1), announced, badHeaderWrapper, badBodyFetcher)
+		tester.fetcher.Notify("good", hashes[i], uint64(len(hashes)-i-1), announced, goodHeaderFetcher, goodBodyFetcher)
+		verifyImportEvent(t, imported, true)
+	}
+	verifyImportDone(t, imported)
+
+	if counter != 0 {
+		t.Fatalf("retrievals from less reputable peer: have %d, want 0", counter)
+	}
+	if score := scores.Score("good"); score < float64(targetBlocks)-1 || score > float64(targetBlocks) {
+		t.Fatalf("reputable peer score mismatch: have %v, want ~%v", score, targetBlocks)
+	}
+}
+
 // Tests that announcements arriving while a previous is being fetched still
 // results in a valid import.
 func TestOverlappingAnnouncements62(t *testing.T) { testOverlappingAnnouncements(t, 62) }
@@ -788,3 +838,172 @@ func TestBlockMemoryExhaustionAttack(t *testing.T) {
 	}
 	verifyImportDone(t, imported)
 }
+
+// Tests that in light validation mode propagated blocks are relayed to all peers
+// before their import finishes but only announced afterwards, while blocks with
+// bodies not matching their headers are rejected without being relayed.
+func TestLightValidationPropagation(t *testing.T) {
+	// Create a chain whose first block contains both a transaction and an uncle
+	hashes, blocks := makeChain(1, 0, genesis)
+	block := blocks[hashes[0]]
+
+	tester := newTester()
+
+	// Stall the import and record the relays and broadcasts
+	release := make(chan struct{})
+	tester.fetcher.insertChain = func(blocks types.Blocks) (int, error) {
+		<-release
+		return tester.insertChain(blocks)
+	}
+	broadcasts := make(chan string, 4)
+	tester.fetcher.relayBlock = func(block *types.Block) { broadcasts <- "relay" }
+	tester.fetcher.broadcastBlock = func(block *types.Block, propagate bool) {
+		if propagate {
+			broadcasts <- "propagate"
+		} else {
+			broadcasts <- "announce"
+		}
+	}
+	imported := make(chan *types.Block)
+	tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+	// Propagate a block with a tampered body, check for a drop without relaying
+	tampered := types.NewBlockWithHeader(block.Header()).WithBody(nil, block.Uncles())
+	tester.fetcher.Enqueue("bad", tampered)
+	verifyImportEvent(t, imported, false)
+
+	tester.lock.RLock()
+	dropped := tester.drops["bad"]
+	tester.lock.RUnlock()
+
+	if !dropped {
+		t.Fatalf("peer with tampered block body not dropped")
+	}
+	select {
+	case <-broadcasts:
+		t.Fatalf("tampered block relayed")
+	default:
+	}
+	// Propagate the valid block, it should be relayed but not announced before import
+	tester.fetcher.Enqueue("good", block)
+	select {
+	case event := <-broadcasts:
+		if event != "relay" {
+			t.Fatalf("broadcast mismatch: have %s, want relay", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("block relay timeout")
+	}
+	select {
+	case event := <-broadcasts:
+		t.Fatalf("unexpected broadcast before import: %s", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Release the import and ensure the block is announced afterwards
+	close(release)
+	verifyImportEvent(t, imported, true)
+
+	select {
+	case event := <-broadcasts:
+		if event != "announce" {
+			t.Fatalf("broadcast mismatch: have %s, want announce", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("block announcement timeout")
+	}
+}
+
+// Tests that in light validation mode the origin of a relayed block rejected as
+// invalid is dropped and the block is neither announced nor accepted again, but
+// that import failures for local reasons are not held against the origin.
+func TestLightValidationImportFailure(t *testing.T) {
+	hashes, blocks := makeChain(1, 0, genesis)
+	block := blocks[hashes[0]]
+
+	tester := newTester()
+
+	// Fail the import and record the relays and broadcasts
+	var (
+		invalid  uint32
+		attempts = make(chan struct{}, 4)
+	)
+	tester.fetcher.insertChain = func(blocks types.Blocks) (int, error) {
+		defer func() { attempts <- struct{}{} }()
+		if atomic.LoadUint32(&invalid) == 1 {
+			return 0, errors.New("invalid state root")
+		}
+		return 0, errors.New("insertion is interrupted")
+	}
+	tester.fetcher.isBadBlock = func(hash common.Hash) bool { return atomic.LoadUint32(&invalid) == 1 }
+
+	broadcasts := make(chan bool, 4)
+	tester.fetcher.relayBlock = func(block *types.Block) { broadcasts <- true }
+	tester.fetcher.broadcastBlock = func(block *types.Block, propagate bool) { broadcasts <- propagate }
+
+	// Fail the import locally, the origin should be kept and the block accepted again
+	tester.fetcher.Enqueue("local", block)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-broadcasts:
+		case <-attempts:
+		case <-time.After(time.Second):
+			t.Fatalf("locally failing block import timeout")
+		}
+	}
+	tester.lock.RLock()
+	dropped := tester.drops["local"]
+	tester.lock.RUnlock()
+
+	if dropped {
+		t.Fatalf("origin of locally failing block dropped")
+	}
+	if tester.fetcher.bad.Contains(block.Hash()) {
+		t.Fatalf("locally failing block remembered as bad")
+	}
+	// Fail the import as invalid, the origin should be dropped. The previous import
+	// might not be cleaned up yet, so retry until the block is accepted again.
+	atomic.StoreUint32(&invalid, 1)
+
+	for relayed := false; !relayed; {
+		tester.fetcher.Enqueue("bad", block)
+		select {
+		case propagate := <-broadcasts:
+			if !propagate {
+				t.Fatalf("failing block announced")
+			}
+			relayed = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		tester.lock.RLock()
+		dropped := tester.drops["bad"]
+		tester.lock.RUnlock()
+
+		if dropped {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("origin of failing block not dropped")
+		}
+	}
+	if !tester.fetcher.bad.Contains(block.Hash()) {
+		t.Fatalf("failing block not remembered as bad")
+	}
+	// Propagate and announce the same block from another peer, neither should go through
+	tester.fetcher.Enqueue("other", block)
+	tester.fetcher.Notify("other", block.Hash(), 1, time.Now().Add(-arriveTimeout), tester.makeHeaderFetcher("other", blocks, -gatherSlack), tester.makeBodyFetcher("other", blocks, 0))
+
+	select {
+	case <-broadcasts:
+		t.Fatalf("bad block relayed again")
+	case <-time.After(arriveTimeout + 2*gatherSlack):
+	}
+	tester.lock.RLock()
+	dropped = tester.drops["other"]
+	tester.lock.RUnlock()
+
+	if dropped {
+		t.Fatalf("peer relaying known bad block dropped")
+	}
+}
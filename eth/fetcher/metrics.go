@@ -33,6 +33,11 @@ var (
 	propBroadcastDropMeter = metrics.NewRegisteredMeter("eth/fetcher/prop/broadcasts/drop", nil)
 	propBroadcastDOSMeter  = metrics.NewRegisteredMeter("eth/fetcher/prop/broadcasts/dos", nil)
 
+	propArrivalTimer         = metrics.NewRegisteredTimer("eth/fetcher/prop/arrival", nil)
+	propImportTimer          = metrics.NewRegisteredTimer("eth/fetcher/prop/import", nil)
+	propLightValidationTimer = metrics.NewRegisteredTimer("eth/fetcher/prop/light/validation", nil)
+	propLightFailMeter       = metrics.NewRegisteredMeter("eth/fetcher/prop/light/fail", nil)
+
 	headerFetchMeter = metrics.NewRegisteredMeter("eth/fetcher/fetch/headers", nil)
 	bodyFetchMeter   = metrics.NewRegisteredMeter("eth/fetcher/fetch/bodies", nil)
 
@@ -40,4 +45,18 @@ var (
 	headerFilterOutMeter = metrics.NewRegisteredMeter("eth/fetcher/filter/headers/out", nil)
 	bodyFilterInMeter    = metrics.NewRegisteredMeter("eth/fetcher/filter/bodies/in", nil)
 	bodyFilterOutMeter   = metrics.NewRegisteredMeter("eth/fetcher/filter/bodies/out", nil)
+
+	txAnnounceInMeter    = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/in", nil)
+	txAnnounceKnownMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/known", nil)
+	txAnnounceDOSMeter   = metrics.NewRegisteredMeter("eth/fetcher/transaction/announces/dos", nil)
+
+	txBroadcastInMeter     = metrics.NewRegisteredMeter("eth/fetcher/transaction/broadcasts/in", nil)
+	txBroadcastRejectMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/broadcasts/reject", nil)
+
+	txRequestOutMeter     = metrics.NewRegisteredMeter("eth/fetcher/transaction/request/out", nil)
+	txRequestFailMeter    = metrics.NewRegisteredMeter("eth/fetcher/transaction/request/fail", nil)
+	txRequestTimeoutMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/request/timeout", nil)
+
+	txReplyInMeter     = metrics.NewRegisteredMeter("eth/fetcher/transaction/replies/in", nil)
+	txReplyRejectMeter = metrics.NewRegisteredMeter("eth/fetcher/transaction/replies/reject", nil)
 )
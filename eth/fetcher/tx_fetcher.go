@@ -0,0 +1,522 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/log"
+)
+
+const (
+	// maxTxAnnounces is the maximum number of unique transactions a peer can
+	// have announced and not yet delivered, to prevent memory exhaustion.
+	maxTxAnnounces = 4096
+
+	// MaxTransactionFetch is the maximum number of transactions that can be
+	// requested from a peer in a single retrieval request.
+	MaxTransactionFetch = 256
+
+	// txArriveTimeout is the time allowance before an announced transaction is
+	// explicitly requested, giving a chance for it to be broadcast in full.
+	txArriveTimeout = 500 * time.Millisecond
+
+	// txGatherSlack is the interval used to collate almost-expired announces
+	// and requests with network fetches.
+	txGatherSlack = 100 * time.Millisecond
+
+	// txFetchTimeout is the maximum allotted time to return an explicitly
+	// requested transaction.
+	txFetchTimeout = 5 * time.Second
+)
+
+// txHasFn is a callback type for checking whether a transaction is already
+// known to the local pool.
+type txHasFn func(common.Hash) bool
+
+// txAddFn is a callback type for inserting a batch of transactions into the
+// local pool.
+type txAddFn func([]*types.Transaction) []error
+
+// txRequestFn is a callback type for requesting a batch of transactions from
+// a remote peer.
+type txRequestFn func(string, []common.Hash) error
+
+// txAnnounce is the notification of the availability of a batch of new
+// transactions in the network.
+type txAnnounce struct {
+	origin string        // Identifier of the peer originating the notification
+	hashes []common.Hash // Batch of transaction hashes being announced
+}
+
+// txRequest represents an in-flight transaction retrieval request destined to
+// a specific peer.
+type txRequest struct {
+	hashes []common.Hash  // Transactions having been requested
+	time   mclock.AbsTime // Timestamp of the request
+}
+
+// txDelivery is the notification that a batch of transactions have been added
+// to the pool and should be untracked.
+type txDelivery struct {
+	origin string        // Identifier of the peer originating the notification
+	hashes []common.Hash // Batch of transaction hashes having been delivered
+	direct bool          // Whether this is a direct reply or a broadcast
+}
+
+// TxFetcher is responsible for retrieving new transactions based on hash
+// announcements.
+//
+// Announced transactions go through three stages:
+//   - Waiting: for a short while the transaction may still arrive through a
+//     plain broadcast, so nothing is requested yet.
+//   - Queued: the transaction is scheduled for retrieval from any of the peers
+//     having announced it.
+//   - Fetching: the transaction is being retrieved from exactly one peer. The
+//     other announcers are kept as alternates to retry with if the retrieval
+//     times out or the peer does not deliver it.
+type TxFetcher struct {
+	notify  chan *txAnnounce
+	cleanup chan *txDelivery
+	drop    chan string
+	quit    chan struct{}
+
+	// Stage 1: Waiting lists for newly discovered transactions that might be
+	// broadcast without needing explicit request/reply round trips.
+	waitlist  map[common.Hash]map[string]struct{} // Transactions waiting for a potential broadcast
+	waittime  map[common.Hash]mclock.AbsTime      // Timestamps when transactions were added to the waitlist
+	waitslots map[string]map[common.Hash]struct{} // Waiting announcements grouped by peer (DoS protection)
+
+	// Stage 2: Queue of transactions waiting to be allocated to some peer to be
+	// retrieved directly.
+	announces map[string]map[common.Hash]struct{} // Set of announced transactions, grouped by origin peer
+	announced map[common.Hash]map[string]struct{} // Set of download locations, grouped by transaction hash
+
+	// Stage 3: Set of transactions currently being retrieved.
+	fetching   map[common.Hash]string              // Transaction set currently being retrieved
+	requests   map[string]*txRequest               // In-flight transaction retrievals
+	alternates map[common.Hash]map[string]struct{} // In-flight transaction alternate origins if retrieval fails
+
+	// Callbacks
+	hasTx    txHasFn     // Retrieves a tx from the local txpool
+	addTxs   txAddFn     // Insert a batch of transactions into local txpool
+	fetchTxs txRequestFn // Retrieves a set of txs from a remote peer
+
+	clock mclock.Clock // Time wrapper to simulate in tests
+}
+
+// NewTxFetcher creates a transaction fetcher to retrieve transactions based on
+// hash announcements.
+func NewTxFetcher(hasTx txHasFn, addTxs txAddFn, fetchTxs txRequestFn) *TxFetcher {
+	return newTxFetcher(hasTx, addTxs, fetchTxs, mclock.System{})
+}
+
+// newTxFetcher creates a transaction fetcher with a custom clock for testing.
+func newTxFetcher(hasTx txHasFn, addTxs txAddFn, fetchTxs txRequestFn, clock mclock.Clock) *TxFetcher {
+	return &TxFetcher{
+		notify:     make(chan *txAnnounce),
+		cleanup:    make(chan *txDelivery),
+		drop:       make(chan string),
+		quit:       make(chan struct{}),
+		waitlist:   make(map[common.Hash]map[string]struct{}),
+		waittime:   make(map[common.Hash]mclock.AbsTime),
+		waitslots:  make(map[string]map[common.Hash]struct{}),
+		announces:  make(map[string]map[common.Hash]struct{}),
+		announced:  make(map[common.Hash]map[string]struct{}),
+		fetching:   make(map[common.Hash]string),
+		requests:   make(map[string]*txRequest),
+		alternates: make(map[common.Hash]map[string]struct{}),
+		hasTx:      hasTx,
+		addTxs:     addTxs,
+		fetchTxs:   fetchTxs,
+		clock:      clock,
+	}
+}
+
+// Start boots up the announcement based synchroniser, accepting and processing
+// hash notifications and transaction fetches until termination requested.
+func (f *TxFetcher) Start() {
+	go f.loop()
+}
+
+// Stop terminates the announcement based synchroniser, canceling all pending
+// operations.
+func (f *TxFetcher) Stop() {
+	close(f.quit)
+}
+
+// Notify announces the fetcher of the potential availability of a batch of new
+// transactions in the network.
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash) error {
+	txAnnounceInMeter.Mark(int64(len(hashes)))
+
+	// Skip any transaction announcements that we already know of, no point in
+	// even scheduling them
+	unknowns := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		if !f.hasTx(hash) {
+			unknowns = append(unknowns, hash)
+		}
+	}
+	txAnnounceKnownMeter.Mark(int64(len(hashes) - len(unknowns)))
+
+	if len(unknowns) == 0 {
+		return nil
+	}
+	select {
+	case f.notify <- &txAnnounce{origin: peer, hashes: unknowns}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Enqueue imports a batch of received transactions into the transaction pool
+// and the fetcher. This method may be called by both transaction broadcasts and
+// direct request replies. The differentiation is important so the fetcher can
+// re-schedule missing transactions as soon as possible.
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool) error {
+	if direct {
+		txReplyInMeter.Mark(int64(len(txs)))
+	} else {
+		txBroadcastInMeter.Mark(int64(len(txs)))
+	}
+	// Push all the transactions into the pool, tracking failures. Whether they
+	// are accepted or not, the fetcher is done with them.
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	var rejected int
+	for _, err := range f.addTxs(txs) {
+		if err != nil {
+			rejected++
+		}
+	}
+	if direct {
+		txReplyRejectMeter.Mark(int64(rejected))
+	} else {
+		txBroadcastRejectMeter.Mark(int64(rejected))
+	}
+	select {
+	case f.cleanup <- &txDelivery{origin: peer, hashes: hashes, direct: direct}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Drop should be called when a peer disconnects. It cleans up all the internal
+// data structures of the given node.
+func (f *TxFetcher) Drop(peer string) error {
+	select {
+	case f.drop <- peer:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// loop is the main fetcher loop, tracking the announcements through their
+// stages and scheduling the network retrievals.
+func (f *TxFetcher) loop() {
+	var (
+		waitTimer    <-chan time.Time
+		timeoutTimer <-chan time.Time
+	)
+	for {
+		select {
+		case ann := <-f.notify:
+			// Drop part of the new announcements if there are too many accumulated.
+			used := len(f.waitslots[ann.origin]) + len(f.announces[ann.origin])
+			if used >= maxTxAnnounces {
+				txAnnounceDOSMeter.Mark(int64(len(ann.hashes)))
+				break
+			}
+			want := used + len(ann.hashes)
+			if want > maxTxAnnounces {
+				txAnnounceDOSMeter.Mark(int64(want - maxTxAnnounces))
+				ann.hashes = ann.hashes[:maxTxAnnounces-used]
+			}
+			for _, hash := range ann.hashes {
+				// If the transaction is already downloading, add it to the list
+				// of possible alternates and move on
+				if f.fetching[hash] != "" {
+					if f.fetching[hash] != ann.origin {
+						f.alternates[hash][ann.origin] = struct{}{}
+						f.track(f.announces, ann.origin, hash)
+					}
+					continue
+				}
+				// If the transaction is already queued, add the new location
+				if f.announced[hash] != nil {
+					f.announced[hash][ann.origin] = struct{}{}
+					f.track(f.announces, ann.origin, hash)
+					continue
+				}
+				// If the transaction is already waiting for a broadcast, add the
+				// new location, otherwise start waiting for it
+				if f.waitlist[hash] == nil {
+					f.waitlist[hash] = make(map[string]struct{})
+					f.waittime[hash] = f.clock.Now()
+				}
+				f.waitlist[hash][ann.origin] = struct{}{}
+				f.track(f.waitslots, ann.origin, hash)
+			}
+			// Start the wait timer if it's not running yet and retrieve the
+			// announced transactions already queued from the idle peers
+			if waitTimer == nil && len(f.waittime) > 0 {
+				waitTimer = f.clock.After(txArriveTimeout)
+			}
+			f.scheduleFetches(&timeoutTimer)
+
+		case <-waitTimer:
+			// At least one transaction's waiting time ran out, move all the
+			// expired ones into the retrieval queues
+			waitTimer = nil
+
+			now := f.clock.Now()
+			for hash, instance := range f.waittime {
+				if time.Duration(now-instance)+txGatherSlack < txArriveTimeout {
+					continue
+				}
+				f.announced[hash] = f.waitlist[hash]
+				for peer := range f.waitlist[hash] {
+					f.untrack(f.waitslots, peer, hash)
+					f.track(f.announces, peer, hash)
+				}
+				delete(f.waitlist, hash)
+				delete(f.waittime, hash)
+			}
+			// If transactions are still waiting, wake up for the earliest one
+			if earliest, ok := f.earliest(f.waittime); ok {
+				waitTimer = f.clock.After(txArriveTimeout - time.Duration(now-earliest))
+			}
+			f.scheduleFetches(&timeoutTimer)
+
+		case <-timeoutTimer:
+			// At least one request's time ran out, reschedule the transactions
+			// requested from the slow peers with alternate peers
+			timeoutTimer = nil
+
+			now := f.clock.Now()
+			for peer, req := range f.requests {
+				if time.Duration(now-req.time)+txGatherSlack < txFetchTimeout {
+					continue
+				}
+				txRequestTimeoutMeter.Mark(int64(len(req.hashes)))
+
+				// The peer is considered not having the transactions anymore
+				for _, hash := range req.hashes {
+					f.untrack(f.announces, peer, hash)
+					f.reschedule(peer, hash)
+				}
+				delete(f.requests, peer)
+			}
+			f.scheduleTimeout(&timeoutTimer)
+			f.scheduleFetches(&timeoutTimer)
+
+		case delivery := <-f.cleanup:
+			// Independent of whether the transactions were requested or simply
+			// broadcast, they are known now, so stop tracking them
+			for _, hash := range delivery.hashes {
+				f.forget(hash)
+			}
+			// If the delivery is a reply to our request, reschedule anything the
+			// peer failed to deliver with the alternate peers
+			if req := f.requests[delivery.origin]; delivery.direct && req != nil {
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == delivery.origin {
+						f.untrack(f.announces, delivery.origin, hash)
+						f.reschedule(delivery.origin, hash)
+					}
+				}
+				delete(f.requests, delivery.origin)
+				f.scheduleTimeout(&timeoutTimer)
+			}
+			f.scheduleFetches(&timeoutTimer)
+
+		case peer := <-f.drop:
+			// Clean up the waiting announcements of the peer
+			for hash := range f.waitslots[peer] {
+				delete(f.waitlist[hash], peer)
+				if len(f.waitlist[hash]) == 0 {
+					delete(f.waitlist, hash)
+					delete(f.waittime, hash)
+				}
+			}
+			delete(f.waitslots, peer)
+
+			// Clean up the queued and in-flight announcements of the peer
+			for hash := range f.announces[peer] {
+				delete(f.announced[hash], peer)
+				if len(f.announced[hash]) == 0 {
+					delete(f.announced, hash)
+				}
+				delete(f.alternates[hash], peer)
+			}
+			delete(f.announces, peer)
+
+			// Reschedule any in-flight retrievals with the alternate peers
+			if req := f.requests[peer]; req != nil {
+				for _, hash := range req.hashes {
+					f.reschedule(peer, hash)
+				}
+				delete(f.requests, peer)
+			}
+			f.scheduleTimeout(&timeoutTimer)
+			f.scheduleFetches(&timeoutTimer)
+
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+// track adds a transaction hash to the set of a peer within an index.
+func (f *TxFetcher) track(index map[string]map[common.Hash]struct{}, peer string, hash common.Hash) {
+	if index[peer] == nil {
+		index[peer] = make(map[common.Hash]struct{})
+	}
+	index[peer][hash] = struct{}{}
+}
+
+// untrack removes a transaction hash from the set of a peer within an index.
+func (f *TxFetcher) untrack(index map[string]map[common.Hash]struct{}, peer string, hash common.Hash) {
+	delete(index[peer], hash)
+	if len(index[peer]) == 0 {
+		delete(index, peer)
+	}
+}
+
+// forget drops a transaction from all the stages of the fetcher.
+func (f *TxFetcher) forget(hash common.Hash) {
+	for peer := range f.waitlist[hash] {
+		f.untrack(f.waitslots, peer, hash)
+	}
+	delete(f.waitlist, hash)
+	delete(f.waittime, hash)
+
+	for peer := range f.announced[hash] {
+		f.untrack(f.announces, peer, hash)
+	}
+	delete(f.announced, hash)
+
+	if origin, ok := f.fetching[hash]; ok {
+		f.untrack(f.announces, origin, hash)
+		for peer := range f.alternates[hash] {
+			f.untrack(f.announces, peer, hash)
+		}
+		delete(f.fetching, hash)
+		delete(f.alternates, hash)
+	}
+}
+
+// reschedule moves a transaction that failed to be retrieved from the given
+// peer back into the retrieval queue, with the alternate peers as sources. If
+// there are no alternates, the transaction is dropped.
+func (f *TxFetcher) reschedule(peer string, hash common.Hash) {
+	if f.fetching[hash] != peer {
+		return
+	}
+	delete(f.fetching, hash)
+
+	alternates := f.alternates[hash]
+	delete(f.alternates, hash)
+	delete(alternates, peer)
+
+	if len(alternates) > 0 {
+		f.announced[hash] = alternates
+	}
+}
+
+// scheduleFetches starts a batch of retrievals for all the idle peers having
+// queued announcements.
+func (f *TxFetcher) scheduleFetches(timer *<-chan time.Time) {
+	now := f.clock.Now()
+	for peer, hashes := range f.announces {
+		if f.requests[peer] != nil {
+			continue // Peer is busy, wait for its request to finish
+		}
+		var request []common.Hash
+		for hash := range hashes {
+			if _, ok := f.announced[hash]; !ok {
+				continue // Already fetching from someone else
+			}
+			f.alternates[hash] = f.announced[hash]
+			delete(f.announced, hash)
+			f.fetching[hash] = peer
+
+			if request = append(request, hash); len(request) >= MaxTransactionFetch {
+				break
+			}
+		}
+		if len(request) == 0 {
+			continue
+		}
+		f.requests[peer] = &txRequest{hashes: request, time: now}
+		txRequestOutMeter.Mark(int64(len(request)))
+
+		go func(peer string, hashes []common.Hash) {
+			// Failed requests are timed out and rescheduled by the loop
+			if err := f.fetchTxs(peer, hashes); err != nil {
+				log.Debug("Failed to request transactions", "peer", peer, "err", err)
+				txRequestFailMeter.Mark(int64(len(hashes)))
+			}
+		}(peer, request)
+	}
+	if *timer == nil {
+		f.scheduleTimeout(timer)
+	}
+}
+
+// scheduleTimeout starts the request timeout timer for the oldest in-flight
+// retrieval, if there is any and the timer is not running yet.
+func (f *TxFetcher) scheduleTimeout(timer *<-chan time.Time) {
+	if *timer != nil {
+		return
+	}
+	var (
+		earliest mclock.AbsTime
+		found    bool
+	)
+	for _, req := range f.requests {
+		if !found || req.time < earliest {
+			earliest, found = req.time, true
+		}
+	}
+	if found {
+		*timer = f.clock.After(txFetchTimeout - time.Duration(f.clock.Now()-earliest))
+	}
+}
+
+// earliest returns the oldest timestamp within a set of tracked transactions.
+func (f *TxFetcher) earliest(times map[common.Hash]mclock.AbsTime) (mclock.AbsTime, bool) {
+	var (
+		earliest mclock.AbsTime
+		found    bool
+	)
+	for _, instance := range times {
+		if !found || instance < earliest {
+			earliest, found = instance, true
+		}
+	}
+	return earliest, found
+}
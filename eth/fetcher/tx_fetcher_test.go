@@ -0,0 +1,285 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/core/types"
+)
+
+var (
+	// testTxs is a set of transactions to use during testing that have meaningful hashes.
+	testTxs = []*types.Transaction{
+		types.NewTransaction(5577006791947779410, common.Address{0x0f}, new(big.Int), 0, new(big.Int), nil),
+		types.NewTransaction(15352856648520921629, common.Address{0xbb}, new(big.Int), 0, new(big.Int), nil),
+		types.NewTransaction(3916589616287113937, common.Address{0x86}, new(big.Int), 0, new(big.Int), nil),
+	}
+	// testTxsHashes is the hashes of the test transactions above
+	testTxsHashes = []common.Hash{testTxs[0].Hash(), testTxs[1].Hash(), testTxs[2].Hash()}
+)
+
+// txFetchRequest is a retrieval request issued by the fetcher under test.
+type txFetchRequest struct {
+	peer   string
+	hashes []common.Hash
+}
+
+// txFetcherTester is a test simulator for mocking out the transaction pool and
+// the network requests of a transaction fetcher.
+type txFetcherTester struct {
+	clock   *mclock.Simulated
+	fetcher *TxFetcher
+
+	pool     map[common.Hash]*types.Transaction // Transactions accepted by the mock pool
+	lock     sync.RWMutex                       // Protects the pool
+	requests chan *txFetchRequest               // Retrieval requests issued by the fetcher
+}
+
+// newTxFetcherTester creates a new transaction fetcher test mocker.
+func newTxFetcherTester() *txFetcherTester {
+	tester := &txFetcherTester{
+		clock:    new(mclock.Simulated),
+		pool:     make(map[common.Hash]*types.Transaction),
+		requests: make(chan *txFetchRequest, 16),
+	}
+	tester.fetcher = newTxFetcher(tester.hasTx, tester.addTxs, tester.fetchTxs, tester.clock)
+	tester.fetcher.Start()
+	return tester
+}
+
+// hasTx checks whether the mock pool contains a transaction.
+func (f *txFetcherTester) hasTx(hash common.Hash) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.pool[hash] != nil
+}
+
+// addTxs inserts a batch of transactions into the mock pool.
+func (f *txFetcherTester) addTxs(txs []*types.Transaction) []error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, tx := range txs {
+		f.pool[tx.Hash()] = tx
+	}
+	return make([]error, len(txs))
+}
+
+// fetchTxs records a retrieval request of the fetcher.
+func (f *txFetcherTester) fetchTxs(peer string, hashes []common.Hash) error {
+	f.requests <- &txFetchRequest{peer: peer, hashes: hashes}
+	return nil
+}
+
+// expectRequest waits for the next retrieval request, checking its contents.
+func (f *txFetcherTester) expectRequest(t *testing.T, peers []string, hashes []common.Hash) string {
+	t.Helper()
+
+	select {
+	case req := <-f.requests:
+		found := false
+		for _, peer := range peers {
+			found = found || peer == req.peer
+		}
+		if !found {
+			t.Fatalf("request peer mismatch: have %s, want one of %v", req.peer, peers)
+		}
+		if len(req.hashes) != len(hashes) {
+			t.Fatalf("request size mismatch: have %d, want %d", len(req.hashes), len(hashes))
+		}
+		requested := make(map[common.Hash]bool)
+		for _, hash := range req.hashes {
+			requested[hash] = true
+		}
+		for _, hash := range hashes {
+			if !requested[hash] {
+				t.Fatalf("transaction %x not requested", hash)
+			}
+		}
+		return req.peer
+	case <-time.After(time.Second):
+		t.Fatalf("retrieval request timeout")
+	}
+	return ""
+}
+
+// expectNoRequest checks that no retrieval request is issued.
+func (f *txFetcherTester) expectNoRequest(t *testing.T) {
+	t.Helper()
+
+	select {
+	case req := <-f.requests:
+		t.Fatalf("unexpected request to %s: %x", req.peer, req.hashes)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that announced transactions are only requested after giving them a
+// chance to arrive via a broadcast.
+func TestTxFetcherWaitBeforeRequest(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.fetcher.Notify("A", testTxsHashes[:2])
+	tester.clock.WaitForTimers(1)
+
+	tester.clock.Run(txArriveTimeout / 2)
+	tester.expectNoRequest(t)
+
+	tester.clock.Run(txArriveTimeout / 2)
+	tester.expectRequest(t, []string{"A"}, testTxsHashes[:2])
+}
+
+// Tests that announcements of already known transactions are ignored and that
+// broadcasts arriving during the wait cancel the retrieval.
+func TestTxFetcherSkipKnown(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	// Known transactions are never scheduled
+	tester.addTxs(testTxs[:1])
+	tester.fetcher.Notify("A", testTxsHashes[:1])
+	if timers := tester.clock.ActiveTimers(); timers != 0 {
+		t.Fatalf("known transaction scheduled: %d timers active", timers)
+	}
+	// Broadcast transactions are not requested any more
+	tester.fetcher.Notify("A", testTxsHashes[1:3])
+	tester.clock.WaitForTimers(1)
+	tester.fetcher.Enqueue("B", testTxs[1:2], false)
+
+	tester.clock.Run(txArriveTimeout)
+	tester.expectRequest(t, []string{"A"}, testTxsHashes[2:3])
+	tester.expectNoRequest(t)
+}
+
+// Tests that a transaction announced by multiple peers is only requested from
+// one of them, being rescheduled with the next one if the request times out.
+func TestTxFetcherTimeoutRescheduling(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.fetcher.Notify("A", testTxsHashes[:1])
+	tester.fetcher.Notify("B", testTxsHashes[:1])
+	tester.clock.WaitForTimers(1)
+
+	tester.clock.Run(txArriveTimeout)
+	first := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[:1])
+	tester.expectNoRequest(t)
+
+	// Time out the request and check it's sent to the other peer
+	tester.clock.WaitForTimers(1)
+	tester.clock.Run(txFetchTimeout)
+
+	second := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[:1])
+	if first == second {
+		t.Fatalf("timed out request resent to the same peer %s", first)
+	}
+	// Time out the second request too, nobody left to ask
+	tester.clock.WaitForTimers(1)
+	tester.clock.Run(txFetchTimeout)
+	tester.expectNoRequest(t)
+}
+
+// Tests that transactions missing from a direct reply are immediately
+// rescheduled with an alternate peer.
+func TestTxFetcherPartialDelivery(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.fetcher.Notify("A", testTxsHashes[:2])
+	tester.fetcher.Notify("B", testTxsHashes[:2])
+	tester.clock.WaitForTimers(1)
+
+	tester.clock.Run(txArriveTimeout)
+	first := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[:2])
+
+	tester.fetcher.Enqueue(first, testTxs[:1], true)
+	second := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[1:2])
+	if first == second {
+		t.Fatalf("undelivered transaction rerequested from the same peer %s", first)
+	}
+	tester.fetcher.Enqueue(second, testTxs[1:2], true)
+	tester.expectNoRequest(t)
+
+	if !tester.hasTx(testTxsHashes[0]) || !tester.hasTx(testTxsHashes[1]) {
+		t.Fatalf("delivered transactions not added to the pool")
+	}
+}
+
+// Tests that dropping a peer reschedules its in-flight retrievals with the
+// alternate peers.
+func TestTxFetcherDropPeer(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.fetcher.Notify("A", testTxsHashes[:1])
+	tester.fetcher.Notify("B", testTxsHashes[:1])
+	tester.clock.WaitForTimers(1)
+
+	tester.clock.Run(txArriveTimeout)
+	first := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[:1])
+
+	tester.fetcher.Drop(first)
+	second := tester.expectRequest(t, []string{"A", "B"}, testTxsHashes[:1])
+	if first == second {
+		t.Fatalf("dropped peer %s requested again", first)
+	}
+}
+
+// Tests that a single request never exceeds the retrieval limit, the rest of
+// the announced transactions being requested after the reply.
+func TestTxFetcherRequestLimit(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := make([]*types.Transaction, MaxTransactionFetch+1)
+	hashes := make([]common.Hash, len(txs))
+	for i := range txs {
+		txs[i] = types.NewTransaction(uint64(i), common.Address{}, new(big.Int), 0, new(big.Int), nil)
+		hashes[i] = txs[i].Hash()
+	}
+	tester.fetcher.Notify("A", hashes)
+	tester.clock.WaitForTimers(1)
+	tester.clock.Run(txArriveTimeout)
+
+	req := <-tester.requests
+	if len(req.hashes) != MaxTransactionFetch {
+		t.Fatalf("request size mismatch: have %d, want %d", len(req.hashes), MaxTransactionFetch)
+	}
+	requested := make(map[common.Hash]bool)
+	for _, hash := range req.hashes {
+		requested[hash] = true
+	}
+	var delivered []*types.Transaction
+	for _, tx := range txs {
+		if requested[tx.Hash()] {
+			delivered = append(delivered, tx)
+		}
+	}
+	tester.fetcher.Enqueue("A", delivered, true)
+
+	if req = <-tester.requests; len(req.hashes) != 1 || requested[req.hashes[0]] {
+		t.Fatalf("remaining transaction not requested: %x", req.hashes)
+	}
+}
@@ -17,6 +17,7 @@
 package filters
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -31,6 +32,8 @@ import (
 	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
+	"github.com/simplechain-org/simplechain/internal/ethapi"
+	"github.com/simplechain-org/simplechain/log"
 	"github.com/simplechain-org/simplechain/rpc"
 )
 
@@ -56,17 +59,20 @@ type PublicFilterAPI struct {
 	mux       *event.TypeMux
 	quit      chan struct{}
 	chainDb   ethdb.Database
+	config    Config
 	events    *EventSystem
 	filtersMu sync.Mutex
 	filters   map[rpc.ID]*filter
 }
 
-// NewPublicFilterAPI returns a new PublicFilterAPI instance.
-func NewPublicFilterAPI(backend Backend, lightMode bool) *PublicFilterAPI {
+// NewPublicFilterAPI returns a new PublicFilterAPI instance, limiting log queries
+// by the given config.
+func NewPublicFilterAPI(backend Backend, lightMode bool, config Config) *PublicFilterAPI {
 	api := &PublicFilterAPI{
 		backend: backend,
 		mux:     backend.EventMux(),
 		chainDb: backend.ChainDb(),
+		config:  config,
 		events:  NewEventSystem(backend.EventMux(), backend, lightMode),
 		filters: make(map[rpc.ID]*filter),
 	}
@@ -133,14 +139,77 @@ func (api *PublicFilterAPI) NewPendingTransactionFilter() rpc.ID {
 	return pendingTxSub.ID
 }
 
+// PendingTxCriteria represents the server side filters of a pending transaction
+// subscription. Transactions need to match every criterion set, any of the items
+// in a list matching the criterion.
+type PendingTxCriteria struct {
+	From     []common.Address `json:"from"`     // Senders of the transactions
+	To       []common.Address `json:"to"`       // Recipients of the transactions (contract creations never match)
+	Methods  []hexutil.Bytes  `json:"methods"`  // 4 byte method selectors at the start of the call data
+	MinValue *hexutil.Big     `json:"minValue"` // Minimum value transferred by the transactions
+}
+
+// validate ensures the method selectors of the criteria are well formed.
+func (crit *PendingTxCriteria) validate() error {
+	for i, method := range crit.Methods {
+		if len(method) != 4 {
+			return fmt.Errorf("invalid method selector #%d: have %d bytes, want 4", i, len(method))
+		}
+	}
+	return nil
+}
+
+// matches checks whether a pending transaction, sent by the given account,
+// satisfies the criteria.
+func (crit *PendingTxCriteria) matches(tx *types.Transaction, from common.Address) bool {
+	if len(crit.From) > 0 && !includes(crit.From, from) {
+		return false
+	}
+	if len(crit.To) > 0 && (tx.To() == nil || !includes(crit.To, *tx.To())) {
+		return false
+	}
+	if len(crit.Methods) > 0 {
+		data := tx.Data()
+		if len(data) < 4 {
+			return false
+		}
+		var found bool
+		for _, method := range crit.Methods {
+			if bytes.Equal(method, data[:4]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if crit.MinValue != nil && tx.Value().Cmp(crit.MinValue.ToInt()) < 0 {
+		return false
+	}
+	return true
+}
+
 // NewPendingTransactions creates a subscription that is triggered each time a transaction
 // enters the transaction pool and was signed from one of the transactions this nodes manages.
-func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+//
+// By default only the hashes of the transactions are delivered. If fullTx is set,
+// the full transaction objects are delivered instead. If criteria are given, only
+// the transactions matching them are delivered.
+func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context, fullTx *bool, crit *PendingTxCriteria) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
 	}
-
+	if (fullTx != nil && *fullTx) || crit != nil {
+		if crit == nil {
+			crit = new(PendingTxCriteria)
+		}
+		if err := crit.validate(); err != nil {
+			return nil, err
+		}
+		return api.newFullPendingTransactions(notifier, fullTx != nil && *fullTx, crit), nil
+	}
 	rpcSub := notifier.CreateSubscription()
 
 	go func() {
@@ -168,6 +237,59 @@ func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Su
 	return rpcSub, nil
 }
 
+// newFullPendingTransactions creates a subscription delivering the transactions
+// entering the transaction pool that match the given criteria, either as full
+// objects or just their hashes.
+func (api *PublicFilterAPI) newFullPendingTransactions(notifier *rpc.Notifier, fullTx bool, crit *PendingTxCriteria) *rpc.Subscription {
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		txs := make(chan []*types.Transaction, 128)
+		pendingTxSub := api.events.SubscribeFullPendingTxs(txs)
+
+		for {
+			select {
+			case batch := <-txs:
+				signer := api.pendingSigner()
+				for _, tx := range batch {
+					// Unprotected transactions need to be recovered pre EIP155
+					txSigner := signer
+					if !tx.Protected() {
+						txSigner = types.FrontierSigner{}
+					}
+					from, err := types.Sender(txSigner, tx)
+					if err != nil || !crit.matches(tx, from) {
+						continue
+					}
+					if fullTx {
+						notifier.Notify(rpcSub.ID, ethapi.NewRPCPendingTransaction(tx, signer))
+					} else {
+						notifier.Notify(rpcSub.ID, tx.Hash())
+					}
+				}
+			case <-rpcSub.Err():
+				pendingTxSub.Unsubscribe()
+				return
+			case <-notifier.Closed():
+				pendingTxSub.Unsubscribe()
+				return
+			}
+		}
+	}()
+
+	return rpcSub
+}
+
+// pendingSigner returns the signer to recover the senders of pending transactions
+// with, the one of the block following the current head.
+func (api *PublicFilterAPI) pendingSigner() types.Signer {
+	number := new(big.Int)
+	if header, _ := api.backend.HeaderByNumber(context.Background(), rpc.LatestBlockNumber); header != nil {
+		number.Add(header.Number, big.NewInt(1))
+	}
+	return types.LatestSigner(api.backend.ChainConfig(), number)
+}
+
 // NewBlockFilter creates a filter that fetches blocks that are imported into the chain.
 // It is part of the filter package since polling goes with eth_getFilterChanges.
 //
@@ -233,8 +355,19 @@ func (api *PublicFilterAPI) NewHeads(ctx context.Context) (*rpc.Subscription, er
 	return rpcSub, nil
 }
 
+// LogsOptions are the replay options of a log subscription.
+type LogsOptions struct {
+	Replay bool       `json:"replay"` // Replay the historic logs from the criteria's from block first
+	Cursor *LogCursor `json:"cursor"` // Last log received, to resume an interrupted subscription after
+}
+
 // Logs creates a subscription that fires for all new log that match the given filter criteria.
-func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc.Subscription, error) {
+//
+// If requested by the options, the historic logs matching the criteria from its
+// from block onwards (or after the given cursor) are replayed first, before the
+// subscription switches to new logs without gaps or duplicates. Passing the last
+// log received as the cursor allows a client to resume after a disconnect.
+func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria, opts *LogsOptions) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
@@ -243,9 +376,14 @@ func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc
 	var (
 		rpcSub      = notifier.CreateSubscription()
 		matchedLogs = make(chan []*types.Log)
+		logsSub     *Subscription
+		err         error
 	)
-
-	logsSub, err := api.events.SubscribeLogs(ethereum.FilterQuery(crit), matchedLogs)
+	if opts != nil && (opts.Replay || opts.Cursor != nil) {
+		logsSub, err = api.events.SubscribeLogsFrom(ethereum.FilterQuery(crit), opts.Cursor, matchedLogs)
+	} else {
+		logsSub, err = api.events.SubscribeLogs(ethereum.FilterQuery(crit), matchedLogs)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -264,6 +402,11 @@ func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc
 			case <-notifier.Closed(): // connection dropped
 				logsSub.Unsubscribe()
 				return
+			case <-logsSub.Err(): // replay failed, client needs to resume
+				if err := logsSub.Failure(); err != nil {
+					notifier.Fail(rpcSub.ID, err)
+				}
+				return
 			}
 		}
 	}()
@@ -321,6 +464,8 @@ func (api *PublicFilterAPI) NewFilter(crit FilterCriteria) (rpc.ID, error) {
 }
 
 // GetLogs returns logs matching the given argument that are stored within the state.
+// Queries spanning more blocks or matching more logs than the server allows are
+// rejected, those need to be paginated via GetLogsPage instead.
 //
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getlogs
 func (api *PublicFilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([]*types.Log, error) {
@@ -338,6 +483,9 @@ func (api *PublicFilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([
 		if crit.ToBlock != nil {
 			end = crit.ToBlock.Int64()
 		}
+		if err := api.checkSpan(ctx, begin, end); err != nil {
+			return nil, err
+		}
 		// Construct the range filter
 		filter = NewRangeFilter(api.backend, begin, end, crit.Addresses, crit.Topics)
 	}
@@ -346,9 +494,100 @@ func (api *PublicFilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([
 	if err != nil {
 		return nil, err
 	}
+	if api.config.MaxResults > 0 && len(logs) > api.config.MaxResults {
+		return nil, fmt.Errorf("query returned more than %d results, use eth_getLogsPage", api.config.MaxResults)
+	}
 	return returnLogs(logs), err
 }
 
+// checkSpan ensures that a log query range does not exceed the block span limit,
+// resolving the latest and pending block numbers to the current head.
+func (api *PublicFilterAPI) checkSpan(ctx context.Context, begin, end int64) error {
+	if api.config.MaxBlockSpan == 0 {
+		return nil
+	}
+	if begin < 0 || end < 0 {
+		header, err := api.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+		if header == nil {
+			return err
+		}
+		if begin < 0 {
+			begin = header.Number.Int64()
+		}
+		if end < 0 {
+			end = header.Number.Int64()
+		}
+	}
+	if end >= begin && uint64(end-begin) >= api.config.MaxBlockSpan {
+		return fmt.Errorf("query spans more than %d blocks, use eth_getLogsPage", api.config.MaxBlockSpan)
+	}
+	return nil
+}
+
+// GetLogsPage returns a page of the logs matching the given criteria that are
+// stored within the state. The blocks to search may be given as a list of ranges
+// in the options, overriding the from and to blocks of the criteria. Each page
+// holds at most the requested number of logs and searches at most the maximum
+// block span allowed by the server; unless the query is exhausted, the page is
+// accompanied by a cursor to request the following one with.
+func (api *PublicFilterAPI) GetLogsPage(ctx context.Context, crit FilterCriteria, opts *PageOptions) (*LogsPage, error) {
+	if opts == nil {
+		opts = new(PageOptions)
+	}
+	pager, err := newLogsPager(ctx, api.backend, api.config, crit, *opts)
+	if err != nil {
+		return nil, err
+	}
+	return pager.page(ctx)
+}
+
+// HistoricalLogs creates a subscription that streams the logs matching the given
+// criteria that are stored within the state, one page per notification, until
+// the query is exhausted (signalled by a page without a cursor). It accepts the
+// same options as GetLogsPage, the cursor of the last page received allowing to
+// resume an interrupted stream.
+func (api *PublicFilterAPI) HistoricalLogs(ctx context.Context, crit FilterCriteria, opts *PageOptions) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	if opts == nil {
+		opts = new(PageOptions)
+	}
+	pager, err := newLogsPager(ctx, api.backend, api.config, crit, *opts)
+	if err != nil {
+		return nil, err
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		// Abort any running page retrieval if the subscription is torn down
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-rpcSub.Err(): // client send an unsubscribe request
+			case <-notifier.Closed(): // connection dropped
+			case <-ctx.Done():
+			}
+			cancel()
+		}()
+		for {
+			page, err := pager.page(ctx)
+			if err != nil {
+				log.Debug("Historical log streaming failed", "err", err)
+				return
+			}
+			if err := notifier.Notify(rpcSub.ID, page); err != nil || page.Cursor == "" {
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
 // UninstallFilter removes the filter with the given filter id.
 //
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_uninstallfilter
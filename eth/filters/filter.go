@@ -27,6 +27,7 @@ import (
 	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
+	"github.com/simplechain-org/simplechain/params"
 	"github.com/simplechain-org/simplechain/rpc"
 )
 
@@ -35,6 +36,7 @@ type Backend interface {
 	EventMux() *event.TypeMux
 	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
 	HeaderByHash(ctx context.Context, blockHash common.Hash) (*types.Header, error)
+	ChainConfig() *params.ChainConfig
 	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
 	GetLogs(ctx context.Context, blockHash common.Hash) ([][]*types.Log, error)
 
@@ -22,11 +22,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/big"
 	"sync"
 	"time"
 
 	ethereum "github.com/simplechain-org/simplechain"
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/core/rawdb"
 	"github.com/simplechain-org/simplechain/core/types"
@@ -51,6 +53,9 @@ const (
 	// PendingTransactionsSubscription queries tx hashes for pending
 	// transactions entering the pending state
 	PendingTransactionsSubscription
+	// FullPendingTransactionsSubscription queries full transactions for
+	// pending transactions entering the pending state
+	FullPendingTransactionsSubscription
 	// BlocksSubscription queries hashes for blocks that are imported
 	BlocksSubscription
 	// LastSubscription keeps track of the last index
@@ -68,12 +73,35 @@ const (
 	logsChanSize = 10
 	// chainEvChanSize is the size of channel listening to ChainEvent.
 	chainEvChanSize = 10
+
+	// replayBatchBlocks is the number of blocks searched at once when replaying
+	// historic logs into a subscription.
+	replayBatchBlocks = 1024
+	// replayDedupDepth is the number of blocks below the head at the start of a
+	// replay, for which logs also delivered by the live subscription (during the
+	// replay) are deduplicated.
+	replayDedupDepth = 1024
+	// replayQueueLimit is the number of log batches (one per block) a replaying
+	// subscription may hold back for a slow replay or client before it fails.
+	replayQueueLimit = 1024
 )
 
 var (
 	ErrInvalidSubscriptionID = errors.New("invalid id")
+
+	errUnknownCursor  = errors.New("unknown cursor block")
+	errReplayOverflow = errors.New("log replay queue overflow")
 )
 
+// LogCursor identifies the last log delivered to a subscriber, allowing it to
+// resume its subscription right after it. Its fields are named after those of
+// the log itself, so the last log received can be passed as the cursor verbatim.
+type LogCursor struct {
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	Index       hexutil.Uint   `json:"logIndex"`
+}
+
 type subscription struct {
 	id        rpc.ID
 	typ       Type
@@ -81,6 +109,7 @@ type subscription struct {
 	logsCrit  ethereum.FilterQuery
 	logs      chan []*types.Log
 	hashes    chan []common.Hash
+	txs       chan []*types.Transaction
 	headers   chan *types.Header
 	installed chan struct{} // closed when the filter is installed
 	err       chan error    // closed when the filter is uninstalled
@@ -153,6 +182,8 @@ type Subscription struct {
 	f         *subscription
 	es        *EventSystem
 	unsubOnce sync.Once
+	failOnce  sync.Once
+	failure   error // Error that ended the subscription, if it failed
 }
 
 // Err returns a channel that is closed when unsubscribed.
@@ -160,6 +191,20 @@ func (sub *Subscription) Err() <-chan error {
 	return sub.f.err
 }
 
+// Failure returns the error that ended the subscription once the channel of Err
+// is closed, or nil if it was unsubscribed.
+func (sub *Subscription) Failure() error {
+	return sub.failure
+}
+
+// fail uninstalls the subscription, reporting the given error through Failure.
+func (sub *Subscription) fail(err error) {
+	sub.failOnce.Do(func() {
+		sub.failure = err
+		go sub.Unsubscribe()
+	})
+}
+
 // Unsubscribe uninstalls the subscription from the event broadcast loop.
 func (sub *Subscription) Unsubscribe() {
 	sub.unsubOnce.Do(func() {
@@ -174,6 +219,7 @@ func (sub *Subscription) Unsubscribe() {
 				break uninstallLoop
 			case <-sub.f.logs:
 			case <-sub.f.hashes:
+			case <-sub.f.txs:
 			case <-sub.f.headers:
 			}
 		}
@@ -231,6 +277,254 @@ func (es *EventSystem) SubscribeLogs(crit ethereum.FilterQuery, logs chan []*typ
 	return nil, fmt.Errorf("invalid from and to block combination: from > to")
 }
 
+// SubscribeLogsFrom creates a subscription that first replays the historic logs
+// matching the given criteria, then switches over to new logs. The replay starts
+// right after the given cursor if any, otherwise at the criteria's from block.
+//
+// Delivery is ordered and gap-free: the live subscription is installed before
+// the replay starts, its logs being held back until the replay finishes and any
+// duplicates dropped. If the cursor's block was reorged out of the chain in the
+// meantime, the logs of the orphaned blocks up to the cursor are delivered again
+// as removed before the replay resumes from the common ancestor.
+func (es *EventSystem) SubscribeLogsFrom(crit ethereum.FilterQuery, cursor *LogCursor, logs chan []*types.Log) (*Subscription, error) {
+	if cursor == nil && (crit.FromBlock == nil || crit.FromBlock.Sign() < 0) {
+		return nil, errors.New("log replay requires a from block or a cursor")
+	}
+	if crit.ToBlock != nil && crit.ToBlock.Cmp(big.NewInt(rpc.PendingBlockNumber.Int64())) == 0 {
+		return nil, errors.New("pending logs cannot be replayed")
+	}
+	if crit.FromBlock != nil && crit.ToBlock != nil && crit.ToBlock.Sign() >= 0 && crit.FromBlock.Cmp(crit.ToBlock) > 0 {
+		return nil, fmt.Errorf("invalid from and to block combination: from > to")
+	}
+	// Install the live subscription first, so no log can slip between the two
+	live := make(chan []*types.Log)
+	sub := es.subscribeLogs(crit, live)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	header, err := es.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if header == nil {
+		cancel()
+		sub.Unsubscribe()
+		if err == nil {
+			err = errors.New("head header unavailable")
+		}
+		return nil, err
+	}
+	end := header.Number.Uint64()
+	if crit.ToBlock != nil && crit.ToBlock.Sign() >= 0 && crit.ToBlock.Uint64() < end {
+		end = crit.ToBlock.Uint64()
+	}
+	replay := make(chan []*types.Log)
+	replayed := make(map[common.Hash]struct{})
+	go func() {
+		defer close(replay)
+		if err := es.replayLogs(ctx, crit, cursor, end, replayed, replay); err != nil && ctx.Err() == nil {
+			log.Warn("Log subscription replay failed", "id", sub.ID, "err", err)
+			sub.fail(err)
+		}
+	}()
+	go es.forwardReplayedLogs(sub, cancel, end, replayed, replay, live, logs)
+	return sub, nil
+}
+
+// replayLogs delivers the historic logs matching the given criteria up to the
+// given end block, resuming after the cursor if set. The hashes of the blocks
+// replayed near the end are collected to deduplicate against the live logs.
+func (es *EventSystem) replayLogs(ctx context.Context, crit ethereum.FilterQuery, cursor *LogCursor, end uint64, replayed map[common.Hash]struct{}, replay chan<- []*types.Log) error {
+	var (
+		start uint64
+		skip  *LogCursor // Logs of the cursor's block already delivered
+	)
+	if crit.FromBlock != nil && crit.FromBlock.Sign() >= 0 {
+		start = crit.FromBlock.Uint64()
+	}
+	if cursor != nil {
+		ancestor, removed, err := es.rewindCursor(ctx, crit, cursor)
+		if err != nil {
+			return err
+		}
+		for _, logs := range removed {
+			select {
+			case replay <- logs:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		resume := ancestor + 1
+		if removed == nil {
+			resume, skip = uint64(cursor.BlockNumber), cursor
+		}
+		if resume > start {
+			start = resume
+		}
+	}
+	for begin := start; begin <= end; begin += replayBatchBlocks {
+		last := begin + replayBatchBlocks - 1
+		if last > end {
+			last = end
+		}
+		logs, err := NewRangeFilter(es.backend, int64(begin), int64(last), crit.Addresses, crit.Topics).Logs(ctx)
+		if err != nil {
+			return err
+		}
+		for skip != nil && len(logs) > 0 && logs[0].BlockHash == skip.BlockHash && logs[0].Index <= uint(skip.Index) {
+			logs = logs[1:]
+		}
+		skip = nil
+		if len(logs) == 0 {
+			continue
+		}
+		for _, log := range logs {
+			if log.BlockNumber+replayDedupDepth > end {
+				replayed[log.BlockHash] = struct{}{}
+			}
+		}
+		select {
+		case replay <- logs:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// rewindCursor checks whether the block of a log cursor is still canonical. If
+// not, it walks back the orphaned chain up to the common ancestor, returning the
+// matching logs of the orphaned blocks (up to the cursor) marked as removed, in
+// reverse order. If the cursor is still canonical, nil removals are returned.
+func (es *EventSystem) rewindCursor(ctx context.Context, crit ethereum.FilterQuery, cursor *LogCursor) (uint64, [][]*types.Log, error) {
+	header, err := es.backend.HeaderByHash(ctx, cursor.BlockHash)
+	if err != nil {
+		return 0, nil, err
+	}
+	if header == nil || header.Number.Uint64() != uint64(cursor.BlockNumber) {
+		return 0, nil, errUnknownCursor
+	}
+	var removed [][]*types.Log
+	for {
+		canonical, err := es.backend.HeaderByNumber(ctx, rpc.BlockNumber(header.Number.Uint64()))
+		if err != nil {
+			return 0, nil, err
+		}
+		if canonical != nil && canonical.Hash() == header.Hash() {
+			return header.Number.Uint64(), removed, nil
+		}
+		logs, err := es.blockLogs(ctx, header, crit)
+		if err != nil {
+			return 0, nil, err
+		}
+		// Removals are reported newest first, as on a live chain reorg
+		var orphaned []*types.Log
+		for i := len(logs) - 1; i >= 0; i-- {
+			if header.Hash() == cursor.BlockHash && logs[i].Index > uint(cursor.Index) {
+				continue
+			}
+			logcopy := *logs[i]
+			logcopy.Removed = true
+			orphaned = append(orphaned, &logcopy)
+		}
+		if removed == nil {
+			removed = [][]*types.Log{}
+		}
+		if len(orphaned) > 0 {
+			removed = append(removed, orphaned)
+		}
+		if header.Number.Sign() == 0 {
+			return 0, nil, errUnknownCursor
+		}
+		if header, err = es.backend.HeaderByHash(ctx, header.ParentHash); err != nil {
+			return 0, nil, err
+		}
+		if header == nil {
+			return 0, nil, errUnknownCursor
+		}
+	}
+}
+
+// blockLogs retrieves the logs of a (possibly non-canonical) block matching the
+// given criteria.
+func (es *EventSystem) blockLogs(ctx context.Context, header *types.Header, crit ethereum.FilterQuery) ([]*types.Log, error) {
+	if !bloomFilter(header.Bloom, crit.Addresses, crit.Topics) {
+		return nil, nil
+	}
+	logsList, err := es.backend.GetLogs(ctx, header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	var unfiltered []*types.Log
+	for _, logs := range logsList {
+		unfiltered = append(unfiltered, logs...)
+	}
+	return filterLogs(unfiltered, crit.FromBlock, crit.ToBlock, crit.Addresses, crit.Topics), nil
+}
+
+// forwardReplayedLogs delivers the replayed logs to the subscriber, followed by
+// the live ones received in the meantime (minus the ones already replayed) and
+// then any subsequent live logs. Live logs are always accepted, so the event loop
+// is never blocked by the replay; if too many of them are held back, the
+// subscription fails instead.
+func (es *EventSystem) forwardReplayedLogs(sub *Subscription, cancel func(), end uint64, replayed map[common.Hash]struct{}, replay <-chan []*types.Log, live <-chan []*types.Log, logs chan<- []*types.Log) {
+	defer cancel()
+
+	var (
+		queued  [][]*types.Log // Live logs held back until the replay finishes
+		deliver [][]*types.Log // Logs ready to be delivered to the subscriber
+	)
+	dedup := func(batch []*types.Log) []*types.Log {
+		var fresh []*types.Log
+		for _, log := range batch {
+			if _, ok := replayed[log.BlockHash]; ok && !log.Removed && log.BlockNumber <= end {
+				continue
+			}
+			fresh = append(fresh, log)
+		}
+		return fresh
+	}
+	for {
+		// Only pull in more history once everything so far was delivered
+		var (
+			replayCh = replay
+			out      chan<- []*types.Log
+			next     []*types.Log
+		)
+		if len(deliver) > 0 {
+			replayCh, out, next = nil, logs, deliver[0]
+		}
+		select {
+		case batch, ok := <-replayCh:
+			if ok {
+				deliver = append(deliver, batch)
+				continue
+			}
+			// Replay finished, release the held back live logs
+			for _, batch := range queued {
+				if fresh := dedup(batch); len(fresh) > 0 {
+					deliver = append(deliver, fresh)
+				}
+			}
+			queued, replay = nil, nil
+
+		case batch := <-live:
+			if len(queued)+len(deliver) >= replayQueueLimit {
+				log.Warn("Log subscription replay overflowed", "id", sub.ID, "queued", len(queued), "undelivered", len(deliver))
+				sub.fail(errReplayOverflow)
+				return
+			}
+			if replay != nil {
+				queued = append(queued, batch)
+			} else if fresh := dedup(batch); len(fresh) > 0 {
+				deliver = append(deliver, fresh)
+			}
+
+		case out <- next:
+			deliver = deliver[1:]
+
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
 // subscribeMinedPendingLogs creates a subscription that returned mined and
 // pending logs that match the given criteria.
 func (es *EventSystem) subscribeMinedPendingLogs(crit ethereum.FilterQuery, logs chan []*types.Log) *Subscription {
@@ -241,6 +535,7 @@ func (es *EventSystem) subscribeMinedPendingLogs(crit ethereum.FilterQuery, logs
 		created:   time.Now(),
 		logs:      logs,
 		hashes:    make(chan []common.Hash),
+		txs:       make(chan []*types.Transaction),
 		headers:   make(chan *types.Header),
 		installed: make(chan struct{}),
 		err:       make(chan error),
@@ -258,6 +553,7 @@ func (es *EventSystem) subscribeLogs(crit ethereum.FilterQuery, logs chan []*typ
 		created:   time.Now(),
 		logs:      logs,
 		hashes:    make(chan []common.Hash),
+		txs:       make(chan []*types.Transaction),
 		headers:   make(chan *types.Header),
 		installed: make(chan struct{}),
 		err:       make(chan error),
@@ -275,6 +571,7 @@ func (es *EventSystem) subscribePendingLogs(crit ethereum.FilterQuery, logs chan
 		created:   time.Now(),
 		logs:      logs,
 		hashes:    make(chan []common.Hash),
+		txs:       make(chan []*types.Transaction),
 		headers:   make(chan *types.Header),
 		installed: make(chan struct{}),
 		err:       make(chan error),
@@ -291,6 +588,7 @@ func (es *EventSystem) SubscribeNewHeads(headers chan *types.Header) *Subscripti
 		created:   time.Now(),
 		logs:      make(chan []*types.Log),
 		hashes:    make(chan []common.Hash),
+		txs:       make(chan []*types.Transaction),
 		headers:   headers,
 		installed: make(chan struct{}),
 		err:       make(chan error),
@@ -307,6 +605,24 @@ func (es *EventSystem) SubscribePendingTxs(hashes chan []common.Hash) *Subscript
 		created:   time.Now(),
 		logs:      make(chan []*types.Log),
 		hashes:    hashes,
+		txs:       make(chan []*types.Transaction),
+		headers:   make(chan *types.Header),
+		installed: make(chan struct{}),
+		err:       make(chan error),
+	}
+	return es.subscribe(sub)
+}
+
+// SubscribeFullPendingTxs creates a subscription that writes the transactions
+// entering the transaction pool.
+func (es *EventSystem) SubscribeFullPendingTxs(txs chan []*types.Transaction) *Subscription {
+	sub := &subscription{
+		id:        rpc.NewID(),
+		typ:       FullPendingTransactionsSubscription,
+		created:   time.Now(),
+		logs:      make(chan []*types.Log),
+		hashes:    make(chan []common.Hash),
+		txs:       txs,
 		headers:   make(chan *types.Header),
 		installed: make(chan struct{}),
 		err:       make(chan error),
@@ -355,6 +671,9 @@ func (es *EventSystem) broadcast(filters filterIndex, ev interface{}) {
 		for _, f := range filters[PendingTransactionsSubscription] {
 			f.hashes <- hashes
 		}
+		for _, f := range filters[FullPendingTransactionsSubscription] {
+			f.txs <- e.Txs
+		}
 	case core.ChainEvent:
 		for _, f := range filters[BlocksSubscription] {
 			f.headers <- e.Block.Header()
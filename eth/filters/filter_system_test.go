@@ -18,6 +18,7 @@ package filters
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -27,13 +28,16 @@ import (
 
 	ethereum "github.com/simplechain-org/simplechain"
 	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
 	"github.com/simplechain-org/simplechain/consensus/ethash"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/core/bloombits"
 	"github.com/simplechain-org/simplechain/core/rawdb"
 	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
 	"github.com/simplechain-org/simplechain/ethdb"
 	"github.com/simplechain-org/simplechain/event"
+	"github.com/simplechain-org/simplechain/internal/ethapi"
 	"github.com/simplechain-org/simplechain/params"
 	"github.com/simplechain-org/simplechain/rpc"
 )
@@ -52,6 +56,10 @@ func (b *testBackend) ChainDb() ethdb.Database {
 	return b.db
 }
 
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return params.TestChainConfig
+}
+
 func (b *testBackend) EventMux() *event.TypeMux {
 	return b.mux
 }
@@ -167,7 +175,7 @@ func TestBlockSubscription(t *testing.T) {
 		logsFeed    = new(event.Feed)
 		chainFeed   = new(event.Feed)
 		backend     = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api         = NewPublicFilterAPI(backend, false)
+		api         = NewPublicFilterAPI(backend, false, DefaultConfig)
 		genesis     = new(core.Genesis).MustCommit(db)
 		chain, _    = core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
 		chainEvents = []core.ChainEvent{}
@@ -224,7 +232,7 @@ func TestPendingTxFilter(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 
 		transactions = []*types.Transaction{
 			types.NewTransaction(0, common.HexToAddress("0xb794f5ea0ba39494ce83a213fffba74279579268"), new(big.Int), 0, new(big.Int), nil),
@@ -284,7 +292,7 @@ func TestLogFilterCreation(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 
 		testCases = []struct {
 			crit    FilterCriteria
@@ -333,7 +341,7 @@ func TestInvalidLogFilterCreation(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 	)
 
 	// different situations where log filter creation should fail.
@@ -360,7 +368,7 @@ func TestInvalidGetLogsRequest(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 		blockHash  = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
 	)
 
@@ -390,7 +398,7 @@ func TestLogFilter(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
 		secondAddr     = common.HexToAddress("0x2222222222222222222222222222222222222222")
@@ -509,7 +517,7 @@ func TestPendingLogsSubscription(t *testing.T) {
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
 		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
-		api        = NewPublicFilterAPI(backend, false)
+		api        = NewPublicFilterAPI(backend, false, DefaultConfig)
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
 		secondAddr     = common.HexToAddress("0x2222222222222222222222222222222222222222")
@@ -614,3 +622,219 @@ func TestPendingLogsSubscription(t *testing.T) {
 		}
 	}
 }
+
+// collectLogs reads logs from a subscription channel until the given number of
+// them was received, then ensures no more arrive.
+func collectLogs(t *testing.T, ch chan []*types.Log, n int) []string {
+	var (
+		positions []string
+		timeout   = time.After(5 * time.Second)
+	)
+	for len(positions) < n {
+		select {
+		case logs := <-ch:
+			for _, log := range logs {
+				position := fmt.Sprintf("%d:%d", log.BlockNumber, log.Index)
+				if log.Removed {
+					position += "-"
+				}
+				positions = append(positions, position)
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for logs: have %v, want %d", positions, n)
+		}
+	}
+	select {
+	case logs := <-ch:
+		t.Fatalf("unexpected logs delivered: %v", logPositions(logs))
+	case <-time.After(100 * time.Millisecond):
+	}
+	return positions
+}
+
+// Tests that replaying log subscriptions deliver the historic logs, followed by
+// the live ones without duplicates, and resume correctly after a cursor.
+func TestReplayedLogsSubscription(t *testing.T) {
+	backend, chain := newPaginationBackend(t)
+	es := NewEventSystem(backend.mux, backend, false)
+
+	// Replay from genesis, with live logs arriving during or after the replay
+	ch := make(chan []*types.Log)
+	sub, err := es.SubscribeLogsFrom(ethereum.FilterQuery{FromBlock: big.NewInt(0)}, nil, ch)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	backend.logsFeed.Send([]*types.Log{
+		{BlockNumber: 18, BlockHash: chain[17].Hash(), Index: 0}, // duplicate of the replay
+		{BlockNumber: 21, BlockHash: common.HexToHash("0x21"), Index: 0},
+	})
+	have := collectLogs(t, ch, 8)
+	if want := []string{"2:0", "5:0", "5:1", "5:2", "6:0", "12:0", "18:0", "21:0"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("replayed logs mismatch: have %v, want %v", have, want)
+	}
+	sub.Unsubscribe()
+
+	// Resume after a canonical cursor
+	cursor := &LogCursor{BlockNumber: 5, BlockHash: chain[4].Hash(), Index: 0}
+	sub, err = es.SubscribeLogsFrom(ethereum.FilterQuery{}, cursor, ch)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	have = collectLogs(t, ch, 5)
+	if want := []string{"5:1", "5:2", "6:0", "12:0", "18:0"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("resumed logs mismatch: have %v, want %v", have, want)
+	}
+	sub.Unsubscribe()
+
+	// Resume after a cursor reorged out of the chain
+	addr := common.HexToAddress("0x10000")
+	fork, receipts := core.GenerateChain(params.TestChainConfig, chain[3], ethash.NewFaker(), backend.db, 1, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(common.HexToAddress("0xdead"))
+		for j := 0; j < 2; j++ {
+			receipt := types.NewReceipt(nil, false, 0)
+			receipt.Logs = []*types.Log{{Address: addr, BlockNumber: 5, Index: uint(j)}}
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+			gen.AddUncheckedReceipt(receipt)
+		}
+	})
+	for _, receipt := range receipts[0] {
+		receipt.Logs[0].BlockHash = fork[0].Hash()
+	}
+	rawdb.WriteBlock(backend.db, fork[0])
+	rawdb.WriteReceipts(backend.db, fork[0].Hash(), 5, receipts[0])
+
+	cursor = &LogCursor{BlockNumber: 5, BlockHash: fork[0].Hash(), Index: 0}
+	sub, err = es.SubscribeLogsFrom(ethereum.FilterQuery{}, cursor, ch)
+	if err != nil {
+		t.Fatalf("failed to resume after reorg: %v", err)
+	}
+	have = collectLogs(t, ch, 7)
+	if want := []string{"5:0-", "5:0", "5:1", "5:2", "6:0", "12:0", "18:0"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("reorged resumed logs mismatch: have %v, want %v", have, want)
+	}
+	sub.Unsubscribe()
+
+	// Invalid replay requests
+	if _, err := es.SubscribeLogsFrom(ethereum.FilterQuery{}, nil, ch); err == nil {
+		t.Errorf("replay without a start accepted")
+	}
+}
+
+// Tests that a replaying log subscription fails instead of buffering without
+// bounds if its subscriber doesn't keep up with the live logs.
+func TestReplayedLogsOverflow(t *testing.T) {
+	backend, _ := newPaginationBackend(t)
+	es := NewEventSystem(backend.mux, backend, false)
+
+	// Subscribe without ever reading the delivered logs
+	sub, err := es.SubscribeLogsFrom(ethereum.FilterQuery{FromBlock: big.NewInt(0)}, nil, make(chan []*types.Log))
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	for i := 0; i <= replayQueueLimit; i++ {
+		backend.logsFeed.Send([]*types.Log{{BlockNumber: uint64(21 + i), BlockHash: common.Hash{0x21}}})
+	}
+	select {
+	case <-sub.Err():
+		if err := sub.Failure(); err != errReplayOverflow {
+			t.Errorf("subscription failure mismatch: have %v, want %v", err, errReplayOverflow)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("overflowing subscription not ended")
+	}
+}
+
+// Tests that pending transaction subscriptions deliver full transactions matching
+// the server side criteria.
+func TestPendingTxCriteria(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		backend = &testBackend{new(event.TypeMux), db, 0, new(event.Feed), new(event.Feed), new(event.Feed), new(event.Feed)}
+		api     = NewPublicFilterAPI(backend, false, DefaultConfig)
+		signer  = types.LatestSigner(params.TestChainConfig, big.NewInt(1))
+
+		key1, _ = crypto.GenerateKey()
+		key2, _ = crypto.GenerateKey()
+		from1   = crypto.PubkeyToAddress(key1.PublicKey)
+		target  = common.HexToAddress("0x10000")
+		method  = []byte{0xa9, 0x05, 0x9c, 0xbb}
+	)
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatalf("failed to register filter API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	sign := func(key *ecdsa.PrivateKey, to *common.Address, value int64, data []byte) *types.Transaction {
+		var tx *types.Transaction
+		if to == nil {
+			tx = types.NewContractCreation(0, big.NewInt(value), 100000, big.NewInt(1), data)
+		} else {
+			tx = types.NewTransaction(0, *to, big.NewInt(value), 100000, big.NewInt(1), data)
+		}
+		tx, _ = types.SignTx(tx, signer, key)
+		return tx
+	}
+	txs := []*types.Transaction{
+		sign(key1, &target, 100, append(method, 0x01)),           // matches everything
+		sign(key2, &target, 100, append(method, 0x02)),           // wrong sender
+		sign(key1, &target, 1, append(method, 0x03)),             // value too low
+		sign(key1, &target, 100, []byte{0x01, 0x02, 0x03}),       // no method selector
+		sign(key1, nil, 100, append(method, 0x04)),               // contract creation
+		sign(key1, &common.Address{}, 100, append(method, 0x05)), // wrong recipient
+	}
+	crit := &PendingTxCriteria{
+		From:     []common.Address{from1},
+		To:       []common.Address{target},
+		Methods:  []hexutil.Bytes{method},
+		MinValue: (*hexutil.Big)(big.NewInt(50)),
+	}
+	full := make(chan *ethapi.RPCTransaction)
+	fullSub, err := client.EthSubscribe(context.Background(), full, "newPendingTransactions", true, crit)
+	if err != nil {
+		t.Fatalf("failed to subscribe to full transactions: %v", err)
+	}
+	defer fullSub.Unsubscribe()
+
+	hashes := make(chan common.Hash)
+	hashSub, err := client.EthSubscribe(context.Background(), hashes, "newPendingTransactions", false, &PendingTxCriteria{MinValue: (*hexutil.Big)(big.NewInt(50))})
+	if err != nil {
+		t.Fatalf("failed to subscribe to transaction hashes: %v", err)
+	}
+	defer hashSub.Unsubscribe()
+
+	// Wait for the subscriptions to be installed in the event system
+	time.Sleep(100 * time.Millisecond)
+	backend.txFeed.Send(core.NewTxsEvent{Txs: txs})
+
+	select {
+	case tx := <-full:
+		if tx.Hash != txs[0].Hash() || tx.From != from1 {
+			t.Errorf("full transaction mismatch: have %x from %x, want %x from %x", tx.Hash, tx.From, txs[0].Hash(), from1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for full transaction")
+	}
+	for _, i := range []int{0, 1, 3, 4, 5} {
+		select {
+		case hash := <-hashes:
+			if hash != txs[i].Hash() {
+				t.Errorf("transaction hash mismatch: have %x, want %x (#%d)", hash, txs[i].Hash(), i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for transaction hash #%d", i)
+		}
+	}
+	select {
+	case tx := <-full:
+		t.Errorf("unexpected full transaction delivered: %x", tx.Hash)
+	case hash := <-hashes:
+		t.Errorf("unexpected transaction hash delivered: %x", hash)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Malformed method selectors are rejected
+	if _, err := client.EthSubscribe(context.Background(), full, "newPendingTransactions", true, &PendingTxCriteria{Methods: []hexutil.Bytes{{0x01}}}); err == nil {
+		t.Errorf("malformed method selector accepted")
+	}
+}
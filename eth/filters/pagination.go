@@ -0,0 +1,337 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/rlp"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+var (
+	errInvalidCursor  = errors.New("invalid cursor")
+	errCursorMismatch = errors.New("cursor does not match the query")
+)
+
+// Config contains the server side limits of log queries.
+type Config struct {
+	MaxResults   int    // Maximum number of logs returned by a query or a single page (0 = unlimited)
+	MaxBlockSpan uint64 // Maximum number of blocks searched by a query or a single page (0 = unlimited)
+}
+
+// DefaultConfig contains the default limits of log queries.
+var DefaultConfig = Config{
+	MaxResults:   10000,
+	MaxBlockSpan: 100000,
+}
+
+// BlockRange is an inclusive range of blocks to search for logs. Missing bounds
+// default to the latest block.
+type BlockRange struct {
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+}
+
+// PageOptions are the pagination options of a log query.
+type PageOptions struct {
+	Ranges []BlockRange `json:"ranges"` // Ascending, disjoint ranges to search instead of fromBlock/toBlock
+	Cursor string       `json:"cursor"` // Position to resume a previous query at
+	Limit  hexutil.Uint `json:"limit"`  // Maximum number of logs to return (capped by the server)
+}
+
+// LogsPage is a page of the results of a log query. The cursor is empty if the
+// query is exhausted, otherwise it needs to be passed in the options of the next
+// request (along with the same query) to retrieve the following page.
+type LogsPage struct {
+	Logs   []*types.Log `json:"logs"`
+	Cursor string       `json:"cursor,omitempty"`
+}
+
+// pageCursor is the position of a paginated log query: the first log to return
+// is the one at or after the given index within the given block of the given
+// range. Tracking the log index instead of a count keeps the position stable
+// even if the block is reorged between pages. The cursor is bound to the query
+// it was handed out for by a hash of the query's criteria.
+type pageCursor struct {
+	Query common.Hash
+	Range uint64
+	Block uint64
+	Index uint64
+}
+
+// encode serializes the cursor into the opaque token handed out to clients.
+func (c *pageCursor) encode() string {
+	blob, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err) // Can't fail, all fields are plain integers
+	}
+	return hexutil.Encode(blob)
+}
+
+// decodeCursor parses a cursor token handed out by a previous page.
+func decodeCursor(token string) (*pageCursor, error) {
+	blob, err := hexutil.Decode(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	cursor := new(pageCursor)
+	if err := rlp.DecodeBytes(blob, cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// queryHash computes the fingerprint of a paginated log query, covering both
+// the filter criteria and the requested ranges as sent by the client (i.e. the
+// latest and pending blocks not resolved, so the head moving between pages does
+// not invalidate the cursor).
+func queryHash(crit FilterCriteria, ranges []BlockRange) common.Hash {
+	blob, err := json.Marshal(struct {
+		BlockHash *common.Hash
+		FromBlock *big.Int
+		ToBlock   *big.Int
+		Addresses []common.Address
+		Topics    [][]common.Hash
+		Ranges    []BlockRange
+	}{crit.BlockHash, crit.FromBlock, crit.ToBlock, crit.Addresses, crit.Topics, ranges})
+	if err != nil {
+		panic(err) // Can't fail, all fields are plain values
+	}
+	return crypto.Keccak256Hash(blob)
+}
+
+// blockRange is a resolved, inclusive range of block numbers.
+type blockRange struct {
+	from, to uint64
+}
+
+// logsPager iterates over the results of a log query page by page, each page
+// respecting the result and block span limits of the server.
+type logsPager struct {
+	backend   Backend
+	addresses []common.Address
+	topics    [][]common.Hash
+
+	block  *common.Hash // Block hash if paginating a single block
+	ranges []blockRange // Ranges to search if paginating multiple blocks
+
+	limit int    // Maximum number of logs per page (0 = unlimited)
+	span  uint64 // Maximum number of blocks searched per page (0 = unlimited)
+
+	cursor pageCursor // Position of the next page
+	done   bool       // Whether the query is exhausted
+}
+
+// newLogsPager validates a paginated log query and creates a pager positioned
+// at its cursor, resolving the latest and pending block numbers to the current
+// head.
+func newLogsPager(ctx context.Context, backend Backend, config Config, crit FilterCriteria, opts PageOptions) (*logsPager, error) {
+	p := &logsPager{
+		backend:   backend,
+		addresses: crit.Addresses,
+		topics:    crit.Topics,
+		limit:     config.MaxResults,
+		span:      config.MaxBlockSpan,
+	}
+	query := queryHash(crit, opts.Ranges)
+
+	if opts.Limit > 0 && (p.limit == 0 || int(opts.Limit) < p.limit) {
+		p.limit = int(opts.Limit)
+	}
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Query != query {
+			return nil, errCursorMismatch
+		}
+		p.cursor = *cursor
+	}
+	p.cursor.Query = query
+
+	// Single block queries only need a log index as the cursor
+	if crit.BlockHash != nil {
+		if len(opts.Ranges) > 0 {
+			return nil, errors.New("cannot specify both blockHash and ranges, choose one or the other")
+		}
+		if p.cursor.Range != 0 || p.cursor.Block != 0 {
+			return nil, errCursorMismatch
+		}
+		p.block = crit.BlockHash
+		return p, nil
+	}
+	// Range queries need all ranges resolved and validated
+	ranges := opts.Ranges
+	if len(ranges) == 0 {
+		ranges = []BlockRange{{FromBlock: bigToBlockNumber(crit.FromBlock), ToBlock: bigToBlockNumber(crit.ToBlock)}}
+	}
+	header, err := backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errors.New("head header unavailable")
+	}
+	head := header.Number.Uint64()
+
+	for i, r := range ranges {
+		from, to := resolveBlockNumber(r.FromBlock, head), resolveBlockNumber(r.ToBlock, head)
+		if from > to {
+			return nil, fmt.Errorf("invalid range #%d: from block #%d after to block #%d", i, from, to)
+		}
+		if i > 0 && from <= p.ranges[i-1].to {
+			return nil, fmt.Errorf("invalid range #%d: overlaps or precedes range #%d", i, i-1)
+		}
+		p.ranges = append(p.ranges, blockRange{from, to})
+	}
+	if opts.Cursor == "" {
+		p.cursor.Block = p.ranges[0].from
+	} else if p.cursor.Range >= uint64(len(p.ranges)) || p.cursor.Block < p.ranges[p.cursor.Range].from || p.cursor.Block > p.ranges[p.cursor.Range].to {
+		return nil, errCursorMismatch
+	}
+	return p, nil
+}
+
+// bigToBlockNumber converts an optional filter criteria block number into an
+// optional RPC block number.
+func bigToBlockNumber(number *big.Int) *rpc.BlockNumber {
+	if number == nil {
+		return nil
+	}
+	n := rpc.BlockNumber(number.Int64())
+	return &n
+}
+
+// resolveBlockNumber converts an optional RPC block number into an absolute one,
+// missing, latest and pending numbers resolving to the given head.
+func resolveBlockNumber(number *rpc.BlockNumber, head uint64) uint64 {
+	if number == nil || *number < 0 {
+		return head
+	}
+	return uint64(*number)
+}
+
+// next retrieves the following page of logs and advances the cursor.
+func (p *logsPager) next(ctx context.Context) ([]*types.Log, error) {
+	if p.done {
+		return nil, nil
+	}
+	if p.block != nil {
+		return p.nextInBlock(ctx)
+	}
+	var (
+		logs    []*types.Log
+		scanned uint64
+	)
+	for p.cursor.Range < uint64(len(p.ranges)) {
+		// Limit the window to the block span remaining for this page
+		r := p.ranges[p.cursor.Range]
+		end := r.to
+		if p.span > 0 {
+			if scanned >= p.span {
+				break
+			}
+			if left := p.span - scanned; end-p.cursor.Block >= left {
+				end = p.cursor.Block + left - 1
+			}
+		}
+		found, err := NewRangeFilter(p.backend, int64(p.cursor.Block), int64(end), p.addresses, p.topics).Logs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		found = p.skipReturned(found)
+
+		// If the window overflows the page, stop at the first log not returned
+		if p.limit > 0 && len(logs)+len(found) > p.limit {
+			take := p.limit - len(logs)
+			logs = append(logs, found[:take]...)
+
+			next := found[take]
+			p.cursor.Block, p.cursor.Index = next.BlockNumber, uint64(next.Index)
+			return logs, nil
+		}
+		logs = append(logs, found...)
+		scanned += end - p.cursor.Block + 1
+
+		// Window fully consumed, move the cursor past it
+		p.cursor.Index = 0
+		if end < r.to {
+			p.cursor.Block = end + 1
+		} else if p.cursor.Range++; p.cursor.Range < uint64(len(p.ranges)) {
+			p.cursor.Block = p.ranges[p.cursor.Range].from
+		}
+		if p.limit > 0 && len(logs) == p.limit {
+			break
+		}
+	}
+	p.done = p.cursor.Range >= uint64(len(p.ranges))
+	return logs, nil
+}
+
+// nextInBlock retrieves the following page of logs of a single block query.
+func (p *logsPager) nextInBlock(ctx context.Context) ([]*types.Log, error) {
+	found, err := NewBlockFilter(p.backend, *p.block, p.addresses, p.topics).Logs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var logs []*types.Log
+	for _, log := range found {
+		if uint64(log.Index) < p.cursor.Index {
+			continue
+		}
+		if p.limit > 0 && len(logs) == p.limit {
+			p.cursor.Index = uint64(log.Index)
+			return logs, nil
+		}
+		logs = append(logs, log)
+	}
+	p.done = true
+	return logs, nil
+}
+
+// skipReturned drops the logs of the cursor's block that were already returned
+// in a previous page.
+func (p *logsPager) skipReturned(logs []*types.Log) []*types.Log {
+	for len(logs) > 0 && logs[0].BlockNumber == p.cursor.Block && uint64(logs[0].Index) < p.cursor.Index {
+		logs = logs[1:]
+	}
+	return logs
+}
+
+// page retrieves the following page of logs, packaged with the cursor to resume
+// the query at.
+func (p *logsPager) page(ctx context.Context) (*LogsPage, error) {
+	logs, err := p.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	page := &LogsPage{Logs: returnLogs(logs)}
+	if !p.done {
+		page.Cursor = p.cursor.encode()
+	}
+	return page, nil
+}
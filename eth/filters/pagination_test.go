@@ -0,0 +1,192 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/consensus/ethash"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/event"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+// newPaginationBackend creates a backend with a chain of 20 blocks, blocks #2, #6,
+// #12 and #18 containing a single log and block #5 containing three.
+func newPaginationBackend(t *testing.T) (*testBackend, []*types.Block) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		backend = &testBackend{new(event.TypeMux), db, 0, new(event.Feed), new(event.Feed), new(event.Feed), new(event.Feed)}
+		addr    = common.HexToAddress("0x10000")
+		counts  = map[int]int{2: 1, 5: 3, 6: 1, 12: 1, 18: 1}
+	)
+	genesis := core.GenesisBlockForTesting(db, addr, big.NewInt(1000000))
+	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 20, func(i int, gen *core.BlockGen) {
+		for j := 0; j < counts[i+1]; j++ {
+			receipt := types.NewReceipt(nil, false, 0)
+			receipt.Logs = []*types.Log{{Address: addr, BlockNumber: uint64(i + 1), Index: uint(j)}}
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+			gen.AddUncheckedReceipt(receipt)
+		}
+	})
+	for i, block := range chain {
+		for _, receipt := range receipts[i] {
+			for _, log := range receipt.Logs {
+				log.BlockHash = block.Hash()
+			}
+		}
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		rawdb.WriteHeadBlockHash(db, block.Hash())
+		rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+	}
+	return backend, chain
+}
+
+// logPositions flattens a list of logs into block number and log index pairs.
+func logPositions(logs []*types.Log) []string {
+	positions := make([]string, len(logs))
+	for i, log := range logs {
+		positions[i] = fmt.Sprintf("%d:%d", log.BlockNumber, log.Index)
+	}
+	return positions
+}
+
+// Tests that log queries are paginated within the result and block span limits,
+// the cursors resuming exactly where the previous pages stopped.
+func TestLogsPagination(t *testing.T) {
+	backend, chain := newPaginationBackend(t)
+	api := NewPublicFilterAPI(backend, false, Config{MaxResults: 2, MaxBlockSpan: 8})
+
+	// collect retrieves all the pages of a query, returning the sizes of the pages
+	// and the positions of the logs
+	collect := func(crit FilterCriteria, opts PageOptions) ([]int, []string) {
+		var (
+			sizes []int
+			logs  []*types.Log
+		)
+		for {
+			page, err := api.GetLogsPage(context.Background(), crit, &opts)
+			if err != nil {
+				t.Fatalf("failed to retrieve page %d: %v", len(sizes), err)
+			}
+			sizes = append(sizes, len(page.Logs))
+			logs = append(logs, page.Logs...)
+			if page.Cursor == "" {
+				return sizes, logPositions(logs)
+			}
+			opts.Cursor = page.Cursor
+		}
+	}
+	number := func(n int64) *rpc.BlockNumber {
+		bn := rpc.BlockNumber(n)
+		return &bn
+	}
+	// Full chain: pages cut by the result limit mid-block and by the span limit
+	sizes, logs := collect(FilterCriteria{FromBlock: big.NewInt(0)}, PageOptions{})
+	if want := []int{2, 2, 2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("page sizes mismatch: have %v, want %v", sizes, want)
+	}
+	if want := []string{"2:0", "5:0", "5:1", "5:2", "6:0", "12:0", "18:0"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("logs mismatch: have %v, want %v", logs, want)
+	}
+	// Multiple ranges, skipping the blocks in between
+	_, logs = collect(FilterCriteria{}, PageOptions{Ranges: []BlockRange{{number(1), number(5)}, {number(12), nil}}})
+	if want := []string{"2:0", "5:0", "5:1", "5:2", "12:0", "18:0"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("multi-range logs mismatch: have %v, want %v", logs, want)
+	}
+	// Requested limits below the server's
+	sizes, _ = collect(FilterCriteria{FromBlock: big.NewInt(5), ToBlock: big.NewInt(5)}, PageOptions{Limit: 1})
+	if want := []int{1, 1, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("limited page sizes mismatch: have %v, want %v", sizes, want)
+	}
+	// Single block queries
+	hash := chain[4].Hash()
+	sizes, logs = collect(FilterCriteria{BlockHash: &hash}, PageOptions{})
+	if want := []int{2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("block page sizes mismatch: have %v, want %v", sizes, want)
+	}
+	if want := []string{"5:0", "5:1", "5:2"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("block logs mismatch: have %v, want %v", logs, want)
+	}
+	// Invalid queries
+	invalid := []struct {
+		crit FilterCriteria
+		opts PageOptions
+	}{
+		{FilterCriteria{}, PageOptions{Cursor: "0xzz"}},
+		{FilterCriteria{}, PageOptions{Cursor: (&pageCursor{Range: 1}).encode()}},
+		{FilterCriteria{FromBlock: big.NewInt(5), ToBlock: big.NewInt(2)}, PageOptions{}},
+		{FilterCriteria{}, PageOptions{Ranges: []BlockRange{{number(1), number(5)}, {number(5), number(8)}}}},
+		{FilterCriteria{BlockHash: &hash}, PageOptions{Ranges: []BlockRange{{number(1), number(5)}}}},
+	}
+	for i, tt := range invalid {
+		if _, err := api.GetLogsPage(context.Background(), tt.crit, &tt.opts); err == nil {
+			t.Errorf("invalid query %d accepted", i)
+		}
+	}
+	// Cursors handed out for a different query
+	crit := FilterCriteria{FromBlock: big.NewInt(0)}
+	page, err := api.GetLogsPage(context.Background(), crit, &PageOptions{})
+	if err != nil {
+		t.Fatalf("failed to retrieve first page: %v", err)
+	}
+	mismatched := []struct {
+		crit FilterCriteria
+		opts PageOptions
+	}{
+		{FilterCriteria{FromBlock: big.NewInt(1)}, PageOptions{Cursor: page.Cursor}},
+		{FilterCriteria{FromBlock: big.NewInt(0), Addresses: []common.Address{{0x01}}}, PageOptions{Cursor: page.Cursor}},
+		{FilterCriteria{FromBlock: big.NewInt(0), Topics: [][]common.Hash{{{0x01}}}}, PageOptions{Cursor: page.Cursor}},
+		{crit, PageOptions{Cursor: page.Cursor, Ranges: []BlockRange{{number(0), nil}}}},
+		{crit, PageOptions{Cursor: (&pageCursor{Block: 5}).encode()}},
+	}
+	for i, tt := range mismatched {
+		if _, err := api.GetLogsPage(context.Background(), tt.crit, &tt.opts); err != errCursorMismatch {
+			t.Errorf("mismatched cursor %d: error mismatch: have %v, want %v", i, err, errCursorMismatch)
+		}
+	}
+}
+
+// Tests that unpaginated log queries exceeding the server limits are rejected.
+func TestGetLogsLimits(t *testing.T) {
+	backend, _ := newPaginationBackend(t)
+	api := NewPublicFilterAPI(backend, false, Config{MaxResults: 2, MaxBlockSpan: 8})
+
+	if _, err := api.GetLogs(context.Background(), FilterCriteria{FromBlock: big.NewInt(10)}); err == nil {
+		t.Errorf("query exceeding the block span accepted")
+	}
+	if _, err := api.GetLogs(context.Background(), FilterCriteria{FromBlock: big.NewInt(0), ToBlock: big.NewInt(7)}); err == nil {
+		t.Errorf("query exceeding the result limit accepted")
+	}
+	logs, err := api.GetLogs(context.Background(), FilterCriteria{FromBlock: big.NewInt(6), ToBlock: big.NewInt(13)})
+	if err != nil {
+		t.Fatalf("failed to retrieve logs within limits: %v", err)
+	}
+	if want := []string{"6:0", "12:0"}; !reflect.DeepEqual(logPositions(logs), want) {
+		t.Errorf("logs mismatch: have %v, want %v", logPositions(logs), want)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+const (
+	// maxFeeHistory is the maximum number of blocks a single fee history request
+	// may cover, larger requests being truncated to the most recent blocks.
+	maxFeeHistory = 1024
+
+	// maxRewardPercentiles is the maximum number of reward percentiles a single
+	// fee history request may ask for.
+	maxRewardPercentiles = 100
+
+	// maxBlockFetchers is the maximum number of blocks retrieved concurrently by
+	// a single fee history request.
+	maxBlockFetchers = 4
+)
+
+var errRequestBeyondHead = errors.New("request beyond head block")
+
+// blockFees is the fee summary of a single block.
+type blockFees struct {
+	gasUsedRatio float64    // Gas used by the block relative to its gas limit
+	rewards      []*big.Int // Gas prices paid at the requested percentiles of the gas used
+	empty        bool       // Whether the block contains no transactions
+}
+
+// txGasAndPrice is the gas used and the gas price paid by a single transaction.
+type txGasAndPrice struct {
+	gasUsed uint64
+	price   *big.Int
+}
+
+// FeeHistory returns the fee summaries of a range of blocks ending at lastBlock:
+// the number of the oldest block in the range, the gas prices paid at the given
+// percentiles of each block's gas used (the percentiles being weighted by gas
+// used, not by transaction count) and the ratio of gas used to gas limit of each
+// block. The pending block is served from the latest one.
+//
+// Requests reaching further back than the genesis or maxFeeHistory blocks are
+// truncated to the most recent blocks.
+func (gpo *Oracle) FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*big.Int, [][]*big.Int, []float64, error) {
+	oldest, fees, err := gpo.feeHistory(ctx, blocks, lastBlock, percentiles)
+	if err != nil || len(fees) == 0 {
+		return oldest, nil, nil, err
+	}
+	var (
+		rewards [][]*big.Int
+		ratios  = make([]float64, len(fees))
+	)
+	if len(percentiles) > 0 {
+		rewards = make([][]*big.Int, len(fees))
+	}
+	for i, fee := range fees {
+		ratios[i] = fee.gasUsedRatio
+		if rewards != nil {
+			rewards[i] = fee.rewards
+		}
+	}
+	return oldest, rewards, ratios, nil
+}
+
+// feeHistory retrieves the fee summaries of a range of blocks ending at lastBlock,
+// along with the number of the oldest one.
+func (gpo *Oracle) feeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*big.Int, []*blockFees, error) {
+	if len(percentiles) > maxRewardPercentiles {
+		return nil, nil, fmt.Errorf("too many reward percentiles: have %d, max %d", len(percentiles), maxRewardPercentiles)
+	}
+	for i, p := range percentiles {
+		if p < 0 || p > 100 {
+			return nil, nil, fmt.Errorf("invalid reward percentile #%d: %f", i, p)
+		}
+		if i > 0 && p < percentiles[i-1] {
+			return nil, nil, fmt.Errorf("invalid reward percentile #%d: %f < #%d: %f", i, p, i-1, percentiles[i-1])
+		}
+	}
+	if blocks < 1 {
+		return new(big.Int), nil, nil
+	}
+	if blocks > maxFeeHistory {
+		blocks = maxFeeHistory
+	}
+	// Resolve the last block of the range, serving pending from the latest
+	head, err := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+	if head == nil {
+		return nil, nil, errors.New("head header unavailable")
+	}
+	last := head.Number.Uint64()
+	switch lastBlock {
+	case rpc.PendingBlockNumber, rpc.LatestBlockNumber:
+	case rpc.EarliestBlockNumber:
+		last = 0
+	default:
+		if uint64(lastBlock) > last {
+			return nil, nil, fmt.Errorf("%v: requested #%d, head #%d", errRequestBeyondHead, lastBlock, last)
+		}
+		last = uint64(lastBlock)
+	}
+	if uint64(blocks) > last+1 {
+		blocks = int(last + 1)
+	}
+	oldest := last + 1 - uint64(blocks)
+
+	// Retrieve the blocks on a few concurrent fetchers, the results being independent
+	type result struct {
+		index int
+		fees  *blockFees
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		tasks   = make(chan int, blocks)
+		results = make(chan result, blocks)
+	)
+	for i := 0; i < blocks; i++ {
+		tasks <- i
+	}
+	close(tasks)
+
+	fetchers := maxBlockFetchers
+	if blocks < fetchers {
+		fetchers = blocks
+	}
+	for i := 0; i < fetchers; i++ {
+		go func() {
+			for index := range tasks {
+				if err := ctx.Err(); err != nil {
+					results <- result{index, nil, err}
+					continue
+				}
+				fees, err := gpo.blockFees(ctx, rpc.BlockNumber(oldest+uint64(index)), percentiles)
+				results <- result{index, fees, err}
+			}
+		}()
+	}
+	fees := make([]*blockFees, blocks)
+	for i := 0; i < blocks; i++ {
+		res := <-results
+		if res.err != nil {
+			return nil, nil, res.err
+		}
+		fees[res.index] = res.fees
+	}
+	return new(big.Int).SetUint64(oldest), fees, nil
+}
+
+// blockFees summarises the fees paid in a single block. The receipts are only
+// retrieved if reward percentiles were requested.
+func (gpo *Oracle) blockFees(ctx context.Context, number rpc.BlockNumber, percentiles []float64) (*blockFees, error) {
+	if len(percentiles) == 0 {
+		header, err := gpo.backend.HeaderByNumber(ctx, number)
+		if header == nil {
+			return nil, headerUnavailable(number, err)
+		}
+		return &blockFees{gasUsedRatio: gasUsedRatio(header), empty: header.GasUsed == 0}, nil
+	}
+	block, err := gpo.backend.BlockByNumber(ctx, number)
+	if block == nil {
+		return nil, headerUnavailable(number, err)
+	}
+	fees := &blockFees{
+		gasUsedRatio: gasUsedRatio(block.Header()),
+		rewards:      make([]*big.Int, len(percentiles)),
+		empty:        len(block.Transactions()) == 0,
+	}
+	if fees.empty {
+		for i := range fees.rewards {
+			fees.rewards[i] = new(big.Int)
+		}
+		return fees, nil
+	}
+	receipts, err := gpo.backend.GetReceipts(ctx, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	if len(receipts) != len(block.Transactions()) {
+		return nil, fmt.Errorf("receipts of block #%d unavailable", block.NumberU64())
+	}
+	txs := make([]txGasAndPrice, len(receipts))
+	for i, tx := range block.Transactions() {
+		txs[i] = txGasAndPrice{gasUsed: receipts[i].GasUsed, price: tx.GasPrice()}
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].price.Cmp(txs[j].price) < 0 })
+
+	for i, price := range gasWeightedPercentiles(txs, block.GasUsed(), percentiles) {
+		fees.rewards[i] = new(big.Int).Set(price)
+	}
+	return fees, nil
+}
+
+// gasWeightedPercentiles returns the prices of a set of transactions sorted by
+// price at the given percentiles of the total gas they use.
+func gasWeightedPercentiles(txs []txGasAndPrice, totalGas uint64, percentiles []float64) []*big.Int {
+	prices := make([]*big.Int, len(percentiles))
+	if len(txs) == 0 {
+		return prices
+	}
+	index, sumGas := 0, txs[0].gasUsed
+	for i, p := range percentiles {
+		threshold := uint64(float64(totalGas) * p / 100)
+		for sumGas < threshold && index < len(txs)-1 {
+			index++
+			sumGas += txs[index].gasUsed
+		}
+		prices[i] = txs[index].price
+	}
+	return prices
+}
+
+// gasUsedRatio returns the ratio of gas used to gas limit of a block.
+func gasUsedRatio(header *types.Header) float64 {
+	if header.GasLimit == 0 {
+		return 0
+	}
+	return float64(header.GasUsed) / float64(header.GasLimit)
+}
+
+// headerUnavailable assembles the error returned for a block missing from the
+// local chain.
+func headerUnavailable(number rpc.BlockNumber, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("block #%d unavailable", number)
+}
+
+// SuggestPrices returns a slow, a standard and a fast gas price recommendation,
+// taken at the configured percentiles of the gas prices paid in recent blocks.
+// The history is blended with the prices offered by the transactions pending in
+// the pool at the same percentiles, weighing in more the more congested the pool
+// is, up to an even mix once a full block's worth of gas is pending.
+func (gpo *Oracle) SuggestPrices(ctx context.Context) (slow, standard, fast *big.Int, err error) {
+	percentiles := []float64{float64(gpo.slowPercentile), float64(gpo.percentile), float64(gpo.fastPercentile)}
+
+	_, history, err := gpo.feeHistory(ctx, gpo.checkBlocks, rpc.LatestBlockNumber, percentiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Take the median of each tier across the recent non-empty blocks
+	gpo.cacheLock.RLock()
+	fallback := gpo.lastPrice
+	gpo.cacheLock.RUnlock()
+
+	prices := make([]*big.Int, len(percentiles))
+	for i := range percentiles {
+		var tier []*big.Int
+		for _, fees := range history {
+			if !fees.empty {
+				tier = append(tier, fees.rewards[i])
+			}
+		}
+		if len(tier) == 0 {
+			prices[i] = fallback
+			continue
+		}
+		sort.Sort(bigIntArray(tier))
+		prices[i] = tier[len(tier)/2]
+	}
+	// Blend in the prices of the pending transactions, weighted by congestion
+	pending, err := gpo.backend.GetPoolTransactions()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	head, err := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(pending) > 0 && head != nil && head.GasLimit > 0 {
+		var (
+			txs      = make([]txGasAndPrice, len(pending))
+			totalGas uint64
+		)
+		for i, tx := range pending {
+			txs[i] = txGasAndPrice{gasUsed: tx.Gas(), price: tx.GasPrice()}
+			totalGas += tx.Gas()
+		}
+		sort.Slice(txs, func(i, j int) bool { return txs[i].price.Cmp(txs[j].price) < 0 })
+
+		// The weight of the pool is in per mille, capped to an even mix
+		weight := uint64(500)
+		if totalGas < head.GasLimit {
+			weight = totalGas * 500 / head.GasLimit
+		}
+		for i, price := range gasWeightedPercentiles(txs, totalGas, percentiles) {
+			if prices[i] == nil {
+				prices[i] = price
+				continue
+			}
+			blended := new(big.Int).Mul(prices[i], new(big.Int).SetUint64(1000-weight))
+			blended.Add(blended, new(big.Int).Mul(price, new(big.Int).SetUint64(weight)))
+			prices[i] = blended.Div(blended, big.NewInt(1000))
+		}
+	}
+	for i, price := range prices {
+		if price == nil {
+			prices[i] = new(big.Int)
+		} else if price.Cmp(maxPrice) > 0 {
+			prices[i] = new(big.Int).Set(maxPrice)
+		}
+	}
+	return prices[0], prices[1], prices[2], nil
+}
@@ -24,7 +24,6 @@ import (
 
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/core/types"
-	"github.com/simplechain-org/simplechain/internal/ethapi"
 	"github.com/simplechain-org/simplechain/params"
 	"github.com/simplechain-org/simplechain/rpc"
 )
@@ -32,15 +31,26 @@ import (
 var maxPrice = big.NewInt(500 * params.GWei)
 
 type Config struct {
-	Blocks     int
-	Percentile int
-	Default    *big.Int `toml:",omitempty"`
+	Blocks         int
+	Percentile     int
+	SlowPercentile int      // Percentile of the slow gas price suggestion (at most Percentile, negative = Percentile)
+	FastPercentile int      // Percentile of the fast gas price suggestion (at least Percentile, negative = Percentile)
+	Default        *big.Int `toml:",omitempty"`
+}
+
+// OracleBackend includes all necessary background APIs for the oracle.
+type OracleBackend interface {
+	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
+	BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Block, error)
+	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
+	GetPoolTransactions() (types.Transactions, error)
+	ChainConfig() *params.ChainConfig
 }
 
 // Oracle recommends gas prices based on the content of recent
 // blocks. Suitable for both light and full clients.
 type Oracle struct {
-	backend   ethapi.Backend
+	backend   OracleBackend
 	lastHead  common.Hash
 	lastPrice *big.Int
 	cacheLock sync.RWMutex
@@ -48,29 +58,48 @@ type Oracle struct {
 
 	checkBlocks, maxEmpty, maxBlocks int
 	percentile                       int
+	slowPercentile, fastPercentile   int
 }
 
 // NewOracle returns a new oracle.
-func NewOracle(backend ethapi.Backend, params Config) *Oracle {
+func NewOracle(backend OracleBackend, params Config) *Oracle {
 	blocks := params.Blocks
 	if blocks < 1 {
 		blocks = 1
 	}
-	percent := params.Percentile
-	if percent < 0 {
-		percent = 0
+	percent := clampPercentile(params.Percentile, 0)
+
+	// The slow and fast suggestions fall back to the standard one if unset (negative)
+	slow := clampPercentile(params.SlowPercentile, percent)
+	if slow > percent {
+		slow = percent
 	}
-	if percent > 100 {
-		percent = 100
+	fast := clampPercentile(params.FastPercentile, percent)
+	if fast < percent {
+		fast = percent
 	}
 	return &Oracle{
-		backend:     backend,
-		lastPrice:   params.Default,
-		checkBlocks: blocks,
-		maxEmpty:    blocks / 2,
-		maxBlocks:   blocks * 5,
-		percentile:  percent,
+		backend:        backend,
+		lastPrice:      params.Default,
+		checkBlocks:    blocks,
+		maxEmpty:       blocks / 2,
+		maxBlocks:      blocks * 5,
+		percentile:     percent,
+		slowPercentile: slow,
+		fastPercentile: fast,
+	}
+}
+
+// clampPercentile caps a configured percentile into [0, 100], substituting the
+// given fallback for unset (negative) values.
+func clampPercentile(percent int, fallback int) int {
+	switch {
+	case percent < 0:
+		return fallback
+	case percent > 100:
+		return 100
 	}
+	return percent
 }
 
 // SuggestPrice returns the recommended gas price.
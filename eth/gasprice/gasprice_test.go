@@ -0,0 +1,230 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/consensus/ethash"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+)
+
+// testBackend is an oracle backend serving a local chain and a fixed set of
+// pending transactions.
+type testBackend struct {
+	chain   *core.BlockChain
+	pending types.Transactions
+
+	fetching    int32 // Number of block retrievals in progress
+	maxFetching int32 // Peak number of concurrent block retrievals
+}
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
+		return b.chain.CurrentHeader(), nil
+	}
+	return b.chain.GetHeaderByNumber(uint64(number)), nil
+}
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	fetching := atomic.AddInt32(&b.fetching, 1)
+	defer atomic.AddInt32(&b.fetching, -1)
+	for peak := atomic.LoadInt32(&b.maxFetching); fetching > peak; peak = atomic.LoadInt32(&b.maxFetching) {
+		if atomic.CompareAndSwapInt32(&b.maxFetching, peak, fetching) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond) // Give the other fetchers a chance to overlap
+
+	if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
+		return b.chain.CurrentBlock(), nil
+	}
+	return b.chain.GetBlockByNumber(uint64(number)), nil
+}
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.chain.GetReceiptsByHash(hash), nil
+}
+
+func (b *testBackend) GetPoolTransactions() (types.Transactions, error) {
+	return b.pending, nil
+}
+
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return b.chain.Config()
+}
+
+// newTestBackend creates a chain of 8 blocks, block #n containing three value
+// transfers priced at n, 2n and 3n gwei, except for the last one which is empty.
+func newTestBackend(t *testing.T) *testBackend {
+	var (
+		db    = ethdb.NewMemDatabase()
+		gspec = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{testAddress: {Balance: big.NewInt(params.Ether)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 8, func(i int, b *core.BlockGen) {
+		if i == 7 {
+			return
+		}
+		for j := 1; j <= 3; j++ {
+			price := big.NewInt(int64((i+1)*j) * params.GWei)
+			tx := types.NewTransaction(b.TxNonce(testAddress), common.HexToAddress("0x10000"), big.NewInt(1), params.TxGas, price, nil)
+			tx, _ = types.SignTx(tx, signer, testKey)
+			b.AddTx(tx)
+		}
+	})
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	return &testBackend{chain: chain}
+}
+
+func gwei(n int64) *big.Int {
+	return big.NewInt(n * params.GWei)
+}
+
+// Tests that the fee history reports the gas used ratios and the gas weighted
+// reward percentiles of the requested block range.
+func TestFeeHistory(t *testing.T) {
+	backend := newTestBackend(t)
+	oracle := NewOracle(backend, Config{Blocks: 5, Percentile: 50})
+
+	oldest, rewards, ratios, err := oracle.FeeHistory(context.Background(), 4, rpc.LatestBlockNumber, []float64{0, 50, 100})
+	if err != nil {
+		t.Fatalf("failed to retrieve fee history: %v", err)
+	}
+	if oldest.Uint64() != 5 {
+		t.Fatalf("oldest block mismatch: have %v, want 5", oldest)
+	}
+	if len(rewards) != 4 || len(ratios) != 4 {
+		t.Fatalf("history length mismatch: have %d/%d, want 4/4", len(rewards), len(ratios))
+	}
+
+	for i := 0; i < 4; i++ {
+		number := int64(5 + i)
+		header := backend.chain.GetHeaderByNumber(uint64(number))
+		if want := float64(header.GasUsed) / float64(header.GasLimit); ratios[i] != want {
+			t.Errorf("block #%d: gas used ratio mismatch: have %v, want %v", number, ratios[i], want)
+		}
+		want := []*big.Int{gwei(number), gwei(2 * number), gwei(3 * number)}
+		if number == 8 {
+			want = []*big.Int{new(big.Int), new(big.Int), new(big.Int)}
+		}
+		for j := range want {
+			if rewards[i][j].Cmp(want[j]) != 0 {
+				t.Errorf("block #%d: reward #%d mismatch: have %v, want %v", number, j, rewards[i][j], want[j])
+			}
+		}
+	}
+	// Ensure long ranges are retrieved by a bounded number of fetchers
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 9, rpc.LatestBlockNumber, []float64{50}); err != nil {
+		t.Fatalf("failed to retrieve full fee history: %v", err)
+	}
+	if peak := atomic.LoadInt32(&backend.maxFetching); peak > maxBlockFetchers {
+		t.Errorf("concurrent block retrievals exceeded: have %d, want at most %d", peak, maxBlockFetchers)
+	}
+	// Ensure rewards are omitted if not requested and ranges are truncated at genesis
+	oldest, rewards, ratios, err = oracle.FeeHistory(context.Background(), 100, 2, nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve truncated fee history: %v", err)
+	}
+	if oldest.Uint64() != 0 || rewards != nil || len(ratios) != 3 {
+		t.Fatalf("truncated history mismatch: have oldest %v, %d rewards, %d ratios; want 0, 0, 3", oldest, len(rewards), len(ratios))
+	}
+	// Ensure invalid requests are rejected
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, 9, nil); err == nil {
+		t.Errorf("request beyond head accepted")
+	}
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, rpc.LatestBlockNumber, []float64{50, 10}); err == nil {
+		t.Errorf("unordered percentiles accepted")
+	}
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, rpc.LatestBlockNumber, []float64{101}); err == nil {
+		t.Errorf("out of range percentile accepted")
+	}
+}
+
+// Tests that the tiered price suggestions are taken from recent non-empty blocks
+// and blended with the prices of the pending transactions.
+func TestSuggestPrices(t *testing.T) {
+	backend := newTestBackend(t)
+	oracle := NewOracle(backend, Config{Blocks: 5, Percentile: 50, SlowPercentile: 10, FastPercentile: 100})
+
+	// Blocks #4-#7 are non-empty, their median pricing being that of block #6
+	slow, standard, fast, err := oracle.SuggestPrices(context.Background())
+	if err != nil {
+		t.Fatalf("failed to suggest prices: %v", err)
+	}
+	if slow.Cmp(gwei(6)) != 0 || standard.Cmp(gwei(12)) != 0 || fast.Cmp(gwei(18)) != 0 {
+		t.Fatalf("history prices mismatch: have %v/%v/%v, want %v/%v/%v", slow, standard, fast, gwei(6), gwei(12), gwei(18))
+	}
+	// A full block's worth of pending gas is mixed in evenly
+	limit := backend.chain.CurrentHeader().GasLimit
+	backend.pending = types.Transactions{types.NewTransaction(0, common.Address{}, nil, limit, gwei(100), nil)}
+
+	slow, standard, fast, err = oracle.SuggestPrices(context.Background())
+	if err != nil {
+		t.Fatalf("failed to suggest congested prices: %v", err)
+	}
+	if slow.Cmp(gwei(53)) != 0 || standard.Cmp(gwei(56)) != 0 || fast.Cmp(gwei(59)) != 0 {
+		t.Fatalf("congested prices mismatch: have %v/%v/%v, want %v/%v/%v", slow, standard, fast, gwei(53), gwei(56), gwei(59))
+	}
+}
+
+// Tests that zero is honoured as a configured percentile, only negative ones
+// falling back to the standard suggestion.
+func TestPercentileLimits(t *testing.T) {
+	tests := []struct {
+		config     Config
+		slow, fast int
+	}{
+		{Config{Percentile: 50, SlowPercentile: 0, FastPercentile: 100}, 0, 100},
+		{Config{Percentile: 50, SlowPercentile: -1, FastPercentile: -1}, 50, 50},
+		{Config{Percentile: 50, SlowPercentile: 70, FastPercentile: 10}, 50, 50},
+		{Config{Percentile: 0, SlowPercentile: 0, FastPercentile: 0}, 0, 0},
+		{Config{Percentile: 50, SlowPercentile: -1, FastPercentile: 200}, 50, 100},
+	}
+	for i, tt := range tests {
+		oracle := NewOracle(nil, tt.config)
+		if oracle.slowPercentile != tt.slow || oracle.fastPercentile != tt.fast {
+			t.Errorf("test %d: percentiles mismatch: have %d/%d, want %d/%d", i, oracle.slowPercentile, oracle.fastPercentile, tt.slow, tt.fast)
+		}
+	}
+}
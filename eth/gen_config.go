@@ -11,6 +11,7 @@ import (
 	"github.com/simplechain-org/simplechain/consensus/ethash"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/eth/downloader"
+	"github.com/simplechain-org/simplechain/eth/filters"
 	"github.com/simplechain-org/simplechain/eth/gasprice"
 )
 
@@ -23,10 +24,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
 		NoPruning               bool
-		LightServ               int  `toml:",omitempty"`
-		LightPeers              int  `toml:",omitempty"`
-		SkipBcVersionCheck      bool `toml:"-"`
-		DatabaseHandles         int  `toml:"-"`
+		LightServ               int    `toml:",omitempty"`
+		LightPeers              int    `toml:",omitempty"`
+		UploadLimit             uint64 `toml:",omitempty"`
+		DownloadLimit           uint64 `toml:",omitempty"`
+		LightPropagation        bool   `toml:",omitempty"`
+		SkipBcVersionCheck      bool   `toml:"-"`
+		DatabaseHandles         int    `toml:"-"`
 		DatabaseCache           int
 		TrieCleanCache          int
 		TrieDirtyCache          int
@@ -42,7 +46,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		Ethash                  ethash.Config
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
+		Filters                 filters.Config
 		EnablePreimageRecording bool
+		BlockProfiling          bool
+		ParallelTxExecution     bool
+		InternalTransferIndex   bool
+		TraceFilterMaxSpan      uint64 `toml:",omitempty"`
 		DocRoot                 string `toml:"-"`
 		EWASMInterpreter        string
 		EVMInterpreter          string
@@ -54,6 +63,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.NoPruning = c.NoPruning
 	enc.LightServ = c.LightServ
 	enc.LightPeers = c.LightPeers
+	enc.UploadLimit = c.UploadLimit
+	enc.DownloadLimit = c.DownloadLimit
+	enc.LightPropagation = c.LightPropagation
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
@@ -71,7 +83,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.Ethash = c.Ethash
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
+	enc.Filters = c.Filters
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.BlockProfiling = c.BlockProfiling
+	enc.ParallelTxExecution = c.ParallelTxExecution
+	enc.InternalTransferIndex = c.InternalTransferIndex
+	enc.TraceFilterMaxSpan = c.TraceFilterMaxSpan
 	enc.DocRoot = c.DocRoot
 	enc.EWASMInterpreter = c.EWASMInterpreter
 	enc.EVMInterpreter = c.EVMInterpreter
@@ -85,10 +102,13 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
 		NoPruning               *bool
-		LightServ               *int  `toml:",omitempty"`
-		LightPeers              *int  `toml:",omitempty"`
-		SkipBcVersionCheck      *bool `toml:"-"`
-		DatabaseHandles         *int  `toml:"-"`
+		LightServ               *int    `toml:",omitempty"`
+		LightPeers              *int    `toml:",omitempty"`
+		UploadLimit             *uint64 `toml:",omitempty"`
+		DownloadLimit           *uint64 `toml:",omitempty"`
+		LightPropagation        *bool   `toml:",omitempty"`
+		SkipBcVersionCheck      *bool   `toml:"-"`
+		DatabaseHandles         *int    `toml:"-"`
 		DatabaseCache           *int
 		TrieCleanCache          *int
 		TrieDirtyCache          *int
@@ -104,7 +124,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		Ethash                  *ethash.Config
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
+		Filters                 *filters.Config
 		EnablePreimageRecording *bool
+		BlockProfiling          *bool
+		ParallelTxExecution     *bool
+		InternalTransferIndex   *bool
+		TraceFilterMaxSpan      *uint64 `toml:",omitempty"`
 		DocRoot                 *string `toml:"-"`
 		EWASMInterpreter        *string
 		EVMInterpreter          *string
@@ -131,6 +156,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.LightPeers != nil {
 		c.LightPeers = *dec.LightPeers
 	}
+	if dec.UploadLimit != nil {
+		c.UploadLimit = *dec.UploadLimit
+	}
+	if dec.DownloadLimit != nil {
+		c.DownloadLimit = *dec.DownloadLimit
+	}
+	if dec.LightPropagation != nil {
+		c.LightPropagation = *dec.LightPropagation
+	}
 	if dec.SkipBcVersionCheck != nil {
 		c.SkipBcVersionCheck = *dec.SkipBcVersionCheck
 	}
@@ -182,9 +216,24 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.GPO != nil {
 		c.GPO = *dec.GPO
 	}
+	if dec.Filters != nil {
+		c.Filters = *dec.Filters
+	}
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.BlockProfiling != nil {
+		c.BlockProfiling = *dec.BlockProfiling
+	}
+	if dec.ParallelTxExecution != nil {
+		c.ParallelTxExecution = *dec.ParallelTxExecution
+	}
+	if dec.InternalTransferIndex != nil {
+		c.InternalTransferIndex = *dec.InternalTransferIndex
+	}
+	if dec.TraceFilterMaxSpan != nil {
+		c.TraceFilterMaxSpan = *dec.TraceFilterMaxSpan
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
@@ -46,8 +46,8 @@ func TestProtocolCompatibility(t *testing.T) {
 		mode       downloader.SyncMode
 		compatible bool
 	}{
-		{61, downloader.FullSync, true}, {62, downloader.FullSync, true}, {63, downloader.FullSync, true},
-		{61, downloader.FastSync, false}, {62, downloader.FastSync, false}, {63, downloader.FastSync, true},
+		{61, downloader.FullSync, true}, {62, downloader.FullSync, true}, {63, downloader.FullSync, true}, {64, downloader.FullSync, true},
+		{61, downloader.FastSync, false}, {62, downloader.FastSync, false}, {63, downloader.FastSync, true}, {64, downloader.FastSync, true},
 	}
 	// Make sure anything we screw up is restored
 	backup := ProtocolVersions
@@ -70,6 +70,7 @@ func TestProtocolCompatibility(t *testing.T) {
 // Tests that block headers can be retrieved from a remote chain based on user queries.
 func TestGetBlockHeaders62(t *testing.T) { testGetBlockHeaders(t, 62) }
 func TestGetBlockHeaders63(t *testing.T) { testGetBlockHeaders(t, 63) }
+func TestGetBlockHeaders64(t *testing.T) { testGetBlockHeaders(t, 64) }
 
 func testGetBlockHeaders(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, downloader.MaxHashFetch+15, nil, nil)
@@ -229,6 +230,7 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 // Tests that block contents can be retrieved from a remote chain based on their hashes.
 func TestGetBlockBodies62(t *testing.T) { testGetBlockBodies(t, 62) }
 func TestGetBlockBodies63(t *testing.T) { testGetBlockBodies(t, 63) }
+func TestGetBlockBodies64(t *testing.T) { testGetBlockBodies(t, 64) }
 
 func testGetBlockBodies(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, downloader.MaxBlockFetch+15, nil, nil)
@@ -300,6 +302,7 @@ func testGetBlockBodies(t *testing.T, protocol int) {
 
 // Tests that the node state database can be retrieved based on hashes.
 func TestGetNodeData63(t *testing.T) { testGetNodeData(t, 63) }
+func TestGetNodeData64(t *testing.T) { testGetNodeData(t, 64) }
 
 func testGetNodeData(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -392,6 +395,7 @@ func testGetNodeData(t *testing.T, protocol int) {
 
 // Tests that the transaction receipts can be retrieved based on hashes.
 func TestGetReceipt63(t *testing.T) { testGetReceipt(t, 63) }
+func TestGetReceipt64(t *testing.T) { testGetReceipt(t, 64) }
 
 func testGetReceipt(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -524,7 +528,7 @@ func testCheckpointChallenge(t *testing.T, syncmode downloader.SyncMode, checkpo
 	if err != nil {
 		t.Fatalf("failed to create new blockchain: %v", err)
 	}
-	pm, err := NewProtocolManager(config, syncmode, DefaultConfig.NetworkId, new(event.TypeMux), new(testTxPool), ethash.NewFaker(), blockchain, db, nil)
+	pm, err := NewProtocolManager(config, syncmode, DefaultConfig.NetworkId, new(event.TypeMux), new(testTxPool), ethash.NewFaker(), blockchain, db, nil, nil, 0, 0, false)
 	if err != nil {
 		t.Fatalf("failed to start test protocol manager: %v", err)
 	}
@@ -611,7 +615,7 @@ func testBroadcastBlock(t *testing.T, totalPeers, broadcastExpected int) {
 	if err != nil {
 		t.Fatalf("failed to create new blockchain: %v", err)
 	}
-	pm, err := NewProtocolManager(config, downloader.FullSync, DefaultConfig.NetworkId, evmux, new(testTxPool), pow, blockchain, db, nil)
+	pm, err := NewProtocolManager(config, downloader.FullSync, DefaultConfig.NetworkId, evmux, new(testTxPool), pow, blockchain, db, nil, nil, 0, 0, false)
 	if err != nil {
 		t.Fatalf("failed to start test protocol manager: %v", err)
 	}
@@ -663,3 +667,96 @@ outer:
 		t.Errorf("block broadcast to %d peers, expected %d", receivedCount, broadcastExpected)
 	}
 }
+
+// Tests that transactions are sent in full to a square root of the eth/65 peers
+// and only announced to the rest.
+func TestBroadcastTransactions65(t *testing.T) {
+	var tests = []struct {
+		totalPeers        int
+		broadcastExpected int
+	}{
+		{1, 1},
+		{3, 1},
+		{4, 2},
+		{9, 3},
+		{26, 5},
+	}
+	for _, test := range tests {
+		testBroadcastTransactions(t, test.totalPeers, test.broadcastExpected)
+	}
+}
+
+func testBroadcastTransactions(t *testing.T, totalPeers, broadcastExpected int) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	var peers []*testPeer
+	for i := 0; i < totalPeers; i++ {
+		peer, _ := newTestPeer(fmt.Sprintf("peer %d", i), eth65, pm, true)
+		defer peer.close()
+		peers = append(peers, peer)
+	}
+	// Wait for the peers to be registered after the handshakes
+	for start := time.Now(); pm.peers.Len() < totalPeers; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("peers: %d: only %d registered", totalPeers, pm.peers.Len())
+		}
+	}
+	tx := newTestTransaction(testAccount, 0, 0)
+	pm.BroadcastTransactions(types.Transactions{tx}, true)
+	pm.BroadcastTransactions(types.Transactions{tx}, false)
+
+	codes := make(chan uint64, totalPeers)
+	for _, peer := range peers {
+		go func(p *testPeer) {
+			msg, err := p.app.ReadMsg()
+			if err != nil {
+				codes <- 0
+				return
+			}
+			msg.Discard()
+			codes <- msg.Code
+		}(peer)
+	}
+	var broadcasts, announces int
+	for i := 0; i < totalPeers; i++ {
+		select {
+		case code := <-codes:
+			switch code {
+			case TxMsg:
+				broadcasts++
+			case NewPooledTransactionHashesMsg:
+				announces++
+			default:
+				t.Fatalf("unexpected message code %d", code)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("peers: %d: transaction not delivered to all peers", totalPeers)
+		}
+	}
+	if broadcasts != broadcastExpected {
+		t.Errorf("peers: %d: transaction broadcast to %d peers, expected %d", totalPeers, broadcasts, broadcastExpected)
+	}
+	if announces != totalPeers-broadcastExpected {
+		t.Errorf("peers: %d: transaction announced to %d peers, expected %d", totalPeers, announces, totalPeers-broadcastExpected)
+	}
+}
+
+// Tests that pooled transactions can be retrieved by hash, skipping the unknown
+// ones.
+func TestGetPooledTransactions65(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	peer, _ := newTestPeer("peer", eth65, pm, true)
+	defer peer.close()
+	defer pm.Stop()
+
+	tx := newTestTransaction(testAccount, 0, 0)
+	pm.txpool.AddRemotes([]*types.Transaction{tx})
+
+	if err := p2p.Send(peer.app, GetPooledTransactionsMsg, []common.Hash{{0x01}, tx.Hash()}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	if err := p2p.ExpectMsg(peer.app, PooledTransactionsMsg, []*types.Transaction{tx}); err != nil {
+		t.Errorf("pooled transactions mismatch: %v", err)
+	}
+}
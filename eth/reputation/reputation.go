@@ -0,0 +1,225 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package reputation tracks how well remote eth peers serve data, persisting the
+// scores across sessions so good peers can be prioritised from the start.
+package reputation
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/metrics"
+	"github.com/simplechain-org/simplechain/rlp"
+)
+
+const (
+	maxScore        = 100       // Absolute limit of a peer's score in either direction
+	scoreDecayTC    = time.Hour // Time constant of the exponential decay of scores towards neutral
+	maxTrackedPeers = 4096      // Maximum number of peer scores to persist
+)
+
+var (
+	usefulMeter  = metrics.NewRegisteredMeter("eth/reputation/useful", nil)
+	emptyMeter   = metrics.NewRegisteredMeter("eth/reputation/empty", nil)
+	timeoutMeter = metrics.NewRegisteredMeter("eth/reputation/timeout", nil)
+	invalidMeter = metrics.NewRegisteredMeter("eth/reputation/invalid", nil)
+)
+
+// Event is a behaviour of a remote peer affecting its reputation.
+type Event int
+
+const (
+	Useful  Event = iota // Peer delivered useful data
+	Empty                // Peer replied to a request without any data
+	Timeout              // Peer failed to reply to a request in time
+	Invalid              // Peer delivered invalid data
+)
+
+// eventWeights is the score change caused by each of the events. Misbehaviour
+// weighs a lot more than good behaviour, so a few invalid deliveries outweigh a
+// long history of useful ones.
+var eventWeights = [...]float64{
+	Useful:  1,
+	Empty:   -2,
+	Timeout: -5,
+	Invalid: -25,
+}
+
+// Tracker maintains the reputation scores of remote peers. Every score is kept
+// within [-maxScore, maxScore] and decays exponentially towards neutral, so past
+// behaviour is gradually forgotten.
+//
+// All methods are safe to call on a nil tracker, which treats every peer as
+// neutral.
+type Tracker struct {
+	db    ethdb.Database // Database to persist the scores into (nil = no persistence)
+	clock mclock.Clock   // Clock to decay the scores by
+
+	scores map[string]*score
+	lock   sync.Mutex
+}
+
+// score is the reputation of a single peer, along with the time it was last
+// updated.
+type score struct {
+	value   float64
+	updated mclock.AbsTime
+}
+
+// NewTracker creates a peer reputation tracker, restoring the scores persisted
+// into the given database previously.
+func NewTracker(db ethdb.Database, clock mclock.Clock) *Tracker {
+	t := &Tracker{
+		db:     db,
+		clock:  clock,
+		scores: make(map[string]*score),
+	}
+	t.loadFromDb()
+	return t
+}
+
+// Report accounts an event to the reputation of the given peer.
+func (t *Tracker) Report(id string, event Event) {
+	if t == nil {
+		return
+	}
+	switch event {
+	case Useful:
+		usefulMeter.Mark(1)
+	case Empty:
+		emptyMeter.Mark(1)
+	case Timeout:
+		timeoutMeter.Mark(1)
+	case Invalid:
+		invalidMeter.Mark(1)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := t.decayed(id)
+	if s == nil {
+		s = &score{updated: t.clock.Now()}
+		t.scores[id] = s
+	}
+	s.value = math.Max(-maxScore, math.Min(maxScore, s.value+eventWeights[event]))
+}
+
+// Score retrieves the current reputation of the given peer, in the range of
+// [-100, 100]. Unknown peers are neutral, scoring 0.
+func (t *Tracker) Score(id string) float64 {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if s := t.decayed(id); s != nil {
+		return s.value
+	}
+	return 0
+}
+
+// Weight retrieves a multiplier in the range of [0, 2] to scale measured peer
+// qualities (e.g. throughput) with when prioritising between peers. Neutral peers
+// have a weight of 1.
+func (t *Tracker) Weight(id string) float64 {
+	return (maxScore + t.Score(id)) / maxScore
+}
+
+// Save persists the current scores into the database, dropping the ones that
+// have decayed to neutral. It should be called when shutting down.
+func (t *Tracker) Save() {
+	if t == nil || t.db == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	list := make([]storedScore, 0, len(t.scores))
+	for id := range t.scores {
+		if s := t.decayed(id); math.Abs(s.value) >= 0.5 {
+			list = append(list, storedScore{ID: id, Score: math.Float64bits(s.value)})
+		}
+	}
+	// Keep only the most extreme scores if there are too many
+	if len(list) > maxTrackedPeers {
+		sort.Slice(list, func(i, j int) bool {
+			return math.Abs(math.Float64frombits(list[i].Score)) > math.Abs(math.Float64frombits(list[j].Score))
+		})
+		list = list[:maxTrackedPeers]
+	}
+	enc, err := rlp.EncodeToBytes(list)
+	if err != nil {
+		log.Error("Failed to encode peer reputations", "err", err)
+		return
+	}
+	rawdb.WritePeerReputations(t.db, enc)
+}
+
+// decayed retrieves the score of the given peer, decaying it towards neutral
+// for the time passed since its last update. The lock must be held.
+func (t *Tracker) decayed(id string) *score {
+	s := t.scores[id]
+	if s == nil {
+		return nil
+	}
+	now := t.clock.Now()
+	if elapsed := time.Duration(now - s.updated); elapsed > 0 {
+		s.value *= math.Exp(-float64(elapsed) / float64(scoreDecayTC))
+		s.updated = now
+	}
+	return s
+}
+
+// storedScore is the database representation of a peer's reputation. The score
+// is stored as the bits of the float, RLP not supporting negative numbers.
+type storedScore struct {
+	ID    string
+	Score uint64
+}
+
+// loadFromDb restores the persisted peer scores from the database (automatically
+// called at initialization).
+func (t *Tracker) loadFromDb() {
+	if t.db == nil {
+		return
+	}
+	enc := rawdb.ReadPeerReputations(t.db)
+	if len(enc) == 0 {
+		return
+	}
+	var list []storedScore
+	if err := rlp.DecodeBytes(enc, &list); err != nil {
+		log.Error("Failed to decode peer reputations", "err", err)
+		return
+	}
+	now := t.clock.Now()
+	for _, stored := range list {
+		value := math.Float64frombits(stored.Score)
+		if math.IsNaN(value) {
+			continue
+		}
+		t.scores[stored.ID] = &score{value: math.Max(-maxScore, math.Min(maxScore, value)), updated: now}
+	}
+	log.Debug("Loaded peer reputations", "peers", len(t.scores))
+}
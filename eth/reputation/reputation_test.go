@@ -0,0 +1,110 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package reputation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common/mclock"
+	"github.com/simplechain-org/simplechain/ethdb"
+)
+
+// Tests that events are accounted to the peers' scores within the allowed range,
+// and that the scores decay towards neutral over time.
+func TestScoring(t *testing.T) {
+	clock := &mclock.Simulated{}
+	tracker := NewTracker(nil, clock)
+
+	if score := tracker.Score("unknown"); score != 0 {
+		t.Fatalf("unknown peer score mismatch: have %v, want 0", score)
+	}
+	if weight := tracker.Weight("unknown"); weight != 1 {
+		t.Fatalf("unknown peer weight mismatch: have %v, want 1", weight)
+	}
+	for i := 0; i < 1000; i++ {
+		tracker.Report("good", Useful)
+		tracker.Report("bad", Invalid)
+	}
+	tracker.Report("slow", Timeout)
+	tracker.Report("slow", Empty)
+
+	if score := tracker.Score("good"); score != maxScore {
+		t.Fatalf("good peer score mismatch: have %v, want %v", score, maxScore)
+	}
+	if score := tracker.Score("bad"); score != -maxScore {
+		t.Fatalf("bad peer score mismatch: have %v, want %v", score, -maxScore)
+	}
+	if score := tracker.Score("slow"); score != -7 {
+		t.Fatalf("slow peer score mismatch: have %v, want -7", score)
+	}
+	if good, bad := tracker.Weight("good"), tracker.Weight("bad"); good != 2 || bad != 0 {
+		t.Fatalf("weights mismatch: have %v/%v, want 2/0", good, bad)
+	}
+	// Let the scores decay and ensure they approach neutral
+	clock.Run(scoreDecayTC)
+
+	if score, want := tracker.Score("good"), maxScore/math.E; math.Abs(score-want) > 1e-9 {
+		t.Fatalf("decayed good peer score mismatch: have %v, want %v", score, want)
+	}
+	if score, want := tracker.Score("bad"), -maxScore/math.E; math.Abs(score-want) > 1e-9 {
+		t.Fatalf("decayed bad peer score mismatch: have %v, want %v", score, want)
+	}
+}
+
+// Tests that scores are persisted across trackers, dropping the neutral ones.
+func TestPersistence(t *testing.T) {
+	var (
+		db    = ethdb.NewMemDatabase()
+		clock = &mclock.Simulated{}
+	)
+	tracker := NewTracker(db, clock)
+	for i := 0; i < 10; i++ {
+		tracker.Report("good", Useful)
+	}
+	tracker.Report("bad", Invalid)
+	tracker.Report("neutral", Useful)
+	tracker.Report("neutral", Empty)
+	tracker.Report("neutral", Useful)
+	tracker.Save()
+
+	restored := NewTracker(db, clock)
+	if score := restored.Score("good"); score != 10 {
+		t.Fatalf("restored good peer score mismatch: have %v, want 10", score)
+	}
+	if score := restored.Score("bad"); score != -25 {
+		t.Fatalf("restored bad peer score mismatch: have %v, want -25", score)
+	}
+	if len(restored.scores) != 2 {
+		t.Fatalf("restored peer count mismatch: have %d, want 2", len(restored.scores))
+	}
+}
+
+// Tests that a nil tracker treats every peer as neutral.
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+
+	tracker.Report("peer", Invalid)
+	tracker.Save()
+
+	if score := tracker.Score("peer"); score != 0 {
+		t.Fatalf("score mismatch: have %v, want 0", score)
+	}
+	if weight := tracker.Weight("peer"); weight != 1 {
+		t.Fatalf("weight mismatch: have %v, want 1", weight)
+	}
+}
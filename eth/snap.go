@@ -0,0 +1,461 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/eth/downloader"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/log"
+	"github.com/simplechain-org/simplechain/p2p"
+	"github.com/simplechain-org/simplechain/rlp"
+	"github.com/simplechain-org/simplechain/trie"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	snap1 = 1
+)
+
+// SnapProtocolName is the official short name of the state range sync protocol,
+// run side by side with the eth protocol during capability negotiation.
+var SnapProtocolName = "snap"
+
+// SnapProtocolVersions are the supported versions of the snap protocol (first is primary).
+var SnapProtocolVersions = []uint{snap1}
+
+// SnapProtocolLengths are the number of implemented message corresponding to different protocol versions.
+var SnapProtocolLengths = []uint64{6}
+
+// snap protocol message codes
+const (
+	GetAccountRangeMsg  = 0x00
+	AccountRangeMsg     = 0x01
+	GetStorageRangesMsg = 0x02
+	StorageRangesMsg    = 0x03
+	GetByteCodesMsg     = 0x04
+	ByteCodesMsg        = 0x05
+)
+
+// getAccountRangeData represents an account range query, requesting the accounts
+// of a state trie starting at a given hash, until the limit hash or the response
+// size cap is reached.
+type getAccountRangeData struct {
+	ID     uint64      // Request ID to match up responses with
+	Root   common.Hash // Root hash of the account trie to serve
+	Origin common.Hash // Hash of the first account to retrieve
+	Limit  common.Hash // Hash of the last account to retrieve
+	Bytes  uint64      // Soft limit at which to stop returning data
+}
+
+// accountRangeData is the network packet for an account range response, with
+// the merkle proofs of the origin and the last returned account.
+type accountRangeData struct {
+	ID       uint64        // ID of the request this is a response for
+	Accounts []accountData // List of consecutive accounts from the trie
+	Proof    [][]byte      // List of trie nodes proving the account range
+}
+
+// accountData represents a single account in a range response.
+type accountData struct {
+	Hash common.Hash // Hash of the account
+	Body []byte      // RLP encoded account, as stored in the trie
+}
+
+// getStorageRangesData represents a storage slot query for a batch of accounts,
+// the origin only applying to the first requested account.
+type getStorageRangesData struct {
+	ID       uint64        // Request ID to match up responses with
+	Root     common.Hash   // Root hash of the account trie to serve
+	Accounts []common.Hash // Account hashes of the storage tries to serve
+	Origin   common.Hash   // Hash of the first storage slot to retrieve
+	Bytes    uint64        // Soft limit at which to stop returning data
+}
+
+// storageRangesData is the network packet for a storage range response. All
+// storage tries but the last are complete, the last one being proven with the
+// merkle proofs of its edges if it's partial or doesn't start at zero.
+type storageRangesData struct {
+	ID    uint64          // ID of the request this is a response for
+	Slots [][]storageData // Lists of consecutive storage slots for the requested accounts
+	Proof [][]byte        // Merkle proofs for the last, partial storage range
+}
+
+// storageData represents a single storage slot in a range response.
+type storageData struct {
+	Hash common.Hash // Hash of the storage slot
+	Body []byte      // RLP encoded storage slot, as stored in the trie
+}
+
+// getByteCodesData represents a contract bytecode query.
+type getByteCodesData struct {
+	ID     uint64        // Request ID to match up responses with
+	Hashes []common.Hash // Code hashes to retrieve the code for
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// byteCodesData is the network packet for a contract bytecode response.
+type byteCodesData struct {
+	ID    uint64   // ID of the request this is a response for
+	Codes [][]byte // Requested contract bytecodes
+}
+
+// snapPeer is the snap protocol connection of a remote node, registered with
+// the downloader next to its eth connection to serve state ranges.
+type snapPeer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	version int // Snap protocol version negotiated
+}
+
+func newSnapPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *snapPeer {
+	return &snapPeer{
+		Peer:    p,
+		rw:      rw,
+		version: version,
+		id:      fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+	}
+}
+
+// SendAccountRange sends a batch of consecutive accounts along with the merkle
+// proofs of the range edges.
+func (p *snapPeer) SendAccountRange(id uint64, accounts []accountData, proof [][]byte) error {
+	return p2p.Send(p.rw, AccountRangeMsg, &accountRangeData{ID: id, Accounts: accounts, Proof: proof})
+}
+
+// SendStorageRanges sends a batch of storage slot ranges along with the merkle
+// proofs of the last range, if partial.
+func (p *snapPeer) SendStorageRanges(id uint64, slots [][]storageData, proof [][]byte) error {
+	return p2p.Send(p.rw, StorageRangesMsg, &storageRangesData{ID: id, Slots: slots, Proof: proof})
+}
+
+// SendByteCodes sends a batch of contract bytecodes, corresponding to the hashes
+// requested.
+func (p *snapPeer) SendByteCodes(id uint64, codes [][]byte) error {
+	return p2p.Send(p.rw, ByteCodesMsg, &byteCodesData{ID: id, Codes: codes})
+}
+
+// RequestAccountRange fetches a batch of consecutive accounts of the state trie
+// with the given root, starting at origin and stopping at limit.
+func (p *snapPeer) RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching range of accounts", "reqid", id, "root", root, "origin", origin, "limit", limit, "bytes", common.StorageSize(bytes))
+	return p2p.Send(p.rw, GetAccountRangeMsg, &getAccountRangeData{ID: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestStorageRanges fetches the storage slots of a batch of accounts of the
+// state trie with the given root, the first one starting at origin.
+func (p *snapPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching ranges of storage slots", "reqid", id, "root", root, "accounts", len(accounts), "origin", origin, "bytes", common.StorageSize(bytes))
+	return p2p.Send(p.rw, GetStorageRangesMsg, &getStorageRangesData{ID: id, Root: root, Accounts: accounts, Origin: origin, Bytes: bytes})
+}
+
+// RequestByteCodes fetches a batch of contract bytecodes by their hashes.
+func (p *snapPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching set of byte codes", "reqid", id, "count", len(hashes), "bytes", common.StorageSize(bytes))
+	return p2p.Send(p.rw, GetByteCodesMsg, &getByteCodesData{ID: id, Hashes: hashes, Bytes: bytes})
+}
+
+func (pm *ProtocolManager) newSnapPeer(pv int, p *p2p.Peer, rw p2p.MsgReadWriter) *snapPeer {
+	return newSnapPeer(pv, p, newLimitedMsgReadWriter(rw, pm.uploadLimit, pm.downloadLimit, pm.quitSync))
+}
+
+// handleSnap is the callback invoked to manage the life cycle of a snap peer.
+// The connection is handed to the downloader once the eth side of the same node
+// is registered too. When this function terminates, the peer is disconnected.
+func (pm *ProtocolManager) handleSnap(p *snapPeer) error {
+	p.Log().Debug("Snap peer connected", "name", p.Name())
+
+	pm.snapLock.Lock()
+	select {
+	case <-pm.quitSync:
+		pm.snapLock.Unlock()
+		return p2p.DiscQuitting
+	default:
+	}
+	if _, ok := pm.snapPeers[p.id]; ok {
+		pm.snapLock.Unlock()
+		return errAlreadyRegistered
+	}
+	pm.snapPeers[p.id] = p
+	pm.downloader.RegisterSnapPeer(p.id, p) // Fails if the eth peer is not yet registered, handle picks it up then
+	pm.snapLock.Unlock()
+
+	defer func() {
+		pm.snapLock.Lock()
+		delete(pm.snapPeers, p.id)
+		pm.downloader.UnregisterSnapPeer(p.id)
+		pm.snapLock.Unlock()
+	}()
+	// Handle incoming messages until the connection is torn down
+	for {
+		if err := pm.handleSnapMsg(p); err != nil {
+			p.Log().Debug("Snap message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleSnapMsg is invoked whenever an inbound snap message is received from a
+// remote peer. The remote connection is torn down upon returning any error.
+func (pm *ProtocolManager) handleSnapMsg(p *snapPeer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case GetAccountRangeMsg:
+		// Decode the account range retrieval message
+		var req getAccountRangeData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		accounts, proof := pm.serveAccountRange(&req)
+		return p.SendAccountRange(req.ID, accounts, proof)
+
+	case AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes, accounts := make([]common.Hash, len(res.Accounts)), make([][]byte, len(res.Accounts))
+		for i, account := range res.Accounts {
+			hashes[i], accounts[i] = account.Hash, account.Body
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverAccountRange(p.id, res.ID, hashes, accounts, res.Proof); err != nil {
+			log.Debug("Failed to deliver account range", "err", err)
+		}
+
+	case GetStorageRangesMsg:
+		// Decode the storage ranges retrieval message
+		var req getStorageRangesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		slots, proof := pm.serveStorageRanges(&req)
+		return p.SendStorageRanges(req.ID, slots, proof)
+
+	case StorageRangesMsg:
+		// A batch of storage ranges arrived to one of our previous requests
+		var res storageRangesData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes, slots := make([][]common.Hash, len(res.Slots)), make([][][]byte, len(res.Slots))
+		for i, storage := range res.Slots {
+			hashes[i], slots[i] = make([]common.Hash, len(storage)), make([][]byte, len(storage))
+			for j, slot := range storage {
+				hashes[i][j], slots[i][j] = slot.Hash, slot.Body
+			}
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverStorageRanges(p.id, res.ID, hashes, slots, res.Proof); err != nil {
+			log.Debug("Failed to deliver storage ranges", "err", err)
+		}
+
+	case GetByteCodesMsg:
+		// Decode the bytecode retrieval message
+		var req getByteCodesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		return p.SendByteCodes(req.ID, pm.serveByteCodes(&req))
+
+	case ByteCodesMsg:
+		// A batch of contract codes arrived to one of our previous requests
+		var res byteCodesData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverByteCodes(p.id, res.ID, res.Codes); err != nil {
+			log.Debug("Failed to deliver byte codes", "err", err)
+		}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// serveAccountRange iterates the state trie of the requested root from the origin
+// onwards, gathering accounts until the limit hash or the response size cap is
+// reached. The range is proven with the merkle proofs of the origin and of the
+// last returned account. If the state is unavailable, nothing is returned.
+func (pm *ProtocolManager) serveAccountRange(req *getAccountRangeData) ([]accountData, [][]byte) {
+	limit := req.Bytes
+	if limit > softResponseLimit {
+		limit = softResponseLimit
+	}
+	tr, err := trie.New(req.Root, pm.blockchain.StateCache().TrieDB())
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		accounts []accountData
+		size     uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(req.Origin[:]))
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		accounts = append(accounts, accountData{Hash: hash, Body: common.CopyBytes(it.Value)})
+
+		size += uint64(common.HashLength + len(it.Value))
+		if bytes.Compare(hash[:], req.Limit[:]) >= 0 || size >= limit {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil
+	}
+	proof := ethdb.NewMemDatabase()
+	if err := tr.Prove(req.Origin[:], 0, proof); err != nil {
+		return nil, nil
+	}
+	if len(accounts) > 0 {
+		if err := tr.Prove(accounts[len(accounts)-1].Hash[:], 0, proof); err != nil {
+			return nil, nil
+		}
+	}
+	return accounts, proofNodes(proof)
+}
+
+// serveStorageRanges gathers the storage slots of the requested accounts of the
+// given state root until the fetch or response size limits are reached. All
+// returned storage tries but the last one are complete. The last one is proven
+// with the merkle proofs of its edges if it's partial or was requested from a
+// non-zero origin.
+func (pm *ProtocolManager) serveStorageRanges(req *getStorageRangesData) ([][]storageData, [][]byte) {
+	limit := req.Bytes
+	if limit > softResponseLimit {
+		limit = softResponseLimit
+	}
+	triedb := pm.blockchain.StateCache().TrieDB()
+	accTrie, err := trie.New(req.Root, triedb)
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		slots [][]storageData
+		proof [][]byte
+		size  uint64
+	)
+	for i, hash := range req.Accounts {
+		if size >= limit || len(slots) >= downloader.MaxStateFetch {
+			break
+		}
+		// Retrieve the storage trie of the next account, stopping if unavailable
+		blob, err := accTrie.TryGet(hash[:])
+		if err != nil || blob == nil {
+			break
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(blob, &account); err != nil {
+			break
+		}
+		stTrie, err := trie.New(account.Root, triedb)
+		if err != nil {
+			break
+		}
+		// Gather the storage slots until the trie or the response is exhausted
+		var origin common.Hash
+		if i == 0 {
+			origin = req.Origin
+		}
+		var (
+			storage []storageData
+			partial bool
+		)
+		it := trie.NewIterator(stTrie.NodeIterator(origin[:]))
+		for it.Next() {
+			if size >= limit {
+				partial = true
+				break
+			}
+			storage = append(storage, storageData{Hash: common.BytesToHash(it.Key), Body: common.CopyBytes(it.Value)})
+			size += uint64(common.HashLength + len(it.Value))
+		}
+		if it.Err != nil {
+			break
+		}
+		slots = append(slots, storage)
+
+		// Partial or shifted ranges need to be proven, and can only be the last
+		if partial || origin != (common.Hash{}) {
+			proofDb := ethdb.NewMemDatabase()
+			if err := stTrie.Prove(origin[:], 0, proofDb); err != nil {
+				return nil, nil
+			}
+			if len(storage) > 0 {
+				if err := stTrie.Prove(storage[len(storage)-1].Hash[:], 0, proofDb); err != nil {
+					return nil, nil
+				}
+			}
+			proof = proofNodes(proofDb)
+			break
+		}
+	}
+	return slots, proof
+}
+
+// serveByteCodes gathers the requested contract bytecodes until the fetch or
+// response size limits are reached. Unknown codes are skipped.
+func (pm *ProtocolManager) serveByteCodes(req *getByteCodesData) [][]byte {
+	limit := req.Bytes
+	if limit > softResponseLimit {
+		limit = softResponseLimit
+	}
+	var (
+		codes [][]byte
+		size  uint64
+	)
+	for _, hash := range req.Hashes {
+		if size >= limit || len(codes) >= downloader.MaxStateFetch {
+			break
+		}
+		if code, err := pm.blockchain.StateCache().ContractCode(common.Hash{}, hash); err == nil {
+			codes = append(codes, code)
+			size += uint64(len(code))
+		}
+	}
+	return codes
+}
+
+// proofNodes flattens the trie nodes of a merkle proof into a list.
+func proofNodes(proof *ethdb.MemDatabase) [][]byte {
+	var nodes [][]byte
+	for _, key := range proof.Keys() {
+		node, _ := proof.Get(key)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/eth/downloader"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/p2p"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rlp"
+	"github.com/simplechain-org/simplechain/trie"
+)
+
+// Tests that contiguous ranges of accounts can be retrieved along with the proofs
+// of their edges, capped by the requested response size.
+func TestGetAccountRange(t *testing.T) {
+	// Fund a handful of accounts to have something to iterate over
+	generator := func(i int, block *core.BlockGen) {
+		for j := 0; j < 4; j++ {
+			addr := common.BigToAddress(big.NewInt(int64(0x10000 + 4*i + j))) // Clear of the precompiles
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testBank), addr, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, testBankKey)
+			block.AddTx(tx)
+		}
+	}
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, generator, nil)
+	peer, _ := newTestSnapPeer("peer", pm)
+	defer peer.close()
+	defer pm.Stop()
+
+	root := pm.blockchain.CurrentBlock().Root()
+	limit := common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+
+	// Retrieve the entire account trie and the first account only
+	tests := []struct {
+		bytes uint64
+		count int
+		more  bool
+	}{
+		{softResponseLimit, 18, false}, // 16 funded accounts, the bank and the coinbase
+		{1, 1, true},
+	}
+	for i, tt := range tests {
+		p2p.Send(peer.app, GetAccountRangeMsg, &getAccountRangeData{ID: uint64(i), Root: root, Limit: limit, Bytes: tt.bytes})
+
+		msg, err := peer.app.ReadMsg()
+		if err != nil {
+			t.Fatalf("test %d: failed to read account range: %v", i, err)
+		}
+		if msg.Code != AccountRangeMsg {
+			t.Fatalf("test %d: response packet code mismatch: have %x, want %x", i, msg.Code, AccountRangeMsg)
+		}
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			t.Fatalf("test %d: failed to decode account range: %v", i, err)
+		}
+		if res.ID != uint64(i) {
+			t.Errorf("test %d: request id mismatch: have %d, want %d", i, res.ID, i)
+		}
+		if len(res.Accounts) != tt.count {
+			t.Fatalf("test %d: account count mismatch: have %d, want %d", i, len(res.Accounts), tt.count)
+		}
+		keys, values := make([][]byte, len(res.Accounts)), make([][]byte, len(res.Accounts))
+		for j, account := range res.Accounts {
+			keys[j], values[j] = account.Hash[:], account.Body
+		}
+		proof := ethdb.NewMemDatabase()
+		for _, node := range res.Proof {
+			proof.Put(crypto.Keccak256(node), node)
+		}
+		more, err := trie.VerifyRangeProof(root, common.Hash{}.Bytes(), keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			t.Fatalf("test %d: failed to verify account range: %v", i, err)
+		}
+		if more != tt.more {
+			t.Errorf("test %d: continuation mismatch: have %v, want %v", i, more, tt.more)
+		}
+	}
+	// Retrieve a range from an unknown root, expecting nothing
+	p2p.Send(peer.app, GetAccountRangeMsg, &getAccountRangeData{ID: 2, Root: common.Hash{0x01}, Limit: limit, Bytes: softResponseLimit})
+	if err := p2p.ExpectMsg(peer.app, AccountRangeMsg, &accountRangeData{ID: 2, Accounts: []accountData{}, Proof: [][]byte{}}); err != nil {
+		t.Errorf("unknown root response mismatch: %v", err)
+	}
+}
+
+// Tests that the storage slots and the code of a contract can be retrieved.
+func TestGetStorageRangesAndByteCodes(t *testing.T) {
+	// Deploy a contract storing two slots and returning a single byte of code
+	generator := func(i int, block *core.BlockGen) {
+		if i == 0 {
+			initcode := common.FromHex("60016000556002600155" + "60016000f3")
+			tx, _ := types.SignTx(types.NewContractCreation(block.TxNonce(testBank), new(big.Int), 100000, new(big.Int), initcode), types.HomesteadSigner{}, testBankKey)
+			block.AddTx(tx)
+		}
+	}
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, generator, nil)
+	peer, _ := newTestSnapPeer("peer", pm)
+	defer peer.close()
+	defer pm.Stop()
+
+	statedb, _ := pm.blockchain.State()
+	contract := crypto.CreateAddress(testBank, 0)
+	if statedb.GetState(contract, common.Hash{}) != common.BigToHash(big.NewInt(1)) {
+		t.Fatalf("contract storage not initialised")
+	}
+	// Retrieve the storage trie of the contract and verify it against its root
+	root := pm.blockchain.CurrentBlock().Root()
+	p2p.Send(peer.app, GetStorageRangesMsg, &getStorageRangesData{ID: 1, Root: root, Accounts: []common.Hash{crypto.Keccak256Hash(contract[:])}, Bytes: softResponseLimit})
+
+	msg, err := peer.app.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read storage ranges: %v", err)
+	}
+	if msg.Code != StorageRangesMsg {
+		t.Fatalf("response packet code mismatch: have %x, want %x", msg.Code, StorageRangesMsg)
+	}
+	var res storageRangesData
+	if err := msg.Decode(&res); err != nil {
+		t.Fatalf("failed to decode storage ranges: %v", err)
+	}
+	if len(res.Slots) != 1 || len(res.Slots[0]) != 2 || len(res.Proof) != 0 {
+		t.Fatalf("storage ranges mismatch: have %d accounts, %d proof nodes", len(res.Slots), len(res.Proof))
+	}
+	keys, values := make([][]byte, 2), make([][]byte, 2)
+	for i, slot := range res.Slots[0] {
+		keys[i], values[i] = slot.Hash[:], slot.Body
+	}
+	if _, err := trie.VerifyRangeProof(statedb.StorageTrie(contract).Hash(), nil, nil, keys, values, nil); err != nil {
+		t.Errorf("failed to verify storage range: %v", err)
+	}
+	var slot []byte
+	if err := rlp.DecodeBytes(values[0], &slot); err != nil || len(slot) != 1 {
+		t.Errorf("storage slot mismatch: %x (%v)", values[0], err)
+	}
+	// Retrieve the code of the contract, skipping unknown ones
+	code := statedb.GetCode(contract)
+	p2p.Send(peer.app, GetByteCodesMsg, &getByteCodesData{ID: 2, Hashes: []common.Hash{{0x01}, crypto.Keccak256Hash(code)}, Bytes: softResponseLimit})
+	if err := p2p.ExpectMsg(peer.app, ByteCodesMsg, &byteCodesData{ID: 2, Codes: [][]byte{code}}); err != nil {
+		t.Errorf("bytecodes mismatch: %v", err)
+	}
+}
+
+// Tests that the number of accounts served in a storage range response is capped,
+// even if their storage tries are empty and never reach the response size limit.
+func TestGetStorageRangesCap(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	peer, _ := newTestSnapPeer("peer", pm)
+	defer peer.close()
+	defer pm.Stop()
+
+	// Request the empty storage of the bank way too many times
+	accounts := make([]common.Hash, downloader.MaxStateFetch+16)
+	for i := range accounts {
+		accounts[i] = crypto.Keccak256Hash(testBank[:])
+	}
+	root := pm.blockchain.CurrentBlock().Root()
+	p2p.Send(peer.app, GetStorageRangesMsg, &getStorageRangesData{ID: 1, Root: root, Accounts: accounts, Bytes: softResponseLimit})
+
+	msg, err := peer.app.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read storage ranges: %v", err)
+	}
+	var res storageRangesData
+	if err := msg.Decode(&res); err != nil {
+		t.Fatalf("failed to decode storage ranges: %v", err)
+	}
+	if len(res.Slots) != downloader.MaxStateFetch {
+		t.Errorf("storage range count mismatch: have %d, want %d", len(res.Slots), downloader.MaxStateFetch)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core/vm"
+)
+
+// callFrame is a single call reported by the call tracer. The field order is
+// the one used for the JSON serialization, matching the JavaScript tracer.
+type callFrame struct {
+	Type    string          `json:"type"`
+	From    *common.Address `json:"from,omitempty"`
+	To      *common.Address `json:"to,omitempty"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     *hexutil.Uint64 `json:"gas,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Input   *hexutil.Bytes  `json:"input,omitempty"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Time    string          `json:"time,omitempty"`
+	Calls   []*callFrame    `json:"calls,omitempty"`
+
+	gasIn   uint64 // Gas available before the call opcode
+	gasCost uint64 // Gas cost of the call opcode, including the forwarded gas
+	outOff  uint64 // Memory offset of the call output
+	outLen  uint64 // Memory length of the call output
+
+	address     common.Address // Self destructed contract
+	beneficiary common.Address // Recipient of the self destructed balance
+	balance     *big.Int       // Balance of the self destructed contract
+}
+
+// callTracer is a native Go implementation of call_tracer.js, extracting and
+// reporting all the internal calls made by a transaction.
+type callTracer struct {
+	callstack []*callFrame // Current recursive call stack of the EVM execution
+	descended bool         // Whether we've just descended into an inner call
+
+	ctx callFrame // Outer call context gathered by the start and end hooks
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newCallTracer creates a native call tracer. It doesn't accept any config.
+func newCallTracer(config json.RawMessage) (Interface, error) {
+	return &callTracer{callstack: []*callFrame{{}}}, nil
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *callTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.Type = "CALL"
+	if create {
+		t.ctx.Type = "CREATE"
+	}
+	t.ctx.From, t.ctx.To = &from, &to
+	t.ctx.Input = (*hexutil.Bytes)(&input)
+	t.ctx.Gas = (*hexutil.Uint64)(&gas)
+	if value == nil {
+		value = new(big.Int)
+	}
+	t.ctx.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Skip any further processing if tracing was interrupted
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	// Capture any errors immediately
+	if err != nil {
+		t.fault(err)
+		return nil
+	}
+	var (
+		mem = &memoryWrapper{memory}
+		stk = &stackWrapper{stack}
+	)
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		// If a new contract is being created, add to the call stack
+		inOff := stk.peek(1).Uint64()
+		inEnd := inOff + stk.peek(2).Uint64()
+
+		from := contract.Address()
+		input := hexutil.Bytes(mem.slice(int64(inOff), int64(inEnd)))
+		t.callstack = append(t.callstack, &callFrame{
+			Type:    op.String(),
+			From:    &from,
+			Input:   &input,
+			Value:   (*hexutil.Big)(new(big.Int).Set(stk.peek(0))),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return nil
+
+	case vm.SELFDESTRUCT:
+		// If a contract is being self destructed, gather that as a subcall too
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &callFrame{
+			Type:        op.String(),
+			address:     contract.Address(),
+			beneficiary: common.BigToAddress(stk.peek(0)),
+			balance:     new(big.Int).Set(env.StateDB.GetBalance(contract.Address())),
+		})
+		return nil
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// Skip any pre-compile invocations, those are just fancy opcodes
+		to := common.BigToAddress(stk.peek(1))
+		if _, ok := vm.ActivePrecompiledContracts(env.ChainConfig(), env.BlockNumber)[to]; ok {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := stk.peek(2 + off).Uint64()
+		inEnd := inOff + stk.peek(3+off).Uint64()
+
+		from := contract.Address()
+		input := hexutil.Bytes(mem.slice(int64(inOff), int64(inEnd)))
+		call := &callFrame{
+			Type:    op.String(),
+			From:    &from,
+			To:      &to,
+			Input:   &input,
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  stk.peek(4 + off).Uint64(),
+			outLen:  stk.peek(5 + off).Uint64(),
+		}
+		if op != vm.DELEGATECALL && op != vm.STATICCALL {
+			call.Value = (*hexutil.Big)(new(big.Int).Set(stk.peek(2)))
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve it's true allowance. We
+	// need to extract if from within the call as there may be funky gas dynamics
+	// with regard to requested and actually given gas (2300 stipend, 63/64 rule).
+	// If the call was made to a plain account, the true gas amount is unknown.
+	if t.descended {
+		if depth >= len(t.callstack) {
+			allowance := hexutil.Uint64(gas)
+			t.callstack[len(t.callstack)-1].Gas = &allowance
+		}
+		t.descended = false
+	}
+	// If an existing call is returning, pop off the call stack
+	if op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		return nil
+	}
+	if depth == len(t.callstack)-1 {
+		// Pop off the last call and get the execution results
+		call := t.callstack[len(t.callstack)-1]
+		t.callstack = t.callstack[:len(t.callstack)-1]
+
+		if call.Type == "CREATE" || call.Type == "CREATE2" {
+			// If the call was a CREATE, retrieve the contract address and output code
+			used := hexutil.Uint64(call.gasIn - call.gasCost - gas)
+			call.GasUsed = &used
+
+			if ret := stk.peek(0); ret.Sign() != 0 {
+				to := common.BigToAddress(ret)
+				code := hexutil.Bytes(env.StateDB.GetCode(to))
+				call.To, call.Output = &to, &code
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		} else if call.Gas != nil {
+			// If the call was a contract call, retrieve the gas usage and output
+			used := hexutil.Uint64(call.gasIn - call.gasCost + uint64(*call.Gas) - gas)
+			call.GasUsed = &used
+
+			if ret := stk.peek(0); ret.Sign() != 0 {
+				output := hexutil.Bytes(mem.slice(int64(call.outOff), int64(call.outOff+call.outLen)))
+				call.Output = &output
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		}
+		// Inject the call into the previous one
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if atomic.LoadUint32(&t.interrupt) == 0 {
+		t.fault(err)
+	}
+	return nil
+}
+
+// fault flattens the call failed with err into its parent.
+func (t *callTracer) fault(err error) {
+	// If the topmost call already reverted, don't handle the additional fault again
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	// Pop off the just failed call, consuming all available gas
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.Error = err.Error()
+	if call.Gas != nil {
+		call.GasUsed = call.Gas
+	}
+	// Flatten the failed call into its parent, or leave it in the stack if the
+	// last call failed too
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+		return
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.ctx.Output = (*hexutil.Bytes)(&output)
+	t.ctx.GasUsed = (*hexutil.Uint64)(&gasUsed)
+	t.ctx.Time = d.String()
+	if err != nil {
+		t.ctx.Error = err.Error()
+	}
+	return nil
+}
+
+// GetResult returns the outer call with all its inner calls in JSON format, or
+// the interruption reason if tracing was stopped.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	result, err := t.result()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// result assembles the outer call with all its inner calls.
+func (t *callTracer) result() (*callFrame, error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil, t.reason
+	}
+	result := t.ctx
+	result.Calls = t.callstack[0].Calls
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	}
+	if result.Error != "" {
+		result.Output = nil
+	}
+	return &result, nil
+}
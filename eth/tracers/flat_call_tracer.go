@@ -0,0 +1,172 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+)
+
+// flatCallAction is the action of a flat call frame. Depending on the frame
+// type, only a subset of the fields are set.
+type flatCallAction struct {
+	Address       *common.Address `json:"address,omitempty"`
+	Balance       *hexutil.Big    `json:"balance,omitempty"`
+	CallType      string          `json:"callType,omitempty"`
+	From          *common.Address `json:"from,omitempty"`
+	Gas           *hexutil.Uint64 `json:"gas,omitempty"`
+	Init          *hexutil.Bytes  `json:"init,omitempty"`
+	Input         *hexutil.Bytes  `json:"input,omitempty"`
+	RefundAddress *common.Address `json:"refundAddress,omitempty"`
+	To            *common.Address `json:"to,omitempty"`
+	Value         *hexutil.Big    `json:"value,omitempty"`
+}
+
+// flatCallResult is the result of a successful flat call frame.
+type flatCallResult struct {
+	Address *common.Address `json:"address,omitempty"`
+	Code    *hexutil.Bytes  `json:"code,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+}
+
+// flatCallFrame is a single call in the Parity trace format, which lists all
+// the calls of a transaction in depth-first order, locating each of them in
+// the call tree by its trace address.
+type flatCallFrame struct {
+	Action       flatCallAction  `json:"action"`
+	Error        string          `json:"error,omitempty"`
+	Result       *flatCallResult `json:"result,omitempty"`
+	Subtraces    int             `json:"subtraces"`
+	TraceAddress []int           `json:"traceAddress"`
+	Type         string          `json:"type"`
+}
+
+// flatCallTracer reports the calls collected by the call tracer in the flat
+// Parity trace format.
+type flatCallTracer struct {
+	*callTracer
+}
+
+// newFlatCallTracer creates a native flat call tracer. It doesn't accept any config.
+func newFlatCallTracer(config json.RawMessage) (Interface, error) {
+	tracer, err := newCallTracer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &flatCallTracer{tracer.(*callTracer)}, nil
+}
+
+// GetResult returns all the calls of the transaction in the flat Parity trace
+// format, or the interruption reason if tracing was stopped.
+func (t *flatCallTracer) GetResult() (json.RawMessage, error) {
+	result, err := t.result()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(flatten(result, []int{}, nil))
+}
+
+// flatten appends the given call and all its inner calls, in depth-first order,
+// to the list of flat call frames.
+func flatten(call *callFrame, address []int, frames []*flatCallFrame) []*flatCallFrame {
+	frame := &flatCallFrame{
+		Error:        parityError(call.Error),
+		Subtraces:    len(call.Calls),
+		TraceAddress: address,
+	}
+	// The gas allowance of calls to plain accounts is unknown, report zeroes
+	var gas, gasUsed hexutil.Uint64
+	if call.Gas != nil {
+		gas = *call.Gas
+	}
+	if call.GasUsed != nil {
+		gasUsed = *call.GasUsed
+	}
+	value := call.Value
+	if value == nil {
+		value = new(hexutil.Big)
+	}
+	switch call.Type {
+	case "CREATE", "CREATE2":
+		frame.Type = "create"
+		frame.Action = flatCallAction{From: call.From, Gas: &gas, Init: call.Input, Value: value}
+		if call.Error == "" {
+			frame.Result = &flatCallResult{Address: call.To, Code: call.Output, GasUsed: &gasUsed}
+		}
+	case "SELFDESTRUCT":
+		frame.Type = "suicide"
+		frame.Action = flatCallAction{
+			Address:       &call.address,
+			Balance:       (*hexutil.Big)(call.balance),
+			RefundAddress: &call.beneficiary,
+		}
+	default:
+		frame.Type = "call"
+		frame.Action = flatCallAction{
+			CallType: strings.ToLower(call.Type),
+			From:     call.From,
+			Gas:      &gas,
+			Input:    call.Input,
+			To:       call.To,
+			Value:    value,
+		}
+		if call.Error == "" {
+			output := call.Output
+			if output == nil {
+				output = new(hexutil.Bytes)
+			}
+			frame.Result = &flatCallResult{GasUsed: &gasUsed, Output: output}
+		}
+	}
+	frames = append(frames, frame)
+
+	for i, inner := range call.Calls {
+		child := make([]int, len(address)+1)
+		copy(child, address)
+		child[len(address)] = i
+
+		frames = flatten(inner, child, frames)
+	}
+	return frames
+}
+
+// parityError converts the error of a call into its Parity equivalent.
+func parityError(err string) string {
+	switch {
+	case err == "":
+		return ""
+	case err == "execution reverted" || err == "evm: execution reverted":
+		return "Reverted"
+	case err == "out of gas" || err == "contract creation code storage out of gas":
+		return "Out of gas"
+	case strings.HasPrefix(err, "invalid opcode"):
+		return "Bad instruction"
+	case strings.HasPrefix(err, "invalid jump destination"):
+		return "Bad jump destination"
+	case strings.HasPrefix(err, "stack limit reached"):
+		return "Out of stack"
+	case strings.HasPrefix(err, "stack underflow"):
+		return "Stack underflow"
+	case err == "evm: write protection":
+		return "Mutable Call In Static Context"
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+)
+
+// prestateAccount is the state of a single account reported by the prestate tracer.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// accountDiff is the modified part of an account reported in diff mode.
+type accountDiff struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *uint64                     `json:"nonce,omitempty"`
+	Code    *hexutil.Bytes              `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// prestateTracerConfig are the options accepted by the prestate tracer.
+type prestateTracerConfig struct {
+	DiffMode bool `json:"diffMode"` // Report the post state of the modified accounts too
+}
+
+// prestateTracer is a native Go implementation of prestate_tracer.js, which
+// outputs sufficient information to create a local execution of a transaction
+// from a custom assembled genesis block. In diff mode it reports the accounts
+// modified by the transaction both before and after its execution.
+type prestateTracer struct {
+	config   prestateTracerConfig
+	prestate map[common.Address]*prestateAccount // Genesis being built, nil until the first step
+	db       vm.StateDB                          // State database of the last executed step
+
+	ctx struct {
+		create   bool
+		from, to common.Address
+		value    *big.Int
+	}
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newPrestateTracer creates a native prestate tracer with the given config.
+func newPrestateTracer(config json.RawMessage) (Interface, error) {
+	tracer := new(prestateTracer)
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &tracer.config); err != nil {
+			return nil, err
+		}
+	}
+	return tracer, nil
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *prestateTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// lookupAccount injects the specified account into the prestate.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    t.db.GetCode(addr),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.prestate[addr].Storage[key]; !ok {
+		t.prestate[addr].Storage[key] = t.db.GetState(addr, key)
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.create, t.ctx.from, t.ctx.to = create, from, to
+	t.ctx.value = new(big.Int)
+	if value != nil {
+		t.ctx.value.Set(value)
+	}
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	t.db = env.StateDB
+
+	// Add the current account if we just started tracing. Balance will potentially
+	// be wrong here, since this will include the value sent along with the message.
+	// We fix that in GetResult.
+	if t.prestate == nil {
+		t.prestate = make(map[common.Address]*prestateAccount)
+		t.lookupAccount(contract.Address())
+	}
+	// Whenever new state is accessed, add it to the prestate
+	stk := &stackWrapper{stack}
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(stk.peek(0)))
+
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, env.StateDB.GetNonce(from)))
+
+	case vm.CREATE2:
+		offset := stk.peek(1).Int64()
+		code := (&memoryWrapper{memory}).slice(offset, offset+stk.peek(2).Int64())
+		salt := common.BigToHash(stk.peek(3))
+		t.lookupAccount(crypto.CreateAddress2(contract.Address(), salt, crypto.Keccak256(code)))
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(stk.peek(1)))
+
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(stk.peek(0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the assembled allocations (prestate) in JSON format, or the
+// pre and post states of the modified accounts in diff mode.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil, t.reason
+	}
+	// No code was executed, there's no state to report
+	if t.prestate == nil {
+		if t.config.DiffMode {
+			return json.Marshal(map[string]interface{}{"pre": struct{}{}, "post": struct{}{}})
+		}
+		return json.Marshal(struct{}{})
+	}
+	// At this point, we need to deduct the 'value' from the outer transaction,
+	// and move it back to the origin
+	t.lookupAccount(t.ctx.from)
+	t.lookupAccount(t.ctx.to)
+
+	from, to := t.prestate[t.ctx.from], t.prestate[t.ctx.to]
+	to.Balance = (*hexutil.Big)(new(big.Int).Sub(to.Balance.ToInt(), t.ctx.value))
+	from.Balance = (*hexutil.Big)(new(big.Int).Add(from.Balance.ToInt(), t.ctx.value))
+
+	// Decrement the caller's nonce, and remove empty create targets. We can blindly
+	// delete the contract prestate, as any existing state would have caused the
+	// transaction to be rejected as invalid in the first place.
+	from.Nonce--
+	if t.ctx.create {
+		delete(t.prestate, t.ctx.to)
+	}
+	if !t.config.DiffMode {
+		return json.Marshal(t.prestate)
+	}
+	return json.Marshal(t.diff())
+}
+
+// diff compares the prestate against the current state, dropping all unmodified
+// accounts and storage slots from the prestate and returning the modified fields
+// of the remaining ones as the post state. Destructed accounts are only present
+// in the prestate, created ones only in the post state.
+func (t *prestateTracer) diff() interface{} {
+	var (
+		pre  = make(map[common.Address]*prestateAccount)
+		post = make(map[common.Address]*accountDiff)
+	)
+	for addr, prev := range t.prestate {
+		if !t.db.Exist(addr) || t.db.HasSuicided(addr) {
+			pre[addr] = prev
+			continue
+		}
+		var (
+			modified bool
+			state    = &accountDiff{Storage: make(map[common.Hash]common.Hash)}
+		)
+		if balance := t.db.GetBalance(addr); balance.Cmp(prev.Balance.ToInt()) != 0 {
+			modified, state.Balance = true, (*hexutil.Big)(new(big.Int).Set(balance))
+		}
+		if nonce := t.db.GetNonce(addr); nonce != prev.Nonce {
+			modified, state.Nonce = true, &nonce
+		}
+		if code := t.db.GetCode(addr); !bytes.Equal(code, prev.Code) {
+			modified, state.Code = true, (*hexutil.Bytes)(&code)
+		}
+		for key, val := range prev.Storage {
+			if cur := t.db.GetState(addr, key); cur != val {
+				modified, state.Storage[key] = true, cur
+			} else {
+				delete(prev.Storage, key)
+			}
+		}
+		if modified {
+			pre[addr], post[addr] = prev, state
+		}
+	}
+	// The created contract has no prestate, report all of its fields
+	if t.ctx.create && t.db.Exist(t.ctx.to) && !t.db.HasSuicided(t.ctx.to) {
+		var (
+			balance = t.db.GetBalance(t.ctx.to)
+			nonce   = t.db.GetNonce(t.ctx.to)
+			code    = hexutil.Bytes(t.db.GetCode(t.ctx.to))
+		)
+		post[t.ctx.to] = &accountDiff{
+			Balance: (*hexutil.Big)(new(big.Int).Set(balance)),
+			Nonce:   &nonce,
+			Code:    &code,
+		}
+	}
+	return map[string]interface{}{"pre": pre, "post": post}
+}
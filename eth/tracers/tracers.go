@@ -14,19 +14,42 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
 
-// Package tracers is a collection of JavaScript transaction tracers.
+// Package tracers is a collection of JavaScript and native Go transaction tracers.
 package tracers
 
 import (
+	"encoding/json"
 	"strings"
 	"unicode"
 
+	"github.com/simplechain-org/simplechain/core/vm"
 	"github.com/simplechain-org/simplechain/eth/tracers/internal/tracers"
 )
 
+// Interface is a transaction tracer aggregating its own result, which can be
+// interrupted while running. It is implemented by both the JavaScript and the
+// native tracers.
+type Interface interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded result of the trace.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
 // all contains all the built in JavaScript tracers by name.
 var all = make(map[string]string)
 
+// native contains the constructors of all the built in Go tracers by name. They
+// take precedence over the JavaScript tracers of the same name.
+var native = map[string]func(config json.RawMessage) (Interface, error){
+	"callTracer":     newCallTracer,
+	"flatCallTracer": newFlatCallTracer,
+	"prestateTracer": newPrestateTracer,
+}
+
 // camel converts a snake cased input string into a camel cased output.
 func camel(str string) string {
 	pieces := strings.Split(str, "_")
@@ -51,3 +74,13 @@ func tracer(name string) (string, bool) {
 	}
 	return "", false
 }
+
+// NewTracer instantiates the native tracer registered with the given name and
+// config, or falls back to a JavaScript tracer from code otherwise. The config
+// is ignored by the JavaScript tracers.
+func NewTracer(code string, config json.RawMessage) (Interface, error) {
+	if constructor, ok := native[code]; ok {
+		return constructor(config)
+	}
+	return New(code)
+}
@@ -200,76 +200,275 @@ func TestPrestateTracerCreate2(t *testing.T) {
 // Iterates over all the input-output datasets in the tracer test harness and
 // runs the JavaScript tracers against them.
 func TestCallTracer(t *testing.T) {
+	testCallTracer(t, func() (Interface, error) { return New("callTracer") })
+}
+
+// Iterates over all the input-output datasets in the tracer test harness and
+// runs the native tracers against them.
+func TestCallTracerNative(t *testing.T) {
+	testCallTracer(t, func() (Interface, error) { return NewTracer("callTracer", nil) })
+}
+
+// loadCallTracerTests reads all the call tracer test cases from disk.
+func loadCallTracerTests(t *testing.T) map[string]*callTracerTest {
 	files, err := ioutil.ReadDir("testdata")
 	if err != nil {
 		t.Fatalf("failed to retrieve tracer test suite: %v", err)
 	}
+	tests := make(map[string]*callTracerTest)
 	for _, file := range files {
 		if !strings.HasPrefix(file.Name(), "call_tracer_") {
 			continue
 		}
-		file := file // capture range variable
-		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+		// Call tracer test found, read if from disk
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		tests[camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json"))] = test
+	}
+	return tests
+}
+
+// runTracerTest executes the transaction of a test case on top of its prestate
+// with the given tracer, returning the trace result.
+func runTracerTest(t *testing.T, test *callTracerTest, tracer Interface) json.RawMessage {
+	// Configure a blockchain with the given prestate
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	statedb := tests.MakePreState(ethdb.NewMemDatabase(), test.Genesis.Alloc)
+
+	// Create the EVM environment with the tracer and run it
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	// Retrieve the trace result
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	return res
+}
+
+func testCallTracer(t *testing.T, newTracer func() (Interface, error)) {
+	for name, test := range loadCallTracerTests(t) {
+		test := test // capture range variable
+		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			// Call tracer test found, read if from disk
-			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+			tracer, err := newTracer()
 			if err != nil {
-				t.Fatalf("failed to read testcase: %v", err)
+				t.Fatalf("failed to create call tracer: %v", err)
 			}
-			test := new(callTracerTest)
-			if err := json.Unmarshal(blob, test); err != nil {
-				t.Fatalf("failed to parse testcase: %v", err)
+			res := runTracerTest(t, test, tracer)
+
+			// Compare the trace result against the etalon
+			ret := new(callTrace)
+			if err := json.Unmarshal(res, ret); err != nil {
+				t.Fatalf("failed to unmarshal trace result: %v", err)
 			}
-			// Configure a blockchain with the given prestate
-			tx := new(types.Transaction)
-			if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
-				t.Fatalf("failed to parse testcase input: %v", err)
+			if !reflect.DeepEqual(ret, test.Result) {
+				t.Fatalf("trace mismatch: \nhave %+v\nwant %+v", ret, test.Result)
 			}
-			signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
-			origin, _ := signer.Sender(tx)
+		})
+	}
+}
 
-			context := vm.Context{
-				CanTransfer: core.CanTransfer,
-				Transfer:    core.Transfer,
-				Origin:      origin,
-				Coinbase:    test.Context.Miner,
-				BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
-				Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
-				Difficulty:  (*big.Int)(test.Context.Difficulty),
-				GasLimit:    uint64(test.Context.GasLimit),
-				GasPrice:    tx.GasPrice(),
-			}
-			statedb := tests.MakePreState(ethdb.NewMemDatabase(), test.Genesis.Alloc)
+// Tests that the native prestate tracer produces the same output as the
+// JavaScript one on all the datasets in the tracer test harness.
+func TestPrestateTracerNative(t *testing.T) {
+	for name, test := range loadCallTracerTests(t) {
+		test := test // capture range variable
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
 
-			// Create the tracer, the EVM environment and run it
-			tracer, err := New("callTracer")
+			jsTracer, err := New("prestateTracer")
 			if err != nil {
-				t.Fatalf("failed to create call tracer: %v", err)
+				t.Fatalf("failed to create JavaScript prestate tracer: %v", err)
+			}
+			nativeTracer, err := NewTracer("prestateTracer", nil)
+			if err != nil {
+				t.Fatalf("failed to create native prestate tracer: %v", err)
+			}
+			var want, have interface{}
+			if err := json.Unmarshal(runTracerTest(t, test, jsTracer), &want); err != nil {
+				t.Fatalf("failed to unmarshal JavaScript trace result: %v", err)
+			}
+			if err := json.Unmarshal(runTracerTest(t, test, nativeTracer), &have); err != nil {
+				t.Fatalf("failed to unmarshal native trace result: %v", err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Fatalf("trace mismatch: \nhave %+v\nwant %+v", have, want)
 			}
-			evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+		})
+	}
+}
 
-			msg, err := tx.AsMessage(signer)
+// Tests that the native prestate tracer in diff mode reports only the modified
+// accounts and storage slots, both before and after the transaction.
+func TestPrestateTracerDiff(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	origin := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000c0")
+
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignTx(types.NewTransaction(1, contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		BlockNumber: new(big.Int).SetUint64(8000000),
+		Time:        new(big.Int).SetUint64(5),
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+		GasPrice:    big.NewInt(1),
+	}
+	// The code loads slot 1, then overwrites slot 0 with 7
+	alloc := core.GenesisAlloc{
+		contract: {
+			Code:    hexutil.MustDecode("0x60015450600760005500"),
+			Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(5)), common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(3))},
+			Balance: big.NewInt(0),
+		},
+		origin: {Nonce: 1, Balance: big.NewInt(500000000000000)},
+	}
+	statedb := tests.MakePreState(ethdb.NewMemDatabase(), alloc)
+
+	tracer, err := NewTracer("prestateTracer", json.RawMessage(`{"diffMode": true}`))
+	if err != nil {
+		t.Fatalf("failed to create prestate tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var ret struct {
+		Pre  map[common.Address]*accountDiff `json:"pre"`
+		Post map[common.Address]*accountDiff `json:"post"`
+	}
+	if err := json.Unmarshal(res, &ret); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	// The contract only had its written slot modified
+	pre, post := ret.Pre[contract], ret.Post[contract]
+	if pre == nil || post == nil {
+		t.Fatalf("contract missing from diff: %s", res)
+	}
+	if want := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(5))}; !reflect.DeepEqual(pre.Storage, want) {
+		t.Errorf("pre storage mismatch: have %v, want %v", pre.Storage, want)
+	}
+	if want := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(7))}; !reflect.DeepEqual(post.Storage, want) {
+		t.Errorf("post storage mismatch: have %v, want %v", post.Storage, want)
+	}
+	if post.Balance != nil || post.Nonce != nil || post.Code != nil {
+		t.Errorf("unmodified contract fields reported: %s", res)
+	}
+	// The sender had its nonce bumped
+	pre, post = ret.Pre[origin], ret.Post[origin]
+	if pre == nil || post == nil {
+		t.Fatalf("sender missing from diff: %s", res)
+	}
+	if pre.Nonce == nil || *pre.Nonce != 1 || post.Nonce == nil || *post.Nonce != 2 {
+		t.Errorf("sender nonce mismatch: %s", res)
+	}
+}
+
+// Tests that calls to the addresses of the fork activated precompiles are only
+// skipped by the call tracers once the fork is active.
+func TestCallTracerPrecompilesByFork(t *testing.T) {
+	config := *params.TestChainConfig
+	config.CryptoBlock = big.NewInt(10)
+
+	var (
+		caller   = common.HexToAddress("0x10000")
+		contract = common.HexToAddress("0x10001")
+	)
+	// CALL(gas, 0x0a, 0, 0, 0, 0, 0)
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0x0a, byte(vm.GAS), byte(vm.CALL), byte(vm.STOP),
+	}
+	tracers := map[string]func() (Interface, error){
+		"js":     func() (Interface, error) { return New("callTracer") },
+		"native": func() (Interface, error) { return NewTracer("callTracer", nil) },
+	}
+	for name, newTracer := range tracers {
+		for _, tt := range []struct {
+			number uint64
+			calls  int
+		}{{9, 1}, {10, 0}} {
+			tracer, err := newTracer()
 			if err != nil {
-				t.Fatalf("failed to prepare transaction for tracing: %v", err)
+				t.Fatalf("%s: failed to create call tracer: %v", name, err)
+			}
+			statedb := tests.MakePreState(ethdb.NewMemDatabase(), core.GenesisAlloc{
+				caller:   {Balance: big.NewInt(params.Ether)},
+				contract: {Code: code, Balance: new(big.Int)},
+			})
+			context := vm.Context{
+				CanTransfer: core.CanTransfer,
+				Transfer:    core.Transfer,
+				Origin:      caller,
+				BlockNumber: new(big.Int).SetUint64(tt.number),
+				Time:        new(big.Int),
+				Difficulty:  new(big.Int),
+				GasPrice:    new(big.Int),
 			}
-			st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
-			if _, _, _, err = st.TransitionDb(); err != nil {
-				t.Fatalf("failed to execute transaction: %v", err)
+			evm := vm.NewEVM(context, statedb, &config, vm.Config{Debug: true, Tracer: tracer})
+			if _, _, err := evm.Call(vm.AccountRef(caller), contract, nil, 100000, new(big.Int)); err != nil {
+				t.Fatalf("%s, block %d: failed to execute call: %v", name, tt.number, err)
 			}
-			// Retrieve the trace result and compare against the etalon
 			res, err := tracer.GetResult()
 			if err != nil {
-				t.Fatalf("failed to retrieve trace result: %v", err)
+				t.Fatalf("%s, block %d: failed to retrieve trace result: %v", name, tt.number, err)
 			}
 			ret := new(callTrace)
 			if err := json.Unmarshal(res, ret); err != nil {
-				t.Fatalf("failed to unmarshal trace result: %v", err)
+				t.Fatalf("%s, block %d: failed to unmarshal trace result: %v", name, tt.number, err)
 			}
-
-			if !reflect.DeepEqual(ret, test.Result) {
-				t.Fatalf("trace mismatch: \nhave %+v\nwant %+v", ret, test.Result)
+			if len(ret.Calls) != tt.calls {
+				t.Errorf("%s, block %d: traced call count mismatch: have %d, want %d", name, tt.number, len(ret.Calls), tt.calls)
 			}
-		})
+		}
 	}
 }
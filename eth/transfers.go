@@ -0,0 +1,348 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/consensus/misc"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+const (
+	// transferSectionSize is the number of blocks in a section of the internal
+	// transfer index.
+	transferSectionSize = 32
+
+	// transferConfirms is the number of confirmations before indexing a section.
+	// It's kept low so the state of the blocks is still available when indexing.
+	transferConfirms = 16
+
+	// transferThrottling is the time to wait between processing two consecutive
+	// index sections.
+	transferThrottling = 100 * time.Millisecond
+
+	// maxTransferQueryBlocks is the maximum number of blocks a single internal
+	// transfer query may span.
+	maxTransferQueryBlocks = 10000
+)
+
+// transferTracer is a vm.Tracer collecting the internal value transfers made by
+// a transaction. Transfers are collected per call frame and are only kept if
+// the frame and all its parents succeed.
+type transferTracer struct {
+	frames  [][]*types.InternalTransfer // Transfers made within each running call frame
+	pending []*pendingTransfer          // Call and create operations waiting for their outcome
+}
+
+// pendingTransfer is a value transfer made by a call or create operation,
+// waiting for the inner frame to return.
+type pendingTransfer struct {
+	depth    int // Depth of the frame executing the operation
+	transfer *types.InternalTransfer
+}
+
+// newTransferTracer creates a tracer collecting internal value transfers.
+func newTransferTracer() *transferTracer {
+	return &transferTracer{frames: make([][]*types.InternalTransfer, 1)}
+}
+
+// CaptureStart implements vm.Tracer.
+func (t *transferTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements vm.Tracer, tracking the call frames and the value
+// transfers made by them.
+func (t *transferTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Settle all the inner frames that returned to the current one. The outcome
+	// of the operation is on top of the stack: zero for failures, non-zero for
+	// successful calls and the address of successfully created contracts.
+	for len(t.pending) > 0 && t.pending[len(t.pending)-1].depth >= depth {
+		call := t.pending[len(t.pending)-1]
+		t.pending = t.pending[:len(t.pending)-1]
+
+		inner := t.frames[len(t.frames)-1]
+		t.frames = t.frames[:len(t.frames)-1]
+
+		data := stack.Data()
+		if call.depth != depth || len(data) == 0 || data[len(data)-1].Sign() == 0 {
+			continue
+		}
+		if call.transfer.Kind == types.TransferCreate {
+			call.transfer.To = common.BigToAddress(data[len(data)-1])
+		}
+		outer := t.frames[len(t.frames)-1]
+		if call.transfer.Value.Sign() > 0 {
+			outer = append(outer, call.transfer)
+		}
+		t.frames[len(t.frames)-1] = append(outer, inner...)
+	}
+	if err != nil {
+		return nil
+	}
+	// Track the operations moving value
+	data := stack.Data()
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		// Value sent by CALLCODE stays with the executing contract
+		transfer := &types.InternalTransfer{Kind: types.TransferCall, From: contract.Address(), Value: new(big.Int)}
+		if op == vm.CALL {
+			transfer.To = common.BigToAddress(data[len(data)-2])
+			transfer.Value.Set(data[len(data)-3])
+		}
+		t.descend(depth, transfer)
+
+	case vm.DELEGATECALL, vm.STATICCALL:
+		t.descend(depth, &types.InternalTransfer{Kind: types.TransferCall, Value: new(big.Int)})
+
+	case vm.CREATE, vm.CREATE2:
+		t.descend(depth, &types.InternalTransfer{Kind: types.TransferCreate, From: contract.Address(), Value: new(big.Int).Set(data[len(data)-1])})
+
+	case vm.SELFDESTRUCT:
+		if balance := env.StateDB.GetBalance(contract.Address()); balance.Sign() > 0 {
+			t.frames[len(t.frames)-1] = append(t.frames[len(t.frames)-1], &types.InternalTransfer{
+				Kind:  types.TransferSelfdestruct,
+				From:  contract.Address(),
+				To:    common.BigToAddress(data[len(data)-1]),
+				Value: new(big.Int).Set(balance),
+			})
+		}
+	}
+	return nil
+}
+
+// descend starts a new call frame for the given pending transfer.
+func (t *transferTracer) descend(depth int, transfer *types.InternalTransfer) {
+	t.pending = append(t.pending, &pendingTransfer{depth: depth, transfer: transfer})
+	t.frames = append(t.frames, nil)
+}
+
+// CaptureFault implements vm.Tracer. Failed frames are detected by the zero
+// result of their operation in the parent frame.
+func (t *transferTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements vm.Tracer, dropping all the transfers if the outermost
+// call failed.
+func (t *transferTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	if err != nil {
+		t.frames = make([][]*types.InternalTransfer, 1)
+	}
+	return nil
+}
+
+// transfers returns the internal value transfers made by the transaction.
+func (t *transferTracer) transfers() []*types.InternalTransfer {
+	return t.frames[0]
+}
+
+// TransferIndexer implements a core.ChainIndexer, re-executing the blocks of
+// the canonical chain shortly after import to record the internal value
+// transfers made by their transactions.
+type TransferIndexer struct {
+	db    ethdb.Database                            // Database instance to write index data into
+	chain *core.BlockChain                          // Blockchain to retrieve the blocks and states from
+	items map[common.Hash][]*types.InternalTransfer // Transfers of the blocks of the current section
+	nums  map[common.Hash]uint64                    // Numbers of the blocks of the current section
+}
+
+// NewTransferIndexer returns a chain indexer that records the internal value
+// transfers of the canonical chain.
+func NewTransferIndexer(db ethdb.Database, chain *core.BlockChain) *core.ChainIndexer {
+	backend := &TransferIndexer{db: db, chain: chain}
+	table := ethdb.NewTable(db, string(rawdb.TransferIndexPrefix))
+
+	return core.NewChainIndexer(db, table, backend, transferSectionSize, transferConfirms, transferThrottling, "transfers")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new internal transfer
+// index section.
+func (t *TransferIndexer) Reset(ctx context.Context, section uint64, lastSectionHead common.Hash) error {
+	t.items = make(map[common.Hash][]*types.InternalTransfer)
+	t.nums = make(map[common.Hash]uint64)
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, re-executing the block of the
+// header to collect its internal value transfers. The state of the parent block
+// is required, so indexing needs an archive node that executed every block; a
+// missing state fails the section, which is retried instead of being committed
+// with the transfers missing.
+func (t *TransferIndexer) Process(ctx context.Context, header *types.Header) error {
+	block := t.chain.GetBlock(header.Hash(), header.Number.Uint64())
+	if block == nil {
+		return fmt.Errorf("block #%d [%x…] not found", header.Number, header.Hash().Bytes()[:4])
+	}
+	if len(block.Transactions()) == 0 {
+		return nil
+	}
+	parent := t.chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	statedb, err := t.chain.StateAt(parent.Root)
+	if err != nil {
+		return fmt.Errorf("state of block #%d [%x…] not available: %v", parent.Number, parent.Hash().Bytes()[:4], err)
+	}
+	config := t.chain.Config()
+	if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	var (
+		gp        = new(core.GasPool).AddGas(block.GasLimit())
+		usedGas   = new(uint64)
+		transfers = make([]*types.InternalTransfer, 0)
+	)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		tracer := newTransferTracer()
+		if _, _, err := core.ApplyTransaction(config, t.chain, nil, gp, statedb, header, tx, usedGas, vm.Config{Debug: true, Tracer: tracer}); err != nil {
+			return fmt.Errorf("transaction %#x: %v", tx.Hash(), err)
+		}
+		for _, transfer := range tracer.transfers() {
+			transfer.TxHash, transfer.TxIndex = tx.Hash(), uint(i)
+			transfers = append(transfers, transfer)
+		}
+	}
+	t.items[block.Hash()], t.nums[block.Hash()] = transfers, block.NumberU64()
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, writing the internal transfers of
+// the section into the database.
+func (t *TransferIndexer) Commit() error {
+	batch := t.db.NewBatch()
+	for hash, transfers := range t.items {
+		rawdb.WriteInternalTransfers(batch, hash, t.nums[hash], transfers)
+	}
+	return batch.Write()
+}
+
+// TransferFilterArgs are the criteria of an internal transfer query. Transfers
+// match if they were sent from or to any of the addresses, an empty list
+// matching all transfers.
+type TransferFilterArgs struct {
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+}
+
+// RPCInternalTransfer is an internal value transfer located in the chain.
+type RPCInternalTransfer struct {
+	Type             string         `json:"type"`
+	BlockHash        common.Hash    `json:"blockHash"`
+	BlockNumber      hexutil.Uint64 `json:"blockNumber"`
+	TransactionHash  common.Hash    `json:"transactionHash"`
+	TransactionIndex hexutil.Uint   `json:"transactionIndex"`
+	From             common.Address `json:"from"`
+	To               common.Address `json:"to"`
+	Value            *hexutil.Big   `json:"value"`
+}
+
+// PublicTransferAPI provides access to the internal value transfers recorded
+// by the transfer indexer.
+type PublicTransferAPI struct {
+	eth *Ethereum
+}
+
+// NewPublicTransferAPI creates a new internal transfer API.
+func NewPublicTransferAPI(eth *Ethereum) *PublicTransferAPI {
+	return &PublicTransferAPI{eth: eth}
+}
+
+// GetInternalTransfers returns the internal value transfers made within the
+// given block range, from or to any of the given addresses.
+func (api *PublicTransferAPI) GetInternalTransfers(ctx context.Context, args TransferFilterArgs) ([]*RPCInternalTransfer, error) {
+	if api.eth.transferIndexer == nil {
+		return nil, errors.New("internal transfer indexing disabled")
+	}
+	// Resolve the block range, defaulting to the last indexed block
+	sections, _, _ := api.eth.transferIndexer.Sections()
+	if sections == 0 {
+		return nil, errors.New("no blocks indexed yet")
+	}
+	indexed := sections*transferSectionSize - 1
+
+	resolve := func(number *rpc.BlockNumber) uint64 {
+		if number == nil || *number < 0 {
+			return indexed
+		}
+		return uint64(*number)
+	}
+	start, end := resolve(args.FromBlock), resolve(args.ToBlock)
+	switch {
+	case start > end:
+		return nil, fmt.Errorf("invalid block range #%d-#%d", start, end)
+	case end > indexed:
+		return nil, fmt.Errorf("block #%d not indexed yet, last indexed #%d", end, indexed)
+	case end-start >= maxTransferQueryBlocks:
+		return nil, fmt.Errorf("block range too large, maximum %d blocks", maxTransferQueryBlocks)
+	}
+	// Collect the matching transfers block by block
+	var (
+		db      = api.eth.ChainDb()
+		results = make([]*RPCInternalTransfer, 0)
+	)
+	for number := start; number <= end; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		if !rawdb.HasInternalTransfers(db, hash, number) {
+			if body := rawdb.ReadBody(db, hash, number); body != nil && len(body.Transactions) > 0 {
+				return nil, fmt.Errorf("internal transfers of block #%d unavailable", number)
+			}
+			continue
+		}
+		for _, transfer := range rawdb.ReadInternalTransfers(db, hash, number) {
+			if !includes(args.Addresses, transfer.From) && !includes(args.Addresses, transfer.To) {
+				continue
+			}
+			results = append(results, &RPCInternalTransfer{
+				Type:             transfer.Kind.String(),
+				BlockHash:        hash,
+				BlockNumber:      hexutil.Uint64(number),
+				TransactionHash:  transfer.TxHash,
+				TransactionIndex: hexutil.Uint(transfer.TxIndex),
+				From:             transfer.From,
+				To:               transfer.To,
+				Value:            (*hexutil.Big)(transfer.Value),
+			})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/consensus/ethash"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+func TestInternalTransfers(t *testing.T) {
+	var (
+		key, _      = crypto.GenerateKey()
+		sender      = crypto.PubkeyToAddress(key.PublicKey)
+		forwarder   = common.HexToAddress("0xa0")
+		reverter    = common.HexToAddress("0xc0")
+		recipient   = common.HexToAddress("0xb0")
+		beneficiary = common.HexToAddress("0xee")
+		signer      = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		txs         []*types.Transaction
+	)
+	alloc := core.GenesisAlloc{
+		sender: {Balance: big.NewInt(params.Ether)},
+		// Sends 100 wei to the recipient, then self destructs to the beneficiary
+		forwarder: {Balance: new(big.Int), Code: common.FromHex("6000600060006000606460b05af15060eeff")},
+		// Sends 50 wei to the recipient, then reverts
+		reverter: {Balance: big.NewInt(50), Code: common.FromHex("6000600060006000603260b05af15060006000fd")},
+	}
+
+	debug := newTestTracerAPI(t, alloc, transferSectionSize+transferConfirms, func(i int, block *core.BlockGen) {
+		if i != 0 {
+			return
+		}
+		tx1, _ := types.SignTx(types.NewTransaction(0, forwarder, big.NewInt(1000), 100000, big.NewInt(1), nil), signer, key)
+		tx2, _ := types.SignTx(types.NewTransaction(1, reverter, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		block.AddTx(tx1)
+		block.AddTx(tx2)
+		txs = append(txs, tx1, tx2)
+	})
+	eth := debug.eth
+	eth.transferIndexer = NewTransferIndexer(eth.chainDb, eth.blockchain)
+	eth.transferIndexer.Start(eth.blockchain)
+	defer eth.transferIndexer.Close()
+
+	api := NewPublicTransferAPI(eth)
+	for i := 0; ; i++ {
+		if sections, _, _ := eth.transferIndexer.Sections(); sections > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("internal transfers not indexed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Retrieve all the transfers of the indexed section
+	zero := rpc.BlockNumber(0)
+	transfers, err := api.GetInternalTransfers(context.Background(), TransferFilterArgs{FromBlock: &zero})
+	if err != nil {
+		t.Fatalf("failed to retrieve internal transfers: %v", err)
+	}
+	want := []struct {
+		kind     string
+		from, to common.Address
+		value    int64
+	}{
+		{"call", forwarder, recipient, 100},
+		{"selfdestruct", forwarder, beneficiary, 900},
+	}
+	if len(transfers) != len(want) {
+		t.Fatalf("transfer count mismatch: have %d, want %d", len(transfers), len(want))
+	}
+	for i, transfer := range transfers {
+		if transfer.Type != want[i].kind || transfer.From != want[i].from || transfer.To != want[i].to || transfer.Value.ToInt().Int64() != want[i].value {
+			t.Errorf("transfer %d: mismatch: have %+v, want %+v", i, transfer, want[i])
+		}
+		if transfer.BlockNumber != 1 || transfer.TransactionHash != txs[0].Hash() || transfer.TransactionIndex != 0 {
+			t.Errorf("transfer %d: location mismatch: have %+v", i, transfer)
+		}
+	}
+	// Filter the transfers by address and check the range limits
+	filters := []struct {
+		args TransferFilterArgs
+		want int
+		fail bool
+	}{
+		{TransferFilterArgs{FromBlock: &zero, Addresses: []common.Address{beneficiary}}, 1, false},
+		{TransferFilterArgs{FromBlock: &zero, Addresses: []common.Address{reverter}}, 0, false},
+		{TransferFilterArgs{}, 0, false},
+		{TransferFilterArgs{FromBlock: &zero, ToBlock: newBlockNumber(transferSectionSize)}, 0, true},
+	}
+	for i, filter := range filters {
+		transfers, err := api.GetInternalTransfers(context.Background(), filter.args)
+		if (err != nil) != filter.fail {
+			t.Errorf("filter %d: error mismatch: have %v, want failure %v", i, err, filter.fail)
+			continue
+		}
+		if len(transfers) != filter.want {
+			t.Errorf("filter %d: transfer count mismatch: have %d, want %d", i, len(transfers), filter.want)
+		}
+	}
+}
+
+// Tests that a block whose parent state is missing fails indexing instead of
+// being committed with its internal transfers silently left out.
+func TestInternalTransfersMissingState(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		signer = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	)
+	alloc := core.GenesisAlloc{sender: {Balance: big.NewInt(params.Ether)}}
+
+	debug := newTestTracerAPI(t, alloc, 2, func(i int, block *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(sender), common.Address{0x01}, big.NewInt(1000), params.TxGas, big.NewInt(1), nil), signer, key)
+		block.AddTx(tx)
+	})
+	// Drop the genesis state and reopen the chain to bypass any state caches
+	db := debug.eth.chainDb
+	db.Delete(debug.eth.blockchain.Genesis().Root().Bytes())
+
+	chain, err := core.NewBlockChain(db, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	defer chain.Stop()
+
+	indexer := &TransferIndexer{db: db, chain: chain}
+	indexer.Reset(context.Background(), 0, common.Hash{})
+
+	if err := indexer.Process(context.Background(), chain.GetHeaderByNumber(1)); err == nil {
+		t.Fatalf("block with missing parent state indexed")
+	}
+	if err := indexer.Process(context.Background(), chain.GetHeaderByNumber(2)); err != nil {
+		t.Fatalf("failed to index block with available parent state: %v", err)
+	}
+}
+
+// newBlockNumber returns a pointer to the given block number.
+func newBlockNumber(number int64) *rpc.BlockNumber {
+	n := rpc.BlockNumber(number)
+	return &n
+}
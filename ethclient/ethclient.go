@@ -230,6 +230,16 @@ func (ec *Client) TransactionSender(ctx context.Context, tx *types.Transaction,
 	return meta.From, nil
 }
 
+// TransactionFeePayer returns the address paying for the gas of the given transaction.
+// Sponsored transactions carry the fee payer signature, so their payer is recovered
+// locally. For all other transactions it is the sender as returned by TransactionSender.
+func (ec *Client) TransactionFeePayer(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
+	if !tx.Sponsored() {
+		return ec.TransactionSender(ctx, tx, block, index)
+	}
+	return types.Payer(nil, tx)
+}
+
 // TransactionCount returns the total number of transactions in the given block.
 func (ec *Client) TransactionCount(ctx context.Context, blockHash common.Hash) (uint, error) {
 	var num hexutil.Uint
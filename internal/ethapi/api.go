@@ -34,6 +34,7 @@ import (
 	"github.com/simplechain-org/simplechain/consensus/ethash"
 	"github.com/simplechain-org/simplechain/core"
 	"github.com/simplechain-org/simplechain/core/rawdb"
+	"github.com/simplechain-org/simplechain/core/state"
 	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/core/vm"
 	"github.com/simplechain-org/simplechain/crypto"
@@ -67,6 +68,59 @@ func (s *PublicEthereumAPI) GasPrice(ctx context.Context) (*hexutil.Big, error)
 	return (*hexutil.Big)(price), err
 }
 
+// GasPrices is the slow, standard and fast gas price recommendations returned
+// by GasPrices.
+type GasPrices struct {
+	Slow     *hexutil.Big `json:"slow"`
+	Standard *hexutil.Big `json:"standard"`
+	Fast     *hexutil.Big `json:"fast"`
+}
+
+// GasPrices returns a slow, a standard and a fast gas price suggestion, blending
+// the prices paid in recent blocks with the ones offered in the transaction pool.
+func (s *PublicEthereumAPI) GasPrices(ctx context.Context) (*GasPrices, error) {
+	slow, standard, fast, err := s.b.SuggestPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GasPrices{
+		Slow:     (*hexutil.Big)(slow),
+		Standard: (*hexutil.Big)(standard),
+		Fast:     (*hexutil.Big)(fast),
+	}, nil
+}
+
+// FeeHistoryResult is the fee summary of a range of blocks returned by FeeHistory.
+type FeeHistoryResult struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+// FeeHistory returns the fee summaries of up to blockCount blocks ending at
+// lastBlock: the gas used ratio of each block and the gas prices paid at the
+// requested percentiles of each block's gas used.
+func (s *PublicEthereumAPI) FeeHistory(ctx context.Context, blockCount hexutil.Uint, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*FeeHistoryResult, error) {
+	oldest, rewards, ratios, err := s.b.FeeHistory(ctx, int(blockCount), lastBlock, rewardPercentiles)
+	if err != nil {
+		return nil, err
+	}
+	result := &FeeHistoryResult{
+		OldestBlock:  (*hexutil.Big)(oldest),
+		GasUsedRatio: ratios,
+	}
+	if rewards != nil {
+		result.Reward = make([][]*hexutil.Big, len(rewards))
+		for i, block := range rewards {
+			result.Reward[i] = make([]*hexutil.Big, len(block))
+			for j, reward := range block {
+				result.Reward[i][j] = (*hexutil.Big)(reward)
+			}
+		}
+	}
+	return result, nil
+}
+
 // ProtocolVersion returns the current Ethereum protocol version this node supports
 func (s *PublicEthereumAPI) ProtocolVersion() hexutil.Uint {
 	return hexutil.Uint(s.b.ProtocolVersion())
@@ -113,12 +167,13 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 		"queued":  make(map[string]map[string]*RPCTransaction),
 	}
 	pending, queue := s.b.TxPoolContent()
+	signer := poolSigner(s.b)
 
 	// Flatten the pending transactions
 	for account, txs := range pending {
 		dump := make(map[string]*RPCTransaction)
 		for _, tx := range txs {
-			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+			dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, signer)
 		}
 		content["pending"][account.Hex()] = dump
 	}
@@ -126,7 +181,7 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	for account, txs := range queue {
 		dump := make(map[string]*RPCTransaction)
 		for _, tx := range txs {
-			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+			dump[fmt.Sprintf("%d", tx.Nonce())] = NewRPCPendingTransaction(tx, signer)
 		}
 		content["queued"][account.Hex()] = dump
 	}
@@ -356,11 +411,11 @@ func (s *PrivateAccountAPI) signTransaction(ctx context.Context, args *SendTxArg
 	// Assemble the transaction and sign with the wallet
 	tx := args.toTransaction()
 
-	var chainID *big.Int
-	if config := s.b.ChainConfig(); config.IsEIP155(s.b.CurrentBlock().Number()) {
-		chainID = config.ChainID
+	signed, err := signTxWithPassphrase(s.b, wallet, account, passwd, tx)
+	if err != nil || args.FeePayer == nil {
+		return signed, err
 	}
-	return wallet.SignTxWithPassphrase(account, passwd, tx, chainID)
+	return signPayer(s.am, *args.FeePayer, signed)
 }
 
 // SendTransaction will create a transaction from the given arguments and
@@ -683,13 +738,88 @@ type CallArgs struct {
 	Data     hexutil.Bytes   `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, timeout time.Duration, globalGasCap *big.Int) ([]byte, uint64, bool, error) {
+// ToMessage converts the call arguments to the message executed by the EVM,
+// filling in the defaults of the unset gas fields and capping the gas limit to
+// the global gas cap, if set.
+func (args *CallArgs) ToMessage(globalGasCap *big.Int) types.Message {
+	// Set default gas & gas price if none were set
+	gas := uint64(args.Gas)
+	if gas == 0 {
+		gas = math.MaxUint64 / 2
+	}
+	if globalGasCap != nil && globalGasCap.Uint64() < gas {
+		log.Warn("Caller gas above allowance, capping", "requested", gas, "cap", globalGasCap)
+		gas = globalGasCap.Uint64()
+	}
+	gasPrice := args.GasPrice.ToInt()
+	if gasPrice.Sign() == 0 {
+		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
+	}
+	return types.NewMessage(args.From, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, false)
+}
+
+// OverrideAccount specifies the fields of an account to replace temporarily
+// while executing a call. State and StateDiff are mutually exclusive: the former
+// replaces the entire storage of the account, the latter individual slots only.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64              `json:"nonce"`
+	Code      *hexutil.Bytes               `json:"code"`
+	Balance   **hexutil.Big                `json:"balance"`
+	State     *map[common.Hash]common.Hash `json:"state"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
+}
+
+// StateOverride is the collection of accounts overridden while executing a call.
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply overrides the fields of the specified accounts in the given state.
+func (diff *StateOverride) Apply(state *state.StateDB) error {
+	if diff == nil {
+		return nil
+	}
+	// Validate all the overrides before touching the state
+	for addr, account := range *diff {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+	}
+	for addr, account := range *diff {
+		// Override account nonce
+		if account.Nonce != nil {
+			state.SetNonce(addr, uint64(*account.Nonce))
+		}
+		// Override account(contract) code
+		if account.Code != nil {
+			state.SetCode(addr, *account.Code)
+		}
+		// Override account balance
+		if account.Balance != nil {
+			state.SetBalance(addr, (*big.Int)(*account.Balance))
+		}
+		// Replace entire state if caller requires
+		if account.State != nil {
+			state.SetStorage(addr, *account.State)
+		}
+		// Apply state diff into specified accounts
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				state.SetState(addr, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride, timeout time.Duration, globalGasCap *big.Int) ([]byte, uint64, bool, error) {
 	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
 
 	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
 		return nil, 0, false, err
 	}
+	if err := overrides.Apply(state); err != nil {
+		return nil, 0, false, err
+	}
 	// Set sender address or use a default if none specified
 	addr := args.From
 	if addr == (common.Address{}) {
@@ -699,21 +829,9 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 			}
 		}
 	}
-	// Set default gas & gas price if none were set
-	gas := uint64(args.Gas)
-	if gas == 0 {
-		gas = math.MaxUint64 / 2
-	}
-	if globalGasCap != nil && globalGasCap.Uint64() < gas {
-		log.Warn("Caller gas above allowance, capping", "requested", gas, "cap", globalGasCap)
-		gas = globalGasCap.Uint64()
-	}
-	gasPrice := args.GasPrice.ToInt()
-	if gasPrice.Sign() == 0 {
-		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
-	}
 	// Create new call message
-	msg := types.NewMessage(addr, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, false)
+	args.From = addr
+	msg := args.ToMessage(globalGasCap)
 
 	// Setup context so it may be cancelled the call has completed
 	// or, in case of unmetered gas, setup a context with a timeout.
@@ -751,14 +869,18 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, 5*time.Second, s.b.RPCGasCap())
+//
+// The optional overrides replace the balance, nonce, code or storage of the given
+// accounts before the call is executed, without persisting any of the changes.
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride) (hexutil.Bytes, error) {
+	result, _, _, err := s.doCall(ctx, args, blockNr, overrides, 5*time.Second, s.b.RPCGasCap())
 	return (hexutil.Bytes)(result), err
 }
 
 // EstimateGas returns an estimate of the amount of gas needed to execute the
-// given transaction against the current pending block.
-func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
+// given transaction against the current pending block. The optional overrides
+// are applied to the state before every execution, the same way as for Call.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs, overrides *StateOverride) (hexutil.Uint64, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var (
 		lo  uint64 = params.TxGas - 1
@@ -786,7 +908,7 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	executable := func(gas uint64) bool {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, 0, gasCap)
+		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, overrides, 0, gasCap)
 		if err != nil || failed {
 			return false
 		}
@@ -874,7 +996,7 @@ func FormatLogs(logs []vm.StructLog) []StructLogRes {
 // RPCMarshalBlock converts the given block to the RPC output which depends on fullTx. If inclTx is true transactions are
 // returned. When fullTx is true the returned block contains full transaction details, otherwise it will only contain
 // transaction hashes.
-func RPCMarshalBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]interface{}, error) {
+func RPCMarshalBlock(b *types.Block, inclTx bool, fullTx bool, config *params.ChainConfig) (map[string]interface{}, error) {
 	head := b.Header() // copies the header once
 	fields := map[string]interface{}{
 		"number":           (*hexutil.Big)(head.Number),
@@ -902,7 +1024,7 @@ func RPCMarshalBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]inter
 		}
 		if fullTx {
 			formatTx = func(tx *types.Transaction) (interface{}, error) {
-				return newRPCTransactionFromBlockHash(b, tx.Hash()), nil
+				return newRPCTransactionFromBlockHash(b, tx.Hash(), config), nil
 			}
 		}
 		txs := b.Transactions()
@@ -929,7 +1051,7 @@ func RPCMarshalBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]inter
 // rpcOutputBlock uses the generalized output filler, then adds the total difficulty field, which requires
 // a `PublicBlockchainAPI`.
 func (s *PublicBlockChainAPI) rpcOutputBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]interface{}, error) {
-	fields, err := RPCMarshalBlock(b, inclTx, fullTx)
+	fields, err := RPCMarshalBlock(b, inclTx, fullTx, s.b.ChainConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -942,6 +1064,7 @@ type RPCTransaction struct {
 	BlockHash        common.Hash     `json:"blockHash"`
 	BlockNumber      *hexutil.Big    `json:"blockNumber"`
 	From             common.Address  `json:"from"`
+	FeePayer         *common.Address `json:"feePayer,omitempty"`
 	Gas              hexutil.Uint64  `json:"gas"`
 	GasPrice         *hexutil.Big    `json:"gasPrice"`
 	Hash             common.Hash     `json:"hash"`
@@ -953,14 +1076,17 @@ type RPCTransaction struct {
 	V                *hexutil.Big    `json:"v"`
 	R                *hexutil.Big    `json:"r"`
 	S                *hexutil.Big    `json:"s"`
+	PayerV           *hexutil.Big    `json:"payerV,omitempty"`
+	PayerR           *hexutil.Big    `json:"payerR,omitempty"`
+	PayerS           *hexutil.Big    `json:"payerS,omitempty"`
 }
 
 // newRPCTransaction returns a transaction that will serialize to the RPC
-// representation, with the given location metadata set (if available).
-func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64) *RPCTransaction {
-	var signer types.Signer = types.FrontierSigner{}
-	if tx.Protected() {
-		signer = types.NewEIP155Signer(tx.ChainId())
+// representation, with the given location metadata set (if available). The
+// sender of replay protected transactions is derived using the given signer.
+func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64, signer types.Signer) *RPCTransaction {
+	if !tx.Protected() {
+		signer = types.FrontierSigner{}
 	}
 	from, _ := types.Sender(signer, tx)
 	v, r, s := tx.RawSignatureValues()
@@ -978,6 +1104,13 @@ func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber
 		R:        (*hexutil.Big)(r),
 		S:        (*hexutil.Big)(s),
 	}
+	if tx.Sponsored() {
+		payer, _ := types.Payer(signer, tx)
+		pv, pr, ps := tx.RawPayerSignatureValues()
+
+		result.FeePayer = &payer
+		result.PayerV, result.PayerR, result.PayerS = (*hexutil.Big)(pv), (*hexutil.Big)(pr), (*hexutil.Big)(ps)
+	}
 	if blockHash != (common.Hash{}) {
 		result.BlockHash = blockHash
 		result.BlockNumber = (*hexutil.Big)(new(big.Int).SetUint64(blockNumber))
@@ -986,18 +1119,18 @@ func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber
 	return result
 }
 
-// newRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
-func newRPCPendingTransaction(tx *types.Transaction) *RPCTransaction {
-	return newRPCTransaction(tx, common.Hash{}, 0, 0)
+// NewRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
+func NewRPCPendingTransaction(tx *types.Transaction, signer types.Signer) *RPCTransaction {
+	return newRPCTransaction(tx, common.Hash{}, 0, 0, signer)
 }
 
 // newRPCTransactionFromBlockIndex returns a transaction that will serialize to the RPC representation.
-func newRPCTransactionFromBlockIndex(b *types.Block, index uint64) *RPCTransaction {
+func newRPCTransactionFromBlockIndex(b *types.Block, index uint64, config *params.ChainConfig) *RPCTransaction {
 	txs := b.Transactions()
 	if index >= uint64(len(txs)) {
 		return nil
 	}
-	return newRPCTransaction(txs[index], b.Hash(), b.NumberU64(), index)
+	return newRPCTransaction(txs[index], b.Hash(), b.NumberU64(), index, types.LatestSigner(config, b.Number()))
 }
 
 // newRPCRawTransactionFromBlockIndex returns the bytes of a transaction given a block and a transaction index.
@@ -1011,10 +1144,10 @@ func newRPCRawTransactionFromBlockIndex(b *types.Block, index uint64) hexutil.By
 }
 
 // newRPCTransactionFromBlockHash returns a transaction that will serialize to the RPC representation.
-func newRPCTransactionFromBlockHash(b *types.Block, hash common.Hash) *RPCTransaction {
+func newRPCTransactionFromBlockHash(b *types.Block, hash common.Hash, config *params.ChainConfig) *RPCTransaction {
 	for idx, tx := range b.Transactions() {
 		if tx.Hash() == hash {
-			return newRPCTransactionFromBlockIndex(b, uint64(idx))
+			return newRPCTransactionFromBlockIndex(b, uint64(idx), config)
 		}
 	}
 	return nil
@@ -1052,7 +1185,7 @@ func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByHash(ctx context.Co
 // GetTransactionByBlockNumberAndIndex returns the transaction for the given block number and index.
 func (s *PublicTransactionPoolAPI) GetTransactionByBlockNumberAndIndex(ctx context.Context, blockNr rpc.BlockNumber, index hexutil.Uint) *RPCTransaction {
 	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
-		return newRPCTransactionFromBlockIndex(block, uint64(index))
+		return newRPCTransactionFromBlockIndex(block, uint64(index), s.b.ChainConfig())
 	}
 	return nil
 }
@@ -1060,7 +1193,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByBlockNumberAndIndex(ctx conte
 // GetTransactionByBlockHashAndIndex returns the transaction for the given block hash and index.
 func (s *PublicTransactionPoolAPI) GetTransactionByBlockHashAndIndex(ctx context.Context, blockHash common.Hash, index hexutil.Uint) *RPCTransaction {
 	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
-		return newRPCTransactionFromBlockIndex(block, uint64(index))
+		return newRPCTransactionFromBlockIndex(block, uint64(index), s.b.ChainConfig())
 	}
 	return nil
 }
@@ -1104,11 +1237,11 @@ func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, addr
 func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) *RPCTransaction {
 	// Try to return an already finalized transaction
 	if tx, blockHash, blockNumber, index := rawdb.ReadTransaction(s.b.ChainDb(), hash); tx != nil {
-		return newRPCTransaction(tx, blockHash, blockNumber, index)
+		return newRPCTransaction(tx, blockHash, blockNumber, index, types.LatestSigner(s.b.ChainConfig(), new(big.Int).SetUint64(blockNumber)))
 	}
 	// No finalized transaction, try to retrieve it from the pool
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
-		return newRPCPendingTransaction(tx)
+		return NewRPCPendingTransaction(tx, poolSigner(s.b))
 	}
 	// Transaction unknown, return as such
 	return nil
@@ -1146,7 +1279,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 
 	var signer types.Signer = types.FrontierSigner{}
 	if tx.Protected() {
-		signer = types.NewEIP155Signer(tx.ChainId())
+		signer = types.LatestSigner(s.b.ChainConfig(), new(big.Int).SetUint64(blockNumber))
 	}
 	from, _ := types.Sender(signer, tx)
 
@@ -1190,16 +1323,31 @@ func (s *PublicTransactionPoolAPI) sign(addr common.Address, tx *types.Transacti
 		return nil, err
 	}
 	// Request the wallet to sign the transaction
-	var chainID *big.Int
-	if config := s.b.ChainConfig(); config.IsEIP155(s.b.CurrentBlock().Number()) {
-		chainID = config.ChainID
+	return signTx(s.b, wallet, account, tx)
+}
+
+// signPayer is a helper function that adds the fee payer signature of the given
+// address to a transaction already signed by its sender, turning it into a
+// sponsored transaction. The fee payer account needs to be unlocked.
+func signPayer(am *accounts.Manager, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	// Look up the wallet containing the requested fee payer
+	account := accounts.Account{Address: addr}
+
+	wallet, err := am.Find(account)
+	if err != nil {
+		return nil, err
 	}
-	return wallet.SignTx(account, tx, chainID)
+	sig, err := wallet.SignHash(account, types.PayerHash(tx).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithPayerSignature(sig)
 }
 
 // SendTxArgs represents the arguments to sumbit a new transaction into the transaction pool.
 type SendTxArgs struct {
 	From     common.Address  `json:"from"`
+	FeePayer *common.Address `json:"feePayer"`
 	To       *common.Address `json:"to"`
 	Gas      *hexutil.Uint64 `json:"gas"`
 	GasPrice *hexutil.Big    `json:"gasPrice"`
@@ -1265,14 +1413,65 @@ func (args *SendTxArgs) toTransaction() *types.Transaction {
 	return types.NewTransaction(uint64(*args.Nonce), *args.To, (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input)
 }
 
+// pendingSigner returns the signer transactions to be included in the pending
+// block need to be signed with.
+func pendingSigner(b Backend) types.Signer {
+	return types.MakeSigner(b.ChainConfig(), new(big.Int).Add(b.CurrentBlock().Number(), big.NewInt(1)))
+}
+
+// poolSigner returns the signer deriving the senders of the replay protected
+// transactions in the transaction pool.
+func poolSigner(b Backend) types.Signer {
+	return types.LatestSigner(b.ChainConfig(), new(big.Int).Add(b.CurrentBlock().Number(), big.NewInt(1)))
+}
+
+// pendingChainID returns the chain ID the transactions of the pending block need
+// to be signed with by wallets not supporting arbitrary signers, nil if replay
+// protection is not active yet. Such wallets can't sign for the chain specific
+// signing domain, in which case an error is returned.
+func pendingChainID(b Backend) (*big.Int, error) {
+	config, number := b.ChainConfig(), new(big.Int).Add(b.CurrentBlock().Number(), big.NewInt(1))
+	switch {
+	case config.IsSigningDomain(number):
+		return nil, errors.New("wallet does not support the chain's signing domain")
+	case config.IsEIP155(number):
+		return config.ChainID, nil
+	}
+	return nil, nil
+}
+
+// signTx signs a transaction for the pending block with the given wallet, using
+// the pending signer if the wallet supports it and the chain ID otherwise.
+func signTx(b Backend, wallet accounts.Wallet, account accounts.Account, tx *types.Transaction) (*types.Transaction, error) {
+	if wallet, ok := wallet.(accounts.SignerWallet); ok {
+		return wallet.SignTxWithSigner(account, tx, pendingSigner(b))
+	}
+	chainID, err := pendingChainID(b)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.SignTx(account, tx, chainID)
+}
+
+// signTxWithPassphrase is the passphrase authenticated variant of signTx.
+func signTxWithPassphrase(b Backend, wallet accounts.Wallet, account accounts.Account, passphrase string, tx *types.Transaction) (*types.Transaction, error) {
+	if wallet, ok := wallet.(accounts.SignerWallet); ok {
+		return wallet.SignTxWithSignerAndPassphrase(account, passphrase, tx, pendingSigner(b))
+	}
+	chainID, err := pendingChainID(b)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.SignTxWithPassphrase(account, passphrase, tx, chainID)
+}
+
 // submitTransaction is a helper function that submits tx to txPool and logs a message.
 func submitTransaction(ctx context.Context, b Backend, tx *types.Transaction) (common.Hash, error) {
 	if err := b.SendTx(ctx, tx); err != nil {
 		return common.Hash{}, err
 	}
 	if tx.To() == nil {
-		signer := types.MakeSigner(b.ChainConfig(), b.CurrentBlock().Number())
-		from, err := types.Sender(signer, tx)
+		from, err := types.Sender(poolSigner(b), tx)
 		if err != nil {
 			return common.Hash{}, err
 		}
@@ -1310,14 +1509,15 @@ func (s *PublicTransactionPoolAPI) SendTransaction(ctx context.Context, args Sen
 	// Assemble the transaction and sign with the wallet
 	tx := args.toTransaction()
 
-	var chainID *big.Int
-	if config := s.b.ChainConfig(); config.IsEIP155(s.b.CurrentBlock().Number()) {
-		chainID = config.ChainID
-	}
-	signed, err := wallet.SignTx(account, tx, chainID)
+	signed, err := signTx(s.b, wallet, account, tx)
 	if err != nil {
 		return common.Hash{}, err
 	}
+	if args.FeePayer != nil {
+		if signed, err = signPayer(s.b.AccountManager(), *args.FeePayer, signed); err != nil {
+			return common.Hash{}, err
+		}
+	}
 	return submitTransaction(ctx, s.b, signed)
 }
 
@@ -1382,6 +1582,11 @@ func (s *PublicTransactionPoolAPI) SignTransaction(ctx context.Context, args Sen
 	if err != nil {
 		return nil, err
 	}
+	if args.FeePayer != nil {
+		if tx, err = signPayer(s.b.AccountManager(), *args.FeePayer, tx); err != nil {
+			return nil, err
+		}
+	}
 	data, err := rlp.EncodeToBytes(tx)
 	if err != nil {
 		return nil, err
@@ -1403,14 +1608,15 @@ func (s *PublicTransactionPoolAPI) PendingTransactions() ([]*RPCTransaction, err
 		}
 	}
 	transactions := make([]*RPCTransaction, 0, len(pending))
+	pooled := poolSigner(s.b)
 	for _, tx := range pending {
 		var signer types.Signer = types.HomesteadSigner{}
 		if tx.Protected() {
-			signer = types.NewEIP155Signer(tx.ChainId())
+			signer = pooled
 		}
 		from, _ := types.Sender(signer, tx)
 		if _, exists := accounts[from]; exists {
-			transactions = append(transactions, newRPCPendingTransaction(tx))
+			transactions = append(transactions, NewRPCPendingTransaction(tx, pooled))
 		}
 	}
 	return transactions, nil
@@ -1431,10 +1637,11 @@ func (s *PublicTransactionPoolAPI) Resend(ctx context.Context, sendArgs SendTxAr
 		return common.Hash{}, err
 	}
 
+	pooled := poolSigner(s.b)
 	for _, p := range pending {
 		var signer types.Signer = types.HomesteadSigner{}
 		if p.Protected() {
-			signer = types.NewEIP155Signer(p.ChainId())
+			signer = pooled
 		}
 		wantSigHash := signer.Hash(matchTx)
 
@@ -0,0 +1,261 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/simplechain-org/simplechain/accounts"
+	"github.com/simplechain-org/simplechain/common"
+	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core"
+	"github.com/simplechain-org/simplechain/core/state"
+	"github.com/simplechain-org/simplechain/core/types"
+	"github.com/simplechain-org/simplechain/core/vm"
+	"github.com/simplechain-org/simplechain/crypto"
+	"github.com/simplechain-org/simplechain/ethdb"
+	"github.com/simplechain-org/simplechain/params"
+	"github.com/simplechain-org/simplechain/rpc"
+)
+
+// callBackend is a Backend executing calls on top of a fixed state, all the
+// methods not needed by Call and EstimateGas being left unimplemented.
+type callBackend struct {
+	Backend
+	state  *state.StateDB
+	header *types.Header
+}
+
+func newCallBackend() *callBackend {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	return &callBackend{
+		state:  statedb,
+		header: &types.Header{Number: big.NewInt(1), GasLimit: 1000000, Difficulty: big.NewInt(1)},
+	}
+}
+
+func (b *callBackend) RPCGasCap() *big.Int { return nil }
+
+func (b *callBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	return types.NewBlockWithHeader(b.header), nil
+}
+
+func (b *callBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	return b.state.Copy(), b.header, nil
+}
+
+func (b *callBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header) (*vm.EVM, func() error, error) {
+	vmctx := core.NewEVMContext(msg, header, nil, &header.Coinbase)
+	return vm.NewEVM(vmctx, state, params.TestChainConfig, vm.Config{}), func() error { return nil }, nil
+}
+
+// Tests that the balance, code and storage overrides are in effect while
+// executing calls, without modifying the underlying state.
+func TestCallStateOverrides(t *testing.T) {
+	var (
+		backend  = newCallBackend()
+		api      = NewPublicBlockChainAPI(backend)
+		sender   = common.Address{0x01}
+		contract = common.Address{0xc0}
+
+		funds   = (*hexutil.Big)(big.NewInt(params.Ether))
+		balance = hexutil.Bytes(common.FromHex("303160005260206000f3"))   // Returns its own balance
+		storage = hexutil.Bytes(common.FromHex("60005460005260206000f3")) // Returns storage slot 0
+		slots   = map[common.Hash]common.Hash{{}: {0xff}}
+	)
+	args := CallArgs{From: sender, To: &contract, Gas: 100000, GasPrice: hexutil.Big(*big.NewInt(1))}
+
+	// The unfunded sender cannot pay for the call without a balance override
+	if _, err := api.Call(context.Background(), args, rpc.LatestBlockNumber, nil); err == nil {
+		t.Fatalf("unfunded call succeeded")
+	}
+	overrides := StateOverride{
+		sender:   {Balance: &funds},
+		contract: {Code: &balance, Balance: &funds},
+	}
+	res, err := api.Call(context.Background(), args, rpc.LatestBlockNumber, &overrides)
+	if err != nil {
+		t.Fatalf("failed to call with balance override: %v", err)
+	}
+	if have := new(big.Int).SetBytes(res); have.Cmp(funds.ToInt()) != 0 {
+		t.Errorf("overridden balance mismatch: have %v, want %v", have, funds.ToInt())
+	}
+	overrides = StateOverride{
+		sender:   {Balance: &funds},
+		contract: {Code: &storage, State: &slots},
+	}
+	if res, err = api.Call(context.Background(), args, rpc.LatestBlockNumber, &overrides); err != nil {
+		t.Fatalf("failed to call with storage override: %v", err)
+	}
+	if have := common.BytesToHash(res); have != slots[common.Hash{}] {
+		t.Errorf("overridden storage mismatch: have %x, want %x", have, slots[common.Hash{}])
+	}
+	// None of the overrides may leak into the backing state
+	if backend.state.GetBalance(sender).Sign() != 0 || len(backend.state.GetCode(contract)) != 0 {
+		t.Errorf("overrides leaked into the backing state")
+	}
+}
+
+// Tests that gas estimation executes against the overridden state.
+func TestEstimateGasStateOverrides(t *testing.T) {
+	var (
+		api      = NewPublicBlockChainAPI(newCallBackend())
+		sender   = common.Address{0x01}
+		contract = common.Address{0xc0}
+
+		funds = (*hexutil.Big)(big.NewInt(params.Ether))
+		code  = hexutil.Bytes(common.FromHex("600054600a57600080fd5b00")) // Reverts unless storage slot 0 is set
+		slots = map[common.Hash]common.Hash{{}: {0x01}}
+	)
+	args := CallArgs{From: sender, To: &contract, GasPrice: hexutil.Big(*big.NewInt(1))}
+
+	overrides := StateOverride{
+		sender:   {Balance: &funds},
+		contract: {Code: &code},
+	}
+	if _, err := api.EstimateGas(context.Background(), args, &overrides); err == nil {
+		t.Fatalf("reverting call estimated")
+	}
+	overrides[contract] = OverrideAccount{Code: &code, StateDiff: &slots}
+	gas, err := api.EstimateGas(context.Background(), args, &overrides)
+	if err != nil {
+		t.Fatalf("failed to estimate with storage override: %v", err)
+	}
+	if gas <= hexutil.Uint64(params.TxGas) {
+		t.Errorf("estimate too low: have %d, want above %d", gas, params.TxGas)
+	}
+	// Without funds no allowance is executable
+	delete(overrides, sender)
+	if _, err := api.EstimateGas(context.Background(), args, &overrides); err == nil {
+		t.Errorf("unfunded call estimated")
+	}
+}
+
+// Tests that invalid state overrides are rejected before any account of the
+// override set is modified.
+func TestStateOverrideApplyInvalid(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+
+	var (
+		nonce = hexutil.Uint64(5)
+		slots = map[common.Hash]common.Hash{{}: {0x01}}
+	)
+	overrides := StateOverride{
+		common.Address{0x01}: {Nonce: &nonce},
+		common.Address{0x02}: {Nonce: &nonce, State: &slots, StateDiff: &slots},
+	}
+	if err := overrides.Apply(statedb); err == nil {
+		t.Fatalf("conflicting storage overrides accepted")
+	}
+	for addr := range overrides {
+		if n := statedb.GetNonce(addr); n != 0 {
+			t.Errorf("account %x: nonce overridden to %d", addr, n)
+		}
+	}
+}
+
+// signingBackend is a Backend with a fixed chain configuration and head block,
+// all the methods not needed to pick the transaction signers left unimplemented.
+type signingBackend struct {
+	Backend
+	config *params.ChainConfig
+	head   *types.Block
+}
+
+func (b *signingBackend) ChainConfig() *params.ChainConfig { return b.config }
+func (b *signingBackend) CurrentBlock() *types.Block       { return b.head }
+
+// keyWallet is a wallet of a single private key, signing transactions via the
+// chain ID based methods only.
+type keyWallet struct {
+	accounts.Wallet
+	key *ecdsa.PrivateKey
+}
+
+func (w *keyWallet) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	var signer types.Signer = types.HomesteadSigner{}
+	if chainID != nil {
+		signer = types.NewEIP155Signer(chainID)
+	}
+	return types.SignTx(tx, signer, w.key)
+}
+
+// signerKeyWallet is a keyWallet also supporting arbitrary signers.
+type signerKeyWallet struct {
+	*keyWallet
+}
+
+func (w *signerKeyWallet) SignTxWithSigner(account accounts.Account, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	return types.SignTx(tx, signer, w.key)
+}
+
+func (w *signerKeyWallet) SignTxWithSignerAndPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	return w.SignTxWithSigner(account, tx, signer)
+}
+
+// Tests that transactions are signed for the pending block: via the chain ID by
+// wallets not supporting arbitrary signers, and with the signing domain by the
+// ones which do once the domain is active.
+func TestSignTxPendingSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		account = accounts.Account{Address: addr}
+		plain   = &keyWallet{key: key}
+		capable = &signerKeyWallet{plain}
+		config  = &params.ChainConfig{
+			ChainID:            big.NewInt(1337),
+			HomesteadBlock:     new(big.Int),
+			EIP155Block:        new(big.Int),
+			SigningDomainBlock: big.NewInt(10),
+			SigningDomain:      "test",
+		}
+		tx = types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	)
+	head := func(number int64) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(number)})
+	}
+	// Before the fork, both wallets produce senders recoverable by the pool
+	backend := &signingBackend{config: config, head: head(8)}
+	for i, wallet := range []accounts.Wallet{plain, capable} {
+		signed, err := signTx(backend, wallet, account, tx)
+		if err != nil {
+			t.Fatalf("wallet %d: failed to sign pre-fork transaction: %v", i, err)
+		}
+		if from, err := types.Sender(poolSigner(backend), signed); err != nil || from != addr {
+			t.Errorf("wallet %d: pre-fork sender mismatch: have %x, want %x, err %v", i, from, addr, err)
+		}
+	}
+	// On the block before the fork, the pending block already needs the domain
+	backend.head = head(9)
+	if _, err := signTx(backend, plain, account, tx); err == nil {
+		t.Errorf("wallet without signer support signed for the signing domain")
+	}
+	signed, err := signTx(backend, capable, account, tx)
+	if err != nil {
+		t.Fatalf("failed to sign domain transaction: %v", err)
+	}
+	if from, err := types.Sender(types.NewDomainSigner(config.ChainID, config.SigningDomain), signed); err != nil || from != addr {
+		t.Errorf("domain sender mismatch: have %x, want %x, err %v", from, addr, err)
+	}
+	if from, err := types.Sender(poolSigner(backend), signed); err != nil || from != addr {
+		t.Errorf("pool sender mismatch: have %x, want %x, err %v", from, addr, err)
+	}
+}
@@ -68,6 +68,8 @@ type TxPool struct {
 	clearIdx     uint64                               // earliest block nr that can contain mined tx info
 
 	homestead bool
+	istanbul  bool
+	sponsor   bool
 }
 
 // TxRelayBackend provides an interface to the mechanism that forwards transacions
@@ -310,6 +312,8 @@ func (pool *TxPool) setNewHead(head *types.Header) {
 	m, r := txc.getLists()
 	pool.relay.NewHead(pool.head, m, r)
 	pool.homestead = pool.config.IsHomestead(head.Number)
+	pool.istanbul = pool.config.IsIstanbul(head.Number)
+	pool.sponsor = pool.config.IsSponsor(head.Number)
 	pool.signer = types.MakeSigner(pool.config, head.Number)
 }
 
@@ -373,12 +377,36 @@ func (pool *TxPool) validateTx(ctx context.Context, tx *types.Transaction) error
 
 	// Transactor should have enough funds to cover the costs
 	// cost == V + GP * GL
-	if b := currentState.GetBalance(from); b.Cmp(tx.Cost()) < 0 {
-		return core.ErrInsufficientFunds
+	if !tx.Sponsored() {
+		if b := currentState.GetBalance(from); b.Cmp(tx.Cost()) < 0 {
+			return core.ErrInsufficientFunds
+		}
+	} else {
+		// Sponsored transactions split the cost: the sender covers the value
+		// and the fee payer covers GP * GL
+		if !pool.sponsor {
+			return core.ErrSponsorNotActive
+		}
+		payer, err := types.Payer(pool.signer, tx)
+		if err != nil {
+			return core.ErrInvalidPayer
+		}
+		if payer == from {
+			if b := currentState.GetBalance(from); b.Cmp(tx.Cost()) < 0 {
+				return core.ErrInsufficientFunds
+			}
+		} else {
+			if b := currentState.GetBalance(from); b.Cmp(tx.Value()) < 0 {
+				return core.ErrInsufficientFunds
+			}
+			if b := currentState.GetBalance(payer); b.Cmp(tx.GasCost()) < 0 {
+				return core.ErrInsufficientPayerFunds
+			}
+		}
 	}
 
 	// Should supply enough intrinsic gas
-	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
+	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead, pool.istanbul)
 	if err != nil {
 		return err
 	}
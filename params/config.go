@@ -94,16 +94,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, "", big.NewInt(0), big.NewInt(0), nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
 
 	// AllScryptProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Scrypt consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllScryptProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(ScryptConfig)}
+	AllScryptProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, "", big.NewInt(0), big.NewInt(0), nil, nil, nil, new(ScryptConfig)}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(ScryptConfig)}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, "", big.NewInt(0), big.NewInt(0), nil, nil, nil, new(ScryptConfig)}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -142,7 +142,16 @@ type ChainConfig struct {
 	ByzantiumBlock      *big.Int `json:"byzantiumBlock,omitempty"`      // Byzantium switch block (nil = no fork, 0 = already on byzantium)
 	ConstantinopleBlock *big.Int `json:"constantinopleBlock,omitempty"` // Constantinople switch block (nil = no fork, 0 = already activated)
 	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = same as Constantinople)
-	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
+	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork, 0 = already on istanbul)
+
+	// SigningDomain separates the transaction signatures of the chain from those
+	// of any other chain, even when sharing the chain id.
+	SigningDomainBlock *big.Int `json:"signingDomainBlock,omitempty"` // Signing domain switch block (nil = no fork, 0 = already activated)
+	SigningDomain      string   `json:"signingDomain,omitempty"`      // Signing domain mixed into the transaction signature hashes
+
+	CryptoBlock  *big.Int `json:"cryptoBlock,omitempty"`  // Native cryptography precompiles switch block (nil = no fork, 0 = already activated)
+	SponsorBlock *big.Int `json:"sponsorBlock,omitempty"` // Fee payer sponsored transactions switch block (nil = no fork, 0 = already activated)
+	EWASMBlock   *big.Int `json:"ewasmBlock,omitempty"`   // EWASM switch block (nil = no fork, 0 = already activated)
 
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
@@ -190,7 +199,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v  ConstantinopleFix: %v Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v  ConstantinopleFix: %v Istanbul: %v SigningDomain: %v Crypto: %v Sponsor: %v Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -201,6 +210,10 @@ func (c *ChainConfig) String() string {
 		c.ByzantiumBlock,
 		c.ConstantinopleBlock,
 		c.PetersburgBlock,
+		c.IstanbulBlock,
+		c.SigningDomainBlock,
+		c.CryptoBlock,
+		c.SponsorBlock,
 		engine,
 	)
 }
@@ -247,6 +260,28 @@ func (c *ChainConfig) IsPetersburg(num *big.Int) bool {
 	return isForked(c.PetersburgBlock, num) || c.PetersburgBlock == nil && isForked(c.ConstantinopleBlock, num)
 }
 
+// IsIstanbul returns whether num is either equal to the Istanbul fork block or greater.
+func (c *ChainConfig) IsIstanbul(num *big.Int) bool {
+	return isForked(c.IstanbulBlock, num)
+}
+
+// IsSigningDomain returns whether num is either equal to the signing domain fork block or greater.
+func (c *ChainConfig) IsSigningDomain(num *big.Int) bool {
+	return isForked(c.SigningDomainBlock, num)
+}
+
+// IsCrypto returns whether num is either equal to the native cryptography
+// precompiles fork block or greater.
+func (c *ChainConfig) IsCrypto(num *big.Int) bool {
+	return isForked(c.CryptoBlock, num)
+}
+
+// IsSponsor returns whether num is either equal to the fee payer sponsored
+// transactions fork block or greater.
+func (c *ChainConfig) IsSponsor(num *big.Int) bool {
+	return isForked(c.SponsorBlock, num)
+}
+
 // IsEWASM returns whether num represents a block number after the EWASM fork
 func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
@@ -260,6 +295,8 @@ func (c *ChainConfig) GasTable(num *big.Int) GasTable {
 		return GasTableHomestead
 	}
 	switch {
+	case c.IsIstanbul(num):
+		return GasTableIstanbul
 	case c.IsConstantinople(num):
 		return GasTableConstantinople
 	case c.IsEIP158(num):
@@ -320,6 +357,21 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.PetersburgBlock, newcfg.PetersburgBlock, head) {
 		return newCompatError("ConstantinopleFix fork block", c.PetersburgBlock, newcfg.PetersburgBlock)
 	}
+	if isForkIncompatible(c.IstanbulBlock, newcfg.IstanbulBlock, head) {
+		return newCompatError("Istanbul fork block", c.IstanbulBlock, newcfg.IstanbulBlock)
+	}
+	if isForkIncompatible(c.SigningDomainBlock, newcfg.SigningDomainBlock, head) {
+		return newCompatError("signing domain fork block", c.SigningDomainBlock, newcfg.SigningDomainBlock)
+	}
+	if c.IsSigningDomain(head) && c.SigningDomain != newcfg.SigningDomain {
+		return newCompatError("signing domain", c.SigningDomainBlock, newcfg.SigningDomainBlock)
+	}
+	if isForkIncompatible(c.CryptoBlock, newcfg.CryptoBlock, head) {
+		return newCompatError("crypto fork block", c.CryptoBlock, newcfg.CryptoBlock)
+	}
+	if isForkIncompatible(c.SponsorBlock, newcfg.SponsorBlock, head) {
+		return newCompatError("sponsor fork block", c.SponsorBlock, newcfg.SponsorBlock)
+	}
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
@@ -390,6 +442,7 @@ type Rules struct {
 	ChainID                                     *big.Int
 	IsHomestead, IsEIP150, IsEIP155, IsEIP158   bool
 	IsByzantium, IsConstantinople, IsPetersburg bool
+	IsIstanbul, IsCrypto                        bool
 }
 
 // Rules ensures c's ChainID is not nil.
@@ -407,5 +460,7 @@ func (c *ChainConfig) Rules(num *big.Int) Rules {
 		IsByzantium:      c.IsByzantium(num),
 		IsConstantinople: c.IsConstantinople(num),
 		IsPetersburg:     c.IsPetersburg(num),
+		IsIstanbul:       c.IsIstanbul(num),
+		IsCrypto:         c.IsCrypto(num),
 	}
 }
@@ -52,6 +52,15 @@ const (
 	NetSstoreResetRefund      uint64 = 4800  // Once per SSTORE operation for resetting to the original non-zero value
 	NetSstoreResetClearRefund uint64 = 19800 // Once per SSTORE operation for resetting to the original zero value
 
+	SstoreSentryGasEIP2200   uint64 = 2300  // Minimum gas required to be present for an SSTORE call, not consumed
+	SstoreNoopGasEIP2200     uint64 = 800   // Once per SSTORE operation if the value doesn't change.
+	SstoreDirtyGasEIP2200    uint64 = 800   // Once per SSTORE operation if a dirty value is changed.
+	SstoreInitGasEIP2200     uint64 = 20000 // Once per SSTORE operation from clean zero to non-zero
+	SstoreInitRefundEIP2200  uint64 = 19200 // Once per SSTORE operation for resetting to the original zero value
+	SstoreCleanGasEIP2200    uint64 = 5000  // Once per SSTORE operation from clean non-zero to something else
+	SstoreCleanRefundEIP2200 uint64 = 4200  // Once per SSTORE operation for resetting to the original non-zero value
+	SstoreClearRefundEIP2200 uint64 = 15000 // Once per SSTORE operation for clearing an originally existing storage slot
+
 	JumpdestGas      uint64 = 1     // Refunded gas, once per SSTORE operation if the zeroness changes to zero.
 	EpochDuration    uint64 = 30000 // Duration between proof-of-work epochs.
 	CallGas          uint64 = 40    // Once per CALL operation & message call transaction.
@@ -69,8 +78,15 @@ const (
 	MemoryGas        uint64 = 3     // Times the address of the (highest referenced byte in memory + 1). NOTE: referencing happens on read, write and in instructions such as RETURN and CALL.
 	TxDataNonZeroGas uint64 = 68    // Per byte of data attached to a transaction that is not equal to zero. NOTE: Not payable on data of calls between transactions.
 
+	TxDataNonZeroGasEIP2028 uint64 = 16 // Per byte of non zero data attached to a transaction after EIP 2028 (part in Istanbul)
+
 	MaxCodeSize = 24576 // Maximum bytecode to permit for a contract
 
+	EWASMMemoryPageGas  uint64 = 6144 // Per 64KB page of ewasm linear memory, the linear part of the EVM memory cost
+	EWASMMaxMemoryPages uint32 = 256  // Maximum number of pages of ewasm linear memory (16MB)
+	EWASMTableGas       uint64 = 3    // Per element of the ewasm function table, priced like a word of EVM memory
+	EWASMMaxTableSize   uint32 = 8192 // Maximum number of elements of the ewasm function table
+
 	// Precompiled contract gas prices
 
 	EcrecoverGas            uint64 = 3000   // Elliptic curve sender recovery gas price
@@ -85,6 +101,21 @@ const (
 	Bn256ScalarMulGas       uint64 = 40000  // Gas needed for an elliptic curve scalar multiplication
 	Bn256PairingBaseGas     uint64 = 100000 // Base price for an elliptic curve pairing check
 	Bn256PairingPerPointGas uint64 = 80000  // Per-point price for an elliptic curve pairing check
+
+	Bn256AddGasIstanbul             uint64 = 150   // Gas needed for an elliptic curve addition after EIP 1108 (part in Istanbul)
+	Bn256ScalarMulGasIstanbul       uint64 = 6000  // Gas needed for an elliptic curve scalar multiplication after EIP 1108
+	Bn256PairingBaseGasIstanbul     uint64 = 45000 // Base price for an elliptic curve pairing check after EIP 1108
+	Bn256PairingPerPointGasIstanbul uint64 = 34000 // Per-point price for an elliptic curve pairing check after EIP 1108
+	Blake2FRoundGas                 uint64 = 1     // Per-round price for the BLAKE2b F compression function (EIP 152)
+
+	ScryptHashGas             uint64 = 15000  // Gas needed for a scrypt proof-of-work hash
+	Bls12381G1AddGas          uint64 = 600    // Gas needed for a BLS12-381 G1 addition (EIP 2537)
+	Bls12381G1MulGas          uint64 = 12000  // Gas needed for a BLS12-381 G1 scalar multiplication (EIP 2537)
+	Bls12381G2AddGas          uint64 = 4500   // Gas needed for a BLS12-381 G2 addition (EIP 2537)
+	Bls12381G2MulGas          uint64 = 55000  // Gas needed for a BLS12-381 G2 scalar multiplication (EIP 2537)
+	Bls12381PairingBaseGas    uint64 = 115000 // Base price for a BLS12-381 pairing check (EIP 2537)
+	Bls12381PairingPerPairGas uint64 = 23000  // Per-pair price for a BLS12-381 pairing check, including the subgroup checks (EIP 2537)
+	P256VerifyGas             uint64 = 3450   // Gas needed for a secp256r1 signature verification
 )
 
 var (
@@ -284,6 +284,38 @@ func TestStreamRaw(t *testing.T) {
 	}
 }
 
+// eofReader reads from a byte slice and returns io.EOF together with the
+// final bytes, which is permitted by the io.Reader contract.
+type eofReader []byte
+
+func (r *eofReader) Read(buf []byte) (n int, err error) {
+	n = copy(buf, *r)
+	*r = (*r)[n:]
+	if len(*r) == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestStreamRawWithEOF(t *testing.T) {
+	// The raw value is larger than the buffer of the stream's reader, so the
+	// read goes directly to the underlying reader and ends with an EOF.
+	input := append(unhex("F92003B92000"), bytes.Repeat([]byte{0x01}, 8192)...)
+	r := eofReader(input)
+
+	s := NewStream(&r, 0)
+	if _, err := s.List(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := s.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, input[3:]) {
+		t.Errorf("raw mismatch: got %x, want %x", raw, input[3:])
+	}
+}
+
 func TestDecodeErrors(t *testing.T) {
 	r := bytes.NewReader(nil)
 
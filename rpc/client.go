@@ -600,14 +600,23 @@ func (c *Client) handleNotification(msg *jsonrpcMessage) {
 	var subResult struct {
 		ID     string          `json:"subscription"`
 		Result json.RawMessage `json:"result"`
+		Error  *jsonError      `json:"error"`
 	}
 	if err := json.Unmarshal(msg.Params, &subResult); err != nil {
 		log.Debug("dropping invalid subscription message", "msg", msg)
 		return
 	}
-	if c.subs[subResult.ID] != nil {
-		c.subs[subResult.ID].deliver(subResult.Result)
+	sub := c.subs[subResult.ID]
+	if sub == nil {
+		return
+	}
+	// A subscription ended by the server carries the error instead of a result
+	if subResult.Error != nil {
+		delete(c.subs, subResult.ID)
+		sub.fail(subResult.Error)
+		return
 	}
+	sub.deliver(subResult.Result)
 }
 
 func (c *Client) handleResponse(msg *jsonrpcMessage) {
@@ -677,6 +686,7 @@ type ClientSubscription struct {
 	namespace string
 	subid     string
 	in        chan json.RawMessage
+	failed    chan error
 
 	quitOnce sync.Once     // ensures quit is closed once
 	quit     chan struct{} // quit is closed when the subscription exits
@@ -693,6 +703,7 @@ func newClientSubscription(c *Client, namespace string, channel reflect.Value) *
 		quit:      make(chan struct{}),
 		err:       make(chan error, 1),
 		in:        make(chan json.RawMessage),
+		failed:    make(chan error),
 	}
 	return sub
 }
@@ -743,6 +754,15 @@ func (sub *ClientSubscription) deliver(result json.RawMessage) (ok bool) {
 	}
 }
 
+// fail ends the subscription with an error sent by the server, after all the
+// notifications received before it were delivered.
+func (sub *ClientSubscription) fail(err error) {
+	select {
+	case sub.failed <- err:
+	case <-sub.quit:
+	}
+}
+
 func (sub *ClientSubscription) start() {
 	sub.quitWithError(sub.forward())
 }
@@ -751,19 +771,25 @@ func (sub *ClientSubscription) forward() (err error, unsubscribeServer bool) {
 	cases := []reflect.SelectCase{
 		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.quit)},
 		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.in)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.failed)},
 		{Dir: reflect.SelectSend, Chan: sub.channel},
 	}
 	buffer := list.New()
 	defer buffer.Init()
+
+	var failure error // Server side failure, reported once the buffer is drained
 	for {
 		var chosen int
 		var recv reflect.Value
 		if buffer.Len() == 0 {
+			if failure != nil {
+				return failure, false
+			}
 			// Idle, omit send case.
-			chosen, recv, _ = reflect.Select(cases[:2])
+			chosen, recv, _ = reflect.Select(cases[:3])
 		} else {
 			// Non-empty buffer, send the first queued item.
-			cases[2].Send = reflect.ValueOf(buffer.Front().Value)
+			cases[3].Send = reflect.ValueOf(buffer.Front().Value)
 			chosen, recv, _ = reflect.Select(cases)
 		}
 
@@ -779,8 +805,10 @@ func (sub *ClientSubscription) forward() (err error, unsubscribeServer bool) {
 				return ErrSubscriptionQueueOverflow, true
 			}
 			buffer.PushBack(val)
-		case 2: // sub.channel<-
-			cases[2].Send = reflect.Value{} // Don't hold onto the value.
+		case 2: // <-sub.failed
+			failure = recv.Interface().(error)
+		case 3: // sub.channel<-
+			cases[3].Send = reflect.Value{} // Don't hold onto the value.
 			buffer.Remove(buffer.Front())
 		}
 	}
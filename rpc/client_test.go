@@ -251,6 +251,35 @@ func TestClientSubscribe(t *testing.T) {
 	}
 }
 
+func TestClientSubscribeServerFailure(t *testing.T) {
+	server := newTestServer("eth", new(NotificationTestService))
+	defer server.Stop()
+	client := DialInProc(server)
+	defer client.Close()
+
+	nc := make(chan int)
+	count := 10
+	sub, err := client.EthSubscribe(context.Background(), nc, "failingSubscription", count)
+	if err != nil {
+		t.Fatal("can't subscribe:", err)
+	}
+	for i := 0; i < count; i++ {
+		if val := <-nc; val != i {
+			t.Fatalf("value mismatch: got %d, want %d", val, i)
+		}
+	}
+	select {
+	case v := <-nc:
+		t.Fatal("received value after failure:", v)
+	case err := <-sub.Err():
+		if want := fmt.Sprintf("failed after %d notifications", count); err == nil || err.Error() != want {
+			t.Fatalf("subscription error mismatch: have %v, want %q", err, want)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("subscription not closed within 1s after failure")
+	}
+}
+
 func TestClientSubscribeCustomNamespace(t *testing.T) {
 	namespace := "custom"
 	server := newTestServer(namespace, new(NotificationTestService))
@@ -56,6 +56,7 @@ type Notifier struct {
 	active   map[ID]*Subscription
 	inactive map[ID]*Subscription
 	buffer   map[ID][]interface{} // unsent notifications of inactive subscriptions
+	failed   map[ID]error         // failures of inactive subscriptions
 }
 
 // newNotifier creates a new notifier that can be used to send subscription
@@ -66,6 +67,7 @@ func newNotifier(codec ServerCodec) *Notifier {
 		active:   make(map[ID]*Subscription),
 		inactive: make(map[ID]*Subscription),
 		buffer:   make(map[ID][]interface{}),
+		failed:   make(map[ID]error),
 	}
 }
 
@@ -95,12 +97,42 @@ func (n *Notifier) Notify(id ID, data interface{}) error {
 
 	if sub, active := n.active[id]; active {
 		n.send(sub, data)
-	} else {
+	} else if _, inactive := n.inactive[id]; inactive && n.failed[id] == nil {
 		n.buffer[id] = append(n.buffer[id], data)
 	}
 	return nil
 }
 
+// Fail ends the subscription with the given error, which is sent to the client
+// after any pending notifications. Notifications sent afterwards are dropped.
+func (n *Notifier) Fail(id ID, err error) error {
+	n.subMu.Lock()
+	defer n.subMu.Unlock()
+
+	if sub, active := n.active[id]; active {
+		delete(n.active, id)
+		return n.fail(sub, err)
+	}
+	if _, inactive := n.inactive[id]; inactive {
+		n.failed[id] = err
+		return nil
+	}
+	return ErrSubscriptionNotFound
+}
+
+func (n *Notifier) fail(sub *Subscription, err error) error {
+	rpcErr, ok := err.(Error)
+	if !ok {
+		rpcErr = &callbackError{err.Error()}
+	}
+	notification := n.codec.CreateErrorNotification(string(sub.ID), sub.namespace, rpcErr)
+	if err := n.codec.Write(notification); err != nil {
+		n.codec.Close()
+		return err
+	}
+	return nil
+}
+
 func (n *Notifier) send(sub *Subscription, data interface{}) error {
 	notification := n.codec.CreateNotification(string(sub.ID), sub.namespace, data)
 	err := n.codec.Write(notification)
@@ -145,5 +177,12 @@ func (n *Notifier) activate(id ID, namespace string) {
 			n.send(sub, data)
 		}
 		delete(n.buffer, id)
+
+		// End the subscription if it failed in the meantime
+		if err, failed := n.failed[id]; failed {
+			delete(n.active, id)
+			delete(n.failed, id)
+			n.fail(sub, err)
+		}
 	}
 }
@@ -78,6 +78,22 @@ func (s *NotificationTestService) SomeSubscription(ctx context.Context, n, val i
 
 // HangSubscription blocks on s.unblockHangSubscription before
 // sending anything.
+func (s *NotificationTestService) FailingSubscription(ctx context.Context, n int) (*Subscription, error) {
+	notifier, supported := NotifierFromContext(ctx)
+	if !supported {
+		return nil, ErrNotificationsUnsupported
+	}
+	subscription := notifier.CreateSubscription()
+
+	// The notifications and the failure are held back until the subscription
+	// id was sent to the client
+	for i := 0; i < n; i++ {
+		notifier.Notify(subscription.ID, i)
+	}
+	notifier.Fail(subscription.ID, fmt.Errorf("failed after %d notifications", n))
+	return subscription, nil
+}
+
 func (s *NotificationTestService) HangSubscription(ctx context.Context, val int) (*Subscription, error) {
 	notifier, supported := NotifierFromContext(ctx)
 	if !supported {
@@ -203,7 +219,7 @@ func waitForMessages(t *testing.T, in *json.Decoder, successes chan<- jsonSucces
 				notifications <- jsonNotification{
 					Version: msg["jsonrpc"].(string),
 					Method:  msg["method"].(string),
-					Params:  jsonSubscription{params["subscription"].(string), params["result"]},
+					Params:  jsonSubscription{params["subscription"].(string), params["result"], nil},
 				}
 				continue
 			}
@@ -17,6 +17,8 @@
 package rpc
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
@@ -24,6 +26,7 @@ import (
 	"sync"
 
 	mapset "github.com/deckarep/golang-set"
+	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/common/hexutil"
 )
 
@@ -108,6 +111,8 @@ type ServerCodec interface {
 	CreateErrorResponseWithInfo(id interface{}, err Error, info interface{}) interface{}
 	// Create notification response
 	CreateNotification(id, namespace string, event interface{}) interface{}
+	// Create notification ending a subscription with an error
+	CreateErrorNotification(id, namespace string, err Error) interface{}
 	// Write msg to client.
 	Write(msg interface{}) error
 	// Close underlying data stream
@@ -163,3 +168,88 @@ func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
 func (bn BlockNumber) Int64() int64 {
 	return (int64)(bn)
 }
+
+// BlockNumberOrHash references a block either by its number (or one of the
+// special block tags) or by its hash.
+type BlockNumberOrHash struct {
+	BlockNumber      *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash        *common.Hash `json:"blockHash,omitempty"`
+	RequireCanonical bool         `json:"requireCanonical,omitempty"` // Whether a hash referenced block must be canonical
+}
+
+// UnmarshalJSON parses the given JSON fragment into a BlockNumberOrHash. It
+// supports everything BlockNumber does, a 32 byte hex block hash, or an object
+// with either a blockNumber or a blockHash field set.
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	type plain BlockNumberOrHash
+
+	var obj plain
+	if err := json.Unmarshal(data, &obj); err == nil {
+		if (obj.BlockNumber == nil) == (obj.BlockHash == nil) {
+			return errors.New("exactly one of blockNumber and blockHash must be specified")
+		}
+		*bnh = BlockNumberOrHash(obj)
+		return nil
+	}
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	if len(input) == 2+2*common.HashLength {
+		var hash common.Hash
+		if err := hash.UnmarshalText([]byte(input)); err != nil {
+			return err
+		}
+		*bnh = BlockNumberOrHashWithHash(hash, false)
+		return nil
+	}
+	var number BlockNumber
+	if err := number.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*bnh = BlockNumberOrHashWithNumber(number)
+	return nil
+}
+
+// Number returns the referenced block number, if the block is referenced by it.
+func (bnh *BlockNumberOrHash) Number() (BlockNumber, bool) {
+	if bnh.BlockNumber != nil {
+		return *bnh.BlockNumber, true
+	}
+	return BlockNumber(0), false
+}
+
+// Hash returns the referenced block hash, if the block is referenced by it.
+func (bnh *BlockNumberOrHash) Hash() (common.Hash, bool) {
+	if bnh.BlockHash != nil {
+		return *bnh.BlockHash, true
+	}
+	return common.Hash{}, false
+}
+
+// String implements fmt.Stringer.
+func (bnh BlockNumberOrHash) String() string {
+	if bnh.BlockHash != nil {
+		return bnh.BlockHash.Hex()
+	}
+	if bnh.BlockNumber != nil {
+		switch *bnh.BlockNumber {
+		case PendingBlockNumber:
+			return "pending"
+		case LatestBlockNumber:
+			return "latest"
+		}
+		return fmt.Sprintf("#%d", *bnh.BlockNumber)
+	}
+	return "nil"
+}
+
+// BlockNumberOrHashWithNumber references a block by its number.
+func BlockNumberOrHashWithNumber(number BlockNumber) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockNumber: &number}
+}
+
+// BlockNumberOrHashWithHash references a block by its hash.
+func BlockNumberOrHashWithHash(hash common.Hash, canonical bool) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockHash: &hash, RequireCanonical: canonical}
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/common/math"
 )
 
@@ -64,3 +65,40 @@ func TestBlockNumberJSONUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestBlockNumberOrHashJSONUnmarshal(t *testing.T) {
+	hash := common.HexToHash("0x2a")
+	tests := []struct {
+		input    string
+		mustFail bool
+		expected BlockNumberOrHash
+	}{
+		0: {`"0x1"`, false, BlockNumberOrHashWithNumber(1)},
+		1: {`"latest"`, false, BlockNumberOrHashWithNumber(LatestBlockNumber)},
+		2: {`"pending"`, false, BlockNumberOrHashWithNumber(PendingBlockNumber)},
+		3: {`"` + hash.Hex() + `"`, false, BlockNumberOrHashWithHash(hash, false)},
+		4: {`{"blockNumber":"0x2"}`, false, BlockNumberOrHashWithNumber(2)},
+		5: {`{"blockHash":"` + hash.Hex() + `","requireCanonical":true}`, false, BlockNumberOrHashWithHash(hash, true)},
+		6: {`{"blockNumber":"0x2","blockHash":"` + hash.Hex() + `"}`, true, BlockNumberOrHash{}},
+		7: {`{}`, true, BlockNumberOrHash{}},
+		8: {`"0x2a2a"`, false, BlockNumberOrHashWithNumber(0x2a2a)},
+		9: {`"ff"`, true, BlockNumberOrHash{}},
+	}
+	for i, test := range tests {
+		var bnh BlockNumberOrHash
+		err := json.Unmarshal([]byte(test.input), &bnh)
+		if test.mustFail {
+			if err == nil {
+				t.Errorf("test %d: should fail", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: should pass but got err: %v", i, err)
+			continue
+		}
+		if bnh.String() != test.expected.String() || bnh.RequireCanonical != test.expected.RequireCanonical {
+			t.Errorf("test %d: got unexpected value, want %v, got %v", i, test.expected, bnh)
+		}
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/simplechain-org/simplechain/accounts/usbwallet"
 	"github.com/simplechain-org/simplechain/common"
 	"github.com/simplechain-org/simplechain/common/hexutil"
+	"github.com/simplechain-org/simplechain/core/types"
 	"github.com/simplechain-org/simplechain/crypto"
 	"github.com/simplechain-org/simplechain/internal/ethapi"
 	"github.com/simplechain-org/simplechain/log"
@@ -89,7 +90,7 @@ type SignerUI interface {
 
 // SignerAPI defines the actual implementation of ExternalAPI
 type SignerAPI struct {
-	chainID    *big.Int
+	signer     types.Signer
 	am         *accounts.Manager
 	UI         SignerUI
 	validator  *Validator
@@ -223,7 +224,9 @@ var ErrRequestDenied = errors.New("Request denied")
 // key that is generated when a new Account is created.
 // noUSB disables USB support that is required to support hardware devices such as
 // ledger and trezor.
-func NewSignerAPI(chainID int64, ksLocation string, noUSB bool, ui SignerUI, abidb *AbiDb, lightKDF bool, advancedMode bool) *SignerAPI {
+// signingDomain specifies the chain specific signing domain of the transactions,
+// plain EIP155 signatures are produced if it's empty.
+func NewSignerAPI(chainID int64, signingDomain string, ksLocation string, noUSB bool, ui SignerUI, abidb *AbiDb, lightKDF bool, advancedMode bool) *SignerAPI {
 	var (
 		backends []accounts.Backend
 		n, p     = keystore.StandardScryptN, keystore.StandardScryptP
@@ -254,7 +257,11 @@ func NewSignerAPI(chainID int64, ksLocation string, noUSB bool, ui SignerUI, abi
 			log.Debug("Trezor support enabled")
 		}
 	}
-	signer := &SignerAPI{big.NewInt(chainID), accounts.NewManager(backends...), ui, NewValidator(abidb), !advancedMode}
+	var txSigner types.Signer = types.NewEIP155Signer(big.NewInt(chainID))
+	if signingDomain != "" {
+		txSigner = types.NewDomainSigner(big.NewInt(chainID), signingDomain)
+	}
+	signer := &SignerAPI{txSigner, accounts.NewManager(backends...), ui, NewValidator(abidb), !advancedMode}
 	if !noUSB {
 		signer.startUSBListener()
 	}
@@ -494,11 +501,18 @@ func (api *SignerAPI) SignTransaction(ctx context.Context, args SendTxArgs, meth
 	var unsignedTx = result.Transaction.toTransaction()
 
 	// The one to sign is the one that was returned from the UI
-	signedTx, err := wallet.SignTxWithPassphrase(acc, result.Password, unsignedTx, api.chainID)
+	signedTx, err := api.signTx(wallet, acc, result.Password, unsignedTx)
 	if err != nil {
 		api.UI.ShowError(err.Error())
 		return nil, err
 	}
+	// If the gas is sponsored, the fee payer has to approve and sign too
+	if result.Transaction.FeePayer != nil {
+		if signedTx, err = api.signFeePayer(ctx, result.Transaction, signedTx); err != nil {
+			api.UI.ShowError(err.Error())
+			return nil, err
+		}
+	}
 
 	rlpdata, err := rlp.EncodeToBytes(signedTx)
 	response := ethapi.SignTransactionResult{Raw: rlpdata, Tx: signedTx}
@@ -510,6 +524,56 @@ func (api *SignerAPI) SignTransaction(ctx context.Context, args SendTxArgs, meth
 
 }
 
+// signTx signs the transaction with the given wallet using the configured signer
+// if the wallet supports arbitrary signers, otherwise via the chain ID of the
+// signer, which is only possible for plain EIP-155 signers.
+func (api *SignerAPI) signTx(wallet accounts.Wallet, acc accounts.Account, passphrase string, tx *types.Transaction) (*types.Transaction, error) {
+	if wallet, ok := wallet.(accounts.SignerWallet); ok {
+		return wallet.SignTxWithSignerAndPassphrase(acc, passphrase, tx, api.signer)
+	}
+	signer, ok := api.signer.(types.EIP155Signer)
+	if !ok {
+		return nil, fmt.Errorf("wallet %s does not support the configured signing domain", wallet.URL())
+	}
+	return wallet.SignTxWithPassphrase(acc, passphrase, tx, signer.ChainID())
+}
+
+// signFeePayer requests a separate approval for the fee payer of a sponsored
+// transaction, since it is a different account that may need its own password,
+// and adds its signature to the already sender-signed transaction.
+func (api *SignerAPI) signFeePayer(ctx context.Context, args SendTxArgs, tx *types.Transaction) (*types.Transaction, error) {
+	payer := args.FeePayer.Address()
+	req := SignTxRequest{
+		Transaction: args,
+		Meta:        MetadataFromContext(ctx),
+		Callinfo: []ValidationInfo{
+			{INFO, fmt.Sprintf("Fee payer %v is requested to pay for the gas of this transaction", payer.Hex())},
+		},
+	}
+	result, err := api.UI.ApproveTx(&req)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Approved {
+		return nil, ErrRequestDenied
+	}
+	// The sender signature is already in place, so the UI may not alter the
+	// transaction anymore
+	if logDiff(&req, &result) {
+		return nil, errors.New("transaction modified during fee payer approval")
+	}
+	acc := accounts.Account{Address: payer}
+	wallet, err := api.am.Find(acc)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := wallet.SignHashWithPassphrase(acc, result.Password, types.PayerHash(tx).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithPayerSignature(sig)
+}
+
 // Sign calculates an Ethereum ECDSA signature for:
 // keccack256("\x19Ethereum Signed Message:\n" + len(message) + message))
 //
@@ -141,6 +141,7 @@ func setup(t *testing.T) (*SignerAPI, chan string) {
 		ui  = &HeadlessUI{controller}
 		api = NewSignerAPI(
 			1,
+			"",
 			tmpDirName(t),
 			true,
 			ui,
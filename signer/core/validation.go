@@ -146,6 +146,12 @@ func (v *Validator) validate(msgs *ValidationMessages, txargs *SendTxArgs, metho
 		// Validate calldata
 		v.validateCallData(msgs, data, methodSelector)
 	}
+	if txargs.FeePayer != nil {
+		if !txargs.FeePayer.ValidChecksum() {
+			msgs.warn("Invalid checksum on fee payer address")
+		}
+		msgs.info(fmt.Sprintf("Gas will be paid by fee payer %v", txargs.FeePayer.Address().Hex()))
+	}
 	return nil
 }
 
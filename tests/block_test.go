@@ -46,8 +46,11 @@ func TestBlockchain(t *testing.T) {
 	//bt.fails(`^bcStateTests/suicideStorageCheck.json/suicideStorageCheck_Constantinople`, "TODO: investigate")
 
 	bt.walk(t, blockTestDir, func(t *testing.T, name string, test *BlockTest) {
-		if err := bt.checkFailure(t, name, test.Run()); err != nil {
-			t.Error(err)
+		if err := bt.checkFailure(t, name, test.Run(false)); err != nil {
+			t.Errorf("test without parallel execution failed: %v", err)
+		}
+		if err := bt.checkFailure(t, name, test.Run(true)); err != nil {
+			t.Errorf("test with parallel execution failed: %v", err)
 		}
 	})
 }
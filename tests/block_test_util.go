@@ -94,7 +94,7 @@ type btHeaderMarshaling struct {
 	Timestamp  math.HexOrDecimal64
 }
 
-func (t *BlockTest) Run() error {
+func (t *BlockTest) Run(parallel bool) error {
 	config, ok := Forks[t.json.Network]
 	if !ok {
 		return UnsupportedForkError{t.json.Network}
@@ -123,6 +123,7 @@ func (t *BlockTest) Run() error {
 		return err
 	}
 	defer chain.Stop()
+	chain.SetParallelExecution(parallel)
 
 	validBlocks, err := t.insertBlocks(chain)
 	if err != nil {
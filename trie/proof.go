@@ -18,6 +18,7 @@ package trie
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/simplechain-org/simplechain/common"
@@ -116,7 +117,7 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb DatabaseReader) (valu
 		if err != nil {
 			return nil, i, fmt.Errorf("bad proof node %d: %v", i, err)
 		}
-		keyrest, cld := get(n, key)
+		keyrest, cld := get(n, key, true)
 		switch cld := cld.(type) {
 		case nil:
 			// The trie doesn't contain the key.
@@ -130,7 +131,452 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb DatabaseReader) (valu
 	}
 }
 
-func get(tn node, key []byte) ([]byte, node) {
+// proofToPath converts a merkle proof to a trie node path. All the nodes on the
+// path to key are resolved from the proof and linked into the given root (which
+// is resolved first if nil), siblings off the path are left as hash nodes.
+//
+// The proof is allowed to be a proof of absence if allowNonExistent is set.
+func proofToPath(rootHash common.Hash, root node, key []byte, proofDb DatabaseReader, allowNonExistent bool) (node, []byte, error) {
+	// resolveNode retrieves and resolves a trie node from the merkle proof
+	resolveNode := func(hash common.Hash) (node, error) {
+		buf, _ := proofDb.Get(hash[:])
+		if buf == nil {
+			return nil, fmt.Errorf("proof node (hash %064x) missing", hash)
+		}
+		n, err := decodeNode(hash[:], buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("bad proof node %v", err)
+		}
+		return n, nil
+	}
+	// The root node must be included in the proof
+	if root == nil {
+		n, err := resolveNode(rootHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		root = n
+	}
+	var (
+		err           error
+		child, parent node
+		keyrest       []byte
+		valnode       []byte
+	)
+	key, parent = keybytesToHex(key), root
+	for {
+		keyrest, child = get(parent, key, false)
+		switch cld := child.(type) {
+		case nil:
+			// The trie doesn't contain the key. All resolved nodes are proven
+			// correct though, which is enough to prove a range.
+			if allowNonExistent {
+				return root, nil, nil
+			}
+			return nil, nil, errors.New("the node is not contained in trie")
+		case *shortNode, *fullNode:
+			key, parent = keyrest, child // Already resolved
+			continue
+		case hashNode:
+			child, err = resolveNode(common.BytesToHash(cld))
+			if err != nil {
+				return nil, nil, err
+			}
+		case valueNode:
+			valnode = cld
+		}
+		// Link the resolved child into its parent
+		switch pnode := parent.(type) {
+		case *shortNode:
+			pnode.Val = child
+		case *fullNode:
+			pnode.Children[key[0]] = child
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", pnode, pnode))
+		}
+		if len(valnode) > 0 {
+			return root, valnode, nil // The whole path is resolved
+		}
+		key, parent = keyrest, child
+	}
+}
+
+// unsetInternal removes all internal node references (hash nodes and embedded
+// nodes) between the two edge paths of a trie constructed from two edge proofs.
+// The removed parts are expected to be refilled by the leaves of the range.
+//
+// All visited nodes are marked dirty since their content might be modified. It
+// can happen that some full nodes are temporarily left with a single child, but
+// a valid range fills the missing children anyway, an invalid one is rejected.
+//
+// The left key must be smaller than the right one. The returned flag reports
+// whether the whole trie needs to be dropped.
+func unsetInternal(n node, left []byte, right []byte) (bool, error) {
+	left, right = keybytesToHex(left), keybytesToHex(right)
+
+	// Step down to the fork point of the two paths. It's either a short node
+	// whose key doesn't match one of the paths, or a full node where the two
+	// paths point to different (or missing) children.
+	var (
+		pos    = 0
+		parent node
+
+		// Fork indicators, 0 means no fork, -1 means the path is less, 1 greater
+		shortForkLeft, shortForkRight int
+	)
+findFork:
+	for {
+		switch rn := n.(type) {
+		case *shortNode:
+			rn.flags = nodeFlag{dirty: true}
+
+			if len(left)-pos < len(rn.Key) {
+				shortForkLeft = bytes.Compare(left[pos:], rn.Key)
+			} else {
+				shortForkLeft = bytes.Compare(left[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if len(right)-pos < len(rn.Key) {
+				shortForkRight = bytes.Compare(right[pos:], rn.Key)
+			} else {
+				shortForkRight = bytes.Compare(right[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if shortForkLeft != 0 || shortForkRight != 0 {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Val, pos+len(rn.Key)
+		case *fullNode:
+			rn.flags = nodeFlag{dirty: true}
+
+			leftnode, rightnode := rn.Children[left[pos]], rn.Children[right[pos]]
+			if leftnode == nil || rightnode == nil || leftnode != rightnode {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Children[left[pos]], pos+1
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+	switch rn := n.(type) {
+	case *shortNode:
+		// Both paths on the same side of the short node leave an empty range
+		if shortForkLeft == -1 && shortForkRight == -1 {
+			return false, errors.New("empty range")
+		}
+		if shortForkLeft == 1 && shortForkRight == 1 {
+			return false, errors.New("empty range")
+		}
+		// The short node is entirely within the range, drop it
+		if shortForkLeft != 0 && shortForkRight != 0 {
+			if parent == nil {
+				return true, nil
+			}
+			parent.(*fullNode).Children[left[pos-1]] = nil
+			return false, nil
+		}
+		// Only one of the paths points to a non-existent key
+		if shortForkRight != 0 {
+			if _, ok := rn.Val.(valueNode); ok {
+				if parent == nil {
+					return true, nil
+				}
+				parent.(*fullNode).Children[left[pos-1]] = nil
+				return false, nil
+			}
+			return false, unset(rn, rn.Val, left[pos:], len(rn.Key), false)
+		}
+		if shortForkLeft != 0 {
+			if _, ok := rn.Val.(valueNode); ok {
+				if parent == nil {
+					return true, nil
+				}
+				parent.(*fullNode).Children[right[pos-1]] = nil
+				return false, nil
+			}
+			return false, unset(rn, rn.Val, right[pos:], len(rn.Key), true)
+		}
+		return false, nil
+	case *fullNode:
+		// Drop all the children between the two paths
+		for i := left[pos] + 1; i < right[pos]; i++ {
+			rn.Children[i] = nil
+		}
+		if err := unset(rn, rn.Children[left[pos]], left[pos:], 1, false); err != nil {
+			return false, err
+		}
+		if err := unset(rn, rn.Children[right[pos]], right[pos:], 1, true); err != nil {
+			return false, err
+		}
+		return false, nil
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unset removes all internal node references on one side of the given path,
+// the left side if removeLeft is set or the right side otherwise. If the path
+// doesn't exist in the trie, the fork point decides what to do: a full node
+// without the child is left as is, a short node within the range is dropped
+// and a short node outside of the range is kept with its cached hash.
+func unset(parent node, child node, key []byte, pos int, removeLeft bool) error {
+	switch cld := child.(type) {
+	case *fullNode:
+		if removeLeft {
+			for i := 0; i < int(key[pos]); i++ {
+				cld.Children[i] = nil
+			}
+		} else {
+			for i := key[pos] + 1; i < 16; i++ {
+				cld.Children[i] = nil
+			}
+		}
+		cld.flags = nodeFlag{dirty: true}
+		return unset(cld, cld.Children[key[pos]], key, pos+1, removeLeft)
+	case *shortNode:
+		if len(key[pos:]) < len(cld.Key) || !bytes.Equal(cld.Key, key[pos:pos+len(cld.Key)]) {
+			// Found the fork point of a non-existent path, drop the branch if
+			// it falls within the range. The parent must be a full node.
+			if removeLeft {
+				if bytes.Compare(cld.Key, key[pos:]) < 0 {
+					parent.(*fullNode).Children[key[pos-1]] = nil
+				}
+			} else {
+				if bytes.Compare(cld.Key, key[pos:]) > 0 {
+					parent.(*fullNode).Children[key[pos-1]] = nil
+				}
+			}
+			return nil
+		}
+		if _, ok := cld.Val.(valueNode); ok {
+			parent.(*fullNode).Children[key[pos-1]] = nil
+			return nil
+		}
+		cld.flags = nodeFlag{dirty: true}
+		return unset(cld, cld.Val, key, pos+len(cld.Key), removeLeft)
+	case nil:
+		// A missing child of the fork point full node, non-existent branch
+		return nil
+	default:
+		panic("it shouldn't happen") // hashNode, valueNode
+	}
+}
+
+// hasRightElement reports whether there are more elements on the right side of
+// the given path, which may point to an existent or a non-existent key. The
+// whole path is expected to be resolved already.
+func hasRightElement(node node, key []byte) bool {
+	pos, key := 0, keybytesToHex(key)
+	for node != nil {
+		switch rn := node.(type) {
+		case *fullNode:
+			for i := key[pos] + 1; i < 16; i++ {
+				if rn.Children[i] != nil {
+					return true
+				}
+			}
+			node, pos = rn.Children[key[pos]], pos+1
+		case *shortNode:
+			if len(key)-pos < len(rn.Key) || !bytes.Equal(rn.Key, key[pos:pos+len(rn.Key)]) {
+				return bytes.Compare(rn.Key, key[pos:]) > 0
+			}
+			node, pos = rn.Val, pos+len(rn.Key)
+		case valueNode:
+			return false // The whole path is resolved
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", node, node)) // hashNode
+		}
+	}
+	return false
+}
+
+// VerifyRangeProof checks whether the given leaves are a contiguous range of a
+// trie with the given root hash, the range being bounded by the merkle proofs
+// of firstKey and lastKey. The edge proofs may be proofs of absence, so a range
+// starting at an arbitrary origin can be proven. The keys must be monotonically
+// increasing and of the same length as the edge keys.
+//
+// Special cases:
+//   - A nil proof means the leaves are expected to be the whole trie.
+//   - No leaves but a proof of firstKey means there must be no elements at or
+//     after firstKey.
+//   - A single leaf with identical edge keys is proven by a single proof.
+//
+// The returned flag reports whether the trie has more elements after the range.
+func VerifyRangeProof(rootHash common.Hash, firstKey []byte, lastKey []byte, keys [][]byte, values [][]byte, proof DatabaseReader) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("inconsistent proof data, keys: %d, values: %d", len(keys), len(values))
+	}
+	// Ensure the received batch is monotonically increasing
+	for i := 0; i < len(keys)-1; i++ {
+		if bytes.Compare(keys[i], keys[i+1]) >= 0 {
+			return false, errors.New("range is not monotonically increasing")
+		}
+	}
+	// No edge proofs at all, the range must be the whole trie
+	if proof == nil {
+		tr := &Trie{db: NewDatabase(ethdb.NewMemDatabase())}
+		for i, key := range keys {
+			if err := tr.TryUpdate(key, values[i]); err != nil {
+				return false, err
+			}
+		}
+		if have, want := tr.Hash(), rootHash; have != want {
+			return false, fmt.Errorf("invalid proof, want hash %x, got %x", want, have)
+		}
+		return false, nil
+	}
+	// An edge proof without leaves, there must be nothing after the origin
+	if len(keys) == 0 {
+		root, val, err := proofToPath(rootHash, nil, firstKey, proof, true)
+		if err != nil {
+			return false, err
+		}
+		if val != nil || hasRightElement(root, firstKey) {
+			return false, errors.New("more entries available")
+		}
+		return false, nil
+	}
+	// A single leaf with identical edge keys, only one path can be built
+	if len(keys) == 1 && bytes.Equal(firstKey, lastKey) {
+		root, val, err := proofToPath(rootHash, nil, firstKey, proof, false)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(firstKey, keys[0]) {
+			return false, errors.New("correct proof but invalid key")
+		}
+		if !bytes.Equal(val, values[0]) {
+			return false, errors.New("correct proof but invalid data")
+		}
+		return hasRightElement(root, firstKey), nil
+	}
+	// In all other cases two distinct edge paths are required
+	if bytes.Compare(firstKey, lastKey) >= 0 {
+		return false, errors.New("invalid edge keys")
+	}
+	if len(firstKey) != len(lastKey) {
+		return false, errors.New("inconsistent edge keys")
+	}
+	if bytes.Compare(firstKey, keys[0]) > 0 || bytes.Compare(keys[len(keys)-1], lastKey) > 0 {
+		return false, errors.New("range out of edge keys")
+	}
+	// Convert the edge proofs to paths, merging the second into the first so
+	// the partial trie has the same shape as the original one.
+	root, _, err := proofToPath(rootHash, nil, firstKey, proof, true)
+	if err != nil {
+		return false, err
+	}
+	root, _, err = proofToPath(rootHash, root, lastKey, proof, true)
+	if err != nil {
+		return false, err
+	}
+	// Remove all internal references and refill them from the leaves
+	empty, err := unsetInternal(root, firstKey, lastKey)
+	if err != nil {
+		return false, err
+	}
+	tr := &Trie{root: root, db: NewDatabase(ethdb.NewMemDatabase())}
+	if empty {
+		tr.root = nil
+	}
+	for i, key := range keys {
+		if err := tr.TryUpdate(key, values[i]); err != nil {
+			return false, err
+		}
+	}
+	if have, want := tr.Hash(), rootHash; have != want {
+		return false, fmt.Errorf("invalid proof, want hash %x, got %x", want, have)
+	}
+	return hasRightElement(tr.root, keys[len(keys)-1]), nil
+}
+
+// CommitRange writes the trie nodes built from a verified range of leaves into
+// the given database, limited to the nodes whose whole key space falls between
+// firstKey and lastKey. Nodes on the edge paths of the range are skipped since
+// they reference data outside of it, so every written node is the root of a
+// complete subtrie. Since the nodes are only written if the range is known to
+// contain all leaves in between the edges, a trie sync may consider any of them
+// as locally available with all its children.
+//
+// The number of written nodes is returned.
+func CommitRange(firstKey []byte, lastKey []byte, keys [][]byte, values [][]byte, db ethdb.Putter) (int, error) {
+	if len(keys) != len(values) {
+		return 0, fmt.Errorf("inconsistent range data, keys: %d, values: %d", len(keys), len(values))
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	// Rebuild the trie of the range alone and collapse it into a scratch database
+	triedb := NewDatabase(ethdb.NewMemDatabase())
+	tr := &Trie{db: triedb}
+	for i, key := range keys {
+		if err := tr.TryUpdate(key, values[i]); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tr.Commit(nil); err != nil {
+		return 0, err
+	}
+	// Walk the trie and write out all hashed nodes covered by the edges
+	first, last := keybytesToHex(firstKey), keybytesToHex(lastKey)
+	first, last = first[:len(first)-1], last[:len(last)-1]
+
+	covered := func(path []byte) bool {
+		if len(path) > len(first) || len(first) != len(last) {
+			return false
+		}
+		min, max := make([]byte, len(first)), make([]byte, len(last))
+		copy(min, path)
+		copy(max, path)
+		for i := len(path); i < len(max); i++ {
+			max[i] = 0x0f
+		}
+		return bytes.Compare(min, first) >= 0 && bytes.Compare(max, last) <= 0
+	}
+	written := 0
+	var walk func(n node, path []byte) error
+	walk = func(n node, path []byte) error {
+		var children []node
+		var paths [][]byte
+
+		switch n := n.(type) {
+		case *shortNode:
+			if _, ok := n.Val.(valueNode); !ok {
+				children, paths = append(children, n.Val), append(paths, append(common.CopyBytes(path), n.Key...))
+			}
+		case *fullNode:
+			for i := 0; i < 16; i++ {
+				if n.Children[i] != nil {
+					children, paths = append(children, n.Children[i]), append(paths, append(common.CopyBytes(path), byte(i)))
+				}
+			}
+		default:
+			return nil
+		}
+		if hash, _ := n.cache(); hash != nil && covered(path) {
+			blob, err := triedb.Node(common.BytesToHash(hash))
+			if err != nil {
+				return err
+			}
+			if err := db.Put(hash, blob); err != nil {
+				return err
+			}
+			written++
+		}
+		for i, child := range children {
+			if err := walk(child, paths[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return written, walk(tr.root, nil)
+}
+
+// get returns the child of the given node along the path of key. If skipResolved
+// is set, embedded nodes are descended into until a hash or value node is met.
+func get(tn node, key []byte, skipResolved bool) ([]byte, node) {
 	for {
 		switch n := tn.(type) {
 		case *shortNode:
@@ -139,9 +585,15 @@ func get(tn node, key []byte) ([]byte, node) {
 			}
 			tn = n.Val
 			key = key[len(n.Key):]
+			if !skipResolved {
+				return key, tn
+			}
 		case *fullNode:
 			tn = n.Children[key[0]]
 			key = key[1:]
+			if !skipResolved {
+				return key, tn
+			}
 		case hashNode:
 			return key, n
 		case nil:
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -143,6 +144,325 @@ func TestMissingKeyProof(t *testing.T) {
 	}
 }
 
+type entrySlice []*kv
+
+func (p entrySlice) Len() int           { return len(p) }
+func (p entrySlice) Less(i, j int) bool { return bytes.Compare(p[i].k, p[j].k) < 0 }
+func (p entrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortedEntries returns the entries of a random trie ordered by key.
+func sortedEntries(vals map[string]*kv) entrySlice {
+	var entries entrySlice
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Sort(entries)
+	return entries
+}
+
+// rangeKeys splits a range of entries into its keys and values.
+func rangeKeys(entries entrySlice) ([][]byte, [][]byte) {
+	var keys, vals [][]byte
+	for _, kv := range entries {
+		keys = append(keys, kv.k)
+		vals = append(vals, kv.v)
+	}
+	return keys, vals
+}
+
+// proveRange creates the merkle proofs of the two edge keys of a range.
+func proveRange(t *testing.T, trie *Trie, first, last []byte) *ethdb.MemDatabase {
+	proof := ethdb.NewMemDatabase()
+	if err := trie.Prove(first, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the first node %v", err)
+	}
+	if err := trie.Prove(last, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the last node %v", err)
+	}
+	return proof
+}
+
+// Tests that random ranges of a trie can be proven with the existent proofs of
+// their edge keys, and that the continuation flag is correctly reported.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof := proveRange(t, trie, entries[start].k, entries[end-1].k)
+		keys, values := rangeKeys(entries[start:end])
+
+		more, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+		if want := end < len(entries); more != want {
+			t.Fatalf("Case %d(%d->%d) continuation mismatch: have %v, want %v", i, start, end-1, more, want)
+		}
+	}
+}
+
+// Tests that ranges can be proven with edge keys that are not part of the trie,
+// as requested by a remote peer asking for an arbitrary hash range.
+func TestRangeProofWithNonExistentProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries)-1) + 1
+		end := mrand.Intn(len(entries)-start) + start
+		if end == len(entries) {
+			end--
+		}
+		if end <= start {
+			continue
+		}
+		first := decreaseKey(common.CopyBytes(entries[start].k))
+		if bytes.Compare(first, entries[start-1].k) <= 0 {
+			continue
+		}
+		last := increaseKey(common.CopyBytes(entries[end-1].k))
+		if bytes.Compare(last, entries[end].k) >= 0 {
+			continue
+		}
+		proof := proveRange(t, trie, first, last)
+		keys, values := rangeKeys(entries[start:end])
+
+		if _, err := VerifyRangeProof(trie.Hash(), first, last, keys, values, proof); err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+	}
+}
+
+// Tests that tampered ranges are rejected.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+		if end-start < 3 {
+			continue
+		}
+		proof := proveRange(t, trie, entries[start].k, entries[end-1].k)
+		keys, values := rangeKeys(entries[start:end])
+
+		var index int
+		switch mrand.Intn(3) {
+		case 0:
+			// Modify a value in the middle of the range
+			index = mrand.Intn(end-start-2) + 1
+			values[index] = randBytes(20)
+		case 1:
+			// Drop an entry in the middle of the range
+			index = mrand.Intn(end-start-2) + 1
+			keys = append(keys[:index], keys[index+1:]...)
+			values = append(values[:index], values[index+1:]...)
+		case 2:
+			// Swap two adjacent values
+			index = mrand.Intn(end - start - 1)
+			if bytes.Equal(values[index], values[index+1]) {
+				continue
+			}
+			values[index], values[index+1] = values[index+1], values[index]
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, values, proof); err == nil {
+			t.Fatalf("Case %d(%d->%d) expect error, got nil", i, start, end-1)
+		}
+	}
+}
+
+// Tests that a single element range can be proven, with both identical and
+// distinct edge keys.
+func TestOneElementRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	// Identical edge keys, a single proof
+	start := 1000
+	proof := ethdb.NewMemDatabase()
+	if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the node %v", err)
+	}
+	keys, values := rangeKeys(entries[start : start+1])
+	if _, err := VerifyRangeProof(trie.Hash(), keys[0], keys[0], keys, values, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Non-existent left edge key
+	first := decreaseKey(common.CopyBytes(entries[start].k))
+	proof = proveRange(t, trie, first, entries[start].k)
+	if _, err := VerifyRangeProof(trie.Hash(), first, keys[0], keys, values, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Single element trie, both edges non-existent
+	single := new(Trie)
+	single.Update(entries[start].k, entries[start].v)
+
+	first, last := common.Hash{}.Bytes(), common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff").Bytes()
+	proof = proveRange(t, single, first, last)
+	more, err := VerifyRangeProof(single.Hash(), first, last, keys, values, proof)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if more {
+		t.Fatalf("Single element trie reported more elements")
+	}
+}
+
+// Tests that the whole trie can be proven without edge proofs, as well as with
+// edge proofs spanning all of it.
+func TestAllElementsProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	keys, values := rangeKeys(entries)
+
+	if _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, values, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	proof := proveRange(t, trie, keys[0], keys[len(keys)-1])
+	if _, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, values, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	first, last := common.Hash{}.Bytes(), common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff").Bytes()
+	proof = proveRange(t, trie, first, last)
+	more, err := VerifyRangeProof(trie.Hash(), first, last, keys, values, proof)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if more {
+		t.Fatalf("Whole trie reported more elements")
+	}
+	// A missing element must be detected without edge proofs too
+	if _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys[1:], values[1:], nil); err == nil {
+		t.Fatalf("Expected error for incomplete trie")
+	}
+}
+
+// Tests that an empty range is only accepted if there are no elements after
+// the requested origin.
+func TestEmptyRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	var cases = []struct {
+		pos int
+		err bool
+	}{
+		{len(entries) - 1, false},
+		{500, true},
+	}
+	for _, c := range cases {
+		first := increaseKey(common.CopyBytes(entries[c.pos].k))
+		proof := ethdb.NewMemDatabase()
+		if err := trie.Prove(first, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		_, err := VerifyRangeProof(trie.Hash(), first, nil, nil, nil, proof)
+		if c.err && err == nil {
+			t.Fatalf("Expected error, got nil")
+		}
+		if !c.err && err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+}
+
+// Tests that the nodes written for a set of adjacent ranges are genuine nodes of
+// the original trie, and that a trie sync can heal the missing edges on top.
+func TestCommitRangeHeal(t *testing.T) {
+	_, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	srcDb := NewDatabase(ethdb.NewMemDatabase())
+	src, _ := New(common.Hash{}, srcDb)
+	content := make(map[string][]byte)
+	for _, kv := range entries {
+		src.Update(kv.k, kv.v)
+		content[string(kv.k)] = kv.v
+	}
+	root, _ := src.Commit(nil)
+
+	// Write the trie in a few chunks, the outermost edges spanning the key space
+	diskdb := ethdb.NewMemDatabase()
+	chunk := len(entries)/7 + 1
+	for start := 0; start < len(entries); start += chunk {
+		end := start + chunk
+		if end > len(entries) {
+			end = len(entries)
+		}
+		first, last := entries[start].k, entries[end-1].k
+		if start == 0 {
+			first = common.Hash{}.Bytes()
+		}
+		if end == len(entries) {
+			last = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff").Bytes()
+		}
+		keys, values := rangeKeys(entries[start:end])
+		if _, err := CommitRange(first, last, keys, values, diskdb); err != nil {
+			t.Fatalf("Failed to commit range %d->%d: %v", start, end-1, err)
+		}
+	}
+	written := diskdb.Len()
+	if written == 0 {
+		t.Fatalf("No nodes written")
+	}
+	for _, key := range diskdb.Keys() {
+		if _, err := srcDb.Node(common.BytesToHash(key)); err != nil {
+			t.Fatalf("Written node %x not part of the original trie", key)
+		}
+	}
+	// Heal the edges with a trie sync, only retrieving the missing nodes
+	healed := 0
+	sched := NewSync(root, diskdb, nil)
+	for queue := sched.Missing(100); len(queue) > 0; queue = sched.Missing(100) {
+		results := make([]SyncResult, len(queue))
+		for i, hash := range queue {
+			data, err := srcDb.Node(hash)
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+			}
+			results[i] = SyncResult{hash, data}
+		}
+		if _, index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		if index, err := sched.Commit(diskdb); err != nil {
+			t.Fatalf("failed to commit data #%d: %v", index, err)
+		}
+		healed += len(queue)
+	}
+	if healed == 0 || healed >= written {
+		t.Fatalf("Unexpected healing: %d nodes healed, %d written from ranges", healed, written)
+	}
+	checkTrieContents(t, NewDatabase(diskdb), root[:], content)
+}
+
+// increaseKey increments the big endian key by one.
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			break
+		}
+	}
+	return key
+}
+
+// decreaseKey decrements the big endian key by one.
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			break
+		}
+	}
+	return key
+}
+
 // mutateByte changes one byte in b.
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {